@@ -414,8 +414,8 @@ func (c *contractBEvm) Delete(rst byzcoin.ReadOnlyStateTrie,
 }
 
 // Handle the log entries produced by an EVM execution.
-// Currently, only the special entries allowing to interact with Byzcoin
-// contracts are handled, the other ones are ignored.
+// The special entries allowing to interact with Byzcoin contracts generate
+// instructions, the other ones are emitted as Byzcoin events.
 func handleLogs(inst byzcoin.Instruction, rst byzcoin.ReadOnlyStateTrie,
 	logEntries []*types.Log) (
 	[]byzcoin.StateChange, error) {
@@ -447,7 +447,8 @@ func handleLogs(inst byzcoin.Instruction, rst byzcoin.ReadOnlyStateTrie,
 
 		if eventName == "" {
 			// Not a recognized event
-			log.Lvlf2("skipping event ID %s", hex.EncodeToString(eventID[:]))
+			log.Lvlf2("emitting event ID %s", hex.EncodeToString(eventID[:]))
+			stateChanges = append(stateChanges, logEvent(inst, logEntry))
 			continue
 		}
 
@@ -509,3 +510,17 @@ func handleLogs(inst byzcoin.Instruction, rst byzcoin.ReadOnlyStateTrie,
 
 	return stateChanges, nil
 }
+
+// logEvent returns the Byzcoin event holding an EVM log entry. Its
+// attributes are the "address" of the EVM contract, the "topics" of the
+// entry, concatenated, and its "data".
+func logEvent(inst byzcoin.Instruction, logEntry *types.Log) byzcoin.StateChange {
+	var topics []byte
+	for _, topic := range logEntry.Topics {
+		topics = append(topics, topic[:]...)
+	}
+	return byzcoin.NewEvent(inst.InstanceID, ContractBEvmID, "log",
+		byzcoin.Argument{Name: "address", Value: logEntry.Address[:]},
+		byzcoin.Argument{Name: "topics", Value: topics},
+		byzcoin.Argument{Name: "data", Value: logEntry.Data})
+}
@@ -2,9 +2,11 @@ package byzcoin
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"errors"
 	"fmt"
 	"go.dedis.ch/cothority/v3/blscosi/protocol"
+	"io"
 	"math/rand"
 	"time"
 
@@ -339,6 +341,144 @@ func (c *Client) GetProofFrom(key []byte, from *skipchain.SkipBlock) (*GetProofR
 	return rep, cothority.ErrorOrNil(err, "request failed")
 }
 
+// GetMultiProof returns a proof for all the keys stored in the skipchain,
+// starting from the genesis block. The proof can prove the existence or the
+// absence of every key, which can be checked with
+// MultiProof.InclusionProof.ExistsAll. Note that the integrity of the proof is
+// verified for all the keys.
+func (c *Client) GetMultiProof(keys [][]byte) (*GetMultiProofResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	decoder := func(buf []byte, msg interface{}) error {
+		err := protobuf.Decode(buf, msg)
+		if err != nil {
+			return xerrors.Errorf("decoding: %v", err)
+		}
+
+		gmpr, ok := msg.(*GetMultiProofResponse)
+		if !ok {
+			return xerrors.New("couldn't cast msg")
+		}
+
+		if err := gmpr.Proof.VerifyFromBlock(c.Genesis); err != nil {
+			return xerrors.Errorf("proof verification: %v", err)
+		}
+		if _, err := gmpr.Proof.InclusionProof.ExistsAll(keys); err != nil {
+			return xerrors.Errorf("proof verification: %v", err)
+		}
+		return nil
+	}
+
+	reply := &GetMultiProofResponse{}
+	_, err := c.SendProtobufParallelWithDecoder(c.GetNodes(), &GetMultiProof{
+		Version: CurrentVersion,
+		Keys:    keys,
+		ID:      c.Genesis.Hash,
+	}, reply, c.options, decoder)
+	if err != nil {
+		return nil, xerrors.Errorf("sending: %v", err)
+	}
+
+	if c.Latest == nil || c.Latest.Index < reply.Proof.Latest.Index {
+		c.Latest = &reply.Proof.Latest
+	}
+	return reply, nil
+}
+
+// GetProofAt returns the value of the instance with the given key as it was
+// right after the block with the given index. The response is verified
+// against the genesis block, and GetProofAtResponse.Verify returns the state
+// change holding the value.
+func (c *Client) GetProofAt(key []byte, index int) (*GetProofAtResponse, error) {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	reply := &GetProofAtResponse{}
+	_, err := c.SendProtobufParallel(c.GetNodes(), &GetProofAt{
+		Version:     CurrentVersion,
+		SkipChainID: c.ID,
+		Key:         key,
+		BlockIndex:  index,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	if _, err := reply.Verify(c.Genesis, key, index); err != nil {
+		return nil, xerrors.Errorf("verifying reply: %v", err)
+	}
+	return reply, nil
+}
+
+// GetReceipt returns the receipt of the transaction with the given hash,
+// which is the hash of its instructions. The receipt tells whether the
+// transaction has been accepted, and holds its state changes and the events
+// emitted by the contracts. It is only known once the transaction is in a
+// block.
+func (c *Client) GetReceipt(txHash []byte) (*GetReceiptResponse, error) {
+	reply := &GetReceiptResponse{}
+	_, err := c.SendProtobufParallel(c.GetNodes(), &GetReceipt{
+		SkipChainID: c.ID,
+		TxHash:      txHash,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	return reply, nil
+}
+
+// QueryInstances returns a page of the instances that match the filters of
+// the query. The Version and SkipChainID of the query are set by the client.
+// To get the next page, the Cursor of the response is copied to the query. If
+// a proof is asked for, it is verified against the genesis block, as well as
+// all the instances of the page.
+func (c *Client) QueryInstances(query QueryInstances) (*QueryInstancesResponse, error) {
+	if query.WithProof && c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			return nil, xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+
+	query.Version = CurrentVersion
+	query.SkipChainID = c.ID
+	reply := &QueryInstancesResponse{}
+	_, err := c.SendProtobufParallel(c.GetNodes(), &query, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	if !query.WithProof {
+		return reply, nil
+	}
+
+	if reply.Proof == nil {
+		return nil, xerrors.New("missing proof")
+	}
+	if err := reply.Proof.VerifyFromBlock(c.Genesis); err != nil {
+		return nil, xerrors.Errorf("verifying proof: %v", err)
+	}
+	if reply.Proof.Latest.Index != reply.Index {
+		return nil, xerrors.New("proof is for another block")
+	}
+	for _, inst := range reply.Instances {
+		value, version, contractID, darcID, err := reply.Proof.GetValues(inst.InstanceID.Slice())
+		if err != nil {
+			return nil, xerrors.Errorf("instance %x: %v", inst.InstanceID[:], err)
+		}
+		if !bytes.Equal(value, inst.Value) || version != inst.Version ||
+			contractID != inst.ContractID || !darcID.Equal(inst.DarcID) {
+			return nil, xerrors.Errorf("instance %x doesn't match the proof",
+				inst.InstanceID[:])
+		}
+	}
+	return reply, nil
+}
+
 // GetProofAfter returns a proof for the key stored in the skipchain
 // starting from the latest known block by this client. The proof will always
 // be newer than the barrier or it will return an error.
@@ -471,6 +611,37 @@ func (c *Client) GetDeferredDataAfter(instrID InstanceID, barrier *skipchain.Ski
 	return &result, nil
 }
 
+// SimulateTransaction asks a node to run the transaction against its current
+// state, without sending it to the leader. The transaction must be signed,
+// but the signer counters are not used up. If the transaction would be
+// refused, the reason is in the Error field of the reply.
+func (c *Client) SimulateTransaction(tx ClientTransaction) (*SimulateTransactionResponse, error) {
+	reply := &SimulateTransactionResponse{}
+	_, err := c.SendProtobufParallel(c.GetNodes(), &SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: c.ID,
+		Transaction: tx,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	return reply, nil
+}
+
+// GetMempool returns the transactions waiting to be included in a block, in
+// the order in which the leader will propose them.
+func (c *Client) GetMempool() (*GetMempoolResponse, error) {
+	reply := &GetMempoolResponse{}
+	_, err := c.SendProtobufParallel(c.GetNodes(), &GetMempool{
+		Version:     CurrentVersion,
+		SkipchainID: c.ID,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	return reply, nil
+}
+
 // CheckAuthorization verifies which actions the given set of identities can
 // execute in the given darc.
 func (c *Client) CheckAuthorization(dID darc.ID, ids ...darc.Identity) ([]darc.Action, error) {
@@ -625,8 +796,21 @@ func (c *Client) WaitProof(id InstanceID, interval time.Duration, value []byte)
 // It contacts any random node by default. A specific node can be chosen by
 // using `c.UseNode`.
 func (c *Client) StreamTransactions(handler func(StreamingResponse, error)) error {
+	return c.StreamFilteredTransactions(nil, 0, handler)
+}
+
+// StreamFilteredTransactions is like StreamTransactions, but only the blocks
+// with transactions matching the filter are sent, with these transactions in
+// StreamingResponse.Transactions. If startIndex is positive, the blocks
+// starting at this index are sent first, or, if it is beyond the latest
+// block, the stream waits for this block. The transactions are verified
+// against the header of their block.
+func (c *Client) StreamFilteredTransactions(filter *StreamingFilter, startIndex int,
+	handler func(StreamingResponse, error)) error {
 	req := StreamingRequest{
-		ID: c.ID,
+		ID:         c.ID,
+		Filter:     filter,
+		StartIndex: startIndex,
 	}
 	n := int(rand.Int31n(int32(len(c.GetNodes()))))
 	if c.options != nil {
@@ -647,15 +831,140 @@ func (c *Client) StreamTransactions(handler func(StreamingResponse, error)) erro
 			return nil
 		}
 
-		if resp.Block.CalculateHash().Equal(resp.Block.Hash) {
-			// send the block only if the integrity is correct
-			handler(resp, nil)
-		} else {
+		if resp.Block == nil || !resp.Block.CalculateHash().Equal(resp.Block.Hash) {
 			err := xerrors.Errorf("got a corrupted block from %v", c.GetNodes()[0])
 			log.Warnf("%+v", err)
 			handler(StreamingResponse{}, err)
+		} else if err := resp.verifyTransactions(filter != nil); err != nil {
+			err = xerrors.Errorf("got corrupted transactions from %v: %v",
+				c.GetNodes()[0], err)
+			log.Warnf("%+v", err)
+			handler(StreamingResponse{}, err)
+		} else {
+			// send the block only if the integrity is correct
+			handler(resp, nil)
+		}
+	}
+}
+
+// SubscribeInstances calls the handler with a proof of every instance that
+// got a new version, was created or was removed, until the client or the
+// service stops. The instances that are newer than the given versions are
+// sent first. This function blocks, and all the proofs are verified against
+// the genesis block.
+//
+// It contacts any random node by default. A specific node can be chosen by
+// using `c.UseNode`.
+func (c *Client) SubscribeInstances(instances []IDVersion,
+	handler func(SubscribeInstancesResponse, error)) error {
+	if c.Genesis == nil {
+		if err := c.fetchGenesis(); err != nil {
+			handler(SubscribeInstancesResponse{}, err)
+			return xerrors.Errorf("fetching genesis block: %v", err)
+		}
+	}
+	req := SubscribeInstances{
+		SkipChainID: c.ID,
+		Instances:   instances,
+	}
+	n := int(rand.Int31n(int32(len(c.GetNodes()))))
+	if c.options != nil {
+		if c.options.DontShuffle {
+			n = c.options.StartNode
 		}
 	}
+
+	conn, err := c.Stream(c.GetNodes()[n], &req)
+	if err != nil {
+		handler(SubscribeInstancesResponse{}, err)
+		return xerrors.Errorf("stream error: %v", err)
+	}
+	for {
+		resp := SubscribeInstancesResponse{}
+		if err := conn.ReadMessage(&resp); err != nil {
+			handler(SubscribeInstancesResponse{}, err)
+			return nil
+		}
+
+		if err := resp.verify(c.Genesis, instances); err != nil {
+			err = xerrors.Errorf("got a corrupted update from %v: %v",
+				c.GetNodes()[n], err)
+			log.Warnf("%+v", err)
+			handler(SubscribeInstancesResponse{}, err)
+			continue
+		}
+		for _, u := range resp.Updates {
+			if c.Latest == nil || c.Latest.Index < u.Proof.Latest.Index {
+				latest := u.Proof.Latest
+				c.Latest = &latest
+			}
+		}
+		handler(resp, nil)
+	}
+}
+
+// verify checks that every proof of the response is valid, and is for one of
+// the instances.
+func (r SubscribeInstancesResponse) verify(genesis *skipchain.SkipBlock,
+	instances []IDVersion) error {
+	for _, u := range r.Updates {
+		var found bool
+		for _, idv := range instances {
+			found = found || idv.ID == u.ID
+		}
+		if !found {
+			return xerrors.Errorf("got an update for %x", u.ID[:])
+		}
+		if err := u.Proof.VerifyFromBlock(genesis); err != nil {
+			return xerrors.Errorf("proof of %x: %v", u.ID[:], err)
+		}
+		if _, err := u.Proof.InclusionProof.Exists(u.ID[:]); err != nil {
+			return xerrors.Errorf("proof of %x: %v", u.ID[:], err)
+		}
+	}
+	return nil
+}
+
+// verifyTransactions checks that the transactions of a filtered response are
+// part of the block.
+func (r StreamingResponse) verifyTransactions(filtered bool) error {
+	if !filtered {
+		return nil
+	}
+	header, err := decodeBlockHeader(r.Block)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+
+	one := []byte{1}
+	zero := []byte{0}
+	h := sha256.New()
+	for _, summary := range r.TxSummaries {
+		h.Write(summary.InstructionsHash)
+		if summary.Accepted {
+			h.Write(one)
+		} else {
+			h.Write(zero)
+		}
+	}
+	if !bytes.Equal(h.Sum(nil), header.ClientTransactionHash) {
+		return xerrors.New("summaries don't match the block")
+	}
+
+	for _, tx := range r.Transactions {
+		if tx.Index < 0 || tx.Index >= len(r.TxSummaries) {
+			return xerrors.Errorf("invalid transaction index %d", tx.Index)
+		}
+		tx.TxResult.ClientTransaction.Instructions.SetVersion(header.Version)
+		summary := r.TxSummaries[tx.Index]
+		if !bytes.Equal(summary.InstructionsHash,
+			tx.TxResult.ClientTransaction.Instructions.Hash()) ||
+			summary.Accepted != tx.TxResult.Accepted {
+			return xerrors.Errorf("transaction %d doesn't match the block",
+				tx.Index)
+		}
+	}
+	return nil
 }
 
 func (c *Client) signerCounterDecoder(buf []byte, data interface{}) error {
@@ -790,6 +1099,168 @@ func (c *Client) DownloadState(byzcoinID skipchain.SkipBlockID, nonce uint64, le
 	return
 }
 
+// ExportState writes the global state of the chain held by the conode si to
+// w, in the format of StateExportWriter. The private key of si is needed to
+// sign the request. All the pages come from the state of the last block
+// applied by the conode, which is described by the returned header.
+func (c *Client) ExportState(w io.Writer, si *network.ServerIdentity) (*StateExportHeader, error) {
+	msg := &ExportState{
+		Version:   CurrentVersion,
+		ByzCoinID: c.ID,
+		Length:    exportStateLength,
+		Timestamp: time.Now().Unix(),
+	}
+	var err error
+	msg.Signature, err = schnorr.Sign(cothority.Suite, si.GetPrivate(),
+		exportMessage(c.ID, msg.Timestamp))
+	if err != nil {
+		return nil, xerrors.Errorf("sign error: %v", err)
+	}
+	reply := &ExportStateResponse{}
+	if err := c.SendProtobuf(si, msg, reply); err != nil {
+		return nil, xerrors.Errorf("request failed: %v", err)
+	}
+	header := reply.Header
+	sw, err := NewStateExportWriter(w, header)
+	if err != nil {
+		return nil, err
+	}
+	msg.Nonce = reply.Nonce
+	for len(reply.Nodes) > 0 {
+		for _, node := range reply.Nodes {
+			if err := sw.Write(node); err != nil {
+				return nil, err
+			}
+		}
+		reply = &ExportStateResponse{}
+		if err := c.SendProtobuf(si, msg, reply); err != nil {
+			return nil, xerrors.Errorf("request failed: %v", err)
+		}
+	}
+	if err := sw.Close(); err != nil {
+		return nil, err
+	}
+	return &header, nil
+}
+
+// ImportLedger creates a new ledger whose genesis state is the state export
+// read from r. The export is sent by pages to every node of the roster, which
+// rebuild the state trie and check it against the block of the export. The
+// first node then creates the genesis block of the new ledger.
+func ImportLedger(r io.ReadSeeker, roster onet.Roster, interval time.Duration, keep bool) (*Client, *CreateGenesisBlockResponse, error) {
+	var c *Client
+	if keep {
+		c = NewClientKeep(nil, roster)
+	} else {
+		c = NewClient(nil, roster)
+	}
+
+	var root []byte
+	for _, si := range roster.List {
+		if _, err := r.Seek(0, io.SeekStart); err != nil {
+			return nil, nil, xerrors.Errorf("rewinding export: %v", err)
+		}
+		sr, err := NewStateExportReader(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		root = sr.Header.TrieRoot
+		if err := importState(c, si, sr); err != nil {
+			return nil, nil, xerrors.Errorf("importing state to %v: %v", si, err)
+		}
+	}
+
+	reply := &CreateGenesisBlockResponse{}
+	err := c.SendProtobuf(roster.List[0], &CreateImportedGenesisBlock{
+		Version:       CurrentVersion,
+		Roster:        roster,
+		BlockInterval: interval,
+		TrieRoot:      root,
+	}, reply)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("client request: %v", err)
+	}
+	sb := reply.Skipblock
+	if sb == nil || !sb.CalculateHash().Equal(sb.Hash) {
+		return nil, nil, xerrors.New("got a corrupted block")
+	}
+	ok, err := sb.Roster.Equal(&roster)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("roster comparison: %v", err)
+	}
+	if !ok {
+		return nil, nil, xerrors.New("wrong roster in genesis block")
+	}
+
+	c.ID = sb.Hash
+	c.Genesis = sb
+	c.Latest = c.Genesis
+	return c, reply, nil
+}
+
+// importState sends the nodes of the export to si, by pages.
+func importState(c *Client, si *network.ServerIdentity, sr *StateExportReader) error {
+	msg := &ImportState{
+		Version: CurrentVersion,
+		Header:  &sr.Header,
+	}
+	for !msg.End {
+		node, err := sr.Next()
+		if err == io.EOF {
+			msg.End = true
+		} else if err != nil {
+			return err
+		} else {
+			msg.Nodes = append(msg.Nodes, *node)
+		}
+		if !msg.End && len(msg.Nodes) < exportStateLength {
+			continue
+		}
+		reply := &ImportStateResponse{}
+		if err := c.SendProtobuf(si, msg, reply); err != nil {
+			return xerrors.Errorf("request failed: %v", err)
+		}
+		msg.Nonce = reply.Nonce
+		msg.Header = nil
+		msg.Nodes = nil
+	}
+	return nil
+}
+
+// GetSnapshots returns the snapshots of the state trie held by the first
+// node of the client that answers.
+func (c *Client) GetSnapshots() (*GetSnapshotsResponse, error) {
+	reply := &GetSnapshotsResponse{}
+	_, err := c.SendProtobufParallel(c.GetNodes(), &GetSnapshots{
+		Version:     CurrentVersion,
+		SkipchainID: c.ID,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	return reply, nil
+}
+
+// DownloadSnapshot returns at most length bytes of the snapshot with the
+// given hash, starting at offset. As the snapshots are addressed by their
+// content, any node holding the snapshot can answer.
+func (c *Client) DownloadSnapshot(hash []byte, offset int64, length int) (*DownloadSnapshotResponse, error) {
+	if length <= 0 {
+		return nil, xerrors.New("invalid parameter")
+	}
+	reply := &DownloadSnapshotResponse{}
+	_, err := c.SendProtobufParallel(c.GetNodes(), &DownloadSnapshot{
+		ByzCoinID: c.ID,
+		Hash:      hash,
+		Offset:    offset,
+		Length:    length,
+	}, reply, c.options)
+	if err != nil {
+		return nil, xerrors.Errorf("request: %v", err)
+	}
+	return reply, nil
+}
+
 // ResolveInstanceID resolves the instance ID using the given darc ID and name.
 // The name must be already set by calling the naming contract.
 func (c *Client) ResolveInstanceID(darcID darc.ID, name string) (InstanceID, error) {
@@ -892,6 +1363,26 @@ func DebugRemove(si *network.ServerIdentity, byzcoinID skipchain.SkipBlockID) er
 	return cothority.ErrorOrNil(err, "request failed")
 }
 
+// Handover asks the conode, which must be the leader of the chain, to give
+// the leadership to the next node of the roster. The private key of si is
+// needed to sign the request. It returns the new leader.
+func Handover(si *network.ServerIdentity, byzcoinID skipchain.SkipBlockID) (*network.ServerIdentity, error) {
+	sig, err := schnorr.Sign(cothority.Suite, si.GetPrivate(), byzcoinID)
+	if err != nil {
+		return nil, xerrors.Errorf("sign error: %v", err)
+	}
+	request := &HandoverRequest{
+		ByzCoinID: byzcoinID,
+		Signature: sig,
+	}
+	reply := &HandoverResponse{}
+	err = onet.NewClient(cothority.Suite, ServiceName).SendProtobuf(si, request, reply)
+	if err != nil {
+		return nil, xerrors.Errorf("request failed: %v", err)
+	}
+	return reply.Leader, nil
+}
+
 // DefaultGenesisMsg creates the message that is used to for creating the
 // genesis Darc and block. It will contain rules for spawning and evolving the
 // darc contract.
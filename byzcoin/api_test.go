@@ -239,6 +239,174 @@ func TestClient_Streaming(t *testing.T) {
 	require.NoError(t, c1.Close())
 }
 
+// A filtered stream only gets the matching transactions, starting with the
+// older blocks.
+func TestClient_StreamFiltered(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	dummy := func() {
+		b.SendInst(nil, createSpawnInstr(b.GenesisDarc.GetBaseID(),
+			DummyContractName, "data", []byte{1}))
+	}
+	addDummyTxs(b, 2, 1)
+	dummy()
+	addDummyTxs(b, 1, 1)
+
+	c1 := NewClientKeep(b.Genesis.Hash, *b.Roster)
+	responses := make(chan StreamingResponse, 10)
+	filter := &StreamingFilter{ContractIDs: []string{DummyContractName}}
+	go func() {
+		err := c1.StreamFilteredTransactions(filter, 1, func(resp StreamingResponse, err error) {
+			if err == nil {
+				responses <- resp
+			}
+		})
+		require.NoError(t, err)
+	}()
+
+	checkResponse := func(index int) {
+		select {
+		case resp := <-responses:
+			require.Equal(t, index, resp.Block.Index)
+			require.Empty(t, resp.Block.Payload)
+			require.Equal(t, 1, len(resp.Transactions))
+			require.Equal(t, 1, len(resp.TxSummaries))
+			instr := resp.Transactions[0].TxResult.ClientTransaction.Instructions[0]
+			require.Equal(t, DummyContractName, instr.ContractID())
+		case <-time.After(10 * b.GenesisMessage.BlockInterval):
+			require.Fail(t, "didn't get the transaction")
+		}
+	}
+	checkResponse(3)
+
+	// New blocks are filtered the same way.
+	addDummyTxs(b, 1, 1)
+	dummy()
+	checkResponse(6)
+
+	require.NoError(t, c1.Close())
+	select {
+	case resp := <-responses:
+		require.Fail(t, "got an unexpected block", "index %d", resp.Block.Index)
+	default:
+	}
+
+	// A response that doesn't match its block is refused.
+	resp := StreamingResponse{Block: b.Genesis, TxSummaries: []TxSummary{{}}}
+	require.Error(t, resp.verifyTransactions(true))
+}
+
+// TestClient_StreamFuture starts a stream at a block that doesn't exist yet.
+func TestClient_StreamFuture(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	reply, err := b.Client.GetProofFromLatest(ConfigInstanceID.Slice())
+	require.NoError(t, err)
+	start := reply.Proof.Latest.Index + 2
+
+	c1 := NewClientKeep(b.Genesis.Hash, *b.Roster)
+	responses := make(chan StreamingResponse, 10)
+	go func() {
+		err := c1.StreamFilteredTransactions(nil, start, func(resp StreamingResponse, err error) {
+			if err == nil {
+				responses <- resp
+			}
+		})
+		require.NoError(t, err)
+	}()
+
+	// Wait for the stream to be registered before adding the blocks.
+	listening := func() bool {
+		for _, s := range b.Services {
+			s.streamingMan.Lock()
+			n := len(s.streamingMan.listeners[string(b.Genesis.Hash)])
+			s.streamingMan.Unlock()
+			if n > 0 {
+				return true
+			}
+		}
+		return false
+	}
+	for i := 0; !listening(); i++ {
+		require.True(t, i < 100, "stream not registered")
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	for index := start - 1; index <= start; index++ {
+		addDummyTxs(b, 1, 1)
+	}
+	select {
+	case resp := <-responses:
+		require.Equal(t, start, resp.Block.Index)
+	case <-time.After(10 * b.GenesisMessage.BlockInterval):
+		require.Fail(t, "didn't get the block")
+	}
+	require.NoError(t, c1.Close())
+}
+
+func TestClient_SubscribeInstances(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	evolve := func(prev *darc.Darc) *darc.Darc {
+		d := prev.Copy()
+		require.NoError(t, d.EvolveFrom(prev))
+		b.EvolveDarc(nil, d)
+		return d
+	}
+	genesisID := NewInstanceID(b.GenesisDarc.GetBaseID())
+	evolved := evolve(b.GenesisDarc)
+	ids := []darc.Identity{b.Signer.Identity()}
+	newDarc := darc.NewDarc(darc.InitRules(ids, ids), []byte("new"))
+	newID := NewInstanceID(newDarc.GetBaseID())
+
+	c1 := NewClientKeep(b.Genesis.Hash, *b.Roster)
+	updates := make(chan InstanceUpdate, 10)
+	go func() {
+		err := c1.SubscribeInstances([]IDVersion{{ID: genesisID}, {ID: newID}},
+			func(resp SubscribeInstancesResponse, err error) {
+				if err == nil {
+					for _, u := range resp.Updates {
+						updates <- u
+					}
+				}
+			})
+		require.NoError(t, err)
+	}()
+
+	checkUpdate := func(id InstanceID, version uint64) {
+		select {
+		case u := <-updates:
+			require.Equal(t, id, u.ID)
+			require.True(t, u.Proof.InclusionProof.Match(id[:]))
+			v, _, _, err := u.Proof.Get(id[:])
+			require.NoError(t, err)
+			var d darc.Darc
+			require.NoError(t, protobuf.Decode(v, &d))
+			require.Equal(t, version, d.Version)
+		case <-time.After(10 * b.GenesisMessage.BlockInterval):
+			require.Fail(t, "didn't get the update")
+		}
+	}
+	// The genesis darc has been evolved before the subscription.
+	checkUpdate(genesisID, 1)
+	evolve(evolved)
+	checkUpdate(genesisID, 2)
+	b.SpawnDarc(nil, newDarc)
+	checkUpdate(newID, 0)
+
+	// Other instances don't trigger an update.
+	addDummyTxs(b, 1, 1)
+	require.NoError(t, c1.Close())
+	select {
+	case u := <-updates:
+		require.Fail(t, "got an unexpected update", "%x", u.ID[:])
+	default:
+	}
+}
+
 func TestClient_NoPhantomSkipchain(t *testing.T) {
 	b := newBCTRun(t, nil)
 	defer b.CloseAll()
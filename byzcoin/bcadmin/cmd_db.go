@@ -19,6 +19,7 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/cothority/v3/storage"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -138,6 +139,7 @@ func dbReplay(c *cli.Context) error {
 
 	log.Lvl2("Copying blocks to skipchain's DB")
 	fb.skipchain.GetDB().DB = fb.db.DB
+	fb.skipchain.GetDB().BlockStore = fb.db.BlockStore
 
 	log.Info("Replaying blocks")
 	rso := byzcoin.ReplayStateOptions{
@@ -154,24 +156,22 @@ func dbReplay(c *cli.Context) error {
 	log.Info("Successfully checked and replayed all blocks.")
 	if c.Bool("write") {
 		log.Info("Writing new stateTrie to DB")
-		err := fb.boltDB.Update(func(tx *bbolt.Tx) error {
-			if tx.Bucket(fb.trieBucketName) != nil {
-				err := tx.DeleteBucket(fb.trieBucketName)
-				if err != nil {
-					return fmt.Errorf("while deleting bucket: %v", err)
-				}
-			}
-			bucket, err := tx.CreateBucket(fb.trieBucketName)
-			if err != nil {
-				return fmt.Errorf("while creating bucket: %v", err)
-			}
-			return st.View(func(b trie.Bucket) error {
-				return b.ForEach(func(k, v []byte) error {
-					return bucket.Put(k, v)
-				})
-			})
+		err := storage.DeleteBucket(fb.boltDB, fb.trieBucketName)
+		if err != nil && err != bbolt.ErrBucketNotFound {
+			return fmt.Errorf("while deleting bucket: %v", err)
+		}
+		err = fb.boltDB.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucket(fb.trieBucketName)
+			return err
 		})
 		if err != nil {
+			return fmt.Errorf("while creating bucket: %v", err)
+		}
+		dst, err := storage.Open(fb.boltDB, fb.trieBucketName)
+		if err != nil {
+			return fmt.Errorf("couldn't open bucket: %v", err)
+		}
+		if _, err := copyStore(dst, st); err != nil {
 			return fmt.Errorf("couldn't update bucket: %v", err)
 		}
 	}
@@ -421,8 +421,7 @@ func dbRemove(c *cli.Context) error {
 
 	// Checking the removal of blocks will not lead to an unrecoverable state
 	// of the node.
-	tr := trie.NewDiskDB(fb.boltDB, fb.trieBucketName)
-	err = tr.View(func(b trie.Bucket) error {
+	err = fb.trieDB.View(func(b trie.Bucket) error {
 		buf := b.Get([]byte("trieIndexKey"))
 		if buf == nil {
 			return errors.New("couldn't get index key")
@@ -732,7 +731,10 @@ func newFetchBlocks(c *cli.Context) (*fetchBlocks,
 	}
 
 	fb.trieBucketName = []byte(fmt.Sprintf("ByzCoin_%x", *fb.bcID))
-	fb.trieDB = trie.NewDiskDB(fb.boltDB, fb.trieBucketName)
+	fb.trieDB, err = storage.Open(fb.boltDB, fb.trieBucketName)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't open trie: %+v", err)
+	}
 	fb.cl = skipchain.NewClient()
 
 	return fb, nil
@@ -839,15 +841,18 @@ func (fb *fetchBlocks) openDB(name string) (*skipchain.SkipBlockDB,
 	if err != nil {
 		return nil, nil, xerrors.Errorf("couldn't open db: %+v", err)
 	}
-	bucketName := []byte("Skipchain_skipblocks")
 	err = db.Update(func(tx *bbolt.Tx) error {
-		_, err := tx.CreateBucketIfNotExists(bucketName)
+		_, err := tx.CreateBucketIfNotExists(skipblocksBucket)
 		return err
 	})
 	if err != nil {
 		return nil, nil, xerrors.Errorf("couldn't create bucket: %+v", err)
 	}
-	return skipchain.NewSkipBlockDB(db, bucketName), db, nil
+	sdb, err := skipchain.OpenSkipBlockDB(db, skipblocksBucket)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't open blocks: %+v", err)
+	}
+	return sdb, db, nil
 }
 
 func (fb *fetchBlocks) setNode(i int) {
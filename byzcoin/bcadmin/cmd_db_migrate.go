@@ -0,0 +1,129 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/urfave/cli"
+	"go.dedis.ch/cothority/v3/storage"
+	"go.dedis.ch/onet/v3/log"
+	"go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// skipblocksBucket is the bucket of the skipchain service holding the blocks
+// in a conode db.
+var skipblocksBucket = []byte("Skipchain_skipblocks")
+
+// receiptsBucket is the bucket of the ByzCoin service holding the receipts of
+// the transactions in a conode db.
+var receiptsBucket = []byte("ByzCoin_receipts")
+
+// trieBucket matches the buckets of the ByzCoin service holding the state
+// tries in a conode db.
+var trieBucket = regexp.MustCompile("^ByzCoin_[0-9a-f]{64}$")
+
+// migrateBatch is the number of keys copied in one transaction.
+const migrateBatch = 1000
+
+// dbMigrate copies the blocks, the state tries and the receipts of a conode
+// db to another storage engine. The keys in the source engine are left untouched.
+func dbMigrate(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return xerrors.New("please give the following arguments: conode.db")
+	}
+	name := c.Args().First()
+	to := c.String("to")
+	if to != storage.EngineLevelDB && to != storage.EngineBBolt {
+		return xerrors.Errorf("unknown storage engine %s", to)
+	}
+
+	boltDB, err := bbolt.Open(name, 0600, nil)
+	if err != nil {
+		return xerrors.Errorf("couldn't open db: %+v", err)
+	}
+	defer boltDB.Close()
+	buckets := [][]byte{skipblocksBucket, receiptsBucket}
+	err = boltDB.Update(func(tx *bbolt.Tx) error {
+		for _, bucket := range buckets {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
+		}
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if trieBucket.Match(name) {
+				buckets = append(buckets, append([]byte{}, name...))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return xerrors.Errorf("couldn't read buckets: %+v", err)
+	}
+
+	for _, bucket := range buckets {
+		boltStore := storage.NewBoltDB(boltDB, bucket)
+		levelStore, err := storage.OpenLevelDB(storage.LevelDBPath(name), bucket)
+		if err != nil {
+			return xerrors.Errorf("couldn't open leveldb: %+v", err)
+		}
+		src, dst := boltStore, levelStore
+		if to == storage.EngineBBolt {
+			src, dst = levelStore, boltStore
+		}
+
+		log.Infof("Copying %s to %s", bucket, to)
+		keys, err := copyStore(dst, src)
+		levelStore.Close()
+		if err != nil {
+			return xerrors.Errorf("couldn't copy %s: %+v", bucket, err)
+		}
+		log.Infof("Copied %d keys of %s", keys, bucket)
+	}
+	log.Infof("Set the engine of the conode to %s to use them:\n\n"+
+		"[Storage]\n  Engine = \"%s\"", to, to)
+	return nil
+}
+
+// copyStore copies all the key/value pairs of src to dst, and returns how
+// many have been copied.
+func copyStore(dst, src storage.DB) (int, error) {
+	var count int
+	var keys, values [][]byte
+	flush := func() error {
+		err := dst.Update(func(b storage.Bucket) error {
+			for i := range keys {
+				if err := b.Put(keys[i], values[i]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return xerrors.Errorf("couldn't write keys: %v", err)
+		}
+		count += len(keys)
+		keys, values = nil, nil
+		if count%(10*migrateBatch) == 0 {
+			log.Info("Copied", count, "keys")
+		}
+		return nil
+	}
+
+	err := src.View(func(b storage.Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, append([]byte{}, k...))
+			values = append(values, append([]byte{}, v...))
+			if len(keys) < migrateBatch {
+				return nil
+			}
+			return flush()
+		})
+	})
+	if err != nil {
+		return count, err
+	}
+	if len(keys) > 0 {
+		err = flush()
+	}
+	return count, err
+}
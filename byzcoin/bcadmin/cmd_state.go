@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/urfave/cli"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/lib"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3/app"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// stateExport writes the state trie held by a conode to a file. The request
+// is signed with the private key of the conode.
+func stateExport(c *cli.Context) error {
+	bcArg := c.String("bc")
+	if bcArg == "" {
+		return xerrors.New("--bc flag is required")
+	}
+	if c.NArg() < 2 {
+		return xerrors.New("please give the following arguments: private.toml state.json")
+	}
+
+	_, cl, err := lib.LoadConfig(bcArg)
+	if err != nil {
+		return xerrors.Errorf("couldn't load config: %v", err)
+	}
+	ccfg, err := app.LoadCothority(c.Args().First())
+	if err != nil {
+		return err
+	}
+	si, err := ccfg.GetServerIdentity()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(c.Args().Get(1))
+	if err != nil {
+		return xerrors.Errorf("couldn't create file: %v", err)
+	}
+	header, err := cl.ExportState(f, si)
+	if err != nil {
+		f.Close()
+		return xerrors.Errorf("couldn't export the state: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return xerrors.Errorf("couldn't write file: %v", err)
+	}
+
+	log.Infof("Exported the state of block %d with trie root %x.",
+		header.BlockIndex, header.TrieRoot)
+	return nil
+}
+
+// stateImport creates a new chain whose genesis state is read from a file.
+func stateImport(c *cli.Context) error {
+	if c.NArg() < 1 {
+		return xerrors.New("please give the following arguments: state.json")
+	}
+	fn := c.String("roster")
+	if fn == "" {
+		return xerrors.New("--roster flag is required")
+	}
+	r, err := lib.ReadRoster(fn)
+	if err != nil {
+		return err
+	}
+
+	// The identity is only known if the config of the exported chain is
+	// given.
+	var adminIdentity darc.Identity
+	if bcArg := c.String("bc"); bcArg != "" {
+		cfg, _, err := lib.LoadConfig(bcArg)
+		if err != nil {
+			return xerrors.Errorf("couldn't load config: %v", err)
+		}
+		adminIdentity = cfg.AdminIdentity
+	}
+
+	f, err := os.Open(c.Args().First())
+	if err != nil {
+		return xerrors.Errorf("couldn't open file: %v", err)
+	}
+	cl, resp, err := byzcoin.ImportLedger(f, *r, c.Duration("interval"), false)
+	f.Close()
+	if err != nil {
+		return xerrors.Errorf("couldn't import the state: %v", err)
+	}
+
+	// The admin darc is the one guarding the config.
+	pr, err := cl.GetProofFromLatest(byzcoin.ConfigInstanceID.Slice())
+	if err != nil {
+		return xerrors.Errorf("couldn't get the config: %v", err)
+	}
+	_, _, _, darcID, err := pr.Proof.KeyValue()
+	if err != nil {
+		return xerrors.Errorf("couldn't read the config: %v", err)
+	}
+	adminDarc, err := lib.GetDarcByID(cl, darcID)
+	if err != nil {
+		return xerrors.Errorf("couldn't get the admin darc: %v", err)
+	}
+
+	cfg := lib.Config{
+		ByzCoinID:     resp.Skipblock.SkipChainID(),
+		Roster:        *r,
+		AdminDarc:     *adminDarc,
+		AdminIdentity: adminIdentity,
+	}
+	fn, err = lib.SaveConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Created ByzCoin with ID %x.\n", cfg.ByzCoinID)
+	fmt.Printf("\nexport BC=\"%s\"\n", fn)
+
+	// For the tests to use.
+	c.App.Metadata["BC"] = fn
+
+	return lib.WaitPropagation(c, cl)
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/urfave/cli"
 	"go.dedis.ch/cothority/v3/byzcoin/bcadmin/clicontracts"
+	"go.dedis.ch/cothority/v3/storage"
 )
 
 // PLEASE READ THIS
@@ -32,6 +33,28 @@ var cmds = cli.Commands{
 				Name:  "blockSize",
 				Usage: "adjust the maximum block size",
 			},
+			cli.StringFlag{
+				Name:  "rotation",
+				Usage: "rotate the leader every epoch: roundrobin, weighted or none",
+			},
+			cli.IntFlag{
+				Name:  "epoch",
+				Value: 10,
+				Usage: "number of blocks of an epoch of the leader rotation",
+			},
+			cli.StringFlag{
+				Name:  "weights",
+				Usage: "epochs in a row of every node of the roster for the weighted rotation, for example 3,1,1",
+			},
+			cli.StringFlag{
+				Name:  "evictAfter",
+				Usage: "propose to evict the nodes unresponsive for this duration, for example 24h, or 0 to turn it off",
+			},
+			cli.StringFlag{
+				Name:  "healthTimeout",
+				Value: "10s",
+				Usage: "how long the leader waits for the nodes when checking their connectivity",
+			},
 		},
 	},
 
@@ -622,6 +645,9 @@ var cmds = cli.Commands{
 		Usage:     "interact with byzcoin for debugging",
 		Aliases:   []string{"d"},
 		ArgsUsage: "conode.db [byzCoinID]",
+		Before: func(c *cli.Context) error {
+			return storage.SetEngine(c.String("engine"))
+		},
 		Subcommands: cli.Commands{
 			{
 				Name:   "status",
@@ -759,6 +785,28 @@ var cmds = cli.Commands{
 					},
 				},
 			},
+			{
+				Name: "migrate",
+				Usage: "Copy the blocks and state tries of a stopped conode to" +
+					" another storage engine",
+				ArgsUsage: "conode.db",
+				Action:    dbMigrate,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "to",
+						Usage: "storage engine to copy to: leveldb or bbolt",
+						Value: "leveldb",
+					},
+				},
+			},
+		},
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name: "engine",
+				Usage: "storage engine of the conode.db, as in the Storage" +
+					" section of its config: bbolt or leveldb",
+				Value: storage.EngineBBolt,
+			},
 		},
 	},
 
@@ -1028,6 +1076,52 @@ var cmds = cli.Commands{
 				Usage:     "Set a specific node to be the leader",
 				Action:    rosterLeader,
 			},
+			{
+				Name:      "handover",
+				ArgsUsage: "bc-xxx.cfg private.toml",
+				Usage:     "Ask the leader to hand over to the next node of the roster",
+				Action:    rosterHandover,
+			},
+		},
+	},
+
+	{
+		Name:  "state",
+		Usage: "export the state of a ByzCoin or create a new one from it",
+		Subcommands: cli.Commands{
+			{
+				Name:      "export",
+				Usage:     "write the state trie held by a conode to a file",
+				ArgsUsage: "private.toml state.json",
+				Action:    stateExport,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:   "bc",
+						EnvVar: "BC",
+						Usage:  "the ByzCoin config to use (required)",
+					},
+				},
+			},
+			{
+				Name:      "import",
+				Usage:     "create a new ByzCoin whose genesis state is read from a file",
+				ArgsUsage: "state.json",
+				Action:    stateImport,
+				Flags: []cli.Flag{
+					cli.StringFlag{
+						Name:  "roster",
+						Usage: "the roster of the new ByzCoin (required)",
+					},
+					cli.DurationFlag{
+						Name:  "interval",
+						Usage: "the block interval of the new ByzCoin (default: the exported one)",
+					},
+					cli.StringFlag{
+						Name:  "bc",
+						Usage: "the config of the exported ByzCoin, to reuse its admin identity",
+					},
+				},
+			},
 		},
 	},
 
@@ -591,6 +591,18 @@ func config(c *cli.Context) error {
 		}
 		chainConfig.MaxBlockSize = blockSize
 	}
+	if c.String("rotation") != "" {
+		chainConfig.LeaderRotation, err = leaderRotation(c, chainConfig.Roster)
+		if err != nil {
+			return err
+		}
+	}
+	if c.String("evictAfter") != "" {
+		chainConfig.RosterHealth, err = rosterHealth(c)
+		if err != nil {
+			return err
+		}
+	}
 
 	err = updateConfig(cl, signer, chainConfig)
 	if err != nil {
@@ -602,6 +614,58 @@ func config(c *cli.Context) error {
 	return lib.WaitPropagation(c, cl)
 }
 
+// leaderRotation returns the rotation of the leader given by the flags. The
+// weights are given in the order of the current roster.
+func leaderRotation(c *cli.Context, roster onet.Roster) (*byzcoin.LeaderRotation, error) {
+	lr := &byzcoin.LeaderRotation{EpochLength: c.Int("epoch")}
+	switch c.String("rotation") {
+	case "none":
+		return nil, nil
+	case "roundrobin":
+		lr.Policy = byzcoin.RotationRoundRobin
+	case "weighted":
+		lr.Policy = byzcoin.RotationWeighted
+	default:
+		return nil, xerrors.Errorf("unknown rotation policy: %s",
+			c.String("rotation"))
+	}
+
+	if weights := c.String("weights"); weights != "" {
+		ws := strings.Split(weights, ",")
+		if len(ws) > len(roster.List) {
+			return nil, xerrors.New("more weights than nodes in the roster")
+		}
+		for i, w := range ws {
+			weight, err := strconv.Atoi(strings.TrimSpace(w))
+			if err != nil {
+				return nil, xerrors.Errorf("couldn't parse weight: %v", err)
+			}
+			lr.Weights = append(lr.Weights, byzcoin.LeaderWeight{
+				Node:   roster.List[i].ID,
+				Weight: weight,
+			})
+		}
+	}
+	return lr, nil
+}
+
+// rosterHealth returns the roster health management given by the flags. An
+// eviction threshold of 0 turns it off.
+func rosterHealth(c *cli.Context) (*byzcoin.RosterHealth, error) {
+	threshold, err := time.ParseDuration(c.String("evictAfter"))
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't parse evictAfter: %v", err)
+	}
+	if threshold == 0 {
+		return nil, nil
+	}
+	timeout, err := time.ParseDuration(c.String("healthTimeout"))
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't parse healthTimeout: %v", err)
+	}
+	return &byzcoin.RosterHealth{Threshold: threshold, Timeout: timeout}, nil
+}
+
 func mint(c *cli.Context) error {
 	if c.NArg() < 4 {
 		return xerrors.New("please give the following arguments: " +
@@ -870,6 +934,33 @@ func rosterLeader(c *cli.Context) error {
 	return lib.WaitPropagation(c, cl)
 }
 
+func rosterHandover(c *cli.Context) error {
+	if c.NArg() < 2 {
+		return xerrors.New("please give the following arguments: " +
+			"bc-xxx.cfg private.toml")
+	}
+	cfg, _, err := lib.LoadConfig(c.Args().First())
+	if err != nil {
+		return err
+	}
+	ccfg, err := app.LoadCothority(c.Args().Get(1))
+	if err != nil {
+		return err
+	}
+	si, err := ccfg.GetServerIdentity()
+	if err != nil {
+		return err
+	}
+
+	leader, err := byzcoin.Handover(si, cfg.ByzCoinID)
+	if err != nil {
+		return xerrors.Errorf("couldn't hand over: %v", err)
+	}
+	log.Infof("%s handed over the leadership to %s", si.Address,
+		leader.Address)
+	return nil
+}
+
 func key(c *cli.Context) error {
 	if f := c.String("print"); f != "" {
 		sig, err := lib.LoadSigner(f)
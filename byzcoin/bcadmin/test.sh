@@ -30,6 +30,7 @@ main(){
     run testReset
     run testDbReplay
     run testDbMerge
+    run testDbMigrate
     run testDbCatchup
     run testDebugBlock
     run testLink
@@ -37,6 +38,7 @@ main(){
     run testCoin
     run testRoster
     run testCreateStoreRead
+    run testState
     run testAddDarc
     run testDarcAddDeferred
     run testDarcAddRuleMinimum
@@ -119,6 +121,41 @@ testDbMerge(){
   testGrep "Last block is: 3" runBA0 db status conode.db $bcID
 }
 
+testDbMigrate(){
+  rm -f config/*
+  runCoBG 1 2 3
+  testOK runBA create public.toml --interval .5s
+  bc=$( echo config/bc*cfg )
+  key=$( echo config/key*cfg )
+  bcID=$( echo $bc | sed -e "s/.*bc-\(.*\).cfg/\1/" )
+  keyPub=$( echo $key | sed -e "s/.*:\(.*\).cfg/\1/" )
+
+  db=$( ls $CONODE_SERVICE_PATH/*.db | head -n 1 )
+  pkill conode 2> /dev/null
+  for n in 1 2 3; do
+    cp co$n/private.toml co$n/private.toml.bak
+    printf '\n[Storage]\n  Engine = "leveldb"\n' >> co$n/private.toml
+  done
+
+  # The conodes and bcadmin refuse to use leveldb before the migration.
+  testFail runCo 1 server
+  testFail runBA db --engine leveldb status $db $bcID
+  testFail runBA db migrate --to badger $db
+  testFail runBA db --engine badger status $db $bcID
+  for d in $CONODE_SERVICE_PATH/*.db; do
+    testOK runBA db migrate --to leveldb $d
+  done
+  testGrep "Last block is: 0" runBA0 db --engine leveldb status $db $bcID
+
+  runCoBG 1 2 3
+  testOK runBA mint $bc $key $keyPub 1000
+  pkill conode 2> /dev/null
+  for n in 1 2 3; do
+    mv co$n/private.toml.bak co$n/private.toml
+  done
+  testGrep "Last block is: 3" runBA0 db --engine leveldb status $db $bcID
+}
+
 testDbCatchup(){
   rm -f config/*
   runCoBG 1 2 3
@@ -266,6 +303,18 @@ testRoster(){
   testFail runBA roster leader $bc $key co1/public.toml
   testOK runBA roster leader $bc $key co3/public.toml
   testGrep "Roster: tls://localhost:2006" runBA0 latest -server 2 $bc
+
+  # Only the leader can hand over to the next node
+  testFail runBA roster handover $bc co1/private.toml
+  testOK runBA roster handover $bc co3/private.toml
+  testGrep "Roster: tls://localhost:2002" runBA0 latest -server 2 $bc
+  testFail runBA config --rotation sometimes $bc $key
+  testFail runBA config --rotation weighted --weights 1,0 $bc $key
+  testOK runBA config --rotation weighted --epoch 5 --weights 2,1 $bc $key
+  testOK runBA config --rotation none $bc $key
+  testFail runBA config --evictAfter soon $bc $key
+  testOK runBA config --evictAfter 24h --healthTimeout 5s $bc $key
+  testOK runBA config --evictAfter 0 $bc $key
 }
 
 
@@ -310,6 +359,29 @@ testCreateStoreRead(){
   testGrep "ByzCoinID: $bcid" runBA0 latest
 }
 
+testState(){
+  runCoBG 1 2 3
+  runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
+  eval $SED
+  [ -z "$BC" ] && exit 1
+  testOK runBA darc add -out_id ./darc_id.txt
+  ID=`cat ./darc_id.txt`
+
+  testFail runBA state export state.json
+  testOK runBA state export co1/private.toml state.json
+  testGrep "byzcoin-state-export/2" cat state.json
+
+  OLDBC=$BC
+  testFail runBA state import state.json
+  runGrepSed "export BC=" "" runBA state import --roster public.toml \
+    --bc $OLDBC state.json
+  eval $SED
+  [ "$BC" = "$OLDBC" ] && exit 1
+  # The darc and the admin key are the same on the new chain.
+  testGrep "${ID:5:${#ID}-0}" runBA0 darc show --darc "$ID"
+  testOK runBA darc add
+}
+
 testAddDarc(){
   runCoBG 1 2 3
   runGrepSed "export BC=" "" runBA create --roster public.toml --interval .5s
@@ -180,39 +180,11 @@ func (c *contractDeferred) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins
 	case "addProof":
 		// This invocation appends the identity and the corresponding signature,
 		// which is based on the stored instruction hash (in instructionHashes)
-
-		// Get the given index
-		indexBuf := inst.Invoke.Args.Search("index")
-		if indexBuf == nil {
-			return nil, nil, xerrors.New("index args is nil")
-		}
-		index := binary.LittleEndian.Uint32(indexBuf)
-
-		// Check if the index is in range
-		numInstruction := len(c.DeferredData.ProposedTransaction.Instructions)
-		if index >= uint32(numInstruction) {
-			return nil, nil, xerrors.Errorf("index is out of range (%d >= %d)", index, numInstruction)
-		}
-
-		// Get the given Identity
-		identityBuf := inst.Invoke.Args.Search("identity")
-		if identityBuf == nil {
-			return nil, nil, xerrors.New("identity args is nil")
-		}
-		identity := darc.Identity{}
-		err = protobuf.Decode(identityBuf, &identity)
+		err = addProof(c.DeferredData.ProposedTransaction.Instructions,
+			c.DeferredData.InstructionHashes, inst.Invoke.Args)
 		if err != nil {
-			return nil, nil, xerrors.New("couldn't decode Identity")
-		}
-
-		// Get the given signature
-		signature := inst.Invoke.Args.Search("signature")
-		if signature == nil {
-			return nil, nil, xerrors.New("signature args is nil")
+			return nil, nil, xerrors.Errorf("adding proof: %v", err)
 		}
-		// Update the contract's data with the given signature and identity
-		c.DeferredData.ProposedTransaction.Instructions[index].SignerIdentities = append(c.DeferredData.ProposedTransaction.Instructions[index].SignerIdentities, identity)
-		c.DeferredData.ProposedTransaction.Instructions[index].Signatures = append(c.DeferredData.ProposedTransaction.Instructions[index].Signatures, signature)
 		// Save and send the modifications
 		cosiDataBuf, err2 := protobuf.Encode(&c.DeferredData)
 		if err2 != nil {
@@ -225,69 +197,12 @@ func (c *contractDeferred) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins
 		// This invocation tries to execute the transaction stored with the
 		// "Spawn" invocation. If it is successful, this invocation fills the
 		// "ExecResult" field of the "deferredData" struct.
-		// We couldn't successfully re-use one of the already implemented
-		// method like the "processOneTx" one because it involved quite a lot
-		// of changes and would bring more complexity compared to the benefits.
-
-		instructionIDs := make([][]byte, len(c.DeferredData.ProposedTransaction.Instructions))
-
-		for i, proposedInstr := range c.DeferredData.ProposedTransaction.Instructions {
-
-			// In case it goes well, we want to return the proposed Tx InstanceID
-			instructionIDs[i] = proposedInstr.DeriveID("").Slice()
-
-			instructionType := proposedInstr.GetType()
-
-			// Here we instantiate the contract from the state trie by getting
-			// its buferred data and then calling its constructor.
-			contractBuf, _, contractID, _, err := rst.GetValues(proposedInstr.InstanceID.Slice())
-			if err != nil {
-				return nil, nil, xerrors.Errorf("couldn't get contract buf: %v", err)
-			}
-			// Get the contract's constructor (like "contractValueFromByte(...)")
-			if c.contracts == nil {
-				return nil, nil, xerrors.New("contracts registry is missing due to bad initialization")
-			}
-
-			fn, exists := c.contracts.Search(contractID)
-			if !exists {
-				return nil, nil, xerrors.New("couldn't get the root function")
-			}
-			// Invoke the contructor and get the contract's instance
-			contract, err := fn(contractBuf)
-			if err != nil {
-				return nil, nil, xerrors.Errorf("couldn't get the root contract: %v", err)
-			}
-			if cwr, ok := contract.(ContractWithRegistry); ok {
-				cwr.SetRegistry(c.contracts)
-			}
-
-			err = contract.VerifyDeferredInstruction(rst, proposedInstr, c.DeferredData.InstructionHashes[i])
-			if err != nil {
-				return nil, nil, xerrors.Errorf("verifying the instruction failed: %v", err)
-			}
-
-			var stateChanges []StateChange
-			switch instructionType {
-			case SpawnType:
-				stateChanges, _, err = contract.Spawn(rst, proposedInstr, coins)
-			case InvokeType:
-				stateChanges, _, err = contract.Invoke(rst, proposedInstr, coins)
-			case DeleteType:
-				stateChanges, _, err = contract.Delete(rst, proposedInstr, coins)
-
-			}
-
-			if err != nil {
-				return nil, nil, xerrors.Errorf("error while executing an instruction: %v", err)
-			}
-
-			rst, err = rst.StoreAllToReplica(stateChanges)
-			if err != nil {
-				return nil, nil, xerrors.Errorf("error while storing state changes: %v", err)
-			}
-
-			sc = append(sc, stateChanges...)
+		var instructionIDs [][]byte
+		sc, instructionIDs, err = executeDeferred(rst, c.contracts,
+			c.DeferredData.ProposedTransaction.Instructions,
+			c.DeferredData.InstructionHashes, coins)
+		if err != nil {
+			return nil, nil, err
 		}
 
 		c.DeferredData.ExecResult = instructionIDs
@@ -329,6 +244,7 @@ func (c *contractDeferred) checkInvoke(rst ReadOnlyStateTrie, invoke *Invoke) er
 	//   1. The MaxNumExecution should be greater than 0
 	//   2. the current skipblock index should be lower than the provided
 	//      "expireBlockIndex" argument.
+	// The proofs are checked when they are added.
 
 	// 1.
 	if c.DeferredData.MaxNumExecution < uint64(1) {
@@ -342,45 +258,6 @@ func (c *contractDeferred) checkInvoke(rst ReadOnlyStateTrie, invoke *Invoke) er
 		return xerrors.Errorf("current block index is too high (%d > %d)", currentIndex, expireBlockIndex)
 	}
 
-	if invoke.Command == "addProof" {
-		// We will go through 2 checks:
-		//   1. Check if the identity is already stored
-		//   2. Check if the signature is valid
-
-		// 1:
-		// Get the given Identity
-		identityBuf := invoke.Args.Search("identity")
-		if identityBuf == nil {
-			return xerrors.New("identity args is nil")
-		}
-		identity := darc.Identity{}
-		err := protobuf.Decode(identityBuf, &identity)
-		if err != nil {
-			return xerrors.New("couldn't decode Identity")
-		}
-		// Get the instruction index
-		indexBuf := invoke.Args.Search("index")
-		if indexBuf == nil {
-			return xerrors.New("index args is nil")
-		}
-		index := binary.LittleEndian.Uint32(indexBuf)
-
-		for _, storedIdentity := range c.DeferredData.ProposedTransaction.Instructions[index].SignerIdentities {
-			if identity.Equal(&storedIdentity) {
-				return xerrors.New("identity already stored")
-			}
-		}
-		// 2:
-		// Get the given signature
-		signature := invoke.Args.Search("signature")
-		if signature == nil {
-			return xerrors.New("signature args is nil")
-		}
-		err = identity.Verify(c.InstructionHashes[index], signature)
-		if err != nil {
-			return xerrors.New("bad signature")
-		}
-	}
 	return nil
 }
 
@@ -420,3 +297,125 @@ func hashDeferred(instr Instruction, instanceID []byte) []byte {
 
 	return h.Sum(nil)
 }
+
+// addProof appends the identity and the signature given in the arguments to
+// the instruction at the "index" argument, once it made sure that the
+// signature is valid for the hash of this instruction and that the identity
+// didn't sign it yet.
+func addProof(instrs Instructions, hashes [][]byte, args Arguments) error {
+	indexBuf := args.Search("index")
+	if indexBuf == nil {
+		return xerrors.New("index args is nil")
+	}
+	if len(indexBuf) < 4 {
+		return xerrors.New("index is not a uint32")
+	}
+	index := binary.LittleEndian.Uint32(indexBuf)
+	if index >= uint32(len(instrs)) {
+		return xerrors.Errorf("index is out of range (%d >= %d)", index,
+			len(instrs))
+	}
+
+	identityBuf := args.Search("identity")
+	if identityBuf == nil {
+		return xerrors.New("identity args is nil")
+	}
+	identity := darc.Identity{}
+	err := protobuf.Decode(identityBuf, &identity)
+	if err != nil {
+		return xerrors.New("couldn't decode Identity")
+	}
+	for _, storedIdentity := range instrs[index].SignerIdentities {
+		if identity.Equal(&storedIdentity) {
+			return xerrors.New("identity already stored")
+		}
+	}
+
+	signature := args.Search("signature")
+	if signature == nil {
+		return xerrors.New("signature args is nil")
+	}
+	err = identity.Verify(hashes[index], signature)
+	if err != nil {
+		return xerrors.New("bad signature")
+	}
+
+	instrs[index].SignerIdentities = append(instrs[index].SignerIdentities,
+		identity)
+	instrs[index].Signatures = append(instrs[index].Signatures, signature)
+	return nil
+}
+
+// executeDeferred executes the instructions one after the other, each one
+// being verified against its hash instead of the hash of a transaction. It
+// returns the state changes of all the instructions and the IDs derived from
+// them.
+// We couldn't successfully re-use one of the already implemented method like
+// the "processOneTx" one because it involved quite a lot of changes and would
+// bring more complexity compared to the benefits.
+func executeDeferred(rst ReadOnlyStateTrie, contracts ReadOnlyContractRegistry,
+	instrs Instructions, hashes [][]byte, coins []Coin) (StateChanges,
+	[][]byte, error) {
+	var sc StateChanges
+	instructionIDs := make([][]byte, len(instrs))
+
+	for i, proposedInstr := range instrs {
+
+		// In case it goes well, we want to return the proposed Tx InstanceID
+		instructionIDs[i] = proposedInstr.DeriveID("").Slice()
+
+		instructionType := proposedInstr.GetType()
+
+		// Here we instantiate the contract from the state trie by getting
+		// its buferred data and then calling its constructor.
+		contractBuf, _, contractID, _, err := rst.GetValues(proposedInstr.InstanceID.Slice())
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't get contract buf: %v", err)
+		}
+		// Get the contract's constructor (like "contractValueFromByte(...)")
+		if contracts == nil {
+			return nil, nil, xerrors.New("contracts registry is missing due to bad initialization")
+		}
+
+		fn, exists := contracts.Search(contractID)
+		if !exists {
+			return nil, nil, xerrors.New("couldn't get the root function")
+		}
+		// Invoke the contructor and get the contract's instance
+		contract, err := fn(contractBuf)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't get the root contract: %v", err)
+		}
+		if cwr, ok := contract.(ContractWithRegistry); ok {
+			cwr.SetRegistry(contracts)
+		}
+
+		err = contract.VerifyDeferredInstruction(rst, proposedInstr, hashes[i])
+		if err != nil {
+			return nil, nil, xerrors.Errorf("verifying the instruction failed: %v", err)
+		}
+
+		var stateChanges []StateChange
+		switch instructionType {
+		case SpawnType:
+			stateChanges, _, err = contract.Spawn(rst, proposedInstr, coins)
+		case InvokeType:
+			stateChanges, _, err = contract.Invoke(rst, proposedInstr, coins)
+		case DeleteType:
+			stateChanges, _, err = contract.Delete(rst, proposedInstr, coins)
+
+		}
+
+		if err != nil {
+			return nil, nil, xerrors.Errorf("error while executing an instruction: %v", err)
+		}
+
+		rst, err = rst.StoreAllToReplica(stateChanges)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("error while storing state changes: %v", err)
+		}
+
+		sc = append(sc, stateChanges...)
+	}
+	return sc, instructionIDs, nil
+}
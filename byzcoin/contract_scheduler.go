@@ -0,0 +1,301 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The scheduler contract stores instructions that are executed in the first
+// block satisfying a condition on its index or on its timestamp. The
+// instructions are authorised like the ones of the deferred contract: they
+// are signed using the hash given by hashDeferred, either before spawning the
+// scheduler or later with "addProof". Once the condition holds, the leader
+// adds an "execute" invocation to the block, so that nobody needs to stay
+// online with the signing keys.
+
+// ContractSchedulerID denotes a contract that executes instructions in a
+// future block.
+var ContractSchedulerID = "scheduler"
+
+// maxSchedulerRepetitions is the maximum number of executions of a scheduler.
+// As the executions are paid when the scheduler is spawned, it also bounds
+// the fee of the spawn.
+const maxSchedulerRepetitions = 1000
+
+// SchedulerData contains the specific data of a scheduler contract.
+type SchedulerData struct {
+	// The instructions are executed one after the other, like in a
+	// transaction.
+	Instructions Instructions
+	// Hashes of each instruction, computed with hashDeferred. They are the
+	// messages signed by the signers of the instructions.
+	InstructionHashes [][]byte
+	// If BlockIndex is not 0, the instructions are only executed in a block
+	// with at least this index.
+	BlockIndex uint64
+	// If Timestamp is not 0, the instructions are only executed in a block
+	// with at least this timestamp, in nanoseconds.
+	Timestamp int64
+	// BlockInterval and TimeInterval are added to BlockIndex and Timestamp
+	// after every execution, if more executions remain.
+	BlockInterval uint64
+	TimeInterval  int64
+	// Remaining is the number of executions left. The instance is kept once
+	// it reaches 0, so that the result of the last execution can be read.
+	Remaining uint64
+	// Executions is the number of executions done.
+	Executions uint64
+	// LastError is the error of the last execution, or empty if it
+	// succeeded. A failed execution counts as an execution, so that it isn't
+	// retried in every block.
+	LastError string
+}
+
+// Due returns true if the instructions can be executed in a block with the
+// given index and timestamp.
+func (sd SchedulerData) Due(index uint64, timestamp int64) bool {
+	return sd.Remaining > 0 &&
+		(sd.BlockIndex == 0 || index >= sd.BlockIndex) &&
+		(sd.Timestamp == 0 || timestamp >= sd.Timestamp)
+}
+
+type contractScheduler struct {
+	BasicContract
+	SchedulerData
+	contracts ReadOnlyContractRegistry
+}
+
+func contractSchedulerFromBytes(in []byte) (Contract, error) {
+	c := &contractScheduler{}
+
+	err := protobuf.Decode(in, &c.SchedulerData)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+// SetRegistry keeps the reference of the contract registry.
+func (c *contractScheduler) SetRegistry(r ReadOnlyContractRegistry) {
+	c.contracts = r
+}
+
+// uint64Arg returns the argument as a little-endian uint64, or 0 if it is
+// missing.
+func uint64Arg(args Arguments, name string) (uint64, error) {
+	buf := args.Search(name)
+	if buf == nil {
+		return 0, nil
+	}
+	if len(buf) != 8 {
+		return 0, xerrors.Errorf("%s is not a uint64", name)
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// Spawn stores the instructions of the "scheduledTransaction" argument. The
+// other arguments are little-endian uint64s:
+//   - blockIndex and/or timestamp give the condition of the first execution
+//   - repetitions is the number of executions, 1 if not given, and at most
+//     maxSchedulerRepetitions. If the chain has fees, all the executions
+//     are paid by the spawn
+//   - blockInterval and/or timeInterval are added to the condition after
+//     every execution
+//
+// The ID of the instance is derived from the "preID" argument, if it is
+// given, so that the instructions can be signed before the spawn.
+func (c *contractScheduler) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) ([]StateChange, []Coin, error) {
+	_, _, _, darcID, err := rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading trie: %v", err)
+	}
+
+	var tx ClientTransaction
+	err = protobuf.Decode(inst.Spawn.Args.Search("scheduledTransaction"), &tx)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't decode scheduledTransaction: %v", err)
+	}
+	if len(tx.Instructions) == 0 {
+		return nil, nil, xerrors.New("no instructions to schedule")
+	}
+
+	data := SchedulerData{Instructions: tx.Instructions, Remaining: 1}
+	args := inst.Spawn.Args
+	var timestamp, timeInterval uint64
+	for _, arg := range []struct {
+		name  string
+		value *uint64
+	}{
+		{"blockIndex", &data.BlockIndex},
+		{"timestamp", &timestamp},
+		{"blockInterval", &data.BlockInterval},
+		{"timeInterval", &timeInterval},
+		{"repetitions", &data.Remaining},
+	} {
+		v, err := uint64Arg(args, arg.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		if args.Search(arg.name) != nil {
+			*arg.value = v
+		}
+	}
+	data.Timestamp = int64(timestamp)
+	data.TimeInterval = int64(timeInterval)
+	if data.Timestamp < 0 || data.TimeInterval < 0 {
+		return nil, nil, xerrors.New("negative time")
+	}
+	if data.BlockIndex == 0 && data.Timestamp == 0 {
+		return nil, nil, xerrors.New("needs a blockIndex or a timestamp")
+	}
+	if data.Remaining == 0 || data.Remaining > maxSchedulerRepetitions {
+		return nil, nil, xerrors.Errorf("repetitions must be between 1 and %d",
+			maxSchedulerRepetitions)
+	}
+	if data.Remaining > 1 && data.BlockInterval == 0 && data.TimeInterval == 0 {
+		return nil, nil, xerrors.New("repetitions need an interval")
+	}
+
+	id, err := inst.DeriveIDArg("", "preID")
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't get deriveID: %v", err)
+	}
+	data.InstructionHashes = make([][]byte, len(data.Instructions))
+	for i, instr := range data.Instructions {
+		data.InstructionHashes[i] = hashDeferred(instr, id.Slice())
+	}
+
+	dataBuf, err := protobuf.Encode(&data)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode SchedulerData: %v", err)
+	}
+	sc := StateChanges{NewStateChange(Create, id, ContractSchedulerID,
+		dataBuf, darcID)}
+	return sc, coins, nil
+}
+
+// Invoke handles two commands:
+//   - addProof adds a signature to an instruction, with the same arguments
+//     as for the deferred contract
+//   - execute runs the instructions if the current block satisfies the
+//     condition. The "execution" argument must be the number of executions
+//     done, so that an execution is never repeated by mistake.
+func (c *contractScheduler) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) (sc []StateChange, cout []Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	switch inst.Invoke.Command {
+	case "addProof":
+		if c.Remaining == 0 {
+			return nil, nil, xerrors.New("no executions left")
+		}
+		err = addProof(c.Instructions, c.InstructionHashes, inst.Invoke.Args)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("adding proof: %v", err)
+		}
+	case "execute":
+		var execution uint64
+		execution, err = uint64Arg(inst.Invoke.Args, "execution")
+		if err != nil {
+			return
+		}
+		if execution != c.Executions {
+			return nil, nil, xerrors.Errorf("execution %d was asked, but "+
+				"%d were done", execution, c.Executions)
+		}
+		tr, ok := rst.(TimeReader)
+		if !ok {
+			return nil, nil, xerrors.New("couldn't get the block timestamp")
+		}
+		if !c.Due(uint64(rst.GetIndex()+1), tr.GetCurrentBlockTimestamp()) {
+			return nil, nil, xerrors.New("the scheduled transaction is not due")
+		}
+
+		c.LastError = ""
+		sc, _, err = executeDeferred(rst, c.contracts, c.Instructions,
+			c.InstructionHashes, coins)
+		if err == nil {
+			// The executions are free, so they must not create
+			// schedulers whose executions haven't been paid.
+			for _, s := range sc {
+				if s.StateAction == Create && s.ContractID == ContractSchedulerID {
+					err = xerrors.New("scheduled instructions cannot create a scheduler")
+					break
+				}
+			}
+		}
+		if err != nil {
+			sc = nil
+			c.LastError = err.Error()
+		}
+		c.Executions++
+		c.Remaining--
+		c.BlockIndex += c.BlockInterval
+		c.Timestamp += c.TimeInterval
+	default:
+		return nil, nil, xerrors.New("scheduler contract can only addProof and execute")
+	}
+
+	dataBuf, err := protobuf.Encode(&c.SchedulerData)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode SchedulerData: %v", err)
+	}
+	sc = append(sc, NewStateChange(Update, inst.InstanceID,
+		ContractSchedulerID, dataBuf, darcID))
+	return
+}
+
+// Delete removes the scheduler, cancelling the remaining executions.
+func (c *contractScheduler) Delete(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) (sc []StateChange, cout []Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return nil, nil, xerrors.Errorf("reading trie: %v", err)
+	}
+
+	sc = StateChanges{
+		NewStateChange(Remove, inst.InstanceID, ContractSchedulerID, nil, darcID),
+	}
+	return
+}
+
+// VerifyInstruction lets anybody execute the scheduled instructions, as they
+// carry their own signatures and the condition is checked by the contract.
+// The other instructions are verified against the darc.
+func (c *contractScheduler) VerifyInstruction(rst ReadOnlyStateTrie, inst Instruction, ctxHash []byte) error {
+	if inst.GetType() == InvokeType && inst.Invoke.Command == "execute" {
+		return nil
+	}
+	return c.BasicContract.VerifyInstruction(rst, inst, ctxHash)
+}
+
+// SchedulerInstructionHash returns the hash a signer of a scheduled
+// instruction needs to sign, id being the ID of the scheduler instance.
+func SchedulerInstructionHash(instr Instruction, id InstanceID) []byte {
+	return hashDeferred(instr, id.Slice())
+}
+
+// newScheduledExecution returns the transaction the leader adds to a block to
+// execute the scheduled instructions.
+func newScheduledExecution(id InstanceID, execution uint64) ClientTransaction {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, execution)
+	return NewClientTransaction(CurrentVersion, Instruction{
+		InstanceID: id,
+		Invoke: &Invoke{
+			ContractID: ContractSchedulerID,
+			Command:    "execute",
+			Args:       Arguments{{Name: "execution", Value: buf}},
+		},
+	})
+}
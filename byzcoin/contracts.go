@@ -354,11 +354,17 @@ func (c *contractConfig) VerifyInstruction(rst ReadOnlyStateTrie, inst Instructi
 	}
 
 	// The config does not exist yet, so this is a genesis config creation. No need/possiblity of verifying it.
-	if !ok {
+	// The same goes for a genesis config that comes with an imported
+	// state, which has been checked against the block it was exported from.
+	if !ok || (isStateImport(inst) && rst.GetIndex() < 0) {
 		return nil
 	}
 
-	err = inst.Verify(rst, msg)
+	ops, err := evictionOptions(rst, inst)
+	if err != nil {
+		return xerrors.Errorf("eviction options: %v", err)
+	}
+	err = inst.VerifyWithOption(rst, msg, ops)
 	return cothority.ErrorOrNil(err, "instruction verification failed")
 }
 
@@ -380,10 +386,40 @@ func (c *contractConfig) VerifyDeferredInstruction(rst ReadOnlyStateTrie, inst I
 		return nil
 	}
 
-	err = inst.VerifyWithOption(rst, msg, &VerificationOptions{IgnoreCounters: true})
+	ops, err := evictionOptions(rst, inst)
+	if err != nil {
+		return xerrors.Errorf("eviction options: %v", err)
+	}
+	ops.IgnoreCounters = true
+	err = inst.VerifyWithOption(rst, msg, ops)
 	return cothority.ErrorOrNil(err, "instruction verification failed")
 }
 
+// evictionOptions returns the verification options of the instruction. An
+// eviction changes the roster without a failure of the leader, so it must
+// be signed by the identities that can update the config, and not by the
+// nodes.
+func evictionOptions(rst ReadOnlyStateTrie, inst Instruction) (*VerificationOptions, error) {
+	ops := &VerificationOptions{}
+	if !isEviction(inst) {
+		return ops, nil
+	}
+	_, _, _, darcID, err := rst.GetValues(ConfigInstanceID.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	d, err := rst.LoadDarc(darcID)
+	if err != nil {
+		return nil, xerrors.Errorf("loading darc: %v", err)
+	}
+	action := darc.Action("invoke:" + ContractConfigID + ".update_config")
+	if !d.Rules.Contains(action) {
+		return nil, xerrors.Errorf("action '%v' does not exist", action)
+	}
+	ops.Expression = d.Rules.Get(action)
+	return ops, nil
+}
+
 // FormatMethod overrides the implementation from the BasicContract in order to
 // proprely print "invoke:config.update_config"
 func (c *contractConfig) FormatMethod(instr Instruction) string {
@@ -413,7 +449,13 @@ func (c *contractConfig) FormatMethod(instr Instruction) string {
 //   - max_block_size int64
 //   - roster         onet.Roster
 //   - darc_contracts darcContractID
+//
+// To create the genesis state from a state export, it expects the arguments
+// of spawnImport instead.
 func (c *contractConfig) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) ([]StateChange, []Coin, error) {
+	if isStateImport(inst) {
+		return c.spawnImport(rst, inst, coins)
+	}
 	darcBuf := inst.Spawn.Args.Search("darc")
 	d, err := darc.NewFromProtobuf(darcBuf)
 	if err != nil {
@@ -478,6 +520,15 @@ func (c *contractConfig) Spawn(rst ReadOnlyStateTrie, inst Instruction, coins []
 // Invoke:view_change sould have the following input arguments:
 //   - newview viewchange.NewViewReq
 //   - multisig []byte
+//
+// or, for a handover of the leader to the next node of the roster:
+//   - handover onet.Roster
+//
+// or, for a node of the roster proposing to evict an unresponsive node:
+//   - evict_proposal onet.Roster
+//
+// or, for the eviction itself, signed by the admins of the config:
+//   - evict onet.Roster
 func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) ([]StateChange, []Coin, error) {
 	// Find the darcID for this instance.
 	var darcID darc.ID
@@ -510,32 +561,37 @@ func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins [
 		if err = newConfig.sanityCheck(oldConfig, rst.GetVersion()); err != nil {
 			return nil, nil, xerrors.Errorf("sanity check: %v", err)
 		}
-		var val []byte
-		val, _, _, _, err = rst.GetValues(darcID)
-		if err != nil {
-			return nil, nil, xerrors.Errorf("reading trie: %v", err)
-		}
-		var genesisDarc *darc.Darc
-		genesisDarc, err = darc.NewFromProtobuf(val)
-		if err != nil {
-			return nil, nil, xerrors.Errorf("decoding darc: %v", err)
-		}
-		var rules []string
-		for _, p := range newConfig.Roster.Publics() {
-			rules = append(rules, "ed25519:"+p.String())
+		if newConfig.LeaderRotation != nil {
+			newConfig.startEpoch(oldConfig, rst.GetIndex()+1)
+			configBuf, err = protobuf.Encode(&newConfig)
+			if err != nil {
+				return nil, nil, xerrors.Errorf("encoding config: %v", err)
+			}
 		}
-		genesisDarc.Rules.UpdateRule("invoke:"+ContractConfigID+".view_change", expression.InitOrExpr(rules...))
-		var genesisBuf []byte
-		genesisBuf, err = genesisDarc.ToProto()
+		var ruleSc StateChange
+		ruleSc, err = viewChangeRuleSc(rst, darcID, newConfig.Roster)
 		if err != nil {
-			return nil, nil, xerrors.Errorf("encoding darc: %v", err)
+			return nil, nil, xerrors.Errorf("darc scs: %v", err)
 		}
 		sc := StateChanges{
 			NewStateChange(Update, NewInstanceID(nil), ContractConfigID, configBuf, darcID),
-			NewStateChange(Update, NewInstanceID(darcID), ContractDarcID, genesisBuf, darcID),
+			ruleSc,
 		}
 		return sc, coins, nil
 	case "view_change":
+		if inst.Invoke.Args.Search(HandoverArgument) != nil {
+			sc, err := handoverScs(rst, inst, darcID)
+			return sc, coins, cothority.ErrorOrNil(err, "handover")
+		}
+		if inst.Invoke.Args.Search(EvictProposalArgument) != nil {
+			sc, err := evictProposalScs(rst, inst, darcID)
+			return sc, coins, cothority.ErrorOrNil(err, "eviction proposal")
+		}
+		if inst.Invoke.Args.Search(EvictArgument) != nil {
+			sc, err := evictScs(rst, inst, darcID)
+			return sc, coins, cothority.ErrorOrNil(err, "eviction")
+		}
+
 		var req viewchange.NewViewReq
 		err = protobuf.DecodeWithConstructors(inst.Invoke.Args.Search("newview"), &req, network.DefaultConstructors(cothority.Suite))
 		if err != nil {
@@ -579,12 +635,38 @@ func (c *contractConfig) Invoke(rst ReadOnlyStateTrie, inst Instruction, coins [
 	}
 }
 
+// viewChangeRuleSc returns the state change of the genesis darc allowing the
+// nodes of the roster to do view-changes.
+func viewChangeRuleSc(rst ReadOnlyStateTrie, darcID darc.ID, roster onet.Roster) (StateChange, error) {
+	val, _, _, _, err := rst.GetValues(darcID)
+	if err != nil {
+		return StateChange{}, xerrors.Errorf("reading trie: %v", err)
+	}
+	genesisDarc, err := darc.NewFromProtobuf(val)
+	if err != nil {
+		return StateChange{}, xerrors.Errorf("decoding darc: %v", err)
+	}
+	var rules []string
+	for _, p := range roster.Publics() {
+		rules = append(rules, "ed25519:"+p.String())
+	}
+	genesisDarc.Rules.UpdateRule("invoke:"+ContractConfigID+".view_change", expression.InitOrExpr(rules...))
+	genesisBuf, err := genesisDarc.ToProto()
+	if err != nil {
+		return StateChange{}, xerrors.Errorf("encoding darc: %v", err)
+	}
+	return NewStateChange(Update, NewInstanceID(darcID), ContractDarcID, genesisBuf, darcID), nil
+}
+
 func updateRosterScs(rst ReadOnlyStateTrie, darcID darc.ID, newRoster onet.Roster) (StateChanges, error) {
 	config, err := rst.LoadConfig()
 	if err != nil {
 		return nil, xerrors.Errorf("reading trie: %v", err)
 	}
+	oldConfig := *config
 	config.Roster = newRoster
+	// The index of the block holding this change.
+	config.startEpoch(&oldConfig, rst.GetIndex()+1)
 	configBuf, err := protobuf.Encode(config)
 	if err != nil {
 		return nil, xerrors.Errorf("encoding: %v", err)
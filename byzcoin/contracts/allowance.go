@@ -0,0 +1,107 @@
+package contracts
+
+import (
+	"crypto/sha256"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// ContractCoinAllowanceID denotes the instances holding the number of coins
+// that a coin account may take from another one. They are created, updated
+// and removed by the "approve" and "transferFrom" commands of the coin
+// contract, never directly.
+const ContractCoinAllowanceID = "coin_allowance"
+
+// Allowance is the value of a coin_allowance instance.
+type Allowance struct {
+	// Owner is the coin account the coins are taken from.
+	Owner byzcoin.InstanceID
+	// Spender is the coin account allowed to take them.
+	Spender byzcoin.InstanceID
+	// Value is the number of coins that can still be taken.
+	Value uint64
+}
+
+// ContractCoinAllowanceDeriveID returns the ID of the instance holding the
+// allowance given by owner to spender.
+func ContractCoinAllowanceDeriveID(owner, spender byzcoin.InstanceID) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write([]byte(ContractCoinAllowanceID))
+	h.Write(owner.Slice())
+	h.Write(spender.Slice())
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// contractCoinAllowance only exists so that the instances can be read, all
+// the changes go through the coin contract.
+type contractCoinAllowance struct {
+	byzcoin.BasicContract
+	Allowance
+}
+
+func contractCoinAllowanceFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractCoinAllowance{}
+	err := protobuf.Decode(in, &c.Allowance)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+func (c *contractCoinAllowance) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	return nil, nil, xerrors.New("allowances are removed by approving 0 coins")
+}
+
+// getAllowance returns the allowance given by owner to spender, which is
+// empty if there is none.
+func getAllowance(rst byzcoin.ReadOnlyStateTrie, owner,
+	spender byzcoin.InstanceID) (a Allowance, exists bool, err error) {
+	a = Allowance{Owner: owner, Spender: spender}
+	id := ContractCoinAllowanceDeriveID(owner, spender)
+	pr, err := rst.GetProof(id.Slice())
+	if err != nil {
+		return a, false, xerrors.Errorf("reading trie: %v", err)
+	}
+	if !pr.Match(id.Slice()) {
+		return a, false, nil
+	}
+	buf, _, cid, _, err := rst.GetValues(id.Slice())
+	if err != nil {
+		return a, false, xerrors.Errorf("reading trie: %v", err)
+	}
+	if cid != ContractCoinAllowanceID {
+		return a, false, xerrors.New("instance is not an allowance")
+	}
+	err = protobuf.Decode(buf, &a)
+	if err != nil {
+		return a, false, xerrors.Errorf("couldn't unmarshal allowance: %v", err)
+	}
+	return a, true, nil
+}
+
+// allowanceChange returns the state change storing the allowance, or
+// removing it once it is used up. The instance is protected by the darc of
+// the owner.
+func allowanceChange(a Allowance, exists bool, darcID darc.ID) (byzcoin.StateChanges, error) {
+	id := ContractCoinAllowanceDeriveID(a.Owner, a.Spender)
+	if a.Value == 0 {
+		if !exists {
+			return nil, nil
+		}
+		return byzcoin.StateChanges{byzcoin.NewStateChange(byzcoin.Remove, id,
+			ContractCoinAllowanceID, nil, darcID)}, nil
+	}
+	buf, err := protobuf.Encode(&a)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't encode allowance: %v", err)
+	}
+	action := byzcoin.Update
+	if !exists {
+		action = byzcoin.Create
+	}
+	return byzcoin.StateChanges{byzcoin.NewStateChange(action, id,
+		ContractCoinAllowanceID, buf, darcID)}, nil
+}
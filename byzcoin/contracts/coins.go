@@ -31,6 +31,26 @@ var CoinName = iid("byzCoin")
 //  - fetch takes "coins" out of the account and returns it as an output
 //    parameter for the next instruction to interpret.
 //  - store puts the coins given to the instance back into the account.
+//  - approve allows the coin instance given in the argument "spender" to
+//    take up to "coins" coins from this account. A new approval replaces
+//    the previous one.
+//  - lock keeps "coins" coins of the account from being spent. They are
+//    released linearly, starting "cliff" after "start" and ending
+//    "duration" after "start", in blocks or in nanoseconds if the
+//    "schedule" argument is "time". The "start" defaults to the current
+//    block. transfer also locks the coins sent if it gets these arguments,
+//    in which case it must also be signed according to the "invoke:coin.lock"
+//    rule of the darc of the destination.
+//  - release unlocks the coins that are due.
+//  - transferFrom takes "coins" coins out of the account given in the
+//    argument "from", which must have approved the current instance. The
+//    coins go to the account given in the optional argument "destination",
+//    else to the current instance.
+// mint emits a "mint" event with the "coins" argument, transfer and
+// transferFrom emit a "transfer" event with the "from" and "to" accounts and
+// the "coins".
+// The coins of a token can only be transferred between instances of the same
+// token, and only be minted through the token contract.
 // You can only delete a contractCoin instance if the account is empty.
 
 func contractCoinFromBytes(in []byte) (byzcoin.Contract, error) {
@@ -102,7 +122,8 @@ func (c *contractCoin) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 
 	// Invoke is one of "mint", "transfer", "fetch", or "store".
 	var coinsArg uint64
-	if inst.Invoke.Command != "store" {
+	var events []byzcoin.StateChange
+	if inst.Invoke.Command != "store" && inst.Invoke.Command != "release" {
 		coinsBuf := inst.Invoke.Args.Search("coins")
 		if coinsBuf == nil {
 			err = xerrors.New("argument \"coins\" is missing")
@@ -117,12 +138,18 @@ func (c *contractCoin) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 
 	switch inst.Invoke.Command {
 	case "mint":
-		// mint simply adds this amount of coins to the account.
+		// mint simply adds this amount of coins to the account, unless the
+		// supply is controlled by a token.
+		if isToken(rst, c.Name) {
+			return nil, nil, xerrors.New("coins of a token must be minted by the token")
+		}
 		log.Lvl2("minting", coinsArg)
 		err = c.SafeAdd(coinsArg)
 		if err != nil {
 			return
 		}
+		events = append(events, byzcoin.NewEvent(inst.InstanceID,
+			ContractCoinID, "mint", coinsArgument(coinsArg)))
 	case "transfer":
 		// transfer sends a given amount of coins to another account.
 		target := inst.Invoke.Args.Search("destination")
@@ -148,6 +175,12 @@ func (c *contractCoin) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 		if err != nil {
 			return nil, nil, xerrors.Errorf("couldn't unmarshal target account: %v", err)
 		}
+		// Plain coins can be sent to accounts of another type, as they
+		// always could, but the coins of a token are kept apart.
+		if !targetCI.Name.Equal(c.Name) &&
+			(isToken(rst, c.Name) || isToken(rst, targetCI.Name)) {
+			return nil, nil, xerrors.New("destination holds other coins")
+		}
 		err = c.SafeSub(coinsArg)
 		if err != nil {
 			return
@@ -156,6 +189,17 @@ func (c *contractCoin) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 		if err != nil {
 			return
 		}
+		var lock *byzcoin.CoinLock
+		lock, err = lockArg(rst, inst.Invoke.Args, coinsArg)
+		if err != nil {
+			return
+		}
+		if lock != nil {
+			err = targetCI.SafeLock(*lock)
+			if err != nil {
+				return
+			}
+		}
 		targetBuf, err := protobuf.Encode(&targetCI)
 		if err != nil {
 			return nil, nil, xerrors.Errorf("couldn't marshal target account: %v", err)
@@ -163,6 +207,8 @@ func (c *contractCoin) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 		log.Lvlf2("transferring %d to %x", coinsArg, target)
 		sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, byzcoin.NewInstanceID(target),
 			ContractCoinID, targetBuf, did))
+		events = append(events, transferEvent(inst.InstanceID,
+			inst.InstanceID, byzcoin.NewInstanceID(target), coinsArg))
 	case "fetch":
 		// fetch removes coins from the account and passes it on to the next
 		// instruction.
@@ -185,6 +231,127 @@ func (c *contractCoin) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 				cout = append(cout, co)
 			}
 		}
+	case "lock":
+		// lock keeps coins of the account from being spent until they are
+		// released.
+		var lock *byzcoin.CoinLock
+		lock, err = lockArg(rst, inst.Invoke.Args, coinsArg)
+		if err != nil {
+			return
+		}
+		if lock == nil {
+			return nil, nil, xerrors.New("argument \"duration\" is missing")
+		}
+		err = c.SafeLock(*lock)
+		if err != nil {
+			return
+		}
+	case "release":
+		// release unlocks the coins that are due.
+		var index, timestamp uint64
+		index, timestamp, err = blockTime(rst)
+		if err != nil {
+			return
+		}
+		if c.Release(index, int64(timestamp)) == 0 {
+			return nil, nil, xerrors.New("no coins to release")
+		}
+	case "approve":
+		// approve sets the number of coins another account can take.
+		spender := byzcoin.NewInstanceID(inst.Invoke.Args.Search("spender"))
+		if spender.Equal(inst.InstanceID) {
+			return nil, nil, xerrors.New("cannot approve ourselves")
+		}
+		if _, _, err = getCoin(rst, spender, c.Name); err != nil {
+			return
+		}
+		var a Allowance
+		var exists bool
+		a, exists, err = getAllowance(rst, inst.InstanceID, spender)
+		if err != nil {
+			return
+		}
+		a.Value = coinsArg
+		sc, err = allowanceChange(a, exists, darcID)
+		if err != nil {
+			return
+		}
+	case "transferFrom":
+		// transferFrom takes coins from an account that approved us.
+		from := byzcoin.NewInstanceID(inst.Invoke.Args.Search("from"))
+		if from.Equal(inst.InstanceID) {
+			return nil, nil, xerrors.New("cannot take coins from ourselves")
+		}
+		var (
+			a          Allowance
+			exists     bool
+			fromCoin   byzcoin.Coin
+			fromDarcID darc.ID
+			fromBuf    []byte
+		)
+		a, exists, err = getAllowance(rst, from, inst.InstanceID)
+		if err != nil {
+			return
+		}
+		if a.Value < coinsArg {
+			return nil, nil, xerrors.Errorf("only %d coins are approved", a.Value)
+		}
+		fromCoin, fromDarcID, err = getCoin(rst, from, c.Name)
+		if err != nil {
+			return
+		}
+		err = fromCoin.SafeSub(coinsArg)
+		if err != nil {
+			return
+		}
+		a.Value -= coinsArg
+		sc, err = allowanceChange(a, exists, fromDarcID)
+		if err != nil {
+			return
+		}
+		fromBuf, err = protobuf.Encode(&fromCoin)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't marshal source account: %v", err)
+		}
+		sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, from,
+			ContractCoinID, fromBuf, fromDarcID))
+
+		target := inst.Invoke.Args.Search("destination")
+		if target == nil {
+			target = inst.InstanceID.Slice()
+		}
+		events = append(events, transferEvent(inst.InstanceID, from,
+			byzcoin.NewInstanceID(target), coinsArg))
+		if inst.InstanceID.Equal(byzcoin.NewInstanceID(target)) {
+			err = c.SafeAdd(coinsArg)
+			if err != nil {
+				return
+			}
+			break
+		}
+		if from.Equal(byzcoin.NewInstanceID(target)) {
+			return nil, nil, xerrors.New("cannot send coins back to their account")
+		}
+		var (
+			targetCI     byzcoin.Coin
+			targetDarcID darc.ID
+			targetBuf    []byte
+		)
+		targetCI, targetDarcID, err = getCoin(rst, byzcoin.NewInstanceID(target), c.Name)
+		if err != nil {
+			return
+		}
+		err = targetCI.SafeAdd(coinsArg)
+		if err != nil {
+			return
+		}
+		targetBuf, err = protobuf.Encode(&targetCI)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't marshal target account: %v", err)
+		}
+		log.Lvlf2("transferring %d from %x to %x", coinsArg, from[:], target)
+		sc = append(sc, byzcoin.NewStateChange(byzcoin.Update,
+			byzcoin.NewInstanceID(target), ContractCoinID, targetBuf, targetDarcID))
 	default:
 		err = xerrors.New("coin contract can only mine and transfer")
 		return
@@ -195,6 +362,7 @@ func (c *contractCoin) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 	ciBuf, err = protobuf.Encode(&c.Coin)
 	sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
 		ContractCoinID, ciBuf, darcID))
+	sc = append(sc, events...)
 	return
 }
 
@@ -217,6 +385,119 @@ func (c *contractCoin) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instru
 	return
 }
 
+// VerifyInstruction implements the byzcoin.Contract interface. A transfer
+// that locks the coins must also be signed by the identities allowed to lock
+// the coins of the destination.
+func (c *contractCoin) VerifyInstruction(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, ctxHash []byte) error {
+	err := c.BasicContract.VerifyInstruction(rst, inst, ctxHash)
+	if err != nil {
+		return err
+	}
+	return verifyLockedTransfer(rst, inst, ctxHash)
+}
+
+// VerifyDeferredInstruction implements the byzcoin.Contract interface, so
+// that coins can be moved by deferred and scheduled transactions.
+func (c *contractCoin) VerifyDeferredInstruction(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, ctxHash []byte) error {
+	err := inst.VerifyWithOption(rst, ctxHash, &byzcoin.VerificationOptions{IgnoreCounters: true})
+	if err != nil {
+		return err
+	}
+	return verifyLockedTransfer(rst, inst, ctxHash)
+}
+
+// verifyLockedTransfer checks that a transfer locking the coins is signed
+// according to the "invoke:coin.lock" rule of the darc of the destination,
+// so that other accounts can't fill a coin with locks.
+func verifyLockedTransfer(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, ctxHash []byte) error {
+	if inst.GetType() != byzcoin.InvokeType ||
+		inst.Invoke.Command != "transfer" ||
+		inst.Invoke.Args.Search("duration") == nil {
+		return nil
+	}
+	_, _, _, darcID, err := rst.GetValues(inst.Invoke.Args.Search("destination"))
+	if err != nil {
+		return xerrors.Errorf("reading destination: %v", err)
+	}
+	d, err := rst.LoadDarc(darcID)
+	if err != nil {
+		return xerrors.Errorf("loading darc of destination: %v", err)
+	}
+	expr := d.Rules.Get(darc.Action("invoke:" + ContractCoinID + ".lock"))
+	if expr == nil {
+		return xerrors.New("destination doesn't accept locked coins")
+	}
+	return inst.VerifyWithOption(rst, ctxHash, &byzcoin.VerificationOptions{
+		IgnoreCounters: true, Expression: expr})
+}
+
+// coinsArgument returns the "coins" argument holding the value.
+func coinsArgument(value uint64) byzcoin.Argument {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, value)
+	return byzcoin.Argument{Name: "coins", Value: buf}
+}
+
+// transferEvent returns the "transfer" event of coins sent from one account
+// to another.
+func transferEvent(id, from, to byzcoin.InstanceID, value uint64) byzcoin.StateChange {
+	return byzcoin.NewEvent(id, ContractCoinID, "transfer",
+		byzcoin.Argument{Name: "from", Value: from.Slice()},
+		byzcoin.Argument{Name: "to", Value: to.Slice()},
+		coinsArgument(value))
+}
+
+// blockTime returns the index and the timestamp of the block being created.
+func blockTime(rst byzcoin.ReadOnlyStateTrie) (index, timestamp uint64, err error) {
+	tr, ok := rst.(byzcoin.TimeReader)
+	if !ok {
+		return 0, 0, xerrors.New("couldn't get the block timestamp")
+	}
+	return uint64(rst.GetIndex() + 1), uint64(tr.GetCurrentBlockTimestamp()), nil
+}
+
+// lockArg returns the lock of total coins described by the arguments, or nil
+// if there is no "duration" argument. The optional "schedule" argument is
+// "block", the default, or "time", in which case "start", "cliff" and
+// "duration" are in nanoseconds instead of blocks. "start" defaults to the
+// block being created.
+func lockArg(rst byzcoin.ReadOnlyStateTrie, args byzcoin.Arguments,
+	total uint64) (*byzcoin.CoinLock, error) {
+	if args.Search("duration") == nil {
+		return nil, nil
+	}
+	lock := &byzcoin.CoinLock{Total: total}
+	switch schedule := string(args.Search("schedule")); schedule {
+	case "", "block":
+	case "time":
+		lock.Timestamp = true
+	default:
+		return nil, xerrors.Errorf("unknown schedule %s", schedule)
+	}
+	var err error
+	for _, arg := range []struct {
+		name  string
+		value *uint64
+	}{{"start", &lock.Start}, {"cliff", &lock.Cliff},
+		{"duration", &lock.Duration}} {
+		*arg.value, err = optionalUint64(args, arg.name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if args.Search("start") == nil {
+		index, timestamp, err := blockTime(rst)
+		if err != nil {
+			return nil, err
+		}
+		lock.Start = index
+		if lock.Timestamp {
+			lock.Start = timestamp
+		}
+	}
+	return lock, nil
+}
+
 // iid uses sha256(in) in order to manufacture an InstanceID from in
 // thereby handling the case where len(in) != 32.
 //
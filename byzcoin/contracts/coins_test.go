@@ -5,6 +5,7 @@ import (
 	"encoding/binary"
 	"errors"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3/byzcoin"
@@ -103,9 +104,11 @@ func TestCoin_InvokeMint(t *testing.T) {
 	sc, co, err := ct.getContract(inst.InstanceID).Invoke(ct, inst, []byzcoin.Coin{})
 	require.NoError(t, err)
 	require.Equal(t, 0, len(co))
-	require.Equal(t, 1, len(sc))
+	require.Equal(t, 2, len(sc))
 	require.Equal(t, byzcoin.NewStateChange(byzcoin.Update, coAddr, ContractCoinID, ciOne, gdarc.GetBaseID()),
 		sc[0])
+	require.Equal(t, byzcoin.NewEvent(coAddr, ContractCoinID, "mint",
+		byzcoin.Argument{Name: "coins", Value: coinOne}), sc[1])
 }
 
 func TestCoin_InvokeOverflow(t *testing.T) {
@@ -240,9 +243,262 @@ func TestCoin_InvokeTransfer(t *testing.T) {
 	sc, co, err := ct.getContract(inst.InstanceID).Invoke(ct, inst, []byzcoin.Coin{})
 	require.NoError(t, err)
 	require.Equal(t, 0, len(co))
-	require.Equal(t, 2, len(sc))
+	require.Equal(t, 3, len(sc))
 	require.Equal(t, byzcoin.NewStateChange(byzcoin.Update, coAddr2, ContractCoinID, ciOne, gdarc.GetBaseID()), sc[0])
 	require.Equal(t, byzcoin.NewStateChange(byzcoin.Update, coAddr1, ContractCoinID, ciZero, gdarc.GetBaseID()), sc[1])
+	require.Equal(t, byzcoin.NewEvent(coAddr1, ContractCoinID, "transfer",
+		byzcoin.Argument{Name: "from", Value: coAddr1.Slice()},
+		byzcoin.Argument{Name: "to", Value: coAddr2.Slice()},
+		byzcoin.Argument{Name: "coins", Value: coinOne}), sc[2])
+}
+
+// TestCoin_Fees makes sure that the fees defined in the ChainConfig are
+// paid with the coins of the fee coin of a transaction.
+func TestCoin_Fees(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:coin", "invoke:coin.mint", "invoke:coin.fetch",
+		"spawn:value")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	payer := b.CreateCoin(nil, 1000)
+	collector := b.CreateCoin(nil, 0)
+
+	config, err := b.Client.GetChainConfig()
+	require.NoError(t, err)
+	config.FeeConfig = &byzcoin.FeeConfig{
+		CoinName:  CoinName,
+		Collector: collector,
+		Costs: []byzcoin.ContractCost{
+			{ContractID: ContractValueID, Spawn: 10},
+		},
+		ByteCost: 1,
+	}
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	b.SendInst(nil, byzcoin.Instruction{
+		InstanceID: byzcoin.ConfigInstanceID,
+		Invoke: &byzcoin.Invoke{
+			ContractID: byzcoin.ContractConfigID,
+			Command:    "update_config",
+			Args:       byzcoin.Arguments{{Name: "config", Value: configBuf}},
+		},
+	})
+
+	value := []byte("fees")
+	spawnValue := func() byzcoin.ClientTransaction {
+		ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+			byzcoin.Instruction{
+				InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+				Spawn: &byzcoin.Spawn{
+					ContractID: ContractValueID,
+					Args: byzcoin.Arguments{
+						{Name: "value", Value: value},
+						{Name: "preID", Value: []byte("fees")},
+					},
+				},
+			})
+		return ctx
+	}
+
+	// Without a fee coin, the transaction is refused.
+	ctx := spawnValue()
+	require.NoError(t, b.Client.SignTransaction(ctx, b.Signer))
+	resp := b.SendTx(&byzcoin.TxArgs{Wait: 10, WaitPropagation: true}, ctx)
+	require.Contains(t, resp.Error, "transaction has no fee coin")
+
+	ctx = spawnValue()
+	require.NoError(t, ctx.SetFeeCoin(payer))
+	require.NoError(t, b.Client.SignTransaction(ctx, b.Signer))
+	sim, err := b.Client.SimulateTransaction(ctx)
+	require.NoError(t, err)
+	fee := uint64(10 + 32 + len(value))
+	require.Equal(t, fee, sim.Fee)
+	b.SendTx(nil, ctx)
+
+	getCoin := func(id byzcoin.InstanceID) byzcoin.Coin {
+		pr, err := b.Client.GetProofFromLatest(id.Slice())
+		require.NoError(t, err)
+		_, buf, _, _, err := pr.Proof.KeyValue()
+		require.NoError(t, err)
+		var c byzcoin.Coin
+		require.NoError(t, protobuf.Decode(buf, &c))
+		return c
+	}
+	require.Equal(t, 1000-fee, getCoin(payer).Value)
+	require.Equal(t, fee, getCoin(collector).Value)
+
+	// The instance exists already, so the transaction is refused, but it
+	// still pays for its instruction.
+	ctx = spawnValue()
+	require.NoError(t, ctx.SetFeeCoin(payer))
+	require.NoError(t, b.Client.SignTransaction(ctx, b.Signer))
+	resp = b.SendTx(&byzcoin.TxArgs{Wait: 10, WaitPropagation: true}, ctx)
+	require.Contains(t, resp.Error, "tried to create existing instanceID")
+	require.Equal(t, 1000-fee-10, getCoin(payer).Value)
+	require.Equal(t, fee+10, getCoin(collector).Value)
+
+	// Replaying the refused transaction doesn't charge it again.
+	_, err = b.Services[0].AddTransaction(&byzcoin.AddTxRequest{
+		Version:       byzcoin.CurrentVersion,
+		SkipchainID:   b.Genesis.SkipChainID(),
+		Transaction:   ctx,
+		InclusionWait: 2,
+	})
+	require.Error(t, err)
+	require.Equal(t, 1000-fee-10, getCoin(payer).Value)
+}
+
+// TestCoin_Vesting locks coins sent to an account, which can only spend
+// them once they are released.
+func TestCoin_Vesting(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:coin", "invoke:coin.mint", "invoke:coin.fetch",
+		"invoke:coin.transfer", "invoke:coin.lock", "invoke:coin.release")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+	darcID := b.GenesisDarc.GetBaseID()
+
+	admin := spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 100)
+	employee := spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 0)
+	require.NoError(t, signAndSend(b.Client, b.Signer, coinInvoke(admin,
+		"transfer", byzcoin.Argument{Name: "coins", Value: u64Buf(60)},
+		byzcoin.Argument{Name: "destination", Value: employee.Slice()},
+		byzcoin.Argument{Name: "cliff", Value: u64Buf(2)},
+		byzcoin.Argument{Name: "duration", Value: u64Buf(4)})))
+	start, err := latestIndex(b.Client)
+	require.NoError(t, err)
+	require.Equal(t, uint64(60), coinValue(t, b.Client, employee))
+	require.Error(t, signAndSend(b.Client, b.Signer, coinInvoke(employee,
+		"fetch", byzcoin.Argument{Name: "coins", Value: u64Buf(1)})))
+
+	// Coins locked by time are not released before the end of the test.
+	require.NoError(t, signAndSend(b.Client, b.Signer, coinInvoke(admin,
+		"lock", byzcoin.Argument{Name: "coins", Value: u64Buf(40)},
+		byzcoin.Argument{Name: "schedule", Value: []byte("time")},
+		byzcoin.Argument{Name: "cliff", Value: u64Buf(uint64(time.Hour))},
+		byzcoin.Argument{Name: "duration", Value: u64Buf(uint64(time.Hour))})))
+	require.Error(t, signAndSend(b.Client, b.Signer, coinInvoke(admin,
+		"fetch", byzcoin.Argument{Name: "coins", Value: u64Buf(1)})))
+	require.Error(t, signAndSend(b.Client, b.Signer, coinInvoke(admin,
+		"release")))
+
+	for index := start; index <= start+5; {
+		spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 0)
+		index, err = latestIndex(b.Client)
+		require.NoError(t, err)
+	}
+	require.Error(t, signAndSend(b.Client, b.Signer, coinInvoke(employee,
+		"fetch", byzcoin.Argument{Name: "coins", Value: u64Buf(1)})))
+	require.NoError(t, signAndSend(b.Client, b.Signer, coinInvoke(employee,
+		"release")))
+	require.NoError(t, signAndSend(b.Client, b.Signer, coinInvoke(employee,
+		"transfer", byzcoin.Argument{Name: "coins", Value: u64Buf(60)},
+		byzcoin.Argument{Name: "destination", Value: admin.Slice()})))
+	require.Equal(t, uint64(100), coinValue(t, b.Client, admin))
+
+	// Locked coins can only be sent to an account that accepts them.
+	worker := darc.NewSignerEd25519(nil, nil)
+	workerDarc := darc.NewDarc(darc.InitRules(
+		[]darc.Identity{worker.Identity()},
+		[]darc.Identity{worker.Identity()}), []byte("worker"))
+	require.NoError(t, workerDarc.Rules.AddRule("invoke:coin.lock",
+		expression.Expr(worker.Identity().String())))
+	darcBuf, err := workerDarc.ToProto()
+	require.NoError(t, err)
+	require.NoError(t, signAndSend(b.Client, b.Signer, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(darcID),
+		Spawn: &byzcoin.Spawn{
+			ContractID: byzcoin.ContractDarcID,
+			Args:       byzcoin.Arguments{{Name: "darc", Value: darcBuf}},
+		},
+	}))
+	ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+		byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractCoinID,
+				Args: byzcoin.Arguments{
+					{Name: "darcID", Value: workerDarc.GetBaseID()}},
+			},
+		})
+	require.NoError(t, b.Client.SignTransaction(ctx, b.Signer))
+	_, err = b.Client.AddTransactionAndWait(ctx, 10)
+	require.NoError(t, err)
+	workerCoin := ctx.Instructions[0].DeriveID("")
+	lockedTransfer := coinInvoke(admin, "transfer",
+		byzcoin.Argument{Name: "coins", Value: u64Buf(10)},
+		byzcoin.Argument{Name: "destination", Value: workerCoin.Slice()},
+		byzcoin.Argument{Name: "duration", Value: u64Buf(4)})
+	require.Error(t, signAndSend(b.Client, b.Signer, lockedTransfer))
+	ctx = byzcoin.NewClientTransaction(byzcoin.CurrentVersion, lockedTransfer)
+	require.NoError(t, b.Client.SignTransaction(ctx, b.Signer, worker))
+	_, err = b.Client.AddTransactionAndWait(ctx, 10)
+	require.NoError(t, err)
+	require.NoError(t, signAndSend(b.Client, b.Signer, coinInvoke(admin,
+		"transfer", byzcoin.Argument{Name: "coins", Value: u64Buf(10)},
+		byzcoin.Argument{Name: "destination", Value: workerCoin.Slice()})))
+	require.Equal(t, uint64(20), coinValue(t, b.Client, workerCoin))
+}
+
+func TestCoin_Receipt(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:coin", "invoke:coin.mint", "invoke:coin.transfer")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+	darcID := b.GenesisDarc.GetBaseID()
+
+	alice := spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 100)
+	bob := spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 0)
+	send := func(coins uint64) (byzcoin.ClientTransaction, error) {
+		ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+			coinInvoke(alice, "transfer",
+				byzcoin.Argument{Name: "coins", Value: u64Buf(coins)},
+				byzcoin.Argument{Name: "destination", Value: bob.Slice()}))
+		require.NoError(t, b.Client.SignTransaction(ctx, b.Signer))
+		_, err := b.Client.AddTransactionAndWait(ctx, 10)
+		return ctx, err
+	}
+
+	ctx, err := send(30)
+	require.NoError(t, err)
+	reply, err := b.Client.GetReceipt(ctx.Instructions.Hash())
+	require.NoError(t, err)
+	r := reply.Receipt
+	require.True(t, r.Accepted)
+	require.Equal(t, ctx.Instructions.Hash(), r.TxHash)
+	block, err := b.Client.GetProofFromLatest(alice.Slice())
+	require.NoError(t, err)
+	require.Equal(t, block.Proof.Latest.Index, r.BlockIndex)
+	require.Equal(t, block.Proof.Latest.Hash, r.BlockID)
+	changed := map[string]bool{}
+	for _, sc := range r.StateChanges {
+		changed[string(sc.InstanceID)] = true
+	}
+	require.True(t, changed[string(alice.Slice())])
+	require.True(t, changed[string(bob.Slice())])
+	require.Equal(t, []byzcoin.Event{{
+		InstanceID: alice,
+		ContractID: ContractCoinID,
+		Topic:      "transfer",
+		Attributes: byzcoin.Arguments{
+			{Name: "from", Value: alice.Slice()},
+			{Name: "to", Value: bob.Slice()},
+			{Name: "coins", Value: u64Buf(30)},
+		},
+	}}, r.Events)
+
+	// A refused transaction has a receipt, but no events.
+	ctx, err = send(100)
+	require.Error(t, err)
+	reply, err = b.Client.GetReceipt(ctx.Instructions.Hash())
+	require.NoError(t, err)
+	require.False(t, reply.Receipt.Accepted)
+	require.Empty(t, reply.Receipt.StateChanges)
+	require.Empty(t, reply.Receipt.Events)
+
+	_, err = b.Client.GetReceipt(make([]byte, 32))
+	require.Error(t, err)
 }
 
 type cvTest struct {
@@ -0,0 +1,256 @@
+package contracts
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/protobuf"
+)
+
+// Note: Those tests rely on the Value and the Coin contracts, hence it is not
+//       possible to include this file in the byzcoin package.
+
+func u64Buf(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	return buf
+}
+
+func getSchedulerData(t *testing.T, b *byzcoin.BCTest,
+	id byzcoin.InstanceID) byzcoin.SchedulerData {
+	pr, err := b.Client.GetProofFromLatest(id.Slice())
+	require.NoError(t, err)
+	buf, _, _, err := pr.Proof.Get(id.Slice())
+	require.NoError(t, err)
+	var data byzcoin.SchedulerData
+	require.NoError(t, protobuf.Decode(buf, &data))
+	return data
+}
+
+func TestScheduler_BlockIndex(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:value", "spawn:scheduler", "spawn:coin",
+		"invoke:coin.mint", "invoke:coin.transfer")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	src := b.CreateCoin(nil, 250)
+	dst := b.CreateCoin(nil, 0)
+
+	// The transfer is signed before the scheduler is spawned, using the ID
+	// derived from the preID.
+	spawn := byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &byzcoin.Spawn{
+			ContractID: byzcoin.ContractSchedulerID,
+			Args: byzcoin.Arguments{
+				{Name: "preID", Value: random.Bits(256, true, random.New())},
+			},
+		},
+	}
+	id, err := spawn.DeriveIDArg("", "preID")
+	require.NoError(t, err)
+
+	transfer := byzcoin.Instruction{
+		InstanceID: src,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractCoinID,
+			Command:    "transfer",
+			Args: byzcoin.Arguments{
+				{Name: "coins", Value: u64Buf(100)},
+				{Name: "destination", Value: dst[:]},
+			},
+		},
+		SignerIdentities: []darc.Identity{b.Signer.Identity()},
+	}
+	sig, err := b.Signer.Sign(byzcoin.SchedulerInstructionHash(transfer, id))
+	require.NoError(t, err)
+	transfer.Signatures = [][]byte{sig}
+	scheduled := byzcoin.NewClientTransaction(byzcoin.CurrentVersion, transfer)
+	scheduledBuf, err := protobuf.Encode(&scheduled)
+	require.NoError(t, err)
+
+	// The number of executions is limited.
+	tooMany := spawn
+	tooMany.Spawn = &byzcoin.Spawn{
+		ContractID: byzcoin.ContractSchedulerID,
+		Args: append(byzcoin.Arguments{}, spawn.Spawn.Args[0],
+			byzcoin.Argument{Name: "scheduledTransaction", Value: scheduledBuf},
+			byzcoin.Argument{Name: "blockIndex", Value: u64Buf(1)},
+			byzcoin.Argument{Name: "blockInterval", Value: u64Buf(1)},
+			byzcoin.Argument{Name: "repetitions", Value: u64Buf(1001)}),
+	}
+	_, resp := b.SendInst(&byzcoin.TxArgs{Wait: 10, WaitPropagation: true},
+		tooMany)
+	require.Contains(t, resp.Error, "repetitions must be between 1 and 1000")
+	// Without fees, the refused transaction doesn't use up the counter.
+	b.SignerCounter--
+
+	pr, err := b.Client.GetProofFromLatest(dst.Slice())
+	require.NoError(t, err)
+	start := uint64(pr.Proof.Latest.Index + 3)
+	spawn.Spawn.Args = append(spawn.Spawn.Args,
+		byzcoin.Argument{Name: "scheduledTransaction", Value: scheduledBuf},
+		byzcoin.Argument{Name: "blockIndex", Value: u64Buf(start)},
+		byzcoin.Argument{Name: "blockInterval", Value: u64Buf(1)},
+		byzcoin.Argument{Name: "repetitions", Value: u64Buf(3)})
+	b.SendInst(nil, spawn)
+
+	data := getSchedulerData(t, b, id)
+	require.Equal(t, start, data.BlockIndex)
+	require.Equal(t, uint64(3), data.Remaining)
+
+	// Executions are refused before the block index.
+	exec := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+		byzcoin.Instruction{
+			InstanceID: id,
+			Invoke: &byzcoin.Invoke{
+				ContractID: byzcoin.ContractSchedulerID,
+				Command:    "execute",
+				Args: byzcoin.Arguments{
+					{Name: "execution", Value: u64Buf(0)},
+				},
+			},
+		})
+	resp = b.SendTx(&byzcoin.TxArgs{Wait: 10, WaitPropagation: true}, exec)
+	require.Contains(t, resp.Error, "not due")
+
+	// The executions are added by the leader to the blocks created for
+	// other transactions, once it looked for the due executions.
+	for i := 0; i < 50 && data.Remaining > 0; i++ {
+		time.Sleep(200 * time.Millisecond)
+		b.SendInst(nil, byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractValueID,
+				Args: byzcoin.Arguments{
+					{Name: "value", Value: []byte("block")},
+				},
+			},
+		})
+		data = getSchedulerData(t, b, id)
+	}
+	require.Equal(t, uint64(0), data.Remaining)
+	require.Equal(t, uint64(3), data.Executions)
+
+	// The third transfer fails, as there are not enough coins left.
+	require.Contains(t, data.LastError, "underflow")
+	getCoin := func(id byzcoin.InstanceID) uint64 {
+		pr, err := b.Client.GetProofFromLatest(id.Slice())
+		require.NoError(t, err)
+		buf, _, _, err := pr.Proof.Get(id.Slice())
+		require.NoError(t, err)
+		var c byzcoin.Coin
+		require.NoError(t, protobuf.Decode(buf, &c))
+		return c.Value
+	}
+	require.Equal(t, uint64(50), getCoin(src))
+	require.Equal(t, uint64(200), getCoin(dst))
+
+	// An execution can't be repeated.
+	exec.Instructions[0].Invoke.Args[0].Value = u64Buf(2)
+	resp = b.SendTx(&byzcoin.TxArgs{Wait: 10, WaitPropagation: true}, exec)
+	require.Contains(t, resp.Error, "execution 2 was asked, but 3 were done")
+}
+
+func TestScheduler_Timestamp(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:value", "spawn:scheduler",
+		"invoke:scheduler.addProof", "delete:scheduler")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	value := byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractValueID,
+			Args: byzcoin.Arguments{
+				{Name: "value", Value: []byte("scheduled")},
+			},
+		},
+	}
+	scheduled := byzcoin.NewClientTransaction(byzcoin.CurrentVersion, value)
+	scheduledBuf, err := protobuf.Encode(&scheduled)
+	require.NoError(t, err)
+
+	spawn := func(args ...byzcoin.Argument) (byzcoin.InstanceID,
+		byzcoin.AddTxResponse) {
+		ctx, resp := b.SendInst(&byzcoin.TxArgs{Wait: 10}, byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+			Spawn: &byzcoin.Spawn{
+				ContractID: byzcoin.ContractSchedulerID,
+				Args: append(byzcoin.Arguments{{Name: "scheduledTransaction",
+					Value: scheduledBuf}}, args...),
+			},
+		})
+		return ctx.Instructions[0].DeriveID(""), resp
+	}
+
+	// A condition is needed, and an interval to repeat.
+	_, resp := spawn()
+	require.Contains(t, resp.Error, "needs a blockIndex or a timestamp")
+	_, resp = spawn(byzcoin.Argument{Name: "blockIndex", Value: u64Buf(100)},
+		byzcoin.Argument{Name: "repetitions", Value: u64Buf(2)})
+	require.Contains(t, resp.Error, "repetitions need an interval")
+
+	// A scheduler that is deleted is never executed.
+	deleted, resp := spawn(byzcoin.Argument{Name: "timestamp",
+		Value: u64Buf(uint64(time.Now().UnixNano()))})
+	require.Empty(t, resp.Error)
+	b.SendInst(nil, byzcoin.Instruction{
+		InstanceID: deleted,
+		Delete:     &byzcoin.Delete{ContractID: byzcoin.ContractSchedulerID},
+	})
+
+	// The instruction isn't signed yet, so the execution fails.
+	failing, resp := spawn(byzcoin.Argument{Name: "timestamp",
+		Value: u64Buf(uint64(time.Now().Add(time.Second).UnixNano()))})
+	require.Empty(t, resp.Error)
+
+	// The signature is added after the spawn.
+	timestamp := time.Now().Add(2 * time.Second).UnixNano()
+	id, resp := spawn(byzcoin.Argument{Name: "timestamp",
+		Value: u64Buf(uint64(timestamp))})
+	require.Empty(t, resp.Error)
+	data := getSchedulerData(t, b, id)
+	identity := b.Signer.Identity()
+	identityBuf, err := protobuf.Encode(&identity)
+	require.NoError(t, err)
+	sig, err := b.Signer.Sign(data.InstructionHashes[0])
+	require.NoError(t, err)
+	b.SendInst(nil, byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: byzcoin.ContractSchedulerID,
+			Command:    "addProof",
+			Args: byzcoin.Arguments{
+				{Name: "identity", Value: identityBuf},
+				{Name: "signature", Value: sig},
+				{Name: "index", Value: make([]byte, 4)},
+			},
+		},
+	})
+
+	// Nothing else is sent, the leader creates the blocks by itself.
+	for i := 0; i < 50 && data.Remaining > 0; i++ {
+		time.Sleep(200 * time.Millisecond)
+		data = getSchedulerData(t, b, id)
+	}
+	require.Equal(t, uint64(0), data.Remaining)
+	require.Empty(t, data.LastError)
+
+	pr, err := b.Client.GetProofFromLatest(id.Slice())
+	require.NoError(t, err)
+	var header byzcoin.DataHeader
+	require.NoError(t, protobuf.Decode(pr.Proof.Latest.Data, &header))
+	require.True(t, header.Timestamp >= timestamp)
+
+	data = getSchedulerData(t, b, failing)
+	require.Equal(t, uint64(1), data.Executions)
+	require.Contains(t, data.LastError, "verifying")
+}
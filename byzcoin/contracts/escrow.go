@@ -0,0 +1,319 @@
+package contracts
+
+import (
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The escrow contract holds the coins of a payer until they are released to
+// the payee or refunded to the payer. Each outcome needs the approval of two
+// of the three participants: both parties, or the arbiter and one of the
+// parties. If the coins are neither released nor refunded before the
+// timeout, the payer can take them back, unless a party opened a dispute.
+// Every step is kept in the history of the instance.
+
+// ContractEscrowID denotes a contract that holds coins in escrow.
+const ContractEscrowID = "escrow"
+
+const (
+	// EscrowRelease sends the coins to the payee.
+	EscrowRelease = "release"
+	// EscrowRefund sends the coins back to the payer.
+	EscrowRefund = "refund"
+)
+
+// Escrow is the value of an escrow instance.
+type Escrow struct {
+	// Coin holds the coins in escrow.
+	Coin byzcoin.Coin
+	// Payer, Payee and Arbiter are the identities of the participants.
+	Payer   string
+	Payee   string
+	Arbiter string
+	// PayerCoin and PayeeCoin are the coin instances receiving the refunded
+	// or released coins.
+	PayerCoin byzcoin.InstanceID
+	PayeeCoin byzcoin.InstanceID
+	// Timeout is the index of the first block where the payer can take the
+	// coins back alone.
+	Timeout uint64
+	// DisputeTimeout is the number of blocks the arbiter has to settle a
+	// dispute, after which the payer can take the coins back alone. If it
+	// is 0, a dispute has to be settled.
+	DisputeTimeout uint64
+	// Disputed is the index of the block where a dispute was opened, or 0.
+	Disputed uint64
+	// Approvals are the outcomes approved by the participants.
+	Approvals []EscrowApproval
+	// Outcome is EscrowRelease or EscrowRefund once the escrow is closed.
+	Outcome string
+	// History is the list of all the steps of the escrow.
+	History []EscrowEvent
+}
+
+// EscrowApproval is the outcome approved by a participant.
+type EscrowApproval struct {
+	Identity string
+	Outcome  string
+}
+
+// EscrowEvent is a step of an escrow.
+type EscrowEvent struct {
+	// Index is the index of the block of the step.
+	Index uint64
+	// Identity is the participant that made the step.
+	Identity string
+	// Action is the command of the step, with its outcome if any.
+	Action string
+}
+
+// isParticipant returns whether the identity takes part in the escrow.
+func (e Escrow) isParticipant(id string) bool {
+	return id == e.Payer || id == e.Payee || id == e.Arbiter
+}
+
+// agreed returns the outcome approved by at least two participants, or an
+// empty string.
+func (e Escrow) agreed() string {
+	count := make(map[string]int)
+	for _, a := range e.Approvals {
+		count[a.Outcome]++
+		if count[a.Outcome] >= 2 {
+			return a.Outcome
+		}
+	}
+	return ""
+}
+
+// ContractEscrow holds coins until two participants agree on their outcome.
+//   - spawn puts the coins given to the instruction in escrow. The arguments
+//     are the "payer", "payee" and "arbiter" identities, the "payerCoin" and
+//     "payeeCoin" instances, of the same type as the coins, the "timeout"
+//     block index, and the optional "disputeTimeout" number of blocks, as
+//     64-bit uints in LittleEndian.
+//   - approve records that the participant in the "identity" argument
+//     approves the "outcome" argument, "release" or "refund". The coins are
+//     sent once two participants approved the same outcome.
+//   - dispute is opened by the payer or the payee given in "identity". It
+//     prevents the payer from taking the coins back after the timeout.
+//   - reclaim sends the coins back to the payer, who must be the "identity",
+//     after the timeout if there is no dispute, or after the dispute timeout.
+//
+// The invoke instructions must be signed by the participant given in the
+// "identity" argument. An escrow instance can only be deleted once it is
+// closed.
+type ContractEscrow struct {
+	byzcoin.BasicContract
+	Escrow
+}
+
+func contractEscrowFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &ContractEscrow{}
+	err := protobuf.Decode(in, &c.Escrow)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+// Spawn implements the byzcoin.Contract interface.
+func (c *ContractEscrow) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if len(coins) == 0 || coins[0].Value == 0 {
+		return nil, nil, xerrors.New("no coins to hold")
+	}
+	c.Escrow = Escrow{Coin: byzcoin.Coin{Name: coins[0].Name,
+		Value: coins[0].Value}}
+	cout = coins[1:]
+
+	args := inst.Spawn.Args
+	for _, arg := range []struct {
+		name  string
+		value *string
+	}{{"payer", &c.Payer}, {"payee", &c.Payee}, {"arbiter", &c.Arbiter}} {
+		*arg.value, err = identityArg(args, arg.name)
+		if err != nil {
+			return
+		}
+	}
+	if c.Payer == c.Payee || c.Payer == c.Arbiter || c.Payee == c.Arbiter {
+		return nil, nil, xerrors.New("the participants must be different")
+	}
+	c.PayerCoin = byzcoin.NewInstanceID(args.Search("payerCoin"))
+	c.PayeeCoin = byzcoin.NewInstanceID(args.Search("payeeCoin"))
+	for _, id := range []byzcoin.InstanceID{c.PayerCoin, c.PayeeCoin} {
+		if _, _, err = getCoin(rst, id, c.Coin.Name); err != nil {
+			return
+		}
+	}
+	if args.Search("timeout") == nil {
+		return nil, nil, xerrors.New("argument \"timeout\" is missing")
+	}
+	c.Timeout, err = optionalUint64(args, "timeout")
+	if err != nil {
+		return
+	}
+	if c.Timeout <= uint64(rst.GetIndex()+1) {
+		return nil, nil, xerrors.New("timeout is already expired")
+	}
+	c.DisputeTimeout, err = optionalUint64(args, "disputeTimeout")
+	if err != nil {
+		return
+	}
+	c.History = []EscrowEvent{{Index: uint64(rst.GetIndex() + 1),
+		Identity: c.Payer, Action: "spawn"}}
+
+	buf, err := protobuf.Encode(&c.Escrow)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode escrow: %v", err)
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""),
+			ContractEscrowID, buf, darcID),
+	}
+	return
+}
+
+// Invoke implements the byzcoin.Contract interface.
+func (c *ContractEscrow) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if c.Outcome != "" {
+		return nil, nil, xerrors.New("escrow is closed")
+	}
+	identity, err := identityArg(inst.Invoke.Args, "identity")
+	if err != nil {
+		return
+	}
+	index := uint64(rst.GetIndex() + 1)
+	event := EscrowEvent{Index: index, Identity: identity,
+		Action: inst.Invoke.Command}
+	switch inst.Invoke.Command {
+	case "approve":
+		outcome := string(inst.Invoke.Args.Search("outcome"))
+		if outcome != EscrowRelease && outcome != EscrowRefund {
+			return nil, nil, xerrors.Errorf("unknown outcome %s", outcome)
+		}
+		approvals := []EscrowApproval{}
+		for _, a := range c.Approvals {
+			if a.Identity != identity {
+				approvals = append(approvals, a)
+			}
+		}
+		c.Approvals = append(approvals, EscrowApproval{Identity: identity,
+			Outcome: outcome})
+		event.Action += " " + outcome
+		c.Outcome = c.agreed()
+	case "dispute":
+		if identity == c.Arbiter {
+			return nil, nil, xerrors.New("only the parties can open a dispute")
+		}
+		if c.Disputed > 0 {
+			return nil, nil, xerrors.New("a dispute is already open")
+		}
+		c.Disputed = index
+	case "reclaim":
+		if identity != c.Payer {
+			return nil, nil, xerrors.New("only the payer can reclaim the coins")
+		}
+		if index < c.Timeout {
+			return nil, nil, xerrors.Errorf("timeout is at block %d", c.Timeout)
+		}
+		if c.Disputed > 0 && (c.DisputeTimeout == 0 ||
+			index < c.Disputed+c.DisputeTimeout) {
+			return nil, nil, xerrors.New("the dispute is not settled")
+		}
+		c.Outcome = EscrowRefund
+	default:
+		return nil, nil, xerrors.New("escrow contract can only approve, " +
+			"dispute and reclaim")
+	}
+	c.History = append(c.History, event)
+
+	if c.Outcome != "" {
+		target := c.PayeeCoin
+		if c.Outcome == EscrowRefund {
+			target = c.PayerCoin
+		}
+		var coin byzcoin.Coin
+		var coinDarcID darc.ID
+		coin, coinDarcID, err = getCoin(rst, target, c.Coin.Name)
+		if err != nil {
+			return
+		}
+		err = coin.SafeAdd(c.Coin.Value)
+		if err != nil {
+			return
+		}
+		var coinBuf []byte
+		coinBuf, err = protobuf.Encode(&coin)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't encode coin: %v", err)
+		}
+		sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, target,
+			ContractCoinID, coinBuf, coinDarcID))
+		c.History = append(c.History, EscrowEvent{Index: index,
+			Action: c.Outcome})
+	}
+
+	buf, err := protobuf.Encode(&c.Escrow)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode escrow: %v", err)
+	}
+	sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
+		ContractEscrowID, buf, darcID))
+	return
+}
+
+// Delete implements the byzcoin.Contract interface.
+func (c *ContractEscrow) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if c.Outcome == "" {
+		return nil, nil, xerrors.New("cannot delete an open escrow")
+	}
+	sc = byzcoin.StateChanges{
+		byzcoin.NewStateChange(byzcoin.Remove, inst.InstanceID,
+			ContractEscrowID, nil, darcID),
+	}
+	return
+}
+
+// VerifyInstruction checks that the invoke instructions are signed by the
+// participant given in their "identity" argument.
+func (c *ContractEscrow) VerifyInstruction(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, ctxHash []byte) error {
+	if inst.GetType() != byzcoin.InvokeType {
+		return c.BasicContract.VerifyInstruction(rst, inst, ctxHash)
+	}
+	identity, err := identityArg(inst.Invoke.Args, "identity")
+	if err != nil {
+		return err
+	}
+	if !c.isParticipant(identity) {
+		return xerrors.New("identity doesn't take part in the escrow")
+	}
+	return inst.VerifyWithOption(rst, ctxHash, &byzcoin.VerificationOptions{
+		Expression: expression.InitOrExpr(identity)})
+}
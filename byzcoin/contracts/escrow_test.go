@@ -0,0 +1,135 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+)
+
+func getEscrow(t *testing.T, cl *byzcoin.Client, id byzcoin.InstanceID) Escrow {
+	pr, err := cl.GetProofFromLatest(id.Slice())
+	require.NoError(t, err)
+	buf, cid, _, err := pr.Proof.Get(id.Slice())
+	require.NoError(t, err)
+	require.Equal(t, ContractEscrowID, cid)
+	var e Escrow
+	require.NoError(t, protobuf.Decode(buf, &e))
+	return e
+}
+
+func actions(e Escrow) []string {
+	var a []string
+	for _, ev := range e.History {
+		a = append(a, ev.Action)
+	}
+	return a
+}
+
+func TestEscrow(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:coin", "invoke:coin.mint", "invoke:coin.fetch",
+		"spawn:escrow", "delete:escrow")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+	darcID := b.GenesisDarc.GetBaseID()
+
+	payer := darc.NewSignerEd25519(nil, nil)
+	payee := darc.NewSignerEd25519(nil, nil)
+	arbiter := darc.NewSignerEd25519(nil, nil)
+	payerCoin := spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 100)
+	payeeCoin := spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 0)
+
+	spawnEscrow := func(timeout, disputeTimeout uint64) byzcoin.InstanceID {
+		ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+			coinInvoke(payerCoin, "fetch",
+				byzcoin.Argument{Name: "coins", Value: u64Buf(30)}),
+			byzcoin.Instruction{
+				InstanceID: byzcoin.NewInstanceID(darcID),
+				Spawn: &byzcoin.Spawn{
+					ContractID: ContractEscrowID,
+					Args: byzcoin.Arguments{
+						identityArgument("payer", payer),
+						identityArgument("payee", payee),
+						identityArgument("arbiter", arbiter),
+						{Name: "payerCoin", Value: payerCoin.Slice()},
+						{Name: "payeeCoin", Value: payeeCoin.Slice()},
+						{Name: "timeout", Value: u64Buf(timeout)},
+						{Name: "disputeTimeout", Value: u64Buf(disputeTimeout)},
+					},
+				},
+			})
+		require.NoError(t, b.Client.SignTransaction(ctx, b.Signer))
+		_, err := b.Client.AddTransactionAndWait(ctx, 10)
+		require.NoError(t, err)
+		return ctx.Instructions[1].DeriveID("")
+	}
+	step := func(id byzcoin.InstanceID, s darc.Signer, identity darc.Signer,
+		command string, args ...byzcoin.Argument) error {
+		args = append(args, identityArgument("identity", identity))
+		return signAndSend(b.Client, s, byzcoin.Instruction{
+			InstanceID: id,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractEscrowID,
+				Command:    command,
+				Args:       args,
+			},
+		})
+	}
+	release := byzcoin.Argument{Name: "outcome", Value: []byte(EscrowRelease)}
+	refund := byzcoin.Argument{Name: "outcome", Value: []byte(EscrowRefund)}
+
+	// Both parties agree to release the coins.
+	index, err := latestIndex(b.Client)
+	require.NoError(t, err)
+	id := spawnEscrow(index+100, 0)
+	require.Equal(t, uint64(70), coinValue(t, b.Client, payerCoin))
+	require.Error(t, step(id, arbiter, payer, "approve", release))
+	require.Error(t, step(id, b.Signer, b.Signer, "approve", release))
+	require.NoError(t, step(id, payer, payer, "approve", release))
+	require.Equal(t, uint64(0), coinValue(t, b.Client, payeeCoin))
+	require.NoError(t, step(id, payee, payee, "approve", release))
+	require.Equal(t, uint64(30), coinValue(t, b.Client, payeeCoin))
+	require.Error(t, step(id, arbiter, arbiter, "approve", refund))
+	e := getEscrow(t, b.Client, id)
+	require.Equal(t, EscrowRelease, e.Outcome)
+	require.Equal(t, []string{"spawn", "approve release", "approve release",
+		"release"}, actions(e))
+	require.NoError(t, signAndSend(b.Client, b.Signer, byzcoin.Instruction{
+		InstanceID: id,
+		Delete:     &byzcoin.Delete{ContractID: ContractEscrowID},
+	}))
+
+	// The arbiter settles a dispute with one of the parties, and the dispute
+	// keeps the payer from taking the coins back alone.
+	index, err = latestIndex(b.Client)
+	require.NoError(t, err)
+	id = spawnEscrow(index+3, 0)
+	require.Error(t, step(id, arbiter, arbiter, "dispute"))
+	require.NoError(t, step(id, payee, payee, "dispute"))
+	require.NoError(t, step(id, payee, payee, "approve", release))
+	require.NoError(t, step(id, payer, payer, "approve", refund))
+	require.Error(t, step(id, payer, payer, "reclaim"))
+	require.NoError(t, step(id, arbiter, arbiter, "approve", refund))
+	require.Equal(t, uint64(70), coinValue(t, b.Client, payerCoin))
+	require.Equal(t, []string{"spawn", "dispute", "approve release",
+		"approve refund", "approve refund", "refund"},
+		actions(getEscrow(t, b.Client, id)))
+
+	// Without dispute, the payer takes the coins back after the timeout.
+	index, err = latestIndex(b.Client)
+	require.NoError(t, err)
+	timeout := index + 4
+	id = spawnEscrow(timeout, 0)
+	require.Error(t, step(id, payer, payer, "reclaim"))
+	for index+1 < timeout {
+		spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 0)
+		index, err = latestIndex(b.Client)
+		require.NoError(t, err)
+	}
+	require.Error(t, step(id, payee, payee, "reclaim"))
+	require.NoError(t, step(id, payer, payer, "reclaim"))
+	require.Equal(t, uint64(70), coinValue(t, b.Client, payerCoin))
+}
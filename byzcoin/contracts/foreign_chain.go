@@ -0,0 +1,213 @@
+package contracts
+
+import (
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The foreign_chain contract follows another ByzCoin chain, so that the
+// instances of this chain can act on the values proven in the other one. It
+// is spawned with the genesis block of the foreign chain, which is trusted,
+// and keeps the latest block of the foreign chain it verified. A proof of
+// the foreign chain only needs the forward links from this block, as
+// returned by byzcoin.Client.GetProofFrom, or from the genesis block.
+
+// ContractForeignChainID denotes a contract that verifies the proofs of
+// another ByzCoin chain.
+const ContractForeignChainID = "foreign_chain"
+
+// ForeignChainData is the value of a foreign_chain instance.
+type ForeignChainData struct {
+	// GenesisID is the ID of the foreign chain.
+	GenesisID skipchain.SkipBlockID
+	// GenesisRoster is the roster of the genesis block.
+	GenesisRoster onet.Roster
+	// LatestID is the ID of the latest verified block.
+	LatestID skipchain.SkipBlockID
+	// LatestIndex is the index of the latest verified block.
+	LatestIndex int
+	// LatestRoster is the roster of the latest verified block, which signs
+	// its forward links.
+	LatestRoster onet.Roster
+}
+
+// VerifyProof verifies that the proof comes from the foreign chain. Its
+// links must start at the latest verified block or at the genesis block. It
+// doesn't verify whether a certain key/value pair exists in the proof.
+func (fcd ForeignChainData) VerifyProof(p byzcoin.Proof) error {
+	if len(p.Links) == 0 {
+		return xerrors.New("proof has no links")
+	}
+	if p.Latest.SkipBlockFix == nil || p.Latest.Roster == nil {
+		return xerrors.New("proof has no latest block")
+	}
+	if !p.Latest.SkipChainID().Equal(fcd.GenesisID) {
+		return xerrors.New("proof is for another chain")
+	}
+
+	// The links are copied, as the roster of the first one is replaced.
+	var from skipchain.SkipBlock
+	for i := len(p.Links) - 1; i >= 0; i-- {
+		to := p.Links[i].To
+		if to.Equal(fcd.LatestID) {
+			from.Hash = fcd.LatestID
+			from.SkipBlockFix = &skipchain.SkipBlockFix{Roster: &fcd.LatestRoster}
+		} else if i == 0 && to.Equal(fcd.GenesisID) {
+			from.Hash = fcd.GenesisID
+			from.SkipBlockFix = &skipchain.SkipBlockFix{Roster: &fcd.GenesisRoster}
+		} else {
+			continue
+		}
+		p.Links = append([]skipchain.ForwardLink{}, p.Links[i:]...)
+		break
+	}
+	if from.Hash == nil {
+		return xerrors.New("proof doesn't start at a verified block")
+	}
+	return cothority.ErrorOrNil(p.VerifyFromBlock(&from), "verifying proof")
+}
+
+// VerifyForeignProof verifies the proof using the foreign_chain instance with
+// the given ID. Other contracts can use it before acting on a value of the
+// foreign chain.
+func VerifyForeignProof(rst byzcoin.ReadOnlyStateTrie, id byzcoin.InstanceID,
+	p byzcoin.Proof) error {
+	buf, _, contractID, _, err := rst.GetValues(id.Slice())
+	if err != nil {
+		return xerrors.Errorf("reading trie: %v", err)
+	}
+	if contractID != ContractForeignChainID {
+		return xerrors.New("instance is not a foreign_chain")
+	}
+	var fcd ForeignChainData
+	err = protobuf.DecodeWithConstructors(buf, &fcd,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return xerrors.Errorf("decoding data: %v", err)
+	}
+	return fcd.VerifyProof(p)
+}
+
+// contractForeignChain is spawned with the "genesisID" and "roster"
+// arguments, the latter being the protobuf encoded roster of the genesis
+// block. The "update" command verifies the "proof" argument and keeps its
+// latest block if it is newer.
+type contractForeignChain struct {
+	byzcoin.BasicContract
+	ForeignChainData
+}
+
+func contractForeignChainFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &contractForeignChain{}
+	err := protobuf.DecodeWithConstructors(in, &c.ForeignChainData,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+func (c *contractForeignChain) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	genesisID := skipchain.SkipBlockID(inst.Spawn.Args.Search("genesisID"))
+	if len(genesisID) != 32 {
+		return nil, nil, xerrors.New("genesisID must be 32 bytes")
+	}
+	var roster onet.Roster
+	err = protobuf.DecodeWithConstructors(inst.Spawn.Args.Search("roster"),
+		&roster, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't decode roster: %v", err)
+	}
+	if len(roster.List) == 0 {
+		return nil, nil, xerrors.New("roster is empty")
+	}
+
+	c.ForeignChainData = ForeignChainData{
+		GenesisID:     genesisID,
+		GenesisRoster: roster,
+		LatestID:      genesisID,
+		LatestRoster:  roster,
+	}
+	buf, err := protobuf.Encode(&c.ForeignChainData)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode data: %v", err)
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""),
+			ContractForeignChainID, buf, darcID),
+	}
+	return
+}
+
+func (c *contractForeignChain) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	switch inst.Invoke.Command {
+	case "update":
+		var p byzcoin.Proof
+		err = protobuf.DecodeWithConstructors(inst.Invoke.Args.Search("proof"),
+			&p, network.DefaultConstructors(cothority.Suite))
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't decode proof: %v", err)
+		}
+		err = c.VerifyProof(p)
+		if err != nil {
+			return nil, nil, err
+		}
+		if p.Latest.Index <= c.LatestIndex {
+			return nil, nil, xerrors.Errorf("block %d is not newer than "+
+				"block %d", p.Latest.Index, c.LatestIndex)
+		}
+		c.LatestID = p.Latest.Hash
+		c.LatestIndex = p.Latest.Index
+		c.LatestRoster = *p.Latest.Roster
+	default:
+		return nil, nil, xerrors.New("foreign_chain contract can only update")
+	}
+
+	buf, err := protobuf.Encode(&c.ForeignChainData)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode data: %v", err)
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
+			ContractForeignChainID, buf, darcID),
+	}
+	return
+}
+
+func (c *contractForeignChain) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	sc = byzcoin.StateChanges{
+		byzcoin.NewStateChange(byzcoin.Remove, inst.InstanceID,
+			ContractForeignChainID, nil, darcID),
+	}
+	return
+}
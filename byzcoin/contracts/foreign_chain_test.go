@@ -0,0 +1,120 @@
+package contracts
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+func TestForeignChain(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:foreign_chain", "invoke:foreign_chain.update")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	// The foreign chain runs on the same nodes.
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, b.Roster,
+		[]string{"spawn:value"}, b.Signer.Identity())
+	require.NoError(t, err)
+	msg.BlockInterval = 500 * time.Millisecond
+	foreign, _, err := byzcoin.NewLedger(msg, false)
+	require.NoError(t, err)
+
+	spawnForeignValue := func(value string) byzcoin.Proof {
+		ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+			byzcoin.Instruction{
+				InstanceID: byzcoin.NewInstanceID(msg.GenesisDarc.GetBaseID()),
+				Spawn: &byzcoin.Spawn{
+					ContractID: ContractValueID,
+					Args: byzcoin.Arguments{
+						{Name: "value", Value: []byte(value)},
+					},
+				},
+			})
+		require.NoError(t, foreign.SignTransaction(ctx, b.Signer))
+		_, err := foreign.AddTransactionAndWait(ctx, 10)
+		require.NoError(t, err)
+		id := ctx.Instructions[0].DeriveID("")
+		pr, err := foreign.GetProof(id.Slice())
+		require.NoError(t, err)
+		return pr.Proof
+	}
+
+	rosterBuf, err := protobuf.Encode(b.Roster)
+	require.NoError(t, err)
+	ctx, _ := b.SendInst(nil, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractForeignChainID,
+			Args: byzcoin.Arguments{
+				{Name: "genesisID", Value: foreign.ID},
+				{Name: "roster", Value: rosterBuf},
+			},
+		},
+	})
+	id := ctx.Instructions[0].DeriveID("")
+
+	getData := func() ForeignChainData {
+		pr, err := b.Client.GetProofFromLatest(id.Slice())
+		require.NoError(t, err)
+		buf, _, _, err := pr.Proof.Get(id.Slice())
+		require.NoError(t, err)
+		var fcd ForeignChainData
+		require.NoError(t, protobuf.DecodeWithConstructors(buf, &fcd,
+			network.DefaultConstructors(cothority.Suite)))
+		return fcd
+	}
+	update := func(p byzcoin.Proof) string {
+		buf, err := protobuf.Encode(&p)
+		require.NoError(t, err)
+		_, resp := b.SendInst(&byzcoin.TxArgs{Wait: 10}, byzcoin.Instruction{
+			InstanceID: id,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractForeignChainID,
+				Command:    "update",
+				Args:       byzcoin.Arguments{{Name: "proof", Value: buf}},
+			},
+		})
+		return resp.Error
+	}
+
+	// A proof from the genesis block.
+	p := spawnForeignValue("burned")
+	require.NoError(t, getData().VerifyProof(p))
+	require.Empty(t, update(p))
+	fcd := getData()
+	require.Equal(t, p.Latest.Hash, fcd.LatestID)
+	require.Equal(t, p.Latest.Index, fcd.LatestIndex)
+
+	// The same block can't be used twice.
+	require.Contains(t, update(p), "is not newer")
+
+	// A proof with the forward links from the latest verified block.
+	for i := 0; i < 3; i++ {
+		p = spawnForeignValue("more")
+	}
+	sb, err := skipchain.NewClient().GetSingleBlock(b.Roster, fcd.LatestID)
+	require.NoError(t, err)
+	pr, err := foreign.GetProofFrom(p.InclusionProof.Key(), sb)
+	require.NoError(t, err)
+	require.Equal(t, fcd.LatestID, pr.Proof.Links[0].To)
+	require.NoError(t, fcd.VerifyProof(pr.Proof))
+	require.Empty(t, update(pr.Proof))
+	require.Equal(t, p.Latest.Index, getData().LatestIndex)
+
+	// Proofs of the local chain are refused.
+	pr, err = b.Client.GetProof(id.Slice())
+	require.NoError(t, err)
+	require.Contains(t, update(pr.Proof), "another chain")
+
+	// As well as proofs whose block has been tampered with.
+	p = spawnForeignValue("last")
+	p.Latest.Index++
+	require.Contains(t, update(p), "verifying proof")
+}
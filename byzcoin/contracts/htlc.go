@@ -0,0 +1,274 @@
+package contracts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The htlc contract locks coins under a hashlock and a timelock, which is
+// the building block of atomic swaps between chains. The coins go to the
+// recipient if the preimage of the hashlock is revealed before the block
+// given by the timelock, else they go back to the owner. The timelock is a
+// block index, or a block timestamp, which expires at the same time on all
+// chains.
+
+// ContractHTLCID denotes a contract that holds hash-time-locked coins.
+const ContractHTLCID = "htlc"
+
+// HTLC is the value of an htlc instance.
+type HTLC struct {
+	// Coin holds the locked coins.
+	Coin byzcoin.Coin
+	// Hashlock is the SHA-256 hash of the secret that unlocks the coins.
+	Hashlock []byte
+	// Timelock is the index of the first block where the coins can't be
+	// claimed anymore, but refunded.
+	Timelock uint64
+	// Timestamp is true if Timelock is a block timestamp, in nanoseconds
+	// since the Unix epoch, instead of a block index.
+	Timestamp bool
+	// Recipient is the coin instance receiving the claimed coins.
+	Recipient byzcoin.InstanceID
+	// Refund is the coin instance receiving the refunded coins.
+	Refund byzcoin.InstanceID
+	// Preimage is the secret, once it has been revealed by a claim.
+	Preimage []byte
+	// Claimed and Refunded tell what happened to the coins.
+	Claimed  bool
+	Refunded bool
+}
+
+// ContractHTLC holds coins until they are claimed or refunded.
+//   - spawn locks the coins given to the instruction. The arguments are
+//     "hashlock", the 32 bytes SHA-256 hash of the secret, "timelock", a block
+//     index as a 64-bit uint in LittleEndian, and "recipient" and "refund",
+//     two coin instances of the same type as the locked coins. If the
+//     "schedule" argument is "time", the timelock is a block timestamp in
+//     nanoseconds instead.
+//   - claim sends the coins to the recipient, if the "preimage" argument
+//     hashes to the hashlock and the timelock didn't expire.
+//   - refund sends the coins to the refund instance once the timelock
+//     expired.
+//
+// Claim and refund don't need to be signed, as the coins can only go to the
+// instances chosen by the owner. As they are not signed, they have no fee
+// coin: on a chain with fees, they must be sent with a fee coin and signed by
+// an identity allowed to fetch from it, or the fees of the htlc contract and
+// the byte cost must be zero. An htlc instance can only be deleted once the
+// coins have been claimed or refunded.
+type ContractHTLC struct {
+	byzcoin.BasicContract
+	HTLC
+}
+
+func contractHTLCFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &ContractHTLC{}
+	err := protobuf.Decode(in, &c.HTLC)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+// now returns the index or the timestamp of the block being created,
+// depending on the timelock.
+func (h HTLC) now(rst byzcoin.ReadOnlyStateTrie) (uint64, error) {
+	if !h.Timestamp {
+		return uint64(rst.GetIndex() + 1), nil
+	}
+	_, timestamp, err := blockTime(rst)
+	return timestamp, err
+}
+
+// expiry describes when the timelock expires.
+func (h HTLC) expiry() string {
+	if h.Timestamp {
+		return time.Unix(0, int64(h.Timelock)).String()
+	}
+	return fmt.Sprintf("block %d", h.Timelock)
+}
+
+// getCoin returns the coin stored in the instance, which must be a coin
+// instance of the given type.
+func getCoin(rst byzcoin.ReadOnlyStateTrie, id byzcoin.InstanceID,
+	name byzcoin.InstanceID) (byzcoin.Coin, darc.ID, error) {
+	var coin byzcoin.Coin
+	buf, _, cid, darcID, err := rst.GetValues(id.Slice())
+	if err != nil {
+		return coin, nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	if cid != ContractCoinID {
+		return coin, nil, xerrors.Errorf("%x is not a coin contract", id[:])
+	}
+	err = protobuf.Decode(buf, &coin)
+	if err != nil {
+		return coin, nil, xerrors.Errorf("couldn't unmarshal coin: %v", err)
+	}
+	if !coin.Name.Equal(name) {
+		return coin, nil, xerrors.Errorf("%x holds other coins", id[:])
+	}
+	return coin, darcID, nil
+}
+
+// Spawn implements the byzcoin.Contract interface.
+func (c *ContractHTLC) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if len(coins) == 0 || coins[0].Value == 0 {
+		return nil, nil, xerrors.New("no coins to lock")
+	}
+	c.HTLC = HTLC{Coin: coins[0]}
+	cout = coins[1:]
+
+	args := inst.Spawn.Args
+	c.Hashlock = args.Search("hashlock")
+	if len(c.Hashlock) != sha256.Size {
+		return nil, nil, xerrors.New("hashlock must be 32 bytes")
+	}
+	timelock := args.Search("timelock")
+	if len(timelock) != 8 {
+		return nil, nil, xerrors.New("timelock must be a uint64")
+	}
+	c.Timelock = binary.LittleEndian.Uint64(timelock)
+	switch schedule := string(args.Search("schedule")); schedule {
+	case "", "block":
+	case "time":
+		c.Timestamp = true
+	default:
+		return nil, nil, xerrors.Errorf("unknown schedule %s", schedule)
+	}
+	now, err := c.now(rst)
+	if err != nil {
+		return
+	}
+	if c.Timelock <= now {
+		return nil, nil, xerrors.New("timelock is already expired")
+	}
+	c.Recipient = byzcoin.NewInstanceID(args.Search("recipient"))
+	c.Refund = byzcoin.NewInstanceID(args.Search("refund"))
+	for _, id := range []byzcoin.InstanceID{c.Recipient, c.Refund} {
+		if _, _, err = getCoin(rst, id, c.Coin.Name); err != nil {
+			return
+		}
+	}
+
+	buf, err := protobuf.Encode(&c.HTLC)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode HTLC: %v", err)
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""),
+			ContractHTLCID, buf, darcID),
+	}
+	return
+}
+
+// Invoke implements the byzcoin.Contract interface.
+func (c *ContractHTLC) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if c.Claimed || c.Refunded {
+		return nil, nil, xerrors.New("coins have already been released")
+	}
+	now, err := c.now(rst)
+	if err != nil {
+		return
+	}
+	var target byzcoin.InstanceID
+	switch inst.Invoke.Command {
+	case "claim":
+		if now >= c.Timelock {
+			return nil, nil, xerrors.New("timelock expired")
+		}
+		preimage := inst.Invoke.Args.Search("preimage")
+		h := sha256.Sum256(preimage)
+		if !bytes.Equal(h[:], c.Hashlock) {
+			return nil, nil, xerrors.New("wrong preimage")
+		}
+		c.Preimage = preimage
+		c.Claimed = true
+		target = c.Recipient
+	case "refund":
+		if now < c.Timelock {
+			return nil, nil, xerrors.Errorf("timelock expires at %s",
+				c.expiry())
+		}
+		c.Refunded = true
+		target = c.Refund
+	default:
+		return nil, nil, xerrors.New("htlc contract can only claim and refund")
+	}
+
+	coin, coinDarcID, err := getCoin(rst, target, c.Coin.Name)
+	if err != nil {
+		return
+	}
+	err = coin.SafeAdd(c.Coin.Value)
+	if err != nil {
+		return
+	}
+	coinBuf, err := protobuf.Encode(&coin)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode coin: %v", err)
+	}
+	buf, err := protobuf.Encode(&c.HTLC)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode HTLC: %v", err)
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Update, target, ContractCoinID,
+			coinBuf, coinDarcID),
+		byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractHTLCID,
+			buf, darcID),
+	}
+	return
+}
+
+// Delete implements the byzcoin.Contract interface.
+func (c *ContractHTLC) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if !c.Claimed && !c.Refunded {
+		return nil, nil, xerrors.New("cannot delete an htlc holding coins")
+	}
+	sc = byzcoin.StateChanges{
+		byzcoin.NewStateChange(byzcoin.Remove, inst.InstanceID, ContractHTLCID,
+			nil, darcID),
+	}
+	return
+}
+
+// VerifyInstruction lets anybody claim or refund the coins, as the contract
+// decides where they go.
+func (c *ContractHTLC) VerifyInstruction(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, ctxHash []byte) error {
+	if inst.GetType() == byzcoin.InvokeType {
+		return nil
+	}
+	return c.BasicContract.VerifyInstruction(rst, inst, ctxHash)
+}
@@ -0,0 +1,171 @@
+package contracts
+
+import (
+	"crypto/sha256"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/protobuf"
+)
+
+var htlcRules = []string{"spawn:coin", "invoke:coin.mint", "invoke:coin.fetch",
+	"spawn:htlc"}
+
+// spawnCoin creates a coin instance of the given type holding value coins.
+func spawnCoin(t *testing.T, cl *byzcoin.Client, signer darc.Signer,
+	d darc.ID, typ byzcoin.InstanceID, value uint64) byzcoin.InstanceID {
+	coinID := random.Bits(256, true, random.New())
+	ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+		byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(d),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractCoinID,
+				Args: byzcoin.Arguments{
+					{Name: "coinID", Value: coinID},
+					{Name: "type", Value: typ.Slice()},
+				},
+			},
+		},
+		byzcoin.Instruction{
+			InstanceID: ContractCoinDeriveID(coinID),
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractCoinID,
+				Command:    "mint",
+				Args:       byzcoin.Arguments{{Name: "coins", Value: u64Buf(value)}},
+			},
+		})
+	require.NoError(t, cl.SignTransaction(ctx, signer))
+	_, err := cl.AddTransactionAndWait(ctx, 10)
+	require.NoError(t, err)
+	return ContractCoinDeriveID(coinID)
+}
+
+func coinValue(t *testing.T, cl *byzcoin.Client, id byzcoin.InstanceID) uint64 {
+	pr, err := cl.GetProofFromLatest(id.Slice())
+	require.NoError(t, err)
+	buf, _, _, err := pr.Proof.Get(id.Slice())
+	require.NoError(t, err)
+	var c byzcoin.Coin
+	require.NoError(t, protobuf.Decode(buf, &c))
+	return c.Value
+}
+
+func TestHTLC_Swap(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules(htlcRules...)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, b.Roster,
+		htlcRules, b.Signer.Identity())
+	require.NoError(t, err)
+	msg.BlockInterval = 500 * time.Millisecond
+	other, _, err := byzcoin.NewLedger(msg, false)
+	require.NoError(t, err)
+
+	gold := iid("gold")
+	darcA := b.GenesisDarc.GetBaseID()
+	darcB := msg.GenesisDarc.GetBaseID()
+	a := SwapParty{
+		Client:  b.Client,
+		Signer:  b.Signer,
+		Darc:    darcA,
+		Coin:    spawnCoin(t, b.Client, b.Signer, darcA, CoinName, 1000),
+		Amount:  300,
+		Receive: spawnCoin(t, other, b.Signer, darcB, gold, 0),
+	}
+	bob := SwapParty{
+		Client:  other,
+		Signer:  b.Signer,
+		Darc:    darcB,
+		Coin:    spawnCoin(t, other, b.Signer, darcB, gold, 50),
+		Amount:  20,
+		Receive: spawnCoin(t, b.Client, b.Signer, darcA, CoinName, 0),
+	}
+
+	idA, idB, err := Swap(a, bob, time.Minute)
+	require.NoError(t, err)
+	require.Equal(t, uint64(700), coinValue(t, b.Client, a.Coin))
+	require.Equal(t, uint64(300), coinValue(t, b.Client, bob.Receive))
+	require.Equal(t, uint64(30), coinValue(t, other, bob.Coin))
+	require.Equal(t, uint64(20), coinValue(t, other, a.Receive))
+
+	htlcA, err := GetHTLC(b.Client, idA)
+	require.NoError(t, err)
+	require.True(t, htlcA.Claimed)
+	htlcB, err := GetHTLC(other, idB)
+	require.NoError(t, err)
+	require.Equal(t, htlcA.Preimage, htlcB.Preimage)
+
+	// Both timelocks are timestamps, whatever the block intervals.
+	require.True(t, htlcA.Timestamp)
+	require.True(t, htlcB.Timestamp)
+	require.True(t, htlcA.Timelock > htlcB.Timelock)
+
+	// The coins can't be released twice.
+	require.Error(t, ClaimCoins(b.Client, idA, htlcA.Preimage))
+	require.Error(t, RefundCoins(b.Client, idA))
+
+	// A receiver of another type of coins is refused.
+	_, err = LockCoins(a, a.Receive, htlcA.Hashlock, 1000)
+	require.Error(t, err)
+}
+
+func TestHTLC_Refund(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules(htlcRules...)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	darcID := b.GenesisDarc.GetBaseID()
+	owner := spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 100)
+	p := SwapParty{
+		Client: b.Client,
+		Signer: b.Signer,
+		Darc:   darcID,
+		Coin:   owner,
+		Amount: 40,
+	}
+	recipient := spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 0)
+	secret := []byte("secret")
+	hashlock := sha256.Sum256(secret)
+
+	index, err := latestIndex(b.Client)
+	require.NoError(t, err)
+	_, err = LockCoins(p, recipient, hashlock[:], index)
+	require.Error(t, err)
+	timelock := index + 5
+	id, err := LockCoins(p, recipient, hashlock[:], timelock)
+	require.NoError(t, err)
+	require.Equal(t, uint64(60), coinValue(t, b.Client, owner))
+
+	require.Error(t, ClaimCoins(b.Client, id, []byte("wrong")))
+	require.Error(t, RefundCoins(b.Client, id))
+
+	// Once the timelock expired, the secret is useless.
+	for index+1 < timelock {
+		spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 0)
+		index, err = latestIndex(b.Client)
+		require.NoError(t, err)
+	}
+	require.Error(t, ClaimCoins(b.Client, id, secret))
+	require.NoError(t, RefundCoins(b.Client, id))
+	require.Equal(t, uint64(100), coinValue(t, b.Client, owner))
+	require.Equal(t, uint64(0), coinValue(t, b.Client, recipient))
+
+	// A timelock can also be the timestamp of the blocks.
+	_, err = LockCoinsUntil(p, recipient, hashlock[:], time.Now())
+	require.Error(t, err)
+	id, err = LockCoinsUntil(p, recipient, hashlock[:],
+		time.Now().Add(2*time.Second))
+	require.NoError(t, err)
+	require.Error(t, RefundCoins(b.Client, id))
+	time.Sleep(2 * time.Second)
+	require.Error(t, ClaimCoins(b.Client, id, secret))
+	require.NoError(t, RefundCoins(b.Client, id))
+	require.Equal(t, uint64(100), coinValue(t, b.Client, owner))
+}
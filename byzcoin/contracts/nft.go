@@ -0,0 +1,516 @@
+package contracts
+
+import (
+	"crypto/sha256"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The nft contracts hold non-fungible tokens, like certificates or tickets.
+// A collection is protected by a darc, which decides who can mint new
+// tokens. Every token of the collection is a separate nft instance, owned by
+// an identity. Only the owner of a token can transfer it, or an identity it
+// approved for this token, or an operator it approved for all its tokens of
+// the collection. The operators of an owner are kept in an nft_operators
+// instance of their own, so that nobody can fill the collection.
+
+// ContractNFTCollectionID denotes a contract that mints non-fungible tokens.
+const ContractNFTCollectionID = "nft_collection"
+
+// ContractNFTID denotes a contract that holds a non-fungible token.
+const ContractNFTID = "nft"
+
+// ContractNFTOperatorsID denotes a contract that holds the operators of an
+// owner in a collection. Its instances can only be changed through the
+// collection.
+const ContractNFTOperatorsID = "nft_operators"
+
+// maxNFTOperators is the maximum number of operators of an owner in a
+// collection.
+const maxNFTOperators = 16
+
+// NFTCollection is the value of an nft_collection instance.
+type NFTCollection struct {
+	// Name and Symbol describe the collection.
+	Name   string
+	Symbol string
+	// Supply is the number of tokens minted and not burnt yet.
+	Supply uint64
+}
+
+// NFTOperators is the value of an nft_operators instance.
+type NFTOperators struct {
+	// Collection is the ID of the nft_collection instance.
+	Collection byzcoin.InstanceID
+	// Owner is the identity whose tokens the operators handle.
+	Owner string
+	// Operators are allowed to handle all the tokens of the owner.
+	Operators []string
+}
+
+// NFT is the value of an nft instance.
+type NFT struct {
+	// Collection is the ID of the nft_collection instance that minted the
+	// token.
+	Collection byzcoin.InstanceID
+	// TokenID is unique in the collection.
+	TokenID []byte
+	// Metadata describes the token.
+	Metadata []byte
+	// Owner is the identity owning the token.
+	Owner string
+	// Approved is an identity allowed to transfer the token, until its next
+	// transfer. It is empty if there is none.
+	Approved string
+	// Burnt is true once the token has been burnt. The instance is kept so
+	// that the token can't be minted again.
+	Burnt bool
+}
+
+// NFTDeriveID returns the ID of the nft instance of the token of the
+// collection.
+func NFTDeriveID(collection byzcoin.InstanceID, tokenID []byte) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write([]byte(ContractNFTID))
+	h.Write(collection.Slice())
+	h.Write(tokenID)
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// NFTOperatorsDeriveID returns the ID of the nft_operators instance of the
+// owner in the collection.
+func NFTOperatorsDeriveID(collection byzcoin.InstanceID, owner string) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write([]byte(ContractNFTOperatorsID))
+	h.Write(collection.Slice())
+	h.Write([]byte(owner))
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// getNFTOperators returns the operators of the owner in the collection, and
+// whether their instance exists.
+func getNFTOperators(rst byzcoin.ReadOnlyStateTrie, collection byzcoin.InstanceID,
+	owner string) (ops NFTOperators, exists bool, err error) {
+	ops = NFTOperators{Collection: collection, Owner: owner}
+	id := NFTOperatorsDeriveID(collection, owner)
+	pr, err := rst.GetProof(id.Slice())
+	if err != nil {
+		return ops, false, xerrors.Errorf("reading trie: %v", err)
+	}
+	if !pr.Match(id.Slice()) {
+		return ops, false, nil
+	}
+	buf, _, cid, _, err := rst.GetValues(id.Slice())
+	if err != nil {
+		return ops, false, xerrors.Errorf("reading trie: %v", err)
+	}
+	if cid != ContractNFTOperatorsID {
+		return ops, false, xerrors.New("instance is not an nft_operators")
+	}
+	err = protobuf.Decode(buf, &ops)
+	if err != nil {
+		return ops, false, xerrors.Errorf("couldn't unmarshal operators: %v", err)
+	}
+	return ops, true, nil
+}
+
+// identityArg returns the identity given in the argument.
+func identityArg(args byzcoin.Arguments, name string) (string, error) {
+	id, err := darc.ParseIdentity(string(args.Search(name)))
+	if err != nil {
+		return "", xerrors.Errorf("argument \"%s\" is not an identity: %v",
+			name, err)
+	}
+	return id.String(), nil
+}
+
+// ContractNFTCollection mints the tokens of a collection.
+//   - spawn creates the collection, with the "name" and "symbol" arguments.
+//   - mint creates the token given in the "tokenID" argument, with the
+//     "metadata" argument, and owned by the identity in the "owner"
+//     argument. Its instance ID is given by NFTDeriveID.
+//   - approveOperator and revokeOperator let the identity in the "operator"
+//     argument handle the tokens of the identity in the "owner" argument,
+//     which has to sign the instruction. The operators are stored in the
+//     nft_operators instance given by NFTOperatorsDeriveID, and an owner can
+//     have at most maxNFTOperators of them.
+//
+// A collection can only be deleted once all its tokens have been burnt.
+type ContractNFTCollection struct {
+	byzcoin.BasicContract
+	NFTCollection
+}
+
+func contractNFTCollectionFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &ContractNFTCollection{}
+	err := protobuf.Decode(in, &c.NFTCollection)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+func getNFTCollection(rst byzcoin.ReadOnlyStateTrie,
+	id byzcoin.InstanceID) (c NFTCollection, darcID darc.ID, err error) {
+	buf, _, cid, darcID, err := rst.GetValues(id.Slice())
+	if err != nil {
+		return c, nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	if cid != ContractNFTCollectionID {
+		return c, nil, xerrors.New("instance is not an nft_collection")
+	}
+	err = protobuf.Decode(buf, &c)
+	if err != nil {
+		return c, nil, xerrors.Errorf("couldn't unmarshal collection: %v", err)
+	}
+	return c, darcID, nil
+}
+
+// Spawn implements the byzcoin.Contract interface.
+func (c *ContractNFTCollection) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	c.NFTCollection = NFTCollection{
+		Name:   string(inst.Spawn.Args.Search("name")),
+		Symbol: string(inst.Spawn.Args.Search("symbol")),
+	}
+	if c.Name == "" {
+		return nil, nil, xerrors.New("a collection needs a name")
+	}
+	buf, err := protobuf.Encode(&c.NFTCollection)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode collection: %v", err)
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""),
+			ContractNFTCollectionID, buf, darcID),
+	}
+	return
+}
+
+// Invoke implements the byzcoin.Contract interface.
+func (c *ContractNFTCollection) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	args := inst.Invoke.Args
+	switch inst.Invoke.Command {
+	case "mint":
+		nft := NFT{
+			Collection: inst.InstanceID,
+			TokenID:    args.Search("tokenID"),
+			Metadata:   args.Search("metadata"),
+		}
+		if len(nft.TokenID) == 0 {
+			return nil, nil, xerrors.New("argument \"tokenID\" is missing")
+		}
+		nft.Owner, err = identityArg(args, "owner")
+		if err != nil {
+			return
+		}
+		id := NFTDeriveID(inst.InstanceID, nft.TokenID)
+		pr, err := rst.GetProof(id.Slice())
+		if err != nil {
+			return nil, nil, xerrors.Errorf("reading trie: %v", err)
+		}
+		if pr.Match(id.Slice()) {
+			return nil, nil, xerrors.Errorf("token %x has already been minted",
+				nft.TokenID)
+		}
+		buf, err := protobuf.Encode(&nft)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't encode nft: %v", err)
+		}
+		sc = append(sc, byzcoin.NewStateChange(byzcoin.Create, id,
+			ContractNFTID, buf, darcID))
+		c.Supply++
+		buf, err = protobuf.Encode(&c.NFTCollection)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't encode collection: %v", err)
+		}
+		sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
+			ContractNFTCollectionID, buf, darcID))
+	case "approveOperator", "revokeOperator":
+		owner, err := identityArg(args, "owner")
+		if err != nil {
+			return nil, nil, err
+		}
+		operator, err := identityArg(args, "operator")
+		if err != nil {
+			return nil, nil, err
+		}
+		ops, exists, err := getNFTOperators(rst, inst.InstanceID, owner)
+		if err != nil {
+			return nil, nil, err
+		}
+		var kept []string
+		for _, o := range ops.Operators {
+			if o != operator {
+				kept = append(kept, o)
+			}
+		}
+		if inst.Invoke.Command == "approveOperator" {
+			kept = append(kept, operator)
+			if len(kept) > maxNFTOperators {
+				return nil, nil, xerrors.Errorf("an owner can't have more "+
+					"than %d operators", maxNFTOperators)
+			}
+		}
+		ops.Operators = kept
+
+		id := NFTOperatorsDeriveID(inst.InstanceID, owner)
+		switch {
+		case len(kept) == 0 && exists:
+			sc = append(sc, byzcoin.NewStateChange(byzcoin.Remove, id,
+				ContractNFTOperatorsID, nil, darcID))
+		case len(kept) > 0:
+			buf, err := protobuf.Encode(&ops)
+			if err != nil {
+				return nil, nil, xerrors.Errorf("couldn't encode operators: %v", err)
+			}
+			action := byzcoin.Update
+			if !exists {
+				action = byzcoin.Create
+			}
+			sc = append(sc, byzcoin.NewStateChange(action, id,
+				ContractNFTOperatorsID, buf, darcID))
+		}
+	default:
+		return nil, nil, xerrors.New("nft_collection contract can only mint " +
+			"and approve or revoke operators")
+	}
+	return
+}
+
+// Delete implements the byzcoin.Contract interface.
+func (c *ContractNFTCollection) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if c.Supply > 0 {
+		return nil, nil, xerrors.New("cannot delete a collection with tokens left")
+	}
+	sc = byzcoin.StateChanges{
+		byzcoin.NewStateChange(byzcoin.Remove, inst.InstanceID,
+			ContractNFTCollectionID, nil, darcID),
+	}
+	return
+}
+
+// VerifyInstruction lets the owners approve and revoke their operators.
+func (c *ContractNFTCollection) VerifyInstruction(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, ctxHash []byte) error {
+	if inst.GetType() == byzcoin.InvokeType &&
+		(inst.Invoke.Command == "approveOperator" ||
+			inst.Invoke.Command == "revokeOperator") {
+		owner, err := identityArg(inst.Invoke.Args, "owner")
+		if err != nil {
+			return err
+		}
+		return inst.VerifyWithOption(rst, ctxHash, &byzcoin.VerificationOptions{
+			Expression: expression.InitOrExpr(owner)})
+	}
+	return c.BasicContract.VerifyInstruction(rst, inst, ctxHash)
+}
+
+// ContractNFT holds a token of a collection.
+//   - transfer gives the token to the identity in the "owner" argument.
+//   - approve lets the identity in the "approved" argument transfer the
+//     token, or nobody else if it is missing.
+//
+// Deleting the instance burns the token: the instance is kept, marked as
+// burnt, so that the token can't be minted again, and it can't be changed
+// anymore. The owner or one of its operators must sign the instructions. An
+// approved identity can only transfer the token.
+type ContractNFT struct {
+	byzcoin.BasicContract
+	NFT
+}
+
+func contractNFTFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &ContractNFT{}
+	err := protobuf.Decode(in, &c.NFT)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+// Invoke implements the byzcoin.Contract interface.
+func (c *ContractNFT) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if c.Burnt {
+		return nil, nil, xerrors.New("token has been burnt")
+	}
+	switch inst.Invoke.Command {
+	case "transfer":
+		c.Owner, err = identityArg(inst.Invoke.Args, "owner")
+		if err != nil {
+			return
+		}
+		c.Approved = ""
+	case "approve":
+		c.Approved = ""
+		if inst.Invoke.Args.Search("approved") != nil {
+			c.Approved, err = identityArg(inst.Invoke.Args, "approved")
+			if err != nil {
+				return
+			}
+		}
+	default:
+		return nil, nil, xerrors.New("nft contract can only transfer and approve")
+	}
+
+	buf, err := protobuf.Encode(&c.NFT)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode nft: %v", err)
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractNFTID,
+			buf, darcID),
+	}
+	return
+}
+
+// Delete implements the byzcoin.Contract interface.
+func (c *ContractNFT) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+	if c.Burnt {
+		return nil, nil, xerrors.New("token has already been burnt")
+	}
+
+	coll, collDarcID, err := getNFTCollection(rst, c.Collection)
+	if err != nil {
+		return
+	}
+	coll.Supply--
+	collBuf, err := protobuf.Encode(&coll)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode collection: %v", err)
+	}
+	c.Burnt = true
+	c.Approved = ""
+	buf, err := protobuf.Encode(&c.NFT)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode nft: %v", err)
+	}
+	sc = byzcoin.StateChanges{
+		byzcoin.NewStateChange(byzcoin.Update, c.Collection,
+			ContractNFTCollectionID, collBuf, collDarcID),
+		byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID, ContractNFTID,
+			buf, darcID),
+	}
+	return
+}
+
+// VerifyInstruction checks that the owner of the token, or one of its
+// operators, signed the instruction. The approved identity can also sign a
+// transfer.
+func (c *ContractNFT) VerifyInstruction(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, ctxHash []byte) error {
+	ops, _, err := getNFTOperators(rst, c.Collection, c.Owner)
+	if err != nil {
+		return err
+	}
+	ids := append([]string{c.Owner}, ops.Operators...)
+	if inst.GetType() == byzcoin.InvokeType &&
+		inst.Invoke.Command == "transfer" && c.Approved != "" {
+		ids = append(ids, c.Approved)
+	}
+	return inst.VerifyWithOption(rst, ctxHash, &byzcoin.VerificationOptions{
+		Expression: expression.InitOrExpr(ids...)})
+}
+
+// GetNFTProof returns the proof of the nft instance from the genesis block,
+// so that its owner can prove the ownership to anybody knowing the genesis
+// block.
+func GetNFTProof(cl *byzcoin.Client, id byzcoin.InstanceID) (*byzcoin.Proof, error) {
+	pr, err := cl.GetProof(id.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("getting proof: %v", err)
+	}
+	return &pr.Proof, nil
+}
+
+// VerifyNFTProof verifies that the proof comes from the chain of the
+// genesis block and returns the token of the nft instance, unless it has
+// been burnt.
+func VerifyNFTProof(p byzcoin.Proof, genesis *skipchain.SkipBlock,
+	id byzcoin.InstanceID) (*NFT, error) {
+	err := p.VerifyFromBlock(genesis)
+	if err != nil {
+		return nil, xerrors.Errorf("invalid proof: %v", err)
+	}
+	buf, cid, _, err := p.Get(id.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("token not in proof: %v", err)
+	}
+	if cid != ContractNFTID {
+		return nil, xerrors.New("instance is not an nft")
+	}
+	var nft NFT
+	err = protobuf.Decode(buf, &nft)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal nft: %v", err)
+	}
+	if nft.Burnt {
+		return nil, xerrors.New("token has been burnt")
+	}
+	return &nft, nil
+}
+
+// ContractNFTOperators holds the operators of an owner in a collection. It
+// doesn't accept any instruction: the operators are approved and revoked
+// through the nft_collection contract.
+type ContractNFTOperators struct {
+	byzcoin.BasicContract
+	NFTOperators
+}
+
+func contractNFTOperatorsFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &ContractNFTOperators{}
+	err := protobuf.Decode(in, &c.NFTOperators)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+// Delete implements the byzcoin.Contract interface. The operators are only
+// removed by revoking them.
+func (c *ContractNFTOperators) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	return nil, nil, xerrors.New("operators are removed with " +
+		"nft_collection.revokeOperator")
+}
@@ -0,0 +1,155 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+)
+
+func nftInvoke(id byzcoin.InstanceID, contractID, command string,
+	args ...byzcoin.Argument) byzcoin.Instruction {
+	return byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: contractID,
+			Command:    command,
+			Args:       args,
+		},
+	}
+}
+
+func identityArgument(name string, s darc.Signer) byzcoin.Argument {
+	return byzcoin.Argument{Name: name, Value: []byte(s.Identity().String())}
+}
+
+func TestNFT(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules("spawn:nft_collection", "invoke:nft_collection.mint",
+		"delete:nft_collection")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	alice := darc.NewSignerEd25519(nil, nil)
+	bob := darc.NewSignerEd25519(nil, nil)
+	carol := darc.NewSignerEd25519(nil, nil)
+
+	ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+		byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractNFTCollectionID,
+				Args: byzcoin.Arguments{
+					{Name: "name", Value: []byte("Concert")},
+					{Name: "symbol", Value: []byte("TKT")},
+				},
+			},
+		})
+	require.NoError(t, b.Client.SignTransaction(ctx, b.Signer))
+	_, err := b.Client.AddTransactionAndWait(ctx, 10)
+	require.NoError(t, err)
+	coll := ctx.Instructions[0].DeriveID("")
+
+	// Only the darc of the collection can mint, and only once per token.
+	mint := nftInvoke(coll, ContractNFTCollectionID, "mint",
+		byzcoin.Argument{Name: "tokenID", Value: []byte("seat 1")},
+		byzcoin.Argument{Name: "metadata", Value: []byte("row A")},
+		identityArgument("owner", alice))
+	require.Error(t, signAndSend(b.Client, alice, mint))
+	require.NoError(t, signAndSend(b.Client, b.Signer, mint))
+	require.Error(t, signAndSend(b.Client, b.Signer, mint))
+	id := NFTDeriveID(coll, []byte("seat 1"))
+
+	transfer := func(s, to darc.Signer) error {
+		return signAndSend(b.Client, s, nftInvoke(id, ContractNFTID,
+			"transfer", identityArgument("owner", to)))
+	}
+	require.Error(t, transfer(bob, bob))
+	require.Error(t, transfer(b.Signer, bob))
+	require.NoError(t, transfer(alice, bob))
+
+	// An approved identity can transfer the token once.
+	require.NoError(t, signAndSend(b.Client, bob, nftInvoke(id, ContractNFTID,
+		"approve", identityArgument("approved", carol))))
+	require.NoError(t, transfer(carol, alice))
+	require.Error(t, transfer(carol, carol))
+
+	// An operator handles all the tokens of its owner, until it is revoked.
+	operator := func(s darc.Signer, command string) error {
+		return signAndSend(b.Client, s, nftInvoke(coll,
+			ContractNFTCollectionID, command, identityArgument("owner", alice),
+			identityArgument("operator", carol)))
+	}
+	require.Error(t, operator(carol, "approveOperator"))
+	require.NoError(t, operator(alice, "approveOperator"))
+	require.NoError(t, transfer(carol, alice))
+	require.NoError(t, operator(alice, "revokeOperator"))
+	require.Error(t, transfer(carol, bob))
+	require.NoError(t, transfer(alice, bob))
+
+	// The operators of an owner are limited, and stay out of the collection.
+	approve := func(op darc.Signer) error {
+		return signAndSend(b.Client, alice, nftInvoke(coll,
+			ContractNFTCollectionID, "approveOperator",
+			identityArgument("owner", alice), identityArgument("operator", op)))
+	}
+	for i := 0; i < maxNFTOperators; i++ {
+		require.NoError(t, approve(darc.NewSignerEd25519(nil, nil)))
+	}
+	require.Error(t, approve(carol))
+	opsID := NFTOperatorsDeriveID(coll, alice.Identity().String())
+	opsPr, err := b.Client.GetProofFromLatest(opsID.Slice())
+	require.NoError(t, err)
+	buf, cid, _, err := opsPr.Proof.Get(opsID.Slice())
+	require.NoError(t, err)
+	require.Equal(t, ContractNFTOperatorsID, cid)
+	var ops NFTOperators
+	require.NoError(t, protobuf.Decode(buf, &ops))
+	require.Len(t, ops.Operators, maxNFTOperators)
+	require.Equal(t, alice.Identity().String(), ops.Owner)
+
+	// The owner proves its ownership to anybody knowing the genesis block.
+	pr, err := GetNFTProof(b.Client, id)
+	require.NoError(t, err)
+	nft, err := VerifyNFTProof(*pr, b.Genesis, id)
+	require.NoError(t, err)
+	require.Equal(t, bob.Identity().String(), nft.Owner)
+	require.Equal(t, []byte("row A"), nft.Metadata)
+	_, err = VerifyNFTProof(*pr, b.Genesis, NFTDeriveID(coll, []byte("seat 2")))
+	require.Error(t, err)
+
+	// The collection can only be deleted once its tokens are burnt.
+	deleteColl := byzcoin.Instruction{
+		InstanceID: coll,
+		Delete:     &byzcoin.Delete{ContractID: ContractNFTCollectionID},
+	}
+	require.Error(t, signAndSend(b.Client, b.Signer, deleteColl))
+	burn := byzcoin.Instruction{
+		InstanceID: id,
+		Delete:     &byzcoin.Delete{ContractID: ContractNFTID},
+	}
+	require.NoError(t, signAndSend(b.Client, bob, burn))
+	require.Error(t, signAndSend(b.Client, bob, burn))
+	require.Error(t, transfer(bob, alice))
+
+	// A burnt token can't be minted again, and doesn't prove anything.
+	require.Error(t, signAndSend(b.Client, b.Signer, mint))
+	pr, err = GetNFTProof(b.Client, id)
+	require.NoError(t, err)
+	_, err = VerifyNFTProof(*pr, b.Genesis, id)
+	require.Error(t, err)
+	_, _, nftDarcID, err := pr.Get(id.Slice())
+	require.NoError(t, err)
+	require.Equal(t, b.GenesisDarc.GetBaseID(), nftDarcID)
+
+	collPr, err := b.Client.GetProofFromLatest(coll.Slice())
+	require.NoError(t, err)
+	buf, _, _, err = collPr.Proof.Get(coll.Slice())
+	require.NoError(t, err)
+	var c NFTCollection
+	require.NoError(t, protobuf.Decode(buf, &c))
+	require.Equal(t, uint64(0), c.Supply)
+	require.NoError(t, signAndSend(b.Client, b.Signer, deleteColl))
+}
@@ -0,0 +1,215 @@
+package contracts
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"time"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// SwapParty is one side of an atomic swap. It pays with coins of its own
+// chain and receives coins on the chain of the other party.
+type SwapParty struct {
+	// Client of the chain where the party pays.
+	Client *byzcoin.Client
+	// Signer must be allowed to "invoke:coin.fetch" on Coin and
+	// "spawn:htlc" on Darc.
+	Signer darc.Signer
+	Darc   darc.ID
+	// Coin pays Amount coins, and gets them back if the swap fails.
+	Coin   byzcoin.InstanceID
+	Amount uint64
+	// Receive is the coin instance on the chain of the other party that
+	// receives its coins.
+	Receive byzcoin.InstanceID
+}
+
+// GetHTLC returns the value of an htlc instance.
+func GetHTLC(cl *byzcoin.Client, id byzcoin.InstanceID) (*HTLC, error) {
+	pr, err := cl.GetProofFromLatest(id.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("getting proof: %v", err)
+	}
+	buf, cid, _, err := pr.Proof.Get(id.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("getting value: %v", err)
+	}
+	if cid != ContractHTLCID {
+		return nil, xerrors.New("instance is not an htlc")
+	}
+	var h HTLC
+	err = protobuf.Decode(buf, &h)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding htlc: %v", err)
+	}
+	return &h, nil
+}
+
+// latestIndex returns the index of the latest block of the chain.
+func latestIndex(cl *byzcoin.Client) (uint64, error) {
+	pr, err := cl.GetProofFromLatest(byzcoin.ConfigInstanceID.Slice())
+	if err != nil {
+		return 0, xerrors.Errorf("getting proof: %v", err)
+	}
+	return uint64(pr.Proof.Latest.Index), nil
+}
+
+// LockCoins locks the coins of the party in a new htlc instance, whose ID is
+// returned. The coins go to recipient if they are claimed before the block
+// timelock.
+func LockCoins(p SwapParty, recipient byzcoin.InstanceID, hashlock []byte,
+	timelock uint64) (byzcoin.InstanceID, error) {
+	return lockCoins(p, recipient, hashlock, timelock, "block")
+}
+
+// LockCoinsUntil locks the coins of the party in a new htlc instance, whose
+// ID is returned. The coins go to recipient if they are claimed in a block
+// created before the deadline.
+func LockCoinsUntil(p SwapParty, recipient byzcoin.InstanceID, hashlock []byte,
+	deadline time.Time) (byzcoin.InstanceID, error) {
+	return lockCoins(p, recipient, hashlock, uint64(deadline.UnixNano()), "time")
+}
+
+func lockCoins(p SwapParty, recipient byzcoin.InstanceID, hashlock []byte,
+	timelock uint64, schedule string) (byzcoin.InstanceID, error) {
+	amount := make([]byte, 8)
+	binary.LittleEndian.PutUint64(amount, p.Amount)
+	timelockBuf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(timelockBuf, timelock)
+	ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+		byzcoin.Instruction{
+			InstanceID: p.Coin,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractCoinID,
+				Command:    "fetch",
+				Args:       byzcoin.Arguments{{Name: "coins", Value: amount}},
+			},
+		},
+		byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(p.Darc),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractHTLCID,
+				Args: byzcoin.Arguments{
+					{Name: "hashlock", Value: hashlock},
+					{Name: "timelock", Value: timelockBuf},
+					{Name: "schedule", Value: []byte(schedule)},
+					{Name: "recipient", Value: recipient.Slice()},
+					{Name: "refund", Value: p.Coin.Slice()},
+				},
+			},
+		})
+	err := p.Client.SignTransaction(ctx, p.Signer)
+	if err != nil {
+		return byzcoin.InstanceID{}, xerrors.Errorf("signing: %v", err)
+	}
+	_, err = p.Client.AddTransactionAndWait(ctx, 10)
+	if err != nil {
+		return byzcoin.InstanceID{}, xerrors.Errorf("locking coins: %v", err)
+	}
+	return ctx.Instructions[1].DeriveID(""), nil
+}
+
+// releaseCoins sends an unsigned claim or refund to the htlc instance. A
+// random nonce is added to the arguments, as the leader ignores a
+// transaction that it already got, even if it was refused. As the
+// transaction has no fee coin, it is refused by a chain charging fees for
+// it.
+func releaseCoins(cl *byzcoin.Client, id byzcoin.InstanceID, command string,
+	args byzcoin.Arguments) error {
+	args = append(args, byzcoin.Argument{Name: "nonce",
+		Value: random.Bits(128, true, random.New())})
+	ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+		byzcoin.Instruction{
+			InstanceID: id,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractHTLCID,
+				Command:    command,
+				Args:       args,
+			},
+		})
+	_, err := cl.AddTransactionAndWait(ctx, 10)
+	return err
+}
+
+// ClaimCoins sends the coins of the htlc instance to its recipient.
+func ClaimCoins(cl *byzcoin.Client, id byzcoin.InstanceID, preimage []byte) error {
+	err := releaseCoins(cl, id, "claim",
+		byzcoin.Arguments{{Name: "preimage", Value: preimage}})
+	if err != nil {
+		return xerrors.Errorf("claiming coins: %v", err)
+	}
+	return nil
+}
+
+// RefundCoins sends the coins of the htlc instance back to its owner, once
+// the timelock expired.
+func RefundCoins(cl *byzcoin.Client, id byzcoin.InstanceID) error {
+	err := releaseCoins(cl, id, "refund", nil)
+	if err != nil {
+		return xerrors.Errorf("refunding coins: %v", err)
+	}
+	return nil
+}
+
+// Swap runs a full atomic swap between the two parties. The first party
+// chooses the secret and locks its coins for 2*timeout. The second party
+// checks them and locks its coins with the same hashlock for timeout. The
+// first party then claims the coins of the second one, which reveals the
+// secret, and the second party uses it to claim the coins of the first one.
+// The timelocks are block timestamps, so that they expire at the same time
+// on both chains, whatever their block intervals. If a step fails, the
+// locked coins can be refunded with RefundCoins once the timelocks expired.
+// The IDs of the htlc instances on the chains of a and b are returned.
+//
+// The claims are not signed, so the htlc contract must not cost any fees on
+// the chains, see ContractHTLC.
+func Swap(a, b SwapParty, timeout time.Duration) (byzcoin.InstanceID,
+	byzcoin.InstanceID, error) {
+	var idA, idB byzcoin.InstanceID
+	secret := random.Bits(256, true, random.New())
+	hashlock := sha256.Sum256(secret)
+
+	idA, err := LockCoinsUntil(a, b.Receive, hashlock[:],
+		time.Now().Add(2*timeout))
+	if err != nil {
+		return idA, idB, xerrors.Errorf("first party: %v", err)
+	}
+
+	// The second party only locks its coins once it is sure it can get the
+	// coins of the first one, with enough time left to claim them after its
+	// own timelock expired.
+	htlcA, err := GetHTLC(a.Client, idA)
+	if err != nil {
+		return idA, idB, err
+	}
+	deadlineB := time.Now().Add(timeout)
+	if htlcA.Coin.Value != a.Amount || !htlcA.Recipient.Equal(b.Receive) ||
+		!bytes.Equal(htlcA.Hashlock, hashlock[:]) || !htlcA.Timestamp ||
+		time.Unix(0, int64(htlcA.Timelock)).Before(deadlineB.Add(timeout/2)) {
+		return idA, idB, xerrors.New("coins of the first party are not locked")
+	}
+	idB, err = LockCoinsUntil(b, a.Receive, hashlock[:], deadlineB)
+	if err != nil {
+		return idA, idB, xerrors.Errorf("second party: %v", err)
+	}
+
+	err = ClaimCoins(b.Client, idB, secret)
+	if err != nil {
+		return idA, idB, xerrors.Errorf("first party: %v", err)
+	}
+	htlcB, err := GetHTLC(b.Client, idB)
+	if err != nil {
+		return idA, idB, err
+	}
+	err = ClaimCoins(a.Client, idA, htlcB.Preimage)
+	if err != nil {
+		return idA, idB, xerrors.Errorf("second party: %v", err)
+	}
+	return idA, idB, nil
+}
@@ -0,0 +1,234 @@
+package contracts
+
+import (
+	"encoding/binary"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// The token contract defines a fungible token. The accounts of a token are
+// coin instances whose type, the Name of the coin, is the ID of the token
+// instance. They transfer, fetch, store and approve coins like any other
+// coin instance, but new coins can only be minted through the token, which
+// keeps track of the total supply.
+
+// ContractTokenID denotes a contract that defines a token.
+const ContractTokenID = "token"
+
+// Token is the value of a token instance.
+type Token struct {
+	// Name and Symbol describe the token, e.g. "Swiss Franc" and "CHF".
+	Name   string
+	Symbol string
+	// Decimals is the number of decimals shown to the users, so that 1234
+	// coins of a token with 2 decimals are displayed as 12.34.
+	Decimals uint32
+	// TotalSupply is the number of coins minted and not burnt yet.
+	TotalSupply uint64
+	// Cap is the maximum total supply, 0 if there is none.
+	Cap uint64
+	// MintDarc is the darc protecting the token instance, whose
+	// "invoke:token.mint" rule tells who can mint new coins.
+	MintDarc darc.ID
+}
+
+// ContractToken holds the definition of a token.
+//   - spawn creates the token. The arguments are "name", "symbol", and the
+//     optional "decimals" and "cap", 64-bit uints in LittleEndian. The darc
+//     given in the optional argument "mintDarc" protects the new instance,
+//     else it is the darc of the spawner.
+//   - mint adds "coins" coins to the coin instance given in "destination",
+//     which must have the ID of the token as type, as long as the total
+//     supply stays below the cap.
+//   - burn destroys the coins of the token given to the instruction, for
+//     example by a previous fetch. It doesn't need to be signed.
+//
+// A token can only be deleted once all its coins have been burnt.
+type ContractToken struct {
+	byzcoin.BasicContract
+	Token
+}
+
+func contractTokenFromBytes(in []byte) (byzcoin.Contract, error) {
+	c := &ContractToken{}
+	err := protobuf.Decode(in, &c.Token)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal instance data: %v", err)
+	}
+	return c, nil
+}
+
+// isToken returns whether the coins with the given name belong to a token.
+func isToken(rst byzcoin.ReadOnlyStateTrie, name byzcoin.InstanceID) bool {
+	_, _, cid, _, err := rst.GetValues(name.Slice())
+	return err == nil && cid == ContractTokenID
+}
+
+// GetToken returns the token of the given instance.
+func GetToken(rst byzcoin.ReadOnlyStateTrie, id byzcoin.InstanceID) (*Token, error) {
+	buf, _, cid, _, err := rst.GetValues(id.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	if cid != ContractTokenID {
+		return nil, xerrors.New("instance is not a token")
+	}
+	var t Token
+	err = protobuf.Decode(buf, &t)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't unmarshal token: %v", err)
+	}
+	return &t, nil
+}
+
+// optionalUint64 returns the 64-bit uint in LittleEndian of the argument,
+// or 0 if it is missing.
+func optionalUint64(args byzcoin.Arguments, name string) (uint64, error) {
+	buf := args.Search(name)
+	if buf == nil {
+		return 0, nil
+	}
+	if len(buf) != 8 {
+		return 0, xerrors.Errorf("argument \"%s\" is wrong length", name)
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// Spawn implements the byzcoin.Contract interface.
+func (c *ContractToken) Spawn(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	args := inst.Spawn.Args
+	c.Token = Token{
+		Name:   string(args.Search("name")),
+		Symbol: string(args.Search("symbol")),
+	}
+	if c.Name == "" || c.Symbol == "" {
+		return nil, nil, xerrors.New("a token needs a name and a symbol")
+	}
+	decimals, err := optionalUint64(args, "decimals")
+	if err != nil {
+		return
+	}
+	if decimals > 18 {
+		return nil, nil, xerrors.New("a token has at most 18 decimals")
+	}
+	c.Decimals = uint32(decimals)
+	c.Cap, err = optionalUint64(args, "cap")
+	if err != nil {
+		return
+	}
+	c.MintDarc = darcID
+	if did := args.Search("mintDarc"); did != nil {
+		if _, err = rst.LoadDarc(did); err != nil {
+			return nil, nil, xerrors.Errorf("unknown mint darc: %v", err)
+		}
+		c.MintDarc = darc.ID(did)
+	}
+
+	buf, err := protobuf.Encode(&c.Token)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode token: %v", err)
+	}
+	sc = []byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, inst.DeriveID(""),
+			ContractTokenID, buf, c.MintDarc),
+	}
+	return
+}
+
+// Invoke implements the byzcoin.Contract interface.
+func (c *ContractToken) Invoke(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	switch inst.Invoke.Command {
+	case "mint":
+		coinsBuf := inst.Invoke.Args.Search("coins")
+		if len(coinsBuf) != 8 {
+			return nil, nil, xerrors.New("argument \"coins\" must be a uint64")
+		}
+		value := binary.LittleEndian.Uint64(coinsBuf)
+		supply := c.TotalSupply + value
+		if supply < c.TotalSupply {
+			return nil, nil, xerrors.New("total supply overflows")
+		}
+		if c.Cap > 0 && supply > c.Cap {
+			return nil, nil, xerrors.Errorf("minting %d coins exceeds the cap "+
+				"of %d", value, c.Cap)
+		}
+		c.TotalSupply = supply
+
+		target := byzcoin.NewInstanceID(inst.Invoke.Args.Search("destination"))
+		var coin byzcoin.Coin
+		var coinDarcID darc.ID
+		coin, coinDarcID, err = getCoin(rst, target, inst.InstanceID)
+		if err != nil {
+			return
+		}
+		err = coin.SafeAdd(value)
+		if err != nil {
+			return
+		}
+		var coinBuf []byte
+		coinBuf, err = protobuf.Encode(&coin)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't encode coin: %v", err)
+		}
+		sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, target,
+			ContractCoinID, coinBuf, coinDarcID))
+	case "burn":
+		cout = []byzcoin.Coin{}
+		for _, co := range coins {
+			if !co.Name.Equal(inst.InstanceID) {
+				cout = append(cout, co)
+				continue
+			}
+			if co.Value > c.TotalSupply {
+				return nil, nil, xerrors.New("burning more coins than minted")
+			}
+			c.TotalSupply -= co.Value
+		}
+	default:
+		return nil, nil, xerrors.New("token contract can only mint and burn")
+	}
+
+	buf, err := protobuf.Encode(&c.Token)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("couldn't encode token: %v", err)
+	}
+	sc = append(sc, byzcoin.NewStateChange(byzcoin.Update, inst.InstanceID,
+		ContractTokenID, buf, c.MintDarc))
+	return
+}
+
+// Delete implements the byzcoin.Contract interface.
+func (c *ContractToken) Delete(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange, cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	if c.TotalSupply > 0 {
+		return nil, nil, xerrors.New("cannot delete a token with coins left")
+	}
+	sc = byzcoin.StateChanges{
+		byzcoin.NewStateChange(byzcoin.Remove, inst.InstanceID, ContractTokenID,
+			nil, c.MintDarc),
+	}
+	return
+}
+
+// VerifyInstruction lets anybody burn coins, as they have to be fetched
+// from an account first.
+func (c *ContractToken) VerifyInstruction(rst byzcoin.ReadOnlyStateTrie, inst byzcoin.Instruction, ctxHash []byte) error {
+	if inst.GetType() == byzcoin.InvokeType && inst.Invoke.Command == "burn" {
+		return nil
+	}
+	return c.BasicContract.VerifyInstruction(rst, inst, ctxHash)
+}
@@ -0,0 +1,195 @@
+package contracts
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
+	"go.dedis.ch/kyber/v3/util/random"
+)
+
+var tokenRules = []string{"spawn:coin", "spawn:token",
+	"invoke:token.mint", "invoke:coin.mint", "invoke:coin.transfer",
+	"invoke:coin.fetch", "invoke:coin.approve", "invoke:coin.transferFrom"}
+
+// signAndSend sends the instructions in one transaction signed by signer.
+func signAndSend(cl *byzcoin.Client, signer darc.Signer,
+	instrs ...byzcoin.Instruction) error {
+	ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion, instrs...)
+	err := cl.SignTransaction(ctx, signer)
+	if err != nil {
+		return err
+	}
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	return err
+}
+
+// spawnAccount creates an empty coin instance of the token, protected by d.
+func spawnAccount(t *testing.T, b *byzcoin.BCTest, token byzcoin.InstanceID,
+	d darc.ID) byzcoin.InstanceID {
+	coinID := random.Bits(256, true, random.New())
+	require.NoError(t, signAndSend(b.Client, b.Signer, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractCoinID,
+			Args: byzcoin.Arguments{
+				{Name: "coinID", Value: coinID},
+				{Name: "type", Value: token.Slice()},
+				{Name: "darcID", Value: d},
+			},
+		},
+	}))
+	return ContractCoinDeriveID(coinID)
+}
+
+func coinInvoke(id byzcoin.InstanceID, command string,
+	args ...byzcoin.Argument) byzcoin.Instruction {
+	return byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractCoinID,
+			Command:    command,
+			Args:       args,
+		},
+	}
+}
+
+func tokenInvoke(id byzcoin.InstanceID, command string,
+	args ...byzcoin.Argument) byzcoin.Instruction {
+	return byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractTokenID,
+			Command:    command,
+			Args:       args,
+		},
+	}
+}
+
+func getToken(t *testing.T, cl *byzcoin.Client, id byzcoin.InstanceID) *Token {
+	pr, err := cl.GetProofFromLatest(id.Slice())
+	require.NoError(t, err)
+	buf, cid, _, err := pr.Proof.Get(id.Slice())
+	require.NoError(t, err)
+	require.Equal(t, ContractTokenID, cid)
+	tok, err := contractTokenFromBytes(buf)
+	require.NoError(t, err)
+	return &tok.(*ContractToken).Token
+}
+
+func TestToken(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.AddGenesisRules(tokenRules...)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+	darcID := b.GenesisDarc.GetBaseID()
+
+	// The spender has its own darc.
+	spender := darc.NewSignerEd25519(nil, nil)
+	spenderDarc := darc.NewDarc(darc.InitRules(
+		[]darc.Identity{spender.Identity()},
+		[]darc.Identity{spender.Identity()}), []byte("spender"))
+	require.NoError(t, spenderDarc.Rules.AddRule("invoke:coin.transferFrom",
+		expression.Expr(spender.Identity().String())))
+	darcBuf, err := spenderDarc.ToProto()
+	require.NoError(t, err)
+	require.NoError(t, signAndSend(b.Client, b.Signer, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(darcID),
+		Spawn: &byzcoin.Spawn{
+			ContractID: byzcoin.ContractDarcID,
+			Args:       byzcoin.Arguments{{Name: "darc", Value: darcBuf}},
+		},
+	}))
+
+	ctx := byzcoin.NewClientTransaction(byzcoin.CurrentVersion,
+		byzcoin.Instruction{
+			InstanceID: byzcoin.NewInstanceID(darcID),
+			Spawn: &byzcoin.Spawn{
+				ContractID: ContractTokenID,
+				Args: byzcoin.Arguments{
+					{Name: "name", Value: []byte("Gold")},
+					{Name: "symbol", Value: []byte("GLD")},
+					{Name: "decimals", Value: u64Buf(2)},
+					{Name: "cap", Value: u64Buf(1000)},
+				},
+			},
+		})
+	require.NoError(t, b.Client.SignTransaction(ctx, b.Signer))
+	_, err = b.Client.AddTransactionAndWait(ctx, 10)
+	require.NoError(t, err)
+	tokenID := ctx.Instructions[0].DeriveID("")
+	tok := getToken(t, b.Client, tokenID)
+	require.Equal(t, "GLD", tok.Symbol)
+	require.Equal(t, uint32(2), tok.Decimals)
+	require.Equal(t, darcID, tok.MintDarc)
+
+	owner := spawnAccount(t, b, tokenID, darcID)
+	other := spawnAccount(t, b, tokenID, darcID)
+	taker := spawnAccount(t, b, tokenID, spenderDarc.GetBaseID())
+	byzCoins := spawnCoin(t, b.Client, b.Signer, darcID, CoinName, 100)
+
+	// Minting goes through the token and respects the cap.
+	require.Error(t, signAndSend(b.Client, b.Signer,
+		coinInvoke(owner, "mint", byzcoin.Argument{Name: "coins", Value: u64Buf(10)})))
+	require.Error(t, signAndSend(b.Client, b.Signer,
+		tokenInvoke(tokenID, "mint",
+			byzcoin.Argument{Name: "coins", Value: u64Buf(1001)},
+			byzcoin.Argument{Name: "destination", Value: owner.Slice()})))
+	require.Error(t, signAndSend(b.Client, b.Signer,
+		tokenInvoke(tokenID, "mint",
+			byzcoin.Argument{Name: "coins", Value: u64Buf(10)},
+			byzcoin.Argument{Name: "destination", Value: byzCoins.Slice()})))
+	require.NoError(t, signAndSend(b.Client, b.Signer,
+		tokenInvoke(tokenID, "mint",
+			byzcoin.Argument{Name: "coins", Value: u64Buf(1000)},
+			byzcoin.Argument{Name: "destination", Value: owner.Slice()})))
+	require.Equal(t, uint64(1000), getToken(t, b.Client, tokenID).TotalSupply)
+	require.Equal(t, uint64(1000), coinValue(t, b.Client, owner))
+
+	// Coins of different types can't be mixed.
+	require.Error(t, signAndSend(b.Client, b.Signer,
+		coinInvoke(owner, "transfer",
+			byzcoin.Argument{Name: "coins", Value: u64Buf(10)},
+			byzcoin.Argument{Name: "destination", Value: byzCoins.Slice()})))
+
+	// The spender can only take the approved coins.
+	transferFrom := func(value uint64) error {
+		return signAndSend(b.Client, spender, coinInvoke(taker, "transferFrom",
+			byzcoin.Argument{Name: "coins", Value: u64Buf(value)},
+			byzcoin.Argument{Name: "from", Value: owner.Slice()},
+			byzcoin.Argument{Name: "destination", Value: other.Slice()}))
+	}
+	require.Error(t, transferFrom(10))
+	require.NoError(t, signAndSend(b.Client, b.Signer,
+		coinInvoke(owner, "approve",
+			byzcoin.Argument{Name: "coins", Value: u64Buf(300)},
+			byzcoin.Argument{Name: "spender", Value: taker.Slice()})))
+	require.NoError(t, transferFrom(200))
+	require.Error(t, transferFrom(200))
+	require.NoError(t, transferFrom(100))
+	require.Error(t, transferFrom(1))
+	require.Equal(t, uint64(700), coinValue(t, b.Client, owner))
+	require.Equal(t, uint64(300), coinValue(t, b.Client, other))
+	require.Equal(t, uint64(0), coinValue(t, b.Client, taker))
+
+	// Only the darc of the owner can approve.
+	require.Error(t, signAndSend(b.Client, spender,
+		coinInvoke(owner, "approve",
+			byzcoin.Argument{Name: "coins", Value: u64Buf(300)},
+			byzcoin.Argument{Name: "spender", Value: taker.Slice()})))
+
+	// Burnt coins are removed from the supply, which allows to mint again.
+	require.NoError(t, signAndSend(b.Client, b.Signer,
+		coinInvoke(owner, "fetch",
+			byzcoin.Argument{Name: "coins", Value: u64Buf(400)}),
+		tokenInvoke(tokenID, "burn")))
+	require.Equal(t, uint64(300), coinValue(t, b.Client, owner))
+	require.Equal(t, uint64(600), getToken(t, b.Client, tokenID).TotalSupply)
+	require.NoError(t, signAndSend(b.Client, b.Signer,
+		tokenInvoke(tokenID, "mint",
+			byzcoin.Argument{Name: "coins", Value: u64Buf(400)},
+			byzcoin.Argument{Name: "destination", Value: other.Slice()})))
+	require.Equal(t, uint64(1000), getToken(t, b.Client, tokenID).TotalSupply)
+}
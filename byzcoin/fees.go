@@ -0,0 +1,331 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// FeeCoinArgument is the name of the argument of the first instruction of a
+// transaction that points to the coin instance paying the fees. As it is part
+// of the instruction, it is covered by the signatures of the transaction.
+const FeeCoinArgument = "fee_coin"
+
+// FeeTipArgument is the name of the argument of the first instruction of a
+// transaction that holds the tip offered to the leader, as a little-endian
+// uint64. The tip is charged on top of the fee, and transactions with a
+// higher tip are proposed first.
+const FeeTipArgument = "fee_tip"
+
+// feeCoinContractID is the contract of the coin instances used to pay fees.
+// It is the same as contracts.ContractCoinID, which cannot be imported here.
+const feeCoinContractID = "coin"
+
+// SetFeeCoin adds the FeeCoinArgument to the first instruction of the
+// transaction. It must be called before the transaction is signed.
+func (ctx *ClientTransaction) SetFeeCoin(coin InstanceID) error {
+	return ctx.addFeeArgument(Argument{Name: FeeCoinArgument, Value: coin[:]})
+}
+
+// SetFeeTip adds the FeeTipArgument to the first instruction of the
+// transaction. It must be called before the transaction is signed.
+func (ctx *ClientTransaction) SetFeeTip(tip uint64) error {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, tip)
+	return ctx.addFeeArgument(Argument{Name: FeeTipArgument, Value: buf})
+}
+
+func (ctx *ClientTransaction) addFeeArgument(arg Argument) error {
+	if len(ctx.Instructions) == 0 {
+		return xerrors.New("transaction has no instructions")
+	}
+	instr := &ctx.Instructions[0]
+	switch instr.GetType() {
+	case SpawnType:
+		instr.Spawn.Args = append(instr.Spawn.Args, arg)
+	case InvokeType:
+		instr.Invoke.Args = append(instr.Invoke.Args, arg)
+	case DeleteType:
+		instr.Delete.Args = append(instr.Delete.Args, arg)
+	default:
+		return xerrors.New("invalid instruction type")
+	}
+	return nil
+}
+
+// FeeCoin returns the coin instance paying the fees of the transaction, if
+// it is set.
+func (ctx ClientTransaction) FeeCoin() (InstanceID, error) {
+	if len(ctx.Instructions) == 0 {
+		return InstanceID{}, xerrors.New("transaction has no instructions")
+	}
+	buf := ctx.Instructions[0].Arguments().Search(FeeCoinArgument)
+	if buf == nil {
+		return InstanceID{}, xerrors.New("transaction has no fee coin")
+	}
+	if len(buf) != len(InstanceID{}) {
+		return InstanceID{}, xerrors.New("fee coin is not an InstanceID")
+	}
+	return NewInstanceID(buf), nil
+}
+
+// FeeTip returns the tip offered by the transaction, or 0 if there is none.
+func (ctx ClientTransaction) FeeTip() (uint64, error) {
+	if len(ctx.Instructions) == 0 {
+		return 0, nil
+	}
+	buf := ctx.Instructions[0].Arguments().Search(FeeTipArgument)
+	if buf == nil {
+		return 0, nil
+	}
+	if len(buf) != 8 {
+		return 0, xerrors.New("fee tip is not a uint64")
+	}
+	return binary.LittleEndian.Uint64(buf), nil
+}
+
+// sanityCheck makes sure the fee configuration can be used.
+func (fc FeeConfig) sanityCheck() error {
+	if fc.CoinName.Equal(InstanceID{}) {
+		return xerrors.New("fees need a coin name")
+	}
+	if fc.Collector.Equal(InstanceID{}) {
+		return xerrors.New("fees need a collector instance")
+	}
+	seen := make(map[string]bool)
+	for _, cc := range fc.Costs {
+		if seen[cc.ContractID] {
+			return xerrors.Errorf("contract %s has more than one cost",
+				cc.ContractID)
+		}
+		seen[cc.ContractID] = true
+	}
+	return nil
+}
+
+// instructionCost returns the cost of the instruction, given the state
+// changes it produced. Instructions on the configuration instance are free,
+// so that a badly configured chain can always be repaired. The executions of
+// the scheduler contract are also free, as they are added by the leader and
+// have nobody to pay for them. Instead, the instruction creating a scheduler
+// pays for all its executions, see schedulerCost.
+func (fc FeeConfig) instructionCost(instr Instruction,
+	scs StateChanges) (uint64, error) {
+	if instr.InstanceID.Equal(ConfigInstanceID) {
+		return 0, nil
+	}
+	if instr.GetType() == InvokeType &&
+		instr.Invoke.ContractID == ContractSchedulerID &&
+		instr.Invoke.Command == "execute" {
+		return 0, nil
+	}
+
+	cost := Coin{Value: fc.DefaultCost}
+	for _, cc := range fc.Costs {
+		if cc.ContractID == instr.ContractID() {
+			switch instr.GetType() {
+			case SpawnType:
+				cost.Value = cc.Spawn
+			case InvokeType:
+				cost.Value = cc.Invoke
+			case DeleteType:
+				cost.Value = cc.Delete
+			}
+			break
+		}
+	}
+
+	for _, sc := range scs {
+		if sc.StateAction != Create && sc.StateAction != Update {
+			continue
+		}
+		size := uint64(len(sc.InstanceID) + len(sc.Value))
+		if fc.ByteCost > 0 && size > ^uint64(0)/fc.ByteCost {
+			return 0, xerrors.New("state changes are too expensive")
+		}
+		if err := cost.SafeAdd(size * fc.ByteCost); err != nil {
+			return 0, xerrors.Errorf("adding byte cost: %v", err)
+		}
+		if sc.StateAction == Create && sc.ContractID == ContractSchedulerID {
+			prepaid, err := fc.schedulerCost(sc.Value)
+			if err == nil {
+				err = cost.SafeAdd(prepaid)
+			}
+			if err != nil {
+				return 0, xerrors.Errorf("adding scheduler cost: %v", err)
+			}
+		}
+	}
+	return cost.Value, nil
+}
+
+// schedulerCost returns the cost of all the executions of a new scheduler,
+// given its data. Every execution costs its instructions, without the byte
+// cost, which is only known once they are executed. Nothing is refunded if
+// the scheduler is deleted or if an execution fails.
+func (fc FeeConfig) schedulerCost(buf []byte) (uint64, error) {
+	var data SchedulerData
+	if err := protobuf.Decode(buf, &data); err != nil {
+		return 0, xerrors.Errorf("decoding scheduler: %v", err)
+	}
+	var once Coin
+	for _, instr := range data.Instructions {
+		cost, err := fc.instructionCost(instr, nil)
+		if err == nil {
+			err = once.SafeAdd(cost)
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if data.Remaining > 0 && once.Value > ^uint64(0)/data.Remaining {
+		return 0, xerrors.New("the executions are too expensive")
+	}
+	return once.Value * data.Remaining, nil
+}
+
+// String returns a human readable representation of the fee configuration,
+// to be included in ChainConfig.String.
+func (fc FeeConfig) String() string {
+	res := new(strings.Builder)
+	fmt.Fprintf(res, "-- FeeConfig:\n")
+	fmt.Fprintf(res, "--- CoinName: %s\n", fc.CoinName)
+	fmt.Fprintf(res, "--- Collector: %s\n", fc.Collector)
+	fmt.Fprintf(res, "--- DefaultCost: %d\n", fc.DefaultCost)
+	fmt.Fprintf(res, "--- ByteCost: %d\n", fc.ByteCost)
+	for _, cc := range fc.Costs {
+		fmt.Fprintf(res, "--- Cost of %s: spawn=%d invoke=%d delete=%d\n",
+			cc.ContractID, cc.Spawn, cc.Invoke, cc.Delete)
+	}
+	return res.String()
+}
+
+// chargeFees returns the state changes that move the fee from the fee coin of
+// the transaction to the collector. The signers of the first instruction must
+// be allowed to fetch coins from the fee coin.
+func chargeFees(sst *stagingStateTrie, tx ClientTransaction, msg []byte,
+	fc *FeeConfig, fee uint64) (StateChanges, error) {
+	payer, err := tx.FeeCoin()
+	if err != nil {
+		return nil, xerrors.Errorf("getting fee coin: %v", err)
+	}
+
+	fetch := tx.Instructions[0]
+	fetch.InstanceID = payer
+	fetch.Spawn = nil
+	fetch.Delete = nil
+	fetch.Invoke = &Invoke{ContractID: feeCoinContractID, Command: "fetch"}
+	err = fetch.VerifyWithOption(sst, msg,
+		&VerificationOptions{IgnoreCounters: true})
+	if err != nil {
+		return nil, xerrors.Errorf("not allowed to pay with fee coin: %v", err)
+	}
+
+	debit, err := updateFeeCoin(sst, payer, fc.CoinName, func(c *Coin) error {
+		return c.SafeSub(fee)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("paying fee of %d: %v", fee, err)
+	}
+	if payer.Equal(fc.Collector) {
+		return nil, nil
+	}
+	credit, err := updateFeeCoin(sst, fc.Collector, fc.CoinName,
+		func(c *Coin) error {
+			return c.SafeAdd(fee)
+		})
+	if err != nil {
+		return nil, xerrors.Errorf("collecting fee of %d: %v", fee, err)
+	}
+	return StateChanges{debit, credit}, nil
+}
+
+// chargeRefusedTx returns the state changes of a transaction that is
+// included in a block but refused, and the staging trie they are stored in.
+// Such a transaction pays for its instructions without the byte cost, as it
+// changes no instance, and it uses up the signer counters of its first
+// instruction, so that it cannot be replayed to charge the fee again. If
+// there are no fees, if the counters are not valid or if the fee coin cannot
+// pay, the transaction is refused without changing anything.
+func chargeRefusedTx(sst *stagingStateTrie,
+	tx ClientTransaction) (StateChanges, *stagingStateTrie) {
+	config, err := sst.LoadConfig()
+	if err != nil || config.FeeConfig == nil || len(tx.Instructions) == 0 {
+		return nil, sst
+	}
+	fc := config.FeeConfig
+
+	var fee Coin
+	for _, instr := range tx.Instructions {
+		cost, err := fc.instructionCost(instr, nil)
+		if err == nil {
+			err = fee.SafeAdd(cost)
+		}
+		if err != nil {
+			log.Lvlf2("couldn't compute fee of refused transaction: %v", err)
+			return nil, sst
+		}
+	}
+	if fee.Value == 0 {
+		return nil, sst
+	}
+
+	first := tx.Instructions[0]
+	err = verifySignerCounters(sst, first.SignerCounter, first.SignerIdentities)
+	if err != nil {
+		log.Lvlf2("not charging refused transaction: %v", err)
+		return nil, sst
+	}
+	scs, err := chargeFees(sst, tx, tx.Instructions.Hash(), fc, fee.Value)
+	if err != nil {
+		log.Lvlf2("not charging refused transaction: %v", err)
+		return nil, sst
+	}
+	counterScs, err := incrementSignerCounters(sst, first.SignerIdentities)
+	if err != nil {
+		log.Lvlf2("not charging refused transaction: %v", err)
+		return nil, sst
+	}
+	scs = append(scs, counterScs...)
+
+	sstTx := sst.Clone()
+	if err = sstTx.StoreAll(scs); err != nil {
+		log.Lvlf2("not charging refused transaction: %v", err)
+		return nil, sst
+	}
+	return scs, sstTx
+}
+
+// updateFeeCoin applies f to the coin stored in the given instance and
+// returns the corresponding state change.
+func updateFeeCoin(sst *stagingStateTrie, id InstanceID, name InstanceID,
+	f func(*Coin) error) (StateChange, error) {
+	buf, ver, cid, darcID, err := sst.GetValues(id.Slice())
+	if err != nil {
+		return StateChange{}, xerrors.Errorf("reading coin %s: %v", id, err)
+	}
+	if cid != feeCoinContractID {
+		return StateChange{}, xerrors.Errorf("instance %s is not a coin", id)
+	}
+	var c Coin
+	if err = protobuf.Decode(buf, &c); err != nil {
+		return StateChange{}, xerrors.Errorf("decoding coin: %v", err)
+	}
+	if !c.Name.Equal(name) {
+		return StateChange{}, xerrors.Errorf("coin %s is of the wrong type", id)
+	}
+	if err = f(&c); err != nil {
+		return StateChange{}, err
+	}
+	buf, err = protobuf.Encode(&c)
+	if err != nil {
+		return StateChange{}, xerrors.Errorf("encoding coin: %v", err)
+	}
+	sc := NewStateChange(Update, id, feeCoinContractID, buf, darcID)
+	sc.Version = ver + 1
+	return sc, nil
+}
@@ -0,0 +1,201 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/protobuf"
+)
+
+func TestFeeConfig_InstructionCost(t *testing.T) {
+	fc := FeeConfig{
+		CoinName:    NewInstanceID([]byte("fees")),
+		Collector:   NewInstanceID([]byte("collector")),
+		DefaultCost: 1,
+		Costs: []ContractCost{
+			{ContractID: "value", Spawn: 10, Invoke: 5, Delete: 2},
+		},
+		ByteCost: 2,
+	}
+	require.NoError(t, fc.sanityCheck())
+
+	spawn := Instruction{
+		InstanceID: NewInstanceID([]byte("darc")),
+		Spawn:      &Spawn{ContractID: "value"},
+	}
+	cost, err := fc.instructionCost(spawn, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), cost)
+
+	// Every byte of created or updated instances is charged.
+	scs := StateChanges{
+		NewStateChange(Create, NewInstanceID(nil), "value", []byte{1, 2}, nil),
+		NewStateChange(Remove, NewInstanceID(nil), "value", nil, nil),
+	}
+	cost, err = fc.instructionCost(spawn, scs)
+	require.NoError(t, err)
+	require.Equal(t, uint64(10+2*(32+2)), cost)
+
+	invoke := Instruction{
+		InstanceID: NewInstanceID([]byte("value")),
+		Invoke:     &Invoke{ContractID: "dummy"},
+	}
+	cost, err = fc.instructionCost(invoke, nil)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), cost)
+
+	// A new scheduler pays for all its executions, which are free.
+	data := SchedulerData{
+		Instructions: Instructions{invoke, spawn},
+		Remaining:    3,
+	}
+	dataBuf, err := protobuf.Encode(&data)
+	require.NoError(t, err)
+	scheduler := Instruction{
+		InstanceID: NewInstanceID([]byte("darc")),
+		Spawn:      &Spawn{ContractID: ContractSchedulerID},
+	}
+	cost, err = fc.instructionCost(scheduler, StateChanges{
+		NewStateChange(Create, NewInstanceID(nil), ContractSchedulerID,
+			dataBuf, nil),
+	})
+	require.NoError(t, err)
+	require.Equal(t, uint64(1+2*(32+len(dataBuf))+3*(1+10)), cost)
+	cost, err = fc.instructionCost(newScheduledExecution(NewInstanceID(nil),
+		0).Instructions[0], scs)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), cost)
+
+	// The configuration can always be updated for free.
+	invoke.InstanceID = ConfigInstanceID
+	cost, err = fc.instructionCost(invoke, scs)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), cost)
+
+	fc.ByteCost = ^uint64(0)
+	_, err = fc.instructionCost(spawn, scs)
+	require.Error(t, err)
+
+	fc.Costs = append(fc.Costs, ContractCost{ContractID: "value"})
+	require.Error(t, fc.sanityCheck())
+	require.Error(t, FeeConfig{}.sanityCheck())
+}
+
+func TestClientTransaction_FeeCoin(t *testing.T) {
+	var ctx ClientTransaction
+	require.Error(t, ctx.SetFeeCoin(InstanceID{}))
+	_, err := ctx.FeeCoin()
+	require.Error(t, err)
+
+	ctx = NewClientTransaction(CurrentVersion, Instruction{
+		Invoke: &Invoke{ContractID: "value", Command: "update"},
+	})
+	_, err = ctx.FeeCoin()
+	require.Error(t, err)
+
+	h := ctx.Instructions.Hash()
+	coin := NewInstanceID([]byte("coin"))
+	require.NoError(t, ctx.SetFeeCoin(coin))
+	fc, err := ctx.FeeCoin()
+	require.NoError(t, err)
+	require.Equal(t, coin, fc)
+	// The fee coin must be part of what is signed.
+	require.NotEqual(t, h, ctx.Instructions.Hash())
+}
+
+func TestClientTransaction_FeeTip(t *testing.T) {
+	ctx := NewClientTransaction(CurrentVersion, Instruction{
+		Invoke: &Invoke{ContractID: "value", Command: "update"},
+	})
+	tip, err := ctx.FeeTip()
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), tip)
+
+	require.NoError(t, ctx.SetFeeTip(42))
+	tip, err = ctx.FeeTip()
+	require.NoError(t, err)
+	require.Equal(t, uint64(42), tip)
+
+	ctx.Instructions[0].Invoke.Args[0].Value = []byte{1}
+	_, err = ctx.FeeTip()
+	require.Error(t, err)
+}
+
+// The leader charges a refused transaction while it fills a block, so that
+// the next transaction of the same signer is accepted in the same block.
+func TestProposedTransactions_RefusedFee(t *testing.T) {
+	b := newBCT(t, nil)
+	b.AddGenesisRules("invoke:" + feeCoinContractID + ".fetch")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	s := b.Services[0]
+	st, err := s.getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	sst := st.MakeStagingStateTrie()
+
+	// The coins and the fees are stored directly in the staging trie.
+	darcID := b.GenesisDarc.GetBaseID()
+	coinName := NewInstanceID([]byte("fees"))
+	payer := NewInstanceID([]byte("payer"))
+	collector := NewInstanceID([]byte("collector"))
+	coinSc := func(id InstanceID, value uint64) StateChange {
+		buf, err := protobuf.Encode(&Coin{Name: coinName, Value: value})
+		require.NoError(t, err)
+		return NewStateChange(Create, id, feeCoinContractID, buf, darcID)
+	}
+	config, err := sst.LoadConfig()
+	require.NoError(t, err)
+	config.FeeConfig = &FeeConfig{
+		CoinName:  coinName,
+		Collector: collector,
+		Costs: []ContractCost{
+			{ContractID: invalidContract, Spawn: 10},
+			{ContractID: DummyContractName, Spawn: 5},
+		},
+	}
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	_, _, _, configDarc, err := sst.GetValues(ConfigInstanceID.Slice())
+	require.NoError(t, err)
+	require.NoError(t, sst.StoreAll(StateChanges{
+		coinSc(payer, 1000),
+		coinSc(collector, 0),
+		NewStateChange(Update, ConfigInstanceID, ContractConfigID, configBuf,
+			configDarc),
+	}))
+
+	spawn := func(contractID string, counter uint64) ClientTransaction {
+		ctx := NewClientTransaction(CurrentVersion, Instruction{
+			InstanceID: NewInstanceID(darcID),
+			Spawn: &Spawn{
+				ContractID: contractID,
+				Args:       Arguments{{Name: "data", Value: []byte("fee")}},
+			},
+			SignerIdentities: []darc.Identity{b.Signer.Identity()},
+			SignerCounter:    []uint64{counter},
+		})
+		require.NoError(t, ctx.SetFeeCoin(payer))
+		require.NoError(t, ctx.FillSignersAndSignWith(b.Signer))
+		return ctx
+	}
+
+	p := &defaultTxProcessor{Service: s, scID: b.Genesis.SkipChainID()}
+	pt := &proposedTransactions{sst: sst}
+	left := pt.addTransactions(p, []ClientTransaction{
+		spawn(invalidContract, b.SignerCounter),
+		spawn(DummyContractName, b.SignerCounter+1),
+	})
+	require.Empty(t, left)
+	require.Len(t, pt.txs, 2)
+	require.False(t, pt.txs[0].Accepted)
+	require.True(t, pt.txs[1].Accepted)
+	require.NotEmpty(t, pt.txs[0].stateChanges)
+
+	buf, _, _, _, err := pt.sst.GetValues(payer.Slice())
+	require.NoError(t, err)
+	var c Coin
+	require.NoError(t, protobuf.Decode(buf, &c))
+	require.Equal(t, uint64(1000-10-5), c.Value)
+}
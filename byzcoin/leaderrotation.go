@@ -0,0 +1,270 @@
+package byzcoin
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// RotationRoundRobin makes every node of the roster lead for one epoch
+	// before handing over to the next node.
+	RotationRoundRobin = iota + 1
+	// RotationWeighted makes every node lead for as many epochs as its
+	// weight before handing over to the next node.
+	RotationWeighted
+)
+
+// HandoverArgument is the name of the argument of the view_change
+// instruction holding the roster of a handover. The roster must be the
+// current roster rotated by one node.
+const HandoverArgument = "handover"
+
+// handoverWaitBlocks is how many block intervals Handover waits for the new
+// leader to be in place.
+var handoverWaitBlocks = 10
+
+func (lr LeaderRotation) sanityCheck() error {
+	if lr.Policy != RotationRoundRobin && lr.Policy != RotationWeighted {
+		return xerrors.Errorf("unknown rotation policy %d", lr.Policy)
+	}
+	if lr.EpochLength <= 0 {
+		return xerrors.New("epoch length is less or equal to zero")
+	}
+	seen := make(map[network.ServerIdentityID]bool)
+	for _, lw := range lr.Weights {
+		if lw.Weight < 1 {
+			return xerrors.Errorf("node %s has a weight less than 1",
+				lw.Node)
+		}
+		if seen[lw.Node] {
+			return xerrors.Errorf("node %s has more than one weight",
+				lw.Node)
+		}
+		seen[lw.Node] = true
+	}
+	return nil
+}
+
+// epochBlocks returns how many blocks the leader proposes before handing
+// over.
+func (lr LeaderRotation) epochBlocks(leader *network.ServerIdentity) int {
+	if lr.Policy == RotationWeighted {
+		for _, lw := range lr.Weights {
+			if lw.Node.Equal(leader.ID) {
+				return lr.EpochLength * lw.Weight
+			}
+		}
+	}
+	return lr.EpochLength
+}
+
+// String returns the text representation of the rotation, as used by
+// ChainConfig.String.
+func (lr LeaderRotation) String() string {
+	res := new(strings.Builder)
+	fmt.Fprintf(res, "-- LeaderRotation:\n")
+	switch lr.Policy {
+	case RotationRoundRobin:
+		fmt.Fprintf(res, "--- Policy: round-robin\n")
+	case RotationWeighted:
+		fmt.Fprintf(res, "--- Policy: weighted\n")
+	default:
+		fmt.Fprintf(res, "--- Policy: unknown (%d)\n", lr.Policy)
+	}
+	fmt.Fprintf(res, "--- EpochLength: %d\n", lr.EpochLength)
+	fmt.Fprintf(res, "--- EpochStart: %d\n", lr.EpochStart)
+	for _, lw := range lr.Weights {
+		fmt.Fprintf(res, "--- Weight of %s: %d\n", lw.Node, lw.Weight)
+	}
+	return res.String()
+}
+
+// handoverDue returns true if the block with the given index must be
+// proposed by another leader than the current one.
+func (c ChainConfig) handoverDue(index int) bool {
+	if c.LeaderRotation == nil || len(c.Roster.List) == 0 {
+		return false
+	}
+	lr := c.LeaderRotation
+	return index >= lr.EpochStart+lr.epochBlocks(c.Roster.List[0])
+}
+
+// startEpoch sets the start of the epoch of the leader in a config that is
+// stored in the block with the given index. The epoch goes on if the leader
+// didn't change, else it starts with this block.
+func (c *ChainConfig) startEpoch(old *ChainConfig, index int) {
+	if c.LeaderRotation == nil {
+		return
+	}
+	lr := *c.LeaderRotation
+	lr.EpochStart = index
+	if old != nil && old.LeaderRotation != nil &&
+		len(old.Roster.List) > 0 && len(c.Roster.List) > 0 &&
+		old.Roster.List[0].Equal(c.Roster.List[0]) {
+		lr.EpochStart = old.LeaderRotation.EpochStart
+	}
+	c.LeaderRotation = &lr
+}
+
+// handoverScs returns the state changes giving the leadership to the next
+// node of the roster. Only the current leader can hand over.
+func handoverScs(rst ReadOnlyStateTrie, inst Instruction, darcID darc.ID) (StateChanges, error) {
+	config, err := rst.LoadConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	leader := darc.NewIdentityEd25519(config.Roster.List[0].Public)
+	if len(inst.SignerIdentities) != 1 || !leader.Equal(&inst.SignerIdentities[0]) {
+		return nil, xerrors.New("only the leader can hand over")
+	}
+
+	var newRoster onet.Roster
+	err = protobuf.DecodeWithConstructors(inst.Invoke.Args.Search(HandoverArgument),
+		&newRoster, network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("decoding roster: %v", err)
+	}
+	ok, err := rotateRoster(&config.Roster, 1).Equal(&newRoster)
+	if err != nil {
+		return nil, xerrors.Errorf("comparing rosters: %v", err)
+	}
+	if !ok {
+		return nil, xerrors.New("the leadership must go to the next node")
+	}
+
+	sc, err := updateRosterScs(rst, darcID, newRoster)
+	return sc, cothority.ErrorOrNil(err, "roster scs")
+}
+
+// createHandoverBlock creates a new block whose leader is the next node of
+// the roster. It must be called by the leader.
+func (s *Service) createHandoverBlock(scID skipchain.SkipBlockID) error {
+	sb, err := s.db().GetLatestByID(scID)
+	if err != nil {
+		return xerrors.Errorf("getting latest: %v", err)
+	}
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	st, err := s.GetReadOnlyStateTrie(scID)
+	if err != nil {
+		return xerrors.Errorf("getting trie: %v", err)
+	}
+	config, err := st.LoadConfig()
+	if err != nil {
+		return xerrors.Errorf("reading config: %v", err)
+	}
+	if !config.Roster.List[0].Equal(s.ServerIdentity()) {
+		return xerrors.New("only the leader can hand over")
+	}
+
+	newRoster := rotateRoster(&config.Roster, 1)
+	rosterBuf, err := protobuf.Encode(newRoster)
+	if err != nil {
+		return xerrors.Errorf("encoding roster: %v", err)
+	}
+
+	signer := darc.NewSignerEd25519(s.ServerIdentity().Public, s.getPrivateKey())
+	ctr, err := getSignerCounter(st, signer.Identity().String())
+	if err != nil {
+		return xerrors.Errorf("getting counter: %v", err)
+	}
+
+	ctx := ClientTransaction{
+		Instructions: []Instruction{{
+			InstanceID: NewInstanceID(nil),
+			Invoke: &Invoke{
+				ContractID: ContractConfigID,
+				Command:    "view_change",
+				Args: []Argument{{
+					Name:  HandoverArgument,
+					Value: rosterBuf,
+				}},
+			},
+			SignerIdentities: []darc.Identity{signer.Identity()},
+			SignerCounter:    []uint64{ctr + 1},
+		}},
+	}
+	ctx.Instructions.SetVersion(header.Version)
+	if err = ctx.Instructions[0].SignWith(ctx.Instructions.Hash(), signer); err != nil {
+		return xerrors.Errorf("signing tx: %v", err)
+	}
+
+	log.Lvlf2("%s hands over the leadership of %x to %s", s.ServerIdentity(),
+		scID, newRoster.List[0])
+	_, err = s.createNewBlock(scID, newRoster, []TxResult{{ClientTransaction: ctx, Accepted: false}})
+	return cothority.ErrorOrNil(err, "creating block")
+}
+
+// Handover asks the leader to give the leadership to the next node of the
+// roster, for example before it goes down for maintenance. The request must
+// be signed by the private key of the node. It returns once the new leader
+// is in place.
+func (s *Service) Handover(req *HandoverRequest) (*HandoverResponse, error) {
+	if err := schnorr.Verify(cothority.Suite, s.ServerIdentity().Public, req.ByzCoinID, req.Signature); err != nil {
+		log.Error("Signature failure:", err)
+		return nil, xerrors.Errorf("verifying signature: %v", err)
+	}
+	if !s.tasks.add(1) {
+		return nil, xerrors.New("node is closed")
+	}
+	defer s.tasks.done()
+
+	leader, err := s.getLeader(req.ByzCoinID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting leader: %v", err)
+	}
+	if !leader.Equal(s.ServerIdentity()) {
+		return nil, xerrors.New("this node is not the leader")
+	}
+	interval, _, err := s.LoadBlockInfo(req.ByzCoinID)
+	if err != nil {
+		return nil, xerrors.Errorf("loading block info: %v", err)
+	}
+
+	ch := s.notifications.registerForBlocks()
+	defer s.notifications.unregisterForBlocks(ch)
+
+	s.txPipelinesMutex.Lock()
+	txp, ok := s.txPipeline[string(req.ByzCoinID)]
+	s.txPipelinesMutex.Unlock()
+	if !ok {
+		return nil, xerrors.New("this pipeline is not available")
+	}
+	select {
+	case txp.needHandover <- true:
+	default:
+		// A handover is already requested.
+	}
+
+	timeout := time.After(time.Duration(handoverWaitBlocks) * interval)
+	for {
+		select {
+		case notif := <-ch:
+			if !notif.block.SkipChainID().Equal(req.ByzCoinID) {
+				continue
+			}
+			leader, err = s.getLeader(req.ByzCoinID)
+			if err != nil {
+				return nil, xerrors.Errorf("getting leader: %v", err)
+			}
+			if !leader.Equal(s.ServerIdentity()) {
+				return &HandoverResponse{Leader: leader}, nil
+			}
+		case <-timeout:
+			return nil, xerrors.New("timed out while waiting for the handover")
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+func TestChainConfig_HandoverDue(t *testing.T) {
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(3, false)
+
+	c := ChainConfig{Roster: *roster}
+	require.False(t, c.handoverDue(100))
+
+	c.LeaderRotation = &LeaderRotation{
+		Policy:      RotationWeighted,
+		EpochLength: 2,
+		Weights: []LeaderWeight{{
+			Node:   roster.List[1].ID,
+			Weight: 3,
+		}},
+	}
+	require.NoError(t, c.LeaderRotation.sanityCheck())
+	c.startEpoch(nil, 10)
+	require.Equal(t, 10, c.LeaderRotation.EpochStart)
+	require.False(t, c.handoverDue(11))
+	require.True(t, c.handoverDue(12))
+
+	// The epoch goes on as long as the leader stays.
+	next := c
+	next.startEpoch(&c, 11)
+	require.Equal(t, 10, next.LeaderRotation.EpochStart)
+
+	// The weight of the new leader is used.
+	next.Roster = *rotateRoster(roster, 1)
+	next.startEpoch(&c, 12)
+	require.Equal(t, 12, next.LeaderRotation.EpochStart)
+	require.False(t, next.handoverDue(17))
+	require.True(t, next.handoverDue(18))
+	require.Equal(t, 10, c.LeaderRotation.EpochStart)
+
+	bad := *c.LeaderRotation
+	bad.Weights = append(bad.Weights, bad.Weights[0])
+	require.Error(t, bad.sanityCheck())
+	bad.Weights = []LeaderWeight{{Node: roster.List[0].ID}}
+	require.Error(t, bad.sanityCheck())
+	bad.Weights = nil
+	bad.EpochLength = 0
+	require.Error(t, bad.sanityCheck())
+	bad.EpochLength = 2
+	bad.Policy = 0
+	require.Error(t, bad.sanityCheck())
+}
+
+// Makes sure that the leader hands over at the end of every epoch, and that
+// a handover can be asked for in the middle of an epoch.
+func TestService_LeaderRotation(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	config, err := b.Services[0].LoadConfig(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	config.LeaderRotation = &LeaderRotation{
+		Policy:      RotationRoundRobin,
+		EpochLength: 2,
+	}
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	_, resp := b.SendInst(nil, Instruction{
+		InstanceID: NewInstanceID(nil),
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: configBuf}},
+		},
+	})
+	require.Empty(t, resp.Error)
+	updated, err := b.Services[0].db().GetLatestByID(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+
+	for i := 0; i < 6; i++ {
+		b.SpawnDummy(nil)
+	}
+
+	// No leader proposes more than an epoch of blocks in a row.
+	start := updated.Index
+	latest, err := b.Services[0].db().GetLatestByID(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.True(t, latest.Index > start+4)
+	leaders := make(map[network.ServerIdentityID]bool)
+	var last network.ServerIdentityID
+	row := 0
+	for sb := latest; sb.Index >= start; sb = b.Services[0].db().GetByID(sb.BackLinkIDs[0]) {
+		leader := sb.Roster.List[0].ID
+		leaders[leader] = true
+		if leader.Equal(last) {
+			row++
+		} else {
+			row = 1
+		}
+		last = leader
+		require.True(t, row <= 2)
+	}
+	require.True(t, len(leaders) > 1)
+
+	// Only the leader can hand over.
+	leader, err := b.Services[0].getLeader(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	idx, _ := b.Roster.Search(leader.ID)
+	other := b.Servers[(idx+1)%len(b.Servers)]
+	_, err = Handover(other.ServerIdentity, b.Genesis.SkipChainID())
+	require.Error(t, err)
+
+	newLeader, err := Handover(b.Servers[idx].ServerIdentity, b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.True(t, newLeader.Equal(other.ServerIdentity))
+
+	// The new leader accepts transactions.
+	b.SpawnDummy(nil)
+}
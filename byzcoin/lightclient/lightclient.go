@@ -0,0 +1,389 @@
+// Package lightclient implements a ByzCoin client that only keeps the headers
+// of the blocks it verified, together with their forward links. It never
+// downloads transactions or the global state, but can verify any
+// byzcoin.Proof or byzcoin.GetUpdatesReply against the chain it trusts.
+//
+// The only trusted input is the genesis block. Every other header is
+// verified through the forward links, following the roster changes of the
+// chain.
+package lightclient
+
+import (
+	"sort"
+	"sync"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// ErrorUntrustedLinks is returned if none of the forward links of a proof
+// start at a block known to the light client.
+var ErrorUntrustedLinks = xerrors.New("links don't start at a trusted block")
+
+// ErrorInstanceNotFound is returned by VerifyInstance if the proof shows that
+// the instance doesn't exist.
+var ErrorInstanceNotFound = xerrors.New("instance doesn't exist")
+
+// Instance is an instance of the global state, verified against a trusted
+// block.
+type Instance struct {
+	ID         byzcoin.InstanceID
+	ContractID string
+	Value      []byte
+	Version    uint64
+	DarcID     darc.ID
+	// BlockIndex is the index of the block the instance has been verified
+	// against.
+	BlockIndex int
+}
+
+// Client is a light client for one ByzCoin chain.
+type Client struct {
+	ByzCoinID skipchain.SkipBlockID
+	store     Store
+	// headers are sorted by index, the first one being the genesis block.
+	headers []*skipchain.SkipBlock
+	known   map[string]*skipchain.SkipBlock
+	sc      *skipchain.Client
+	bc      *onet.Client
+	sync.Mutex
+}
+
+// NewClient returns a light client for the chain starting with genesis,
+// which must come from a trusted source. If store is not nil, the trusted
+// headers are persisted in it, and the headers already stored are used.
+func NewClient(genesis *skipchain.SkipBlock, store Store) (*Client, error) {
+	if genesis == nil || genesis.Index != 0 {
+		return nil, xerrors.New("need a genesis block")
+	}
+	if !genesis.CalculateHash().Equal(genesis.Hash) {
+		return nil, xerrors.New("wrong hash of genesis block")
+	}
+	c := &Client{
+		ByzCoinID: genesis.Hash,
+		store:     store,
+		known:     make(map[string]*skipchain.SkipBlock),
+		sc:        skipchain.NewClient(),
+		bc:        onet.NewClient(cothority.Suite, byzcoin.ServiceName),
+	}
+
+	headers := []*skipchain.SkipBlock{genesis}
+	if store != nil {
+		ts, err := store.Load()
+		if err != nil {
+			return nil, xerrors.Errorf("loading trusted state: %v", err)
+		}
+		if ts != nil && len(ts.Headers) > 0 {
+			if !ts.Headers[0].Hash.Equal(genesis.Hash) {
+				return nil, xerrors.New("stored state is for another chain")
+			}
+			headers = ts.Headers
+		}
+	}
+	for _, sb := range headers {
+		if _, err := c.add(sb); err != nil {
+			return nil, xerrors.Errorf("invalid header %d: %v", sb.Index, err)
+		}
+	}
+	return c, cothority.ErrorOrNil(c.save(), "saving state")
+}
+
+// Head returns the most recent trusted header.
+func (c *Client) Head() *skipchain.SkipBlock {
+	c.Lock()
+	defer c.Unlock()
+	return c.headers[len(c.headers)-1].Copy()
+}
+
+// Roster returns the roster of the most recent trusted header, which is
+// used to contact the chain.
+func (c *Client) Roster() *onet.Roster {
+	return c.Head().Roster
+}
+
+// Sync fetches and verifies the headers from the trusted head up to the
+// latest block of the chain, following the highest forward links.
+func (c *Client) Sync() error {
+	head := c.Head()
+	blocks, err := c.sc.GetUpdateChainLevel(head.Roster, head.Hash, -1, -1)
+	if err != nil {
+		return xerrors.Errorf("getting update chain: %v", err)
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	changed := false
+	for _, sb := range blocks {
+		added, err := c.add(sb)
+		if err != nil {
+			return xerrors.Errorf("invalid block %d: %v", sb.Index, err)
+		}
+		changed = changed || added
+	}
+	log.Lvlf2("Synced chain %x up to block %d", c.ByzCoinID[:],
+		c.headers[len(c.headers)-1].Index)
+	if !changed {
+		return nil
+	}
+	return cothority.ErrorOrNil(c.save(), "saving state")
+}
+
+// VerifyProof checks that the proof is valid for one of the trusted blocks,
+// or for a block that can be reached from them through the links of the
+// proof. In the latter case, the block is added to the trusted headers. It
+// doesn't verify which key the proof is about.
+func (c *Client) VerifyProof(p *byzcoin.Proof) error {
+	c.Lock()
+	defer c.Unlock()
+	header, err := c.verifyLinks(p.Links, &p.Latest)
+	if err != nil {
+		return xerrors.Errorf("verifying links: %v", err)
+	}
+	if err := p.VerifyInclusionProof(header); err != nil {
+		return xerrors.Errorf("verifying inclusion proof: %v", err)
+	}
+	return nil
+}
+
+// VerifyUpdates checks that all the proofs in the reply are valid for the
+// latest block of the reply.
+func (c *Client) VerifyUpdates(reply *byzcoin.GetUpdatesReply) error {
+	c.Lock()
+	defer c.Unlock()
+	header, err := c.verifyLinks(reply.Links, reply.Latest)
+	if err != nil {
+		return xerrors.Errorf("verifying links: %v", err)
+	}
+	for i, pr := range reply.Proofs {
+		p := byzcoin.Proof{InclusionProof: pr}
+		if err := p.VerifyInclusionProof(header); err != nil {
+			return xerrors.Errorf("verifying proof %d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// GetProof asks the nodes of the trusted head for a proof of the key, and
+// verifies it.
+func (c *Client) GetProof(key []byte) (*byzcoin.Proof, error) {
+	reply := &byzcoin.GetProofResponse{}
+	err := c.send(func(head *skipchain.SkipBlock) error {
+		_, err := c.bc.SendProtobufParallel(head.Roster.List, &byzcoin.GetProof{
+			Version: byzcoin.CurrentVersion,
+			Key:     key,
+			ID:      head.Hash,
+		}, reply, nil)
+		return err
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("getting proof: %v", err)
+	}
+	if err := c.VerifyProof(&reply.Proof); err != nil {
+		return nil, xerrors.Errorf("invalid proof: %v", err)
+	}
+	return &reply.Proof, nil
+}
+
+// GetUpdates returns the verified proofs of the given instances that have a
+// newer version than the one given. See byzcoin.Client.GetUpdates for the
+// flags.
+func (c *Client) GetUpdates(instances []byzcoin.IDVersion,
+	flags byzcoin.GetUpdatesFlags) (*byzcoin.GetUpdatesReply, error) {
+	reply := &byzcoin.GetUpdatesReply{}
+	err := c.send(func(head *skipchain.SkipBlock) error {
+		_, err := c.bc.SendProtobufParallel(head.Roster.List,
+			&byzcoin.GetUpdatesRequest{
+				Instances:     instances,
+				Flags:         flags,
+				LatestBlockID: head.Hash,
+				SkipchainID:   c.ByzCoinID,
+			}, reply, nil)
+		return err
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("getting updates: %v", err)
+	}
+	if err := c.VerifyUpdates(reply); err != nil {
+		return nil, xerrors.Errorf("invalid updates: %v", err)
+	}
+	return reply, nil
+}
+
+// VerifyInstance fetches the proof of the instance and returns its verified
+// content.
+func (c *Client) VerifyInstance(iid byzcoin.InstanceID) (*Instance, error) {
+	p, err := c.GetProof(iid.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("getting proof: %v", err)
+	}
+	if !p.InclusionProof.Match(iid.Slice()) {
+		return nil, ErrorInstanceNotFound
+	}
+	_, buf := p.InclusionProof.KeyValue()
+	var body byzcoin.StateChangeBody
+	if err := protobuf.Decode(buf, &body); err != nil {
+		return nil, xerrors.Errorf("decoding instance: %v", err)
+	}
+	return &Instance{
+		ID:         iid,
+		ContractID: body.ContractID,
+		Value:      body.Value,
+		Version:    body.Version,
+		DarcID:     body.DarcID,
+		BlockIndex: p.Latest.Index,
+	}, nil
+}
+
+// send calls f with the trusted head. If it fails, the nodes of the head
+// might not be part of the roster anymore, so the client syncs and tries
+// once more with the new head.
+func (c *Client) send(f func(head *skipchain.SkipBlock) error) error {
+	head := c.Head()
+	err := f(head)
+	if err == nil {
+		return nil
+	}
+	log.Lvlf2("Request failed, syncing before trying again: %v", err)
+	if serr := c.Sync(); serr != nil {
+		return xerrors.Errorf("%v - and couldn't sync: %v", err, serr)
+	}
+	if newHead := c.Head(); newHead.Hash.Equal(head.Hash) {
+		return err
+	}
+	return f(c.Head())
+}
+
+// verifyLinks returns the trusted header of latest. If latest is not known
+// yet, the links must lead to it from a trusted block. Then latest is added
+// to the trusted headers. The caller must hold the lock.
+func (c *Client) verifyLinks(links []skipchain.ForwardLink,
+	latest *skipchain.SkipBlock) (*skipchain.SkipBlock, error) {
+	if latest == nil || latest.SkipBlockFix == nil {
+		return nil, xerrors.New("missing latest block")
+	}
+	if !latest.CalculateHash().Equal(latest.Hash) {
+		return nil, byzcoin.ErrorVerifyHash
+	}
+	if header, ok := c.known[string(latest.Hash)]; ok {
+		return header, nil
+	}
+	if len(links) == 0 {
+		return nil, byzcoin.ErrorMissingForwardLinks
+	}
+
+	// Start with the last link pointing to a trusted block. The first link
+	// is a synthetic link to the block the proof starts with.
+	start := -1
+	for i := len(links) - 1; i >= 0; i-- {
+		if _, ok := c.known[string(links[i].To)]; ok {
+			start = i
+			break
+		}
+	}
+	if start < 0 {
+		return nil, ErrorUntrustedLinks
+	}
+	from := c.known[string(links[start].To)]
+	id := from.Hash
+	publics := from.Roster.ServicePublics(skipchain.ServiceName)
+	for _, l := range links[start+1:] {
+		if !l.From.Equal(id) {
+			return nil, byzcoin.ErrorVerifySkipchain
+		}
+		err := l.VerifyWithScheme(pairing.NewSuiteBn256(), publics,
+			latest.SignatureScheme)
+		if err != nil {
+			return nil, byzcoin.ErrorVerifySkipchain
+		}
+		id = l.To
+		if l.NewRoster != nil {
+			publics = l.NewRoster.ServicePublics(skipchain.ServiceName)
+		}
+	}
+	if !id.Equal(latest.Hash) {
+		return nil, byzcoin.ErrorVerifyHash
+	}
+
+	// The header might be pruned right away if it is older than the latest
+	// roster change, so a copy is returned.
+	if _, err := c.add(latest); err != nil {
+		return nil, xerrors.Errorf("adding header: %v", err)
+	}
+	if err := c.save(); err != nil {
+		return nil, xerrors.Errorf("saving state: %v", err)
+	}
+	header := latest.Copy()
+	header.Payload = nil
+	return header, nil
+}
+
+// add stores the header of the block, which must be verified by the
+// caller, and prunes the headers that are not needed anymore. If the header
+// is already known, only its forward links are updated. It returns true if
+// something changed. The caller must hold the lock.
+func (c *Client) add(sb *skipchain.SkipBlock) (bool, error) {
+	if !sb.SkipChainID().Equal(c.ByzCoinID) {
+		return false, xerrors.New("block is from another chain")
+	}
+	if err := sb.VerifyForwardSignatures(); err != nil {
+		return false, xerrors.Errorf("verifying block: %v", err)
+	}
+	header := sb.Copy()
+	header.Payload = nil
+
+	if old, ok := c.known[string(sb.Hash)]; ok {
+		if len(header.ForwardLink) <= len(old.ForwardLink) {
+			return false, nil
+		}
+		old.ForwardLink = header.ForwardLink
+		return true, nil
+	}
+
+	i := sort.Search(len(c.headers), func(i int) bool {
+		return c.headers[i].Index >= header.Index
+	})
+	if i < len(c.headers) && c.headers[i].Index == header.Index {
+		return false, xerrors.Errorf("got two different blocks with index %d",
+			header.Index)
+	}
+	c.headers = append(c.headers, nil)
+	copy(c.headers[i+1:], c.headers[i:])
+	c.headers[i] = header
+	c.known[string(header.Hash)] = header
+	c.prune()
+	return true, nil
+}
+
+// prune removes the headers older than the latest roster change, except for
+// the genesis block. The new roster is trusted once its block has been
+// verified, so the older headers are not needed to verify the links of the
+// chain anymore, and links that start before them can still be verified
+// from the genesis block. The caller must hold the lock.
+func (c *Client) prune() {
+	for i := len(c.headers) - 1; i > 1; i-- {
+		if c.headers[i].Roster.ID.Equal(c.headers[i-1].Roster.ID) {
+			continue
+		}
+		for _, header := range c.headers[1:i] {
+			delete(c.known, string(header.Hash))
+		}
+		c.headers = append(c.headers[:1], c.headers[i:]...)
+		return
+	}
+}
+
+// save persists the trusted headers, if the client has a store. The caller
+// must hold the lock.
+func (c *Client) save() error {
+	if c.store == nil {
+		return nil
+	}
+	return c.store.Save(&TrustedState{Headers: c.headers})
+}
@@ -0,0 +1,108 @@
+package lightclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func TestClient_VerifyInstance(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	dir, err := ioutil.TempDir("", "lightclient")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store := NewFileStore(filepath.Join(dir, "state"))
+
+	lc, err := NewClient(b.Genesis, store)
+	require.NoError(t, err)
+
+	inst, err := lc.VerifyInstance(byzcoin.ConfigInstanceID)
+	require.NoError(t, err)
+	require.Equal(t, byzcoin.ContractConfigID, inst.ContractID)
+
+	newDarc := darc.NewDarc(darc.InitRules(nil, nil), []byte("light"))
+	b.SpawnDarc(nil, newDarc)
+	iid := byzcoin.NewInstanceID(newDarc.GetBaseID())
+	inst, err = lc.VerifyInstance(iid)
+	require.NoError(t, err)
+	require.Equal(t, byzcoin.ContractDarcID, inst.ContractID)
+	require.True(t, inst.BlockIndex > 0)
+	require.Equal(t, inst.BlockIndex, lc.Head().Index)
+
+	_, err = lc.VerifyInstance(byzcoin.NewInstanceID([]byte("missing")))
+	require.Equal(t, ErrorInstanceNotFound, err)
+
+	// A proof for another block or another trie root must be refused.
+	p, err := b.Client.GetProofFromLatest(iid.Slice())
+	require.NoError(t, err)
+	require.NoError(t, lc.VerifyProof(&p.Proof))
+	p.Proof.Latest.Data = append(p.Proof.Latest.Data, 0)
+	require.Error(t, lc.VerifyProof(&p.Proof))
+
+	// Updates are verified as well.
+	reply, err := lc.GetUpdates([]byzcoin.IDVersion{{ID: iid}},
+		byzcoin.GUFSendVersion0)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(reply.Proofs))
+	reply.Latest = b.Genesis.Copy()
+	require.Error(t, lc.VerifyUpdates(reply))
+
+	// A restarted client starts from the stored head.
+	require.NoError(t, lc.Sync())
+	head := lc.Head()
+	lc, err = NewClient(b.Genesis, store)
+	require.NoError(t, err)
+	require.True(t, lc.Head().Hash.Equal(head.Hash))
+	require.Empty(t, lc.Head().Payload)
+
+	other := b.Genesis.Copy()
+	other.Data = append(other.Data, 0)
+	_, err = NewClient(other, store)
+	require.Error(t, err)
+}
+
+func TestClient_Prune(t *testing.T) {
+	local := onet.NewLocalTest(cothority.Suite)
+	defer local.CloseAll()
+	_, ro1, _ := local.GenTree(3, true)
+	ro2 := onet.NewRoster(ro1.List[1:])
+
+	c := &Client{known: make(map[string]*skipchain.SkipBlock)}
+	for i, ro := range []*onet.Roster{ro1, ro1, ro1, ro2, ro2} {
+		sb := skipchain.NewSkipBlock()
+		sb.Index = i
+		sb.Roster = ro
+		sb.Hash = sb.CalculateHash()
+		c.headers = append(c.headers, sb)
+		c.known[string(sb.Hash)] = sb
+	}
+	c.prune()
+
+	var indexes []int
+	for _, header := range c.headers {
+		indexes = append(indexes, header.Index)
+		require.NotNil(t, c.known[string(header.Hash)])
+	}
+	require.Equal(t, []int{0, 3, 4}, indexes)
+	require.Equal(t, 3, len(c.known))
+
+	// Without a roster change, nothing is pruned.
+	c.prune()
+	require.Equal(t, 3, len(c.headers))
+}
@@ -0,0 +1,81 @@
+package lightclient
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// TrustedState holds everything a light client needs to restart without
+// trusting any node: the headers of the blocks it verified since the latest
+// roster change, starting with the genesis block.
+type TrustedState struct {
+	Headers []*skipchain.SkipBlock
+}
+
+// Store persists the TrustedState of a light client.
+type Store interface {
+	// Load returns the stored state, or nil if nothing has been stored yet.
+	Load() (*TrustedState, error)
+	// Save replaces the stored state.
+	Save(*TrustedState) error
+}
+
+// FileStore is a Store that keeps the TrustedState in a single file.
+type FileStore struct {
+	Path string
+}
+
+// NewFileStore returns a FileStore writing to the given path.
+func NewFileStore(path string) *FileStore {
+	return &FileStore{Path: path}
+}
+
+// Load implements Store.
+func (fs *FileStore) Load() (*TrustedState, error) {
+	buf, err := ioutil.ReadFile(fs.Path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, xerrors.Errorf("reading file: %v", err)
+	}
+	ts := &TrustedState{}
+	err = protobuf.DecodeWithConstructors(buf, ts,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("decoding state: %v", err)
+	}
+	return ts, nil
+}
+
+// Save implements Store. The state is first written to a temporary file, so
+// that a crash never leaves a half-written state behind.
+func (fs *FileStore) Save(ts *TrustedState) error {
+	buf, err := protobuf.Encode(ts)
+	if err != nil {
+		return xerrors.Errorf("encoding state: %v", err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(fs.Path), ".lightclient")
+	if err != nil {
+		return xerrors.Errorf("creating file: %v", err)
+	}
+	_, err = tmp.Write(buf)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), fs.Path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return xerrors.Errorf("writing state: %v", err)
+	}
+	return nil
+}
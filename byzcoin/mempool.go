@@ -0,0 +1,287 @@
+package byzcoin
+
+import (
+	"bytes"
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// maxMempoolSize is the maximum number of transactions waiting in the
+// mempool. Once it is full, a new transaction is only accepted if it comes
+// before the last waiting transaction, which is then evicted.
+var maxMempoolSize = 10000
+
+// maxMempoolPerSigner is the maximum number of transactions a single signer
+// can have waiting in the mempool.
+var maxMempoolPerSigner = 100
+
+// The priority classes of the transactions in the mempool. Transactions of a
+// lower class are proposed first. The class is only used to order the
+// transactions, the authorizations are checked once they are executed.
+const (
+	// PriorityConfig is for transactions with an instruction on the
+	// configuration instance, e.g., a roster change.
+	PriorityConfig = iota
+	// PriorityDarc is for transactions with an instruction of the darc
+	// contract.
+	PriorityDarc
+	// PriorityUser is for all other transactions.
+	PriorityUser
+)
+
+// mempoolTx is a transaction waiting in the mempool.
+type mempoolTx struct {
+	tx ClientTransaction
+	// hash includes the signatures, so that the same transaction is only
+	// added once.
+	hash []byte
+	// signer and counter are taken from the first signer of the first
+	// instruction.
+	signer   string
+	counter  uint64
+	priority int
+	fee      uint64
+	// seq is the order of arrival in the mempool.
+	seq uint64
+}
+
+// newMempoolTx prepares tx to be added to the mempool. The tip of the
+// transaction is only taken into account if withFees is true, as it is not
+// charged otherwise.
+func newMempoolTx(tx ClientTransaction, withFees bool) (*mempoolTx, error) {
+	if len(tx.Instructions) == 0 {
+		return nil, xerrors.New("transaction has no instructions")
+	}
+	mtx := &mempoolTx{
+		tx:       tx,
+		hash:     tx.Instructions.HashWithSignatures(),
+		priority: PriorityUser,
+	}
+
+	first := tx.Instructions[0]
+	if len(first.SignerIdentities) > 0 && len(first.SignerCounter) > 0 {
+		mtx.signer = first.SignerIdentities[0].String()
+		mtx.counter = first.SignerCounter[0]
+	}
+
+	for _, instr := range tx.Instructions {
+		if instr.InstanceID.Equal(ConfigInstanceID) {
+			mtx.priority = PriorityConfig
+		} else if instr.ContractID() == ContractDarcID &&
+			mtx.priority > PriorityDarc {
+			mtx.priority = PriorityDarc
+		}
+	}
+
+	if withFees {
+		tip, err := tx.FeeTip()
+		if err != nil {
+			return nil, xerrors.Errorf("getting tip: %v", err)
+		}
+		mtx.fee = tip
+	}
+	return mtx, nil
+}
+
+// queue returns the key of the queue of the transaction. Transactions
+// without a signer get a queue of their own.
+func (mtx *mempoolTx) queue() string {
+	if mtx.signer == "" {
+		return fmt.Sprintf("%x", mtx.hash)
+	}
+	return mtx.signer
+}
+
+// before returns true if mtx must be proposed before other, without taking
+// into account the signer counters.
+func (mtx *mempoolTx) before(other *mempoolTx) bool {
+	if mtx.priority != other.priority {
+		return mtx.priority < other.priority
+	}
+	if mtx.fee != other.fee {
+		return mtx.fee > other.fee
+	}
+	return mtx.seq < other.seq
+}
+
+// entry returns the description of the transaction sent to the clients.
+func (mtx *mempoolTx) entry() MempoolEntry {
+	return MempoolEntry{
+		TransactionHash: mtx.tx.Instructions.Hash(),
+		Signer:          mtx.signer,
+		Counter:         mtx.counter,
+		Priority:        mtx.priority,
+		Fee:             mtx.fee,
+	}
+}
+
+// mempool holds the transactions waiting to be proposed by the leader. Every
+// signer has a queue ordered by the signer counter. The heads of the queues
+// are proposed by priority class, then by fee, then by order of arrival.
+type mempool struct {
+	queues map[string][]*mempoolTx
+	size   int
+	seq    uint64
+	sync.Mutex
+}
+
+func newMempool() *mempool {
+	return &mempool{queues: make(map[string][]*mempoolTx)}
+}
+
+// add puts the transaction in the mempool. If a transaction of the same
+// signer with the same counter is already waiting, it is replaced if the
+// new one has a higher fee.
+func (m *mempool) add(mtx *mempoolTx) error {
+	m.Lock()
+	defer m.Unlock()
+
+	key := mtx.queue()
+	queue := m.queues[key]
+	i := sort.Search(len(queue), func(i int) bool {
+		return queue[i].counter >= mtx.counter
+	})
+	if i < len(queue) && queue[i].counter == mtx.counter {
+		old := queue[i]
+		if bytes.Equal(old.hash, mtx.hash) {
+			return xerrors.New("transaction is already in the mempool")
+		}
+		if mtx.fee <= old.fee {
+			return xerrors.Errorf("a transaction with counter %d and a fee "+
+				"of %d is already waiting", old.counter, old.fee)
+		}
+		// The replacement keeps the place of the old transaction.
+		mtx.seq = old.seq
+		queue[i] = mtx
+		return nil
+	}
+
+	if len(queue) >= maxMempoolPerSigner {
+		return xerrors.Errorf("signer already has %d transactions waiting",
+			len(queue))
+	}
+	mtx.seq = m.seq + 1
+	if m.size >= maxMempoolSize {
+		if err := m.evictFor(mtx); err != nil {
+			return err
+		}
+		queue = m.queues[key]
+		i = sort.Search(len(queue), func(i int) bool {
+			return queue[i].counter >= mtx.counter
+		})
+	}
+	m.seq++
+
+	queue = append(queue, nil)
+	copy(queue[i+1:], queue[i:])
+	queue[i] = mtx
+	m.queues[key] = queue
+	m.size++
+	return nil
+}
+
+// evictFor removes the last transaction of the mempool, if mtx comes
+// before it. Only the tails of the queues are considered, so that no gaps
+// are created in the signer counters.
+func (m *mempool) evictFor(mtx *mempoolTx) error {
+	var worst *mempoolTx
+	for _, queue := range m.queues {
+		tail := queue[len(queue)-1]
+		if worst == nil || worst.before(tail) {
+			worst = tail
+		}
+	}
+	if worst == nil || !mtx.before(worst) {
+		return xerrors.New("mempool is full")
+	}
+	m.removeTx(worst)
+	return nil
+}
+
+// removeTx deletes the transaction from its queue, if it is still there.
+// The caller must hold the lock.
+func (m *mempool) removeTx(mtx *mempoolTx) {
+	key := mtx.queue()
+	queue := m.queues[key]
+	for i, q := range queue {
+		if q == mtx {
+			queue = append(queue[:i], queue[i+1:]...)
+			m.size--
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(m.queues, key)
+	} else {
+		m.queues[key] = queue
+	}
+}
+
+// remove deletes the given transactions from the mempool, typically
+// because they have been added to a block.
+func (m *mempool) remove(txs []*mempoolTx) {
+	m.Lock()
+	defer m.Unlock()
+	for _, mtx := range txs {
+		m.removeTx(mtx)
+	}
+}
+
+// ordered returns all waiting transactions in the order they should be
+// proposed. The transactions of a signer are always returned in the order
+// of their counters.
+func (m *mempool) ordered() []*mempoolTx {
+	m.Lock()
+	defer m.Unlock()
+
+	heads := &mempoolHeap{}
+	next := make(map[string]int)
+	for _, queue := range m.queues {
+		heap.Push(heads, queue[0])
+	}
+	out := make([]*mempoolTx, 0, m.size)
+	for heads.Len() > 0 {
+		mtx := heap.Pop(heads).(*mempoolTx)
+		out = append(out, mtx)
+		key := mtx.queue()
+		next[key]++
+		if queue := m.queues[key]; next[key] < len(queue) {
+			heap.Push(heads, queue[next[key]])
+		}
+	}
+	return out
+}
+
+// entries returns the description of all waiting transactions, in the order
+// they will be proposed.
+func (m *mempool) entries() []MempoolEntry {
+	txs := m.ordered()
+	out := make([]MempoolEntry, len(txs))
+	for i, mtx := range txs {
+		out[i] = mtx.entry()
+	}
+	return out
+}
+
+// mempoolHeap implements heap.Interface to find the next transaction to be
+// proposed among the heads of the queues.
+type mempoolHeap []*mempoolTx
+
+func (h mempoolHeap) Len() int           { return len(h) }
+func (h mempoolHeap) Less(i, j int) bool { return h[i].before(h[j]) }
+func (h mempoolHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
+
+func (h *mempoolHeap) Push(x interface{}) {
+	*h = append(*h, x.(*mempoolTx))
+}
+
+func (h *mempoolHeap) Pop() interface{} {
+	old := *h
+	mtx := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return mtx
+}
@@ -0,0 +1,105 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+)
+
+func newMempoolTestTx(t *testing.T, signer darc.Signer, counter uint64,
+	instID InstanceID, contract string, tip uint64) *mempoolTx {
+	ctx := NewClientTransaction(CurrentVersion, Instruction{
+		InstanceID:       instID,
+		Invoke:           &Invoke{ContractID: contract, Command: "update"},
+		SignerIdentities: []darc.Identity{signer.Identity()},
+		SignerCounter:    []uint64{counter},
+	})
+	if tip > 0 {
+		require.NoError(t, ctx.SetFeeTip(tip))
+	}
+	mtx, err := newMempoolTx(ctx, true)
+	require.NoError(t, err)
+	return mtx
+}
+
+func mempoolCounters(m *mempool) []uint64 {
+	var out []uint64
+	for _, mtx := range m.ordered() {
+		out = append(out, mtx.counter)
+	}
+	return out
+}
+
+func TestMempool_Order(t *testing.T) {
+	alice := darc.NewSignerEd25519(nil, nil)
+	bob := darc.NewSignerEd25519(nil, nil)
+	value := NewInstanceID([]byte("value"))
+
+	m := newMempool()
+	// Alice's transactions arrive in the wrong order, but must be proposed
+	// by counter.
+	require.NoError(t, m.add(newMempoolTestTx(t, alice, 2, value, "value", 0)))
+	require.NoError(t, m.add(newMempoolTestTx(t, alice, 1, value, "value", 0)))
+	// Bob pays a tip, so his transaction comes before Alice's.
+	require.NoError(t, m.add(newMempoolTestTx(t, bob, 11, value, "value", 5)))
+	// Configuration and darc changes come first.
+	require.NoError(t, m.add(newMempoolTestTx(t, bob, 10,
+		NewInstanceID([]byte("darc")), ContractDarcID, 0)))
+	require.NoError(t, m.add(newMempoolTestTx(t, alice, 3, ConfigInstanceID,
+		ContractConfigID, 0)))
+	require.Equal(t, []uint64{10, 11, 1, 2, 3}, mempoolCounters(m))
+
+	entries := m.entries()
+	require.Equal(t, PriorityDarc, entries[0].Priority)
+	require.Equal(t, bob.Identity().String(), entries[0].Signer)
+	require.Equal(t, uint64(5), entries[1].Fee)
+	// Alice's config change has to wait for her other transactions.
+	require.Equal(t, PriorityConfig, entries[4].Priority)
+
+	// The same transaction is only added once.
+	dup := *m.ordered()[0]
+	require.Error(t, m.add(&dup))
+
+	// Only a higher tip can replace a transaction.
+	require.Error(t, m.add(newMempoolTestTx(t, alice, 1, value, "value", 0)))
+	repl := newMempoolTestTx(t, alice, 1, value, "value", 10)
+	require.NoError(t, m.add(repl))
+	require.Equal(t, []uint64{10, 1, 11, 2, 3}, mempoolCounters(m))
+	require.Equal(t, repl, m.ordered()[1])
+
+	m.remove(m.ordered()[:2])
+	require.Equal(t, []uint64{11, 2, 3}, mempoolCounters(m))
+	require.Equal(t, 3, m.size)
+}
+
+func TestMempool_Limits(t *testing.T) {
+	defer func(size, perSigner int) {
+		maxMempoolSize = size
+		maxMempoolPerSigner = perSigner
+	}(maxMempoolSize, maxMempoolPerSigner)
+	maxMempoolSize = 3
+	maxMempoolPerSigner = 2
+
+	alice := darc.NewSignerEd25519(nil, nil)
+	bob := darc.NewSignerEd25519(nil, nil)
+	carol := darc.NewSignerEd25519(nil, nil)
+	value := NewInstanceID([]byte("value"))
+
+	m := newMempool()
+	require.NoError(t, m.add(newMempoolTestTx(t, alice, 1, value, "value", 1)))
+	require.NoError(t, m.add(newMempoolTestTx(t, alice, 2, value, "value", 1)))
+	require.Error(t, m.add(newMempoolTestTx(t, alice, 3, value, "value", 1)))
+	require.NoError(t, m.add(newMempoolTestTx(t, bob, 1, value, "value", 0)))
+
+	// The mempool is full and carol doesn't pay more than bob.
+	require.Error(t, m.add(newMempoolTestTx(t, carol, 1, value, "value", 0)))
+
+	// A config change evicts the last transaction.
+	require.NoError(t, m.add(newMempoolTestTx(t, carol, 1, ConfigInstanceID,
+		ContractConfigID, 0)))
+	require.Equal(t, 3, m.size)
+	for _, mtx := range m.ordered() {
+		require.NotEqual(t, bob.Identity().String(), mtx.signer)
+	}
+}
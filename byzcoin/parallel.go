@@ -0,0 +1,135 @@
+package byzcoin
+
+import (
+	"sync"
+
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// accessSet records the keys of the global state read by a transaction.
+type accessSet struct {
+	keys map[string]bool
+	// all is set if the transaction read the whole state.
+	all bool
+	sync.Mutex
+}
+
+func newAccessSet() *accessSet {
+	return &accessSet{keys: make(map[string]bool)}
+}
+
+func (as *accessSet) add(key []byte) {
+	as.Lock()
+	as.keys[string(key)] = true
+	as.Unlock()
+}
+
+func (as *accessSet) addAll() {
+	as.Lock()
+	as.all = true
+	as.Unlock()
+}
+
+// conflicts returns true if any of the recorded keys has been written.
+func (as *accessSet) conflicts(written map[string]bool) bool {
+	as.Lock()
+	defer as.Unlock()
+	if as.all {
+		return len(written) > 0
+	}
+	for k := range as.keys {
+		if written[k] {
+			return true
+		}
+	}
+	return false
+}
+
+// speculativeTx is the result of a transaction executed on the state at the
+// beginning of the block.
+type speculativeTx struct {
+	states StateChanges
+	err    error
+	reads  *accessSet
+}
+
+// processTxsParallel executes the transactions concurrently on copies of sst,
+// then goes through them in order. If a transaction read a key written by one
+// of the preceding accepted transactions, it is executed again on the
+// updated state. Otherwise its state changes are applied as they are. This
+// gives exactly the same result as executing the transactions one after the
+// other. The transactions are executed by the given number of go-routines.
+func (s *Service) processTxsParallel(sst *stagingStateTrie,
+	scID skipchain.SkipBlockID, txIn TxResults, timestamp int64,
+	workers int) (TxResults, StateChanges, *stagingStateTrie) {
+	spec := make([]speculativeTx, len(txIn))
+	jobs := make(chan int)
+	if workers > len(txIn) {
+		workers = len(txIn)
+	}
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				reads := newAccessSet()
+				base := sst.Clone()
+				base.reads = reads
+				// The trace makes sure that refused transactions don't
+				// store their errors yet.
+				scs, _, err := s.traceOneTx(base,
+					txIn[i].ClientTransaction.Clone(), scID, timestamp,
+					&txTrace{})
+				spec[i] = speculativeTx{states: scs, err: err, reads: reads}
+			}
+		}()
+	}
+	for i := range txIn {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+
+	var txOut TxResults
+	var states StateChanges
+	written := make(map[string]bool)
+	for i, tx := range txIn {
+		res := spec[i]
+		if res.reads.conflicts(written) {
+			log.Lvlf3("%s: executing transaction %d again because of "+
+				"conflicts", s.ServerIdentity(), i)
+			var sstTx *stagingStateTrie
+			res.states, sstTx, res.err = s.processOneTx(sst,
+				tx.ClientTransaction, scID, timestamp)
+			if res.err == nil {
+				sst = sstTx
+			}
+		} else if res.err == nil {
+			if err := sst.StoreAll(res.states); err != nil {
+				res.err = err
+			}
+		} else {
+			s.addError(tx.ClientTransaction, res.err)
+		}
+
+		if res.err != nil {
+			tx.Accepted = false
+			res.states, sst = chargeRefusedTx(sst, tx.ClientTransaction)
+			log.Warnf("%s: %+v", s.ServerIdentity(), res.err)
+		} else {
+			tx.Accepted = true
+		}
+		tx.setStateChanges(res.states)
+		states = append(states, tx.stateChanges...)
+		for _, sc := range res.states {
+			if sc.Op() != trie.Nop {
+				written[string(sc.InstanceID)] = true
+			}
+		}
+		txOut = append(txOut, tx)
+	}
+	return txOut, states, sst
+}
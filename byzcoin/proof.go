@@ -26,16 +26,30 @@ func NewProof(c ReadOnlyStateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBl
 		return nil, xerrors.Errorf("couldn't get proof: %+v", err)
 	}
 	p.InclusionProof = *pr
+	links, latest, err := newProofLinks(s, id, c.GetIndex())
+	if err != nil {
+		return nil, err
+	}
+	p.Links = links
+	p.Latest = *latest
+	return
+}
+
+// newProofLinks returns the forward links going from the block with the given
+// id to the block with the given index, as well as the latter block. The
+// first link is a synthetic link pointing to the block with the given id.
+func newProofLinks(s *skipchain.SkipBlockDB, id skipchain.SkipBlockID,
+	index int) ([]skipchain.ForwardLink, *skipchain.SkipBlock, error) {
 	sb := s.GetByID(id)
 	if sb == nil {
-		return nil, xerrors.New("didn't find skipchain")
+		return nil, nil, xerrors.New("didn't find skipchain")
 	}
-	p.Links = []skipchain.ForwardLink{{
+	links := []skipchain.ForwardLink{{
 		From:      []byte{},
 		To:        id,
 		NewRoster: sb.Roster,
 	}}
-	for len(sb.ForwardLink) > 0 && sb.Index < c.GetIndex() {
+	for len(sb.ForwardLink) > 0 && sb.Index < index {
 		var link *skipchain.ForwardLink
 		// Corner-case when the database is downloading blocks and a proof is
 		// requested before all blocks are stored - then we need to make sure that
@@ -48,25 +62,24 @@ func NewProof(c ReadOnlyStateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBl
 				log.Warnf("Found block %d with invalid forward-link at level"+
 					" %d", sb.Index, height)
 				if height == 0 {
-					return nil, xerrors.New("missing block in chain")
+					return nil, nil, xerrors.New("missing block in chain")
 				}
 				continue
 			}
 			if sbTemp.Index <= sb.Index {
-				return nil, cothority.ErrorOrNil(skipchain.ErrorInconsistentForwardLink, "")
+				return nil, nil, cothority.ErrorOrNil(skipchain.ErrorInconsistentForwardLink, "")
 			}
-			if sbTemp.Index <= c.GetIndex() {
+			if sbTemp.Index <= index {
 				sb = sbTemp
 				break
 			}
 		}
-		p.Links = append(p.Links, *link)
+		links = append(links, *link)
 	}
-	if c.GetIndex() != sb.Index {
-		return nil, xerrors.New("didn't find skipblock with same index as state-trie")
+	if index != sb.Index {
+		return nil, nil, xerrors.New("didn't find skipblock with same index as state-trie")
 	}
-	p.Latest = *sb
-	return
+	return links, sb, nil
 }
 
 // ErrorVerifyTrie is returned if the proof itself is not properly set up.
@@ -125,19 +138,27 @@ func (p Proof) Verify(sbID skipchain.SkipBlockID) error {
 		return cothority.WrapError(err)
 	}
 
-	if len(p.Links) == 0 {
+	return verifyForwardLinks(sbID, p.Links, &p.Latest)
+}
+
+// verifyForwardLinks checks that the links go from the block with ID sbID to
+// the latest block. The roster of the first, synthetic, link must have been
+// verified by the caller.
+func verifyForwardLinks(sbID skipchain.SkipBlockID, links []skipchain.ForwardLink,
+	latest *skipchain.SkipBlock) error {
+	if len(links) == 0 {
 		return cothority.WrapError(ErrorMissingForwardLinks)
 	}
-	if p.Links[0].NewRoster == nil {
+	if links[0].NewRoster == nil {
 		return cothority.WrapError(ErrorMalformedForwardLink)
 	}
 
 	// Get the first from the synthetic link which is assumed to be verified
 	// before against the block with ID stored in the To field by the caller.
-	publics := p.Links[0].NewRoster.ServicePublics(skipchain.ServiceName)
+	publics := links[0].NewRoster.ServicePublics(skipchain.ServiceName)
 
-	for _, l := range p.Links[1:] {
-		if err = l.VerifyWithScheme(pairing.NewSuiteBn256(), publics, p.Latest.SignatureScheme); err != nil {
+	for _, l := range links[1:] {
+		if err := l.VerifyWithScheme(pairing.NewSuiteBn256(), publics, latest.SignatureScheme); err != nil {
 			return cothority.WrapError(ErrorVerifySkipchain)
 		}
 		if !l.From.Equal(sbID) {
@@ -150,7 +171,7 @@ func (p Proof) Verify(sbID skipchain.SkipBlockID) error {
 	}
 
 	// Check that the given latest block matches the last forward link target
-	if !p.Latest.CalculateHash().Equal(sbID) {
+	if !latest.CalculateHash().Equal(sbID) {
 		return cothority.WrapError(ErrorVerifyHash)
 	}
 
@@ -235,3 +256,177 @@ func (p Proof) VerifyAndDecode(suite network.Suite, cid string, value interface{
 	err = protobuf.DecodeWithConstructors(buf, value, network.DefaultConstructors(suite))
 	return cothority.ErrorOrNil(err, "decoding")
 }
+
+// newMultiProof creates a proof for all the keys in the skipchain with the
+// given id, like NewProof does for a single key.
+func newMultiProof(st *stateTrie, s *skipchain.SkipBlockDB, id skipchain.SkipBlockID,
+	keys [][]byte) (*MultiProof, error) {
+	pr, err := st.GetMultiProof(keys)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get proof: %v", err)
+	}
+	links, latest, err := newProofLinks(s, id, st.GetIndex())
+	if err != nil {
+		return nil, err
+	}
+	return &MultiProof{
+		InclusionProof: *pr,
+		Latest:         *latest,
+		Links:          links,
+	}, nil
+}
+
+// VerifyFromBlock verifies the proof like Proof.VerifyFromBlock, using the
+// roster of the given block for the first link. It does not verify whether
+// certain keys exist in the proof.
+func (p MultiProof) VerifyFromBlock(verifiedBlock *skipchain.SkipBlock) error {
+	if len(p.Links) > 0 {
+		p.Links[0].NewRoster = verifiedBlock.Roster
+	}
+	err := p.Verify(verifiedBlock.Hash)
+	return cothority.ErrorOrNil(err, "verification failed")
+}
+
+// Verify verifies that the root of the trie is the one of the latest block,
+// and that the latest block is part of the skipchain, like Proof.Verify.
+//
+// Notice: the roster of the first link must be verified before. See
+// MultiProof.VerifyFromBlock.
+func (p MultiProof) Verify(sbID skipchain.SkipBlockID) error {
+	var header DataHeader
+	err := protobuf.Decode(p.Latest.Data, &header)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(p.InclusionProof.GetRoot(), header.TrieRoot) {
+		return cothority.WrapError(ErrorVerifyTrieRoot)
+	}
+	return verifyForwardLinks(sbID, p.Links, &p.Latest)
+}
+
+// GetValues returns the values associated with the given key. An error is
+// returned if the proof shows that the key is absent, or if it doesn't hold
+// the key.
+func (p MultiProof) GetValues(k []byte) (value []byte, version uint64,
+	contractID string, darcID darc.ID, err error) {
+	ok, err := p.InclusionProof.Exists(k)
+	if err != nil {
+		err = xerrors.Errorf("invalid proof: %v", err)
+		return
+	}
+	if !ok {
+		err = xerrors.Errorf("key %x is absent", k)
+		return
+	}
+	s, err := decodeStateChangeBody(p.InclusionProof.Get(k))
+	if err != nil {
+		err = xerrors.Errorf("decoding body: %v", err)
+		return
+	}
+	value = s.Value
+	version = s.Version
+	contractID = s.ContractID
+	darcID = s.DarcID
+	return
+}
+
+// ErrorVersionGap is returned if the state changes of a GetProofAtResponse
+// don't show that the instance didn't change until the requested block.
+var ErrorVersionGap = xerrors.New("state changes are not consecutive")
+
+// Verify checks that the state change has been applied in a block of the
+// chain starting with the given genesis block, and returns it.
+func (p StateChangeProof) Verify(genesis *skipchain.SkipBlock) (*StateChange, error) {
+	if p.Position < 0 || p.Position >= len(p.StateChanges) {
+		return nil, xerrors.New("invalid position")
+	}
+	header, err := decodeBlockHeader(&p.Block)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(StateChanges(p.StateChanges).Hash(), header.StateChangesHash) {
+		return nil, xerrors.New("state changes are not the ones of the block")
+	}
+
+	if !genesis.CalculateHash().Equal(genesis.Hash) {
+		return nil, cothority.WrapError(ErrorVerifyHash)
+	}
+	// The roster of the synthetic link is taken from the verified genesis
+	// block.
+	links := append([]skipchain.ForwardLink{}, p.Links...)
+	if len(links) > 0 {
+		links[0].NewRoster = genesis.Roster
+	}
+	err = verifyForwardLinks(genesis.Hash, links, &p.Block)
+	if err != nil {
+		return nil, xerrors.Errorf("verifying links: %v", err)
+	}
+	return &p.StateChanges[p.Position], nil
+}
+
+// Verify checks the response to a GetProofAt request for the key and the
+// block index, using the genesis block of the chain. It returns the last
+// state change applied to the instance at or before the block. If its
+// StateAction is Remove, the instance didn't exist at this block.
+//
+// The versions of an instance start over when it is spawned again, so if the
+// returned state change is a Remove, Verify cannot detect that a spawn and a
+// delete of the instance have been left out before the block.
+func (r GetProofAtResponse) Verify(genesis *skipchain.SkipBlock, key []byte,
+	index int) (*StateChange, error) {
+	sc, err := r.Change.Verify(genesis)
+	if err != nil {
+		return nil, xerrors.Errorf("verifying state change: %v", err)
+	}
+	if !bytes.Equal(sc.InstanceID, key) || r.Change.Block.Index > index {
+		return nil, xerrors.New("got the wrong state change")
+	}
+
+	switch {
+	case r.Next != nil:
+		next, err := r.Next.Verify(genesis)
+		if err != nil {
+			return nil, xerrors.Errorf("verifying next state change: %v", err)
+		}
+		if !bytes.Equal(next.InstanceID, key) || r.Next.Block.Index <= index {
+			return nil, xerrors.New("got the wrong next state change")
+		}
+		if sc.StateAction == Remove {
+			if next.StateAction != Create || next.Version != 0 {
+				return nil, ErrorVersionGap
+			}
+		} else if next.Version != sc.Version+1 {
+			return nil, ErrorVersionGap
+		}
+	case r.Latest != nil:
+		if err := r.Latest.VerifyFromBlock(genesis); err != nil {
+			return nil, xerrors.Errorf("verifying proof: %v", err)
+		}
+		if r.Latest.Latest.Index < index {
+			return nil, xerrors.New("proof is older than the block")
+		}
+		ok, err := r.Latest.InclusionProof.Exists(key)
+		if err != nil {
+			return nil, xerrors.Errorf("verifying proof: %v", err)
+		}
+		if sc.StateAction == Remove {
+			if ok {
+				return nil, ErrorVersionGap
+			}
+		} else {
+			if !ok {
+				return nil, ErrorVersionGap
+			}
+			body, err := decodeStateChangeBody(r.Latest.InclusionProof.Get(key))
+			if err != nil {
+				return nil, xerrors.Errorf("decoding value: %v", err)
+			}
+			if body.Version != sc.Version {
+				return nil, ErrorVersionGap
+			}
+		}
+	default:
+		return nil, xerrors.New("missing next state change or proof")
+	}
+	return sc, nil
+}
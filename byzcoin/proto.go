@@ -7,6 +7,7 @@ import (
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
 	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
 )
 
 // PROTOSTART
@@ -20,8 +21,10 @@ import (
 // type :InstanceID:bytes
 // type :Version:sint32
 // type :GetUpdatesFlags:uint64
+// type :network.ServerIdentityID:bytes
 // import "skipchain.proto";
 // import "onet.proto";
+// import "network.proto";
 // import "darc.proto";
 // import "trie.proto";
 //
@@ -150,6 +153,111 @@ type GetProofResponse struct {
 	Proof Proof
 }
 
+// GetMultiProof returns the proof of several keys at once. It is smaller than
+// the proofs of every key, as the skipchain proof and the common nodes of the
+// trie are only sent once.
+type GetMultiProof struct {
+	// Version of the protocol
+	Version Version
+	// Keys are the keys we want to look up
+	Keys [][]byte
+	// ID is any block that is known to us in the skipchain, like in GetProof.
+	ID skipchain.SkipBlockID
+}
+
+// GetMultiProofResponse can be used together with the Genesis block to proof
+// the presence or absence of all the requested keys.
+type GetMultiProofResponse struct {
+	// Version of the protocol
+	Version Version
+	// Proof contains everything necessary to prove the inclusion or absence
+	// of the keys given a genesis skipblock.
+	Proof MultiProof
+}
+
+// SimulateTransaction asks the service to run a transaction against the
+// current state of the chain without proposing a block. The transaction
+// must be signed with the correct counters, but the counters are not
+// consumed.
+type SimulateTransaction struct {
+	// Version of the protocol
+	Version Version
+	// SkipchainID is the hash of the first skipblock
+	SkipchainID skipchain.SkipBlockID
+	// Transaction to be simulated
+	Transaction ClientTransaction
+}
+
+// SimulateTransactionResponse holds the result of a simulated transaction.
+type SimulateTransactionResponse struct {
+	// Version of the protocol
+	Version Version
+	// Accepted is true if the transaction would be accepted given the
+	// current state.
+	Accepted bool
+	// Error describes why the transaction would be refused.
+	Error string `protobuf:"opt"`
+	// Instructions holds the result of every executed instruction,
+	// including the instructions generated during the execution. If the
+	// transaction is refused, the last entry holds the error.
+	Instructions []SimulatedInstruction
+	// StateChanges are all the state changes the transaction would
+	// produce, including the signer counter updates.
+	StateChanges []StateChange
+	// Coins are the coins left over after the last instruction.
+	Coins []Coin
+	// Fee is the amount that would be charged for the transaction.
+	Fee uint64 `protobuf:"opt"`
+}
+
+// SimulatedInstruction is the result of running one instruction in a
+// simulated transaction.
+type SimulatedInstruction struct {
+	// InstructionHash is the hash of the executed instruction.
+	InstructionHash []byte
+	// StateChanges created by the contract for this instruction.
+	StateChanges []StateChange
+	// Coins output by the contract for this instruction.
+	Coins []Coin
+	// Error is set if the instruction failed.
+	Error string `protobuf:"opt"`
+}
+
+// GetMempool asks the leader for the transactions waiting to be included in
+// a block.
+type GetMempool struct {
+	// Version of the protocol
+	Version Version
+	// SkipchainID is the hash of the first skipblock
+	SkipchainID skipchain.SkipBlockID
+}
+
+// GetMempoolResponse lists the waiting transactions in the order in which
+// they will be proposed.
+type GetMempoolResponse struct {
+	// Version of the protocol
+	Version Version
+	// Transactions waiting in the mempool, the first one being proposed
+	// first.
+	Transactions []MempoolEntry
+}
+
+// MempoolEntry describes a transaction waiting in the mempool.
+type MempoolEntry struct {
+	// TransactionHash is the hash of the instructions of the transaction.
+	TransactionHash []byte
+	// Signer is the first signer of the first instruction, or empty if the
+	// transaction isn't signed.
+	Signer string `protobuf:"opt"`
+	// Counter is the first signer counter of the first instruction.
+	Counter uint64
+	// Priority is one of PriorityConfig, PriorityDarc or PriorityUser.
+	Priority int
+	// Fee is the tip offered by the transaction. It is always 0 if the
+	// chain doesn't charge fees.
+	Fee uint64
+}
+
 // CheckAuthorization returns the list of actions that could be executed if the
 // signatures of the given identities are present and valid
 type CheckAuthorization struct {
@@ -177,6 +285,77 @@ type ChainConfig struct {
 	Roster          onet.Roster
 	MaxBlockSize    int
 	DarcContractIDs []string
+	// FeeConfig, if present, defines the fees to pay for executing
+	// instructions.
+	FeeConfig *FeeConfig `protobuf:"opt"`
+	// LeaderRotation, if present, makes the leader hand over to the next
+	// node of the roster at the end of its epoch.
+	LeaderRotation *LeaderRotation `protobuf:"opt"`
+	// RosterHealth, if present, makes the leader propose to evict the nodes
+	// that are unresponsive for too long.
+	RosterHealth *RosterHealth `protobuf:"opt"`
+}
+
+// LeaderRotation defines how long a node stays the leader before it hands
+// over to the next node of the roster. The epochs are counted in blocks, so
+// that all nodes agree on when a handover is due.
+type LeaderRotation struct {
+	// Policy is either RotationRoundRobin or RotationWeighted.
+	Policy int
+	// EpochLength is the number of blocks of an epoch.
+	EpochLength int
+	// Weights holds the number of epochs in a row that a node leads with
+	// the RotationWeighted policy. Nodes without a weight lead for one
+	// epoch.
+	Weights []LeaderWeight
+	// EpochStart is the index of the block from which the current leader
+	// leads. It is set by the config contract whenever the leader changes.
+	EpochStart int
+}
+
+// RosterHealth defines when the leader proposes to evict a node from the
+// roster. The proposal is a deferred instance that the admins must sign
+// before it is executed.
+type RosterHealth struct {
+	// Threshold is how long a node must be unresponsive before its
+	// eviction is proposed.
+	Threshold time.Duration
+	// Timeout is how long the leader waits for the nodes to reply to a
+	// connectivity check.
+	Timeout time.Duration
+}
+
+// LeaderWeight is the weight of a node in the RotationWeighted policy.
+type LeaderWeight struct {
+	Node   network.ServerIdentityID
+	Weight int
+}
+
+// FeeConfig defines how much every instruction costs. The fees of a
+// transaction are paid from the coin instance given in the FeeCoinArgument
+// of its first instruction. If the transaction is refused, only the cost of
+// its instructions is paid, without the byte cost and the tip.
+type FeeConfig struct {
+	// CoinName is the type of coin the fees are paid with.
+	CoinName InstanceID
+	// Collector is the coin instance receiving all fees.
+	Collector InstanceID
+	// DefaultCost is charged for every instruction of a contract that is
+	// not in Costs.
+	DefaultCost uint64
+	// Costs holds the price of the instructions per contract.
+	Costs []ContractCost
+	// ByteCost is charged for every byte of the created or updated
+	// instances.
+	ByteCost uint64
+}
+
+// ContractCost holds the price of the instructions of one contract.
+type ContractCost struct {
+	ContractID string
+	Spawn      uint64
+	Invoke     uint64
+	Delete     uint64
 }
 
 // Proof represents everything necessary to verify a given
@@ -280,6 +459,27 @@ type ClientTransaction struct {
 type TxResult struct {
 	ClientTransaction ClientTransaction
 	Accepted          bool
+	// Events are emitted by the contracts while running an accepted
+	// transaction.
+	Events []Event
+	// stateChanges is a private field holding the state changes of an
+	// accepted transaction, so that they can be put in its receipt.
+	// This field must be the last field of the struct, so that the
+	// protobuf-library enumerates the fields correctly.
+	stateChanges StateChanges
+}
+
+// Event is emitted by a contract with NewEvent.
+type Event struct {
+	// InstanceID is the instance that emitted the event.
+	InstanceID InstanceID
+	// ContractID is the contract of the instance.
+	ContractID string
+	// Topic is interpreted by the readers of the event, like the command
+	// of an instruction.
+	Topic string
+	// Attributes hold the data of the event.
+	Attributes Arguments
 }
 
 // StateChange is one new state that will be applied to the collection.
@@ -305,17 +505,100 @@ type Coin struct {
 	Name InstanceID
 	// Value is the total number of coins of that type.
 	Value uint64
+	// Locks hold the part of Value that cannot be spent yet.
+	Locks []CoinLock
+}
+
+// CoinLock locks coins of an account until they are released. Nothing is
+// released before Start+Cliff, then the coins are released linearly until
+// Start+Duration.
+type CoinLock struct {
+	// Total is the number of coins locked.
+	Total uint64
+	// Released is the number of coins released so far.
+	Released uint64
+	// Start is the block index, or the timestamp in nanoseconds if
+	// Timestamp is set, where the release begins.
+	Start uint64
+	// Cliff is the delay after Start before the first release.
+	Cliff uint64
+	// Duration is the delay after Start when all coins are released.
+	Duration uint64
+	// Timestamp tells whether Start, Cliff and Duration are in nanoseconds
+	// instead of blocks.
+	Timestamp bool
 }
 
 // StreamingRequest is a request asking the service to start streaming blocks
 // on the chain specified by ID.
 type StreamingRequest struct {
 	ID skipchain.SkipBlockID
+	// Filter, if set, only streams the matching transactions.
+	Filter *StreamingFilter `protobuf:"opt"`
+	// StartIndex, if positive, first streams the blocks from this index on,
+	// so that a stream can be resumed after a disconnection. If it is bigger
+	// than the index of the latest block, the stream waits for the block
+	// with this index.
+	StartIndex int `protobuf:"opt"`
+}
+
+// StreamingFilter restricts the transactions that are streamed. Every list
+// that is not empty must hold the contract ID, the instance ID or the darc ID
+// of one of the instructions of the transaction. The darc of an instruction
+// is the darc controlling its instance in the current state.
+type StreamingFilter struct {
+	ContractIDs  []string
+	InstanceIDs  []InstanceID
+	DarcIDs      []darc.ID
+	AcceptedOnly bool
+	RefusedOnly  bool
 }
 
 // StreamingResponse is the reply (block) that is streamed back to the client
 type StreamingResponse struct {
 	Block *skipchain.SkipBlock
+	// Transactions holds the transactions of the block matching the filter
+	// of the request, if any. The payload of the block is then left out.
+	Transactions []StreamingTx
+	// TxSummaries holds a summary of every transaction of the block when
+	// the stream is filtered, so that the Transactions can be verified
+	// against the header of the block.
+	TxSummaries []TxSummary
+}
+
+// StreamingTx is a transaction of a filtered stream.
+type StreamingTx struct {
+	// Index of the transaction in the block.
+	Index    int
+	TxResult TxResult
+}
+
+// TxSummary holds what is needed of a transaction to compute the
+// ClientTransactionHash of a block.
+type TxSummary struct {
+	InstructionsHash []byte
+	Accepted         bool
+}
+
+// SubscribeInstances is a request to get a proof of the instances whenever
+// they get a new version. The instances whose version is newer than the given
+// one are sent right away.
+type SubscribeInstances struct {
+	SkipChainID skipchain.SkipBlockID
+	Instances   []IDVersion
+}
+
+// SubscribeInstancesResponse is streamed back to the client whenever some of
+// the instances got a new version, were created or were removed.
+type SubscribeInstancesResponse struct {
+	Updates []InstanceUpdate
+}
+
+// InstanceUpdate holds the proof of the current state of an instance, which
+// is an absence proof if the instance has been removed.
+type InstanceUpdate struct {
+	ID    InstanceID
+	Proof Proof
 }
 
 // PaginateRequest is a request to get NumPages times the consecutive list of
@@ -388,6 +671,156 @@ type DBKeyValue struct {
 	Value []byte
 }
 
+// Snapshot describes a copy of the state trie of a chain, taken right after a
+// block has been applied. The snapshot is stored in a file named after the
+// hash of its content.
+type Snapshot struct {
+	// Hash is the sha256 hash of the content of the snapshot.
+	Hash []byte
+	// Index of the block the snapshot has been taken at.
+	Index int
+	// BlockID of the block the snapshot has been taken at. The root of the
+	// trie in the snapshot is the TrieRoot of this block.
+	BlockID skipchain.SkipBlockID
+	// Size of the snapshot in bytes.
+	Size int64
+}
+
+// GetSnapshots requests the list of the snapshots a node holds for a chain.
+type GetSnapshots struct {
+	Version     Version
+	SkipchainID skipchain.SkipBlockID
+}
+
+// GetSnapshotsResponse holds the snapshots of a node, sorted by increasing
+// index.
+type GetSnapshotsResponse struct {
+	Snapshots []Snapshot
+}
+
+// DownloadSnapshot requests a part of the content of a snapshot.
+type DownloadSnapshot struct {
+	// ByzCoinID of the chain of the snapshot.
+	ByzCoinID skipchain.SkipBlockID
+	// Hash of the snapshot to download.
+	Hash []byte
+	// Offset of the first byte to return.
+	Offset int64
+	// Length is the maximum number of bytes to return.
+	Length int
+}
+
+// DownloadSnapshotResponse holds a part of the content of a snapshot. If the
+// end of the snapshot has been reached, Data is shorter than the requested
+// length.
+type DownloadSnapshotResponse struct {
+	Data []byte
+}
+
+// ExportState requests a page of the nodes of the state trie of a chain. All
+// the pages of an export come from the same state, even if new blocks are
+// added during the export. Starting an export needs the private key of the
+// node.
+type ExportState struct {
+	Version Version
+	// ByzCoinID of the chain to export.
+	ByzCoinID skipchain.SkipBlockID
+	// Nonce is 0 to start a new export, else it must be the nonce returned
+	// with the previous page.
+	Nonce uint64
+	// Length is the maximum number of nodes to return.
+	Length int
+	// Timestamp in Unix seconds, only needed to start an export.
+	Timestamp int64
+	// Signature by the node of ByzCoinID | Timestamp, with the timestamp as
+	// a little-endian uint64. It is only needed to start an export.
+	Signature []byte
+}
+
+// ExportStateResponse holds a page of the nodes of an export. An empty page
+// marks the end of the export.
+type ExportStateResponse struct {
+	// Nonce of the export, to be given when requesting the next page.
+	Nonce uint64
+	// Header describes the exported state.
+	Header StateExportHeader
+	// Nodes of the page, in the order of the state trie.
+	Nodes []ExportedNode
+}
+
+// StateExportHeader describes the state of an export.
+type StateExportHeader struct {
+	// ByzCoinID of the exported chain.
+	ByzCoinID skipchain.SkipBlockID
+	// BlockIndex of the last block applied to the exported state.
+	BlockIndex int
+	// TrieRoot is the root of the state trie of the chain after BlockIndex.
+	TrieRoot []byte
+	// TrieNonce is the nonce of the state trie of the chain.
+	TrieNonce []byte
+	// Config of the chain after BlockIndex.
+	Config ChainConfig
+	// Block is the block at BlockIndex, whose header holds TrieRoot.
+	Block *skipchain.SkipBlock
+}
+
+// ExportedNode is a leaf or an empty node of the state trie. The empty nodes
+// are needed to rebuild a trie with the same root as the exported one.
+type ExportedNode struct {
+	// Instance of a leaf.
+	Instance *ExportedInstance `protobuf:"opt"`
+	// Empty is the path of an empty node, if Instance is nil.
+	Empty []bool
+}
+
+// ExportedInstance is an instance of the global state, as it is exported.
+type ExportedInstance struct {
+	InstanceID InstanceID
+	ContractID string
+	DarcID     darc.ID
+	Version    uint64
+	Value      []byte
+	// StateAction that last changed the instance, which is stored with it.
+	StateAction StateAction
+}
+
+// ImportState sends a page of a state export to a node, which rebuilds the
+// state trie of the export, to be used by CreateImportedGenesisBlock. The
+// pages must be sent in the order of the export.
+type ImportState struct {
+	Version Version
+	// Nonce is 0 for the first page, else it must be the nonce returned
+	// with the previous page.
+	Nonce uint64
+	// Header of the export, only needed in the first page.
+	Header *StateExportHeader `protobuf:"opt"`
+	// Nodes of the page, in the order of the export.
+	Nodes []ExportedNode
+	// End marks the last page. The rebuilt trie must then have the root
+	// of the block of the header.
+	End bool
+}
+
+// ImportStateResponse is the reply to ImportState.
+type ImportStateResponse struct {
+	// Nonce of the import, to be given with the next page.
+	Nonce uint64
+}
+
+// CreateImportedGenesisBlock asks the service to create a new chain whose
+// genesis state is a state export. The export must have been sent to every
+// node of the roster with ImportState. The config of the export is kept,
+// except for the roster and, if it is given, the block interval.
+type CreateImportedGenesisBlock struct {
+	Version Version
+	// Roster of the new chain.
+	Roster onet.Roster
+	// BlockInterval of the new chain. Zero keeps the exported one.
+	BlockInterval time.Duration `protobuf:"opt"`
+	// TrieRoot of the imported state.
+	TrieRoot []byte
+}
+
 // StateChangeBody represents the body part of a state change, which is the
 // part that needs to be serialised and stored in a merkle tree.
 type StateChangeBody struct {
@@ -469,6 +902,127 @@ type CheckStateChangeValidityResponse struct {
 	BlockID      skipchain.SkipBlockID
 }
 
+// GetReceipt is a request for the receipt of a transaction.
+type GetReceipt struct {
+	SkipChainID skipchain.SkipBlockID
+	// TxHash is the hash of the instructions of the transaction.
+	TxHash []byte
+}
+
+// GetReceiptResponse holds the receipt of a transaction.
+type GetReceiptResponse struct {
+	Receipt Receipt
+}
+
+// Receipt holds the result of a transaction that has been included in a
+// block.
+type Receipt struct {
+	// TxHash is the hash of the instructions of the transaction.
+	TxHash []byte
+	// BlockID and BlockIndex point to the block holding the transaction.
+	BlockID    skipchain.SkipBlockID
+	BlockIndex int
+	// Accepted is false if the transaction has been refused.
+	Accepted bool
+	// StateChanges are the changes of the trie made by the transaction. They
+	// are missing if the node rebuilt the receipt from the block, because it
+	// didn't apply the block itself.
+	StateChanges []StateChange
+	// Events are emitted by the contracts while running the transaction.
+	Events []Event
+}
+
+// GetProofAt is a request for the value of an instance as it was right after
+// the block with the given index, together with the material to verify it.
+type GetProofAt struct {
+	Version     Version
+	SkipChainID skipchain.SkipBlockID
+	Key         []byte
+	BlockIndex  int
+}
+
+// GetProofAtResponse holds the last state change of the instance applied at
+// or before the requested block. To show that the instance didn't change
+// until the requested block, it also holds either the next state change of
+// the instance, or a proof that the instance still has the same version.
+type GetProofAtResponse struct {
+	// Change is the last state change applied at or before the block.
+	Change StateChangeProof
+	// Next is the first state change applied after the block. It is only
+	// set if the instance changed after the block.
+	Next *StateChangeProof `protobuf:"opt"`
+	// Latest is the proof of the current value of the instance. It is only
+	// set if the instance didn't change after the block.
+	Latest *Proof `protobuf:"opt"`
+}
+
+// StateChangeProof shows that a state change has been applied in a block of
+// the chain: the hash of the StateChanges must be the StateChangesHash of the
+// block, and the links must go from the genesis block to the block.
+type StateChangeProof struct {
+	// Position of the state change in StateChanges.
+	Position     int
+	StateChanges []StateChange
+	Block        skipchain.SkipBlock
+	Links        []skipchain.ForwardLink
+}
+
+// QueryInstances is a request for the instances of the global state that
+// match all the given filters. The instances are returned by pages, in the
+// order of the trie, which doesn't depend on the order they were created in.
+type QueryInstances struct {
+	Version     Version
+	SkipChainID skipchain.SkipBlockID
+	// ContractID, if not empty, only returns the instances of this contract.
+	ContractID string
+	// DarcID, if not empty, only returns the instances controlled by this
+	// darc.
+	DarcID darc.ID
+	// Prefix, if not empty, only returns the instances whose ID starts with
+	// it.
+	Prefix []byte
+	// Cursor is the Cursor of the previous page, or empty for the first page.
+	Cursor []byte
+	// Limit is the maximum number of instances in the page. If it is 0, or
+	// bigger than the maximum allowed by the node, the latter is used.
+	Limit int
+	// WithProof asks for a proof of the instances of the page.
+	WithProof bool
+}
+
+// QueryInstancesResponse holds a page of instances.
+type QueryInstancesResponse struct {
+	Instances []QueriedInstance
+	// Index of the block whose state has been queried.
+	Index int
+	// Cursor is used to request the next page. It is empty if there are no
+	// more instances.
+	Cursor []byte
+	// Proof is the proof of all the instances of the page, if asked for.
+	// If the page is empty, it only proves the state of the block at Index.
+	Proof *MultiProof `protobuf:"opt"`
+}
+
+// QueriedInstance is an instance returned by QueryInstances.
+type QueriedInstance struct {
+	InstanceID InstanceID
+	ContractID string
+	Value      []byte
+	Version    uint64
+	DarcID     darc.ID
+}
+
+// MultiProof is like Proof, but for several keys at once. The nodes of the
+// trie and the skipchain proof are only stored once.
+type MultiProof struct {
+	// InclusionProof holds the inclusion or absence proofs of the keys.
+	InclusionProof trie.MultiProof
+	// Providing the latest skipblock to retrieve the Merkle tree root.
+	Latest skipchain.SkipBlock
+	// Proving the path to the latest skipblock, like in Proof.
+	Links []skipchain.ForwardLink
+}
+
 // ResolveInstanceID is the request for resolving the instance ID based on the
 // Darc ID and the name.
 type ResolveInstanceID struct {
@@ -510,6 +1064,19 @@ type DebugResponseState struct {
 	State StateChangeBody
 }
 
+// HandoverRequest asks the conode, which must be the leader of the chain, to
+// hand over the lead to the next node of the roster, e.g., before a
+// maintenance. It needs to be signed by the private key of the conode.
+type HandoverRequest struct {
+	ByzCoinID skipchain.SkipBlockID
+	Signature []byte
+}
+
+// HandoverResponse holds the new leader of the chain.
+type HandoverResponse struct {
+	Leader *network.ServerIdentity
+}
+
 // DebugRemoveRequest asks the conode to delete the given byzcoin-instance from its database.
 // It needs to be signed by the private key of the conode.
 type DebugRemoveRequest struct {
@@ -538,6 +1105,8 @@ type GetUpdatesRequest struct {
 
 // GetUpdatesReply only sends back the instances that have a new version,
 // but will not send any proof for an instance that didn't change.
+// The Links go from LatestBlockID, or the genesis block if it is unknown, to
+// Latest, the same way as in Proof.
 type GetUpdatesReply struct {
 	Proofs []trie.Proof
 	Links  []skipchain.ForwardLink
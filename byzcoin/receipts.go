@@ -0,0 +1,182 @@
+package byzcoin
+
+import (
+	"bytes"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/cothority/v3/storage"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// receiptBucket holds the receipts of the transactions of all the chains.
+var receiptBucket = []byte("receipts")
+
+// receiptRebuildDepth is the number of blocks, starting from the latest one,
+// whose missing receipts are rebuilt when a chain starts, or when it catches
+// up from a snapshot.
+var receiptRebuildDepth = 1000
+
+func receiptKey(scID skipchain.SkipBlockID, txHash []byte) []byte {
+	return append(append([]byte{}, scID...), txHash...)
+}
+
+// setStateChanges keeps the state changes of a transaction for its receipt,
+// and moves the events out of them. For a refused transaction, they are the
+// ones charging its fee, if any.
+func (txr *TxResult) setStateChanges(scs StateChanges) {
+	txr.Events = nil
+	txr.stateChanges = nil
+	for _, sc := range scs {
+		if sc.StateAction != EmitEvent {
+			txr.stateChanges = append(txr.stateChanges, sc)
+			continue
+		}
+		var ev Event
+		// The events have already been decoded when running the
+		// transaction.
+		if err := protobuf.Decode(sc.Value, &ev); err != nil {
+			log.Error("failed to decode event:", err)
+			continue
+		}
+		txr.Events = append(txr.Events, ev)
+	}
+}
+
+// sameEvents returns whether both lists hold the same events.
+func sameEvents(a, b []Event) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		bufA, errA := protobuf.Encode(&a[i])
+		bufB, errB := protobuf.Encode(&b[i])
+		if errA != nil || errB != nil || !bytes.Equal(bufA, bufB) {
+			return false
+		}
+	}
+	return true
+}
+
+// storeReceipts stores the receipts of the transactions of the block. The
+// transactions must have been run by createStateChanges, so that they hold
+// their state changes.
+func (s *Service) storeReceipts(sb *skipchain.SkipBlock, txs TxResults) error {
+	return s.receipts.Update(func(b storage.Bucket) error {
+		for _, txr := range txs {
+			r := Receipt{
+				TxHash:       txr.ClientTransaction.Instructions.Hash(),
+				BlockID:      sb.Hash,
+				BlockIndex:   sb.Index,
+				Accepted:     txr.Accepted,
+				StateChanges: txr.stateChanges,
+				Events:       txr.Events,
+			}
+			buf, err := protobuf.Encode(&r)
+			if err != nil {
+				return xerrors.Errorf("encoding receipt: %v", err)
+			}
+			err = b.Put(receiptKey(sb.SkipChainID(), r.TxHash), buf)
+			if err != nil {
+				return xerrors.Errorf("writing receipt: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// GetReceipt returns the receipt of a transaction that has been included in
+// a block, with the events and the state changes of the transaction. If the
+// node didn't apply the block itself, e.g., because it got the global state
+// from a snapshot, the receipt is only known once it has been rebuilt from
+// the block, without the state changes.
+func (s *Service) GetReceipt(req *GetReceipt) (*GetReceiptResponse, error) {
+	resp := &GetReceiptResponse{}
+	err := s.receipts.View(func(b storage.Bucket) error {
+		buf := b.Get(receiptKey(req.SkipChainID, req.TxHash))
+		if buf == nil {
+			return xerrors.New("unknown transaction")
+		}
+		return protobuf.Decode(buf, &resp.Receipt)
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("reading receipt: %v", err)
+	}
+	return resp, nil
+}
+
+// rebuildReceiptsInBackground rebuilds the missing receipts of the chain
+// without blocking the caller.
+func (s *Service) rebuildReceiptsInBackground(scID skipchain.SkipBlockID) {
+	if !s.tasks.add(1) {
+		return
+	}
+	go func() {
+		defer s.tasks.done()
+		if err := s.rebuildReceipts(scID); err != nil {
+			log.Warnf("%s: couldn't rebuild the receipts of %x: %v",
+				s.ServerIdentity(), scID, err)
+		}
+	}()
+}
+
+// rebuildReceipts stores the receipts that are missing for the transactions
+// of the latest blocks of the chain, e.g., because the node got the global
+// state from a snapshot. The state changes of the transactions are not in
+// the blocks, so the rebuilt receipts don't hold them.
+func (s *Service) rebuildReceipts(scID skipchain.SkipBlockID) error {
+	sb, err := s.db().GetLatestByID(scID)
+	if err != nil {
+		return xerrors.Errorf("getting latest block: %v", err)
+	}
+	for i := 0; i < receiptRebuildDepth && sb != nil; i++ {
+		if !s.tasks.areTasksAllowed() {
+			return nil
+		}
+		var body DataBody
+		err = protobuf.Decode(sb.Payload, &body)
+		if err != nil {
+			return xerrors.Errorf("decoding block %d: %v", sb.Index, err)
+		}
+		err = s.storeMissingReceipts(sb, body.TxResults)
+		if err != nil {
+			return err
+		}
+		if len(sb.BackLinkIDs) == 0 {
+			break
+		}
+		sb = s.db().GetByID(sb.BackLinkIDs[0])
+	}
+	return nil
+}
+
+// storeMissingReceipts stores the receipts of the transactions of the block
+// that are not known yet, using only the content of the block.
+func (s *Service) storeMissingReceipts(sb *skipchain.SkipBlock,
+	txs TxResults) error {
+	return s.receipts.Update(func(b storage.Bucket) error {
+		for _, txr := range txs {
+			key := receiptKey(sb.SkipChainID(),
+				txr.ClientTransaction.Instructions.Hash())
+			if b.Get(key) != nil {
+				continue
+			}
+			buf, err := protobuf.Encode(&Receipt{
+				TxHash:     txr.ClientTransaction.Instructions.Hash(),
+				BlockID:    sb.Hash,
+				BlockIndex: sb.Index,
+				Accepted:   txr.Accepted,
+				Events:     txr.Events,
+			})
+			if err != nil {
+				return xerrors.Errorf("encoding receipt: %v", err)
+			}
+			err = b.Put(key, buf)
+			if err != nil {
+				return xerrors.Errorf("writing receipt: %v", err)
+			}
+		}
+		return nil
+	})
+}
@@ -0,0 +1,58 @@
+package byzcoin
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/storage"
+)
+
+// Makes sure that a node rebuilds the receipts of the transactions of the
+// blocks it didn't apply itself, and that GetReceipt doesn't look for them.
+func TestService_RebuildReceipt(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	ctx, _ := b.SpawnDummy(nil)
+	txHash := ctx.Instructions.Hash()
+	s := b.Services[0]
+	req := &GetReceipt{SkipChainID: b.Genesis.SkipChainID(), TxHash: txHash}
+	stored, err := s.GetReceipt(req)
+	require.NoError(t, err)
+	require.NotEmpty(t, stored.Receipt.StateChanges)
+
+	deleteReceipt := func() {
+		require.NoError(t, s.receipts.Update(func(b storage.Bucket) error {
+			return b.Delete(receiptKey(req.SkipChainID, txHash))
+		}))
+	}
+	deleteReceipt()
+	_, err = s.GetReceipt(req)
+	require.Error(t, err)
+
+	require.NoError(t, s.rebuildReceipts(req.SkipChainID))
+	rebuilt, err := s.GetReceipt(req)
+	require.NoError(t, err)
+	require.Equal(t, stored.Receipt.BlockID, rebuilt.Receipt.BlockID)
+	require.Equal(t, stored.Receipt.BlockIndex, rebuilt.Receipt.BlockIndex)
+	require.True(t, rebuilt.Receipt.Accepted)
+	require.Empty(t, rebuilt.Receipt.StateChanges)
+
+	// The rebuild is limited to the latest blocks.
+	deleteReceipt()
+	defer func(depth int) { receiptRebuildDepth = depth }(receiptRebuildDepth)
+	receiptRebuildDepth = 0
+	require.NoError(t, s.rebuildReceipts(req.SkipChainID))
+	_, err = s.GetReceipt(req)
+	require.Error(t, err)
+
+	// The receipts are rebuilt when the chain starts.
+	receiptRebuildDepth = 1000
+	s.TestClose()
+	require.NoError(t, s.TestRestart())
+	require.Eventually(t, func() bool {
+		_, err := s.GetReceipt(req)
+		return err == nil
+	}, 10*time.Second, 100*time.Millisecond)
+}
@@ -0,0 +1,432 @@
+package byzcoin
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/viewchange"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	status "go.dedis.ch/cothority/v3/status/service"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// EvictArgument is the name of the argument of the view_change instruction
+// holding the roster without the evicted node. As it changes the roster
+// without a failure of the leader, it must be signed by the identities that
+// can update the config.
+const EvictArgument = "evict"
+
+// EvictProposalArgument is the name of the argument of the view_change
+// instruction with which a node of the roster proposes an eviction. It
+// holds the roster without the evicted node, and creates a deferred
+// instance with the eviction, for the admins to sign.
+const EvictProposalArgument = "evict_proposal"
+
+// minEvictionRoster is the smallest roster a node can be evicted from, so
+// that the remaining nodes keep a majority.
+const minEvictionRoster = 4
+
+// rosterHealthInterval is how often the leader checks the connectivity of
+// the nodes of the roster.
+var rosterHealthInterval = time.Minute
+
+func (rh RosterHealth) sanityCheck() error {
+	if rh.Threshold <= 0 {
+		return xerrors.New("threshold is less or equal to zero")
+	}
+	if rh.Timeout <= 0 {
+		return xerrors.New("timeout is less or equal to zero")
+	}
+	return nil
+}
+
+// String returns the text representation of the roster health, as used by
+// ChainConfig.String.
+func (rh RosterHealth) String() string {
+	res := new(strings.Builder)
+	fmt.Fprintf(res, "-- RosterHealth:\n")
+	fmt.Fprintf(res, "--- Threshold: %s\n", rh.Threshold)
+	fmt.Fprintf(res, "--- Timeout: %s\n", rh.Timeout)
+	return res.String()
+}
+
+// checkEviction makes sure that the new roster is the roster of the config
+// without one of its nodes, and returns this node.
+func (c ChainConfig) checkEviction(newRoster onet.Roster) (*network.ServerIdentity, error) {
+	if len(c.Roster.List) < minEvictionRoster {
+		return nil, xerrors.Errorf("a roster of less than %d nodes cannot "+
+			"lose one", minEvictionRoster)
+	}
+	if len(newRoster.List) != len(c.Roster.List)-1 {
+		return nil, xerrors.New("exactly one node must be evicted")
+	}
+	for i, si := range c.Roster.List {
+		if i < len(newRoster.List) && si.Equal(newRoster.List[i]) {
+			continue
+		}
+		rest := append([]*network.ServerIdentity{}, c.Roster.List[:i]...)
+		rest = append(rest, c.Roster.List[i+1:]...)
+		ok, err := onet.NewRoster(rest).Equal(&newRoster)
+		if err != nil {
+			return nil, xerrors.Errorf("comparing rosters: %v", err)
+		}
+		if !ok {
+			return nil, xerrors.New("the other nodes must stay in the same order")
+		}
+		return si, nil
+	}
+	return nil, xerrors.New("no node is evicted")
+}
+
+// evictScs returns the state changes removing a node from the roster. The
+// evicted node also loses its right to do view-changes.
+func evictScs(rst ReadOnlyStateTrie, inst Instruction, darcID darc.ID) (StateChanges, error) {
+	config, err := rst.LoadConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	newRoster, err := decodeEvictRoster(inst.Invoke.Args.Search(EvictArgument))
+	if err != nil {
+		return nil, err
+	}
+	si, err := config.checkEviction(*newRoster)
+	if err != nil {
+		return nil, xerrors.Errorf("checking eviction: %v", err)
+	}
+	newConfig := *config
+	newConfig.Roster = *newRoster
+	if err = newConfig.sanityCheck(config, rst.GetVersion()); err != nil {
+		return nil, xerrors.Errorf("sanity check: %v", err)
+	}
+
+	log.Lvlf2("Evicting %s from the roster", si)
+	sc, err := updateRosterScs(rst, darcID, *newRoster)
+	if err != nil {
+		return nil, xerrors.Errorf("roster scs: %v", err)
+	}
+	ruleSc, err := viewChangeRuleSc(rst, darcID, *newRoster)
+	if err != nil {
+		return nil, xerrors.Errorf("darc scs: %v", err)
+	}
+	return append(sc, ruleSc), nil
+}
+
+// evictProposalID returns the ID of the deferred instance holding the
+// eviction of the node, so that there is only one proposal per node.
+func evictProposalID(si *network.ServerIdentity) InstanceID {
+	h := sha256.New()
+	h.Write([]byte(EvictProposalArgument))
+	h.Write(si.ID[:])
+	return NewInstanceID(h.Sum(nil))
+}
+
+// evictProposalScs returns the state changes creating a deferred instance
+// with the eviction proposed by a node of the roster. A proposal replaces the
+// previous one for the same node only once it expired or has been executed.
+func evictProposalScs(rst ReadOnlyStateTrie, inst Instruction, darcID darc.ID) (StateChanges, error) {
+	config, err := rst.LoadConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	rosterBuf := inst.Invoke.Args.Search(EvictProposalArgument)
+	newRoster, err := decodeEvictRoster(rosterBuf)
+	if err != nil {
+		return nil, err
+	}
+	si, err := config.checkEviction(*newRoster)
+	if err != nil {
+		return nil, xerrors.Errorf("checking eviction: %v", err)
+	}
+
+	id := evictProposalID(si)
+	action := Create
+	pr, err := rst.GetProof(id.Slice())
+	if err != nil {
+		return nil, xerrors.Errorf("reading trie: %v", err)
+	}
+	if pr.Match(id.Slice()) {
+		buf, _, _, _, err := rst.GetValues(id.Slice())
+		if err != nil {
+			return nil, xerrors.Errorf("reading trie: %v", err)
+		}
+		var previous DeferredData
+		if err = protobuf.Decode(buf, &previous); err != nil {
+			return nil, xerrors.Errorf("decoding deferred data: %v", err)
+		}
+		if previous.MaxNumExecution > 0 &&
+			uint64(rst.GetIndex()) <= previous.ExpireBlockIndex {
+			return nil, xerrors.Errorf("eviction of %s is already proposed", si)
+		}
+		action = Update
+	}
+
+	evict := Instruction{
+		InstanceID: ConfigInstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "view_change",
+			Args:       Arguments{{Name: EvictArgument, Value: rosterBuf}},
+		},
+	}
+	data := DeferredData{
+		ProposedTransaction: ClientTransaction{Instructions: Instructions{evict}},
+		ExpireBlockIndex:    uint64(rst.GetIndex()) + defaultExpireThreshold,
+		InstructionHashes:   [][]byte{hashDeferred(evict, id.Slice())},
+		MaxNumExecution:     defaultNumExecution,
+	}
+	dataBuf, err := protobuf.Encode(&data)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding deferred data: %v", err)
+	}
+	return StateChanges{
+		NewStateChange(action, id, ContractDeferredID, dataBuf, darcID),
+	}, nil
+}
+
+func decodeEvictRoster(buf []byte) (*onet.Roster, error) {
+	var roster onet.Roster
+	err := protobuf.DecodeWithConstructors(buf, &roster,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, xerrors.Errorf("decoding roster: %v", err)
+	}
+	return &roster, nil
+}
+
+// isEviction returns true if the instruction evicts a node from the roster.
+func isEviction(inst Instruction) bool {
+	return inst.GetType() == InvokeType &&
+		inst.Invoke.Command == "view_change" &&
+		inst.Invoke.Args.Search(EvictArgument) != nil
+}
+
+// rosterHealth tracks since when the nodes of every chain are
+// unresponsive.
+type rosterHealth struct {
+	sync.Mutex
+	// down holds, per chain, since when the nodes are unresponsive.
+	down map[string]map[network.ServerIdentityID]time.Time
+	// proposed holds, per chain, when the eviction of the nodes has been
+	// proposed.
+	proposed map[string]map[network.ServerIdentityID]time.Time
+}
+
+func newRosterHealth() *rosterHealth {
+	return &rosterHealth{
+		down:     make(map[string]map[network.ServerIdentityID]time.Time),
+		proposed: make(map[string]map[network.ServerIdentityID]time.Time),
+	}
+}
+
+// alive marks the nodes as responsive.
+func (rh *rosterHealth) alive(scID skipchain.SkipBlockID, sis ...*network.ServerIdentity) {
+	rh.Lock()
+	defer rh.Unlock()
+	for _, si := range sis {
+		delete(rh.down[string(scID)], si.ID)
+		delete(rh.proposed[string(scID)], si.ID)
+	}
+}
+
+// unresponsive marks the nodes as unresponsive, unless they already are.
+func (rh *rosterHealth) unresponsive(scID skipchain.SkipBlockID, now time.Time,
+	sis ...*network.ServerIdentity) {
+	rh.Lock()
+	defer rh.Unlock()
+	down, ok := rh.down[string(scID)]
+	if !ok {
+		down = make(map[network.ServerIdentityID]time.Time)
+		rh.down[string(scID)] = down
+	}
+	for _, si := range sis {
+		if _, ok := down[si.ID]; !ok {
+			down[si.ID] = now
+		}
+	}
+}
+
+// toEvict returns the node of the roster that has been unresponsive for the
+// longest time, if it is longer than the threshold. As the roster can only
+// change by one node at a time, there is no new proposal while another one
+// is pending. A proposal that didn't lead to an eviction is renewed once it
+// expired.
+func (rh *rosterHealth) toEvict(scID skipchain.SkipBlockID, roster onet.Roster,
+	threshold, expiry time.Duration, now time.Time) *network.ServerIdentity {
+	rh.Lock()
+	defer rh.Unlock()
+	down := rh.down[string(scID)]
+	proposed, ok := rh.proposed[string(scID)]
+	if !ok {
+		proposed = make(map[network.ServerIdentityID]time.Time)
+		rh.proposed[string(scID)] = proposed
+	}
+
+	// Forget about the nodes that left the roster.
+	for id := range down {
+		if i, _ := roster.Search(id); i < 0 {
+			delete(down, id)
+			delete(proposed, id)
+		}
+	}
+
+	var evict *network.ServerIdentity
+	for _, si := range roster.List {
+		if t, ok := proposed[si.ID]; ok && now.Sub(t) < expiry {
+			return nil
+		}
+		since, ok := down[si.ID]
+		if !ok || now.Sub(since) < threshold {
+			continue
+		}
+		if evict == nil || since.Before(down[evict.ID]) {
+			evict = si
+		}
+	}
+	if evict != nil {
+		proposed[evict.ID] = now
+	}
+	return evict
+}
+
+// updateRosterHealth records the liveness shown by a new block: its leader
+// is responsive, and the leaders replaced by a view-change are not.
+func (s *Service) updateRosterHealth(sb *skipchain.SkipBlock, view *viewchange.View) {
+	scID := sb.SkipChainID()
+	s.rosterHealth.alive(scID, sb.Roster.List[0])
+	if view == nil || len(sb.BackLinkIDs) == 0 {
+		return
+	}
+	prev := s.db().GetByID(sb.BackLinkIDs[0])
+	if prev == nil || len(prev.Roster.List) == 0 {
+		return
+	}
+	failed := view.LeaderIndex % len(prev.Roster.List)
+	s.rosterHealth.unresponsive(scID, time.Now(), prev.Roster.List[:failed]...)
+}
+
+// checkRosterHealth checks the connectivity of the nodes of the roster, and
+// proposes to evict a node that is unresponsive for longer than the
+// threshold of the config. It must be called by the leader.
+func (s *Service) checkRosterHealth(scID skipchain.SkipBlockID) {
+	config, err := s.LoadConfig(scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get configuration:", err)
+		return
+	}
+	if config.RosterHealth == nil {
+		return
+	}
+	list := append([]*network.ServerIdentity{}, config.Roster.List...)
+	if !list[0].Equal(s.ServerIdentity()) {
+		return
+	}
+
+	nodes, err := status.NewClient().CheckConnectivity(s.getPrivateKey(),
+		list, config.RosterHealth.Timeout, true)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't check connectivity:", err)
+		return
+	}
+	reachable := onet.NewRoster(nodes)
+	var down []*network.ServerIdentity
+	for _, si := range config.Roster.List {
+		if i, _ := reachable.Search(si.ID); i < 0 {
+			down = append(down, si)
+		}
+	}
+	now := time.Now()
+	s.rosterHealth.alive(scID, nodes...)
+	s.rosterHealth.unresponsive(scID, now, down...)
+	if len(down) > 0 {
+		log.Warnf("%s: nodes unresponsive in %x: %v", s.ServerIdentity(),
+			scID, down)
+	}
+
+	// A roster of 3 nodes cannot lose one more.
+	if len(config.Roster.List) < minEvictionRoster {
+		return
+	}
+	// The deferred instance of a proposal expires after
+	// defaultExpireThreshold blocks.
+	expiry := config.BlockInterval * time.Duration(defaultExpireThreshold)
+	evict := s.rosterHealth.toEvict(scID, config.Roster,
+		config.RosterHealth.Threshold, expiry, now)
+	if evict == nil {
+		return
+	}
+	if err := s.proposeEviction(scID, config, evict); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't propose eviction:", err)
+	}
+}
+
+// proposeEviction sends the transaction creating a deferred instance that
+// removes the node from the roster once it is signed by the admins.
+func (s *Service) proposeEviction(scID skipchain.SkipBlockID, config *ChainConfig,
+	evict *network.ServerIdentity) error {
+	var list []*network.ServerIdentity
+	for _, si := range config.Roster.List {
+		if !si.Equal(evict) {
+			list = append(list, si)
+		}
+	}
+	rosterBuf, err := protobuf.Encode(onet.NewRoster(list))
+	if err != nil {
+		return xerrors.Errorf("encoding roster: %v", err)
+	}
+
+	latest, err := s.db().GetLatestByID(scID)
+	if err != nil {
+		return xerrors.Errorf("getting latest: %v", err)
+	}
+	header, err := decodeBlockHeader(latest)
+	if err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	st, err := s.GetReadOnlyStateTrie(scID)
+	if err != nil {
+		return xerrors.Errorf("getting trie: %v", err)
+	}
+	signer := darc.NewSignerEd25519(s.ServerIdentity().Public, s.getPrivateKey())
+	ctr, err := getSignerCounter(st, signer.Identity().String())
+	if err != nil {
+		return xerrors.Errorf("getting counter: %v", err)
+	}
+
+	ctx := ClientTransaction{
+		Instructions: []Instruction{{
+			InstanceID: NewInstanceID(nil),
+			Invoke: &Invoke{
+				ContractID: ContractConfigID,
+				Command:    "view_change",
+				Args: []Argument{{
+					Name:  EvictProposalArgument,
+					Value: rosterBuf,
+				}},
+			},
+			SignerIdentities: []darc.Identity{signer.Identity()},
+			SignerCounter:    []uint64{ctr + 1},
+		}},
+	}
+	ctx.Instructions.SetVersion(header.Version)
+	if err = ctx.Instructions[0].SignWith(ctx.Instructions.Hash(), signer); err != nil {
+		return xerrors.Errorf("signing tx: %v", err)
+	}
+
+	log.Lvlf1("%s proposes to evict %s from %x in deferred instance %x",
+		s.ServerIdentity(), evict, scID, evictProposalID(evict).Slice())
+	_, err = s.AddTransaction(&AddTxRequest{
+		Version:     CurrentVersion,
+		SkipchainID: scID,
+		Transaction: ctx,
+	})
+	return cothority.ErrorOrNil(err, "adding transaction")
+}
@@ -0,0 +1,218 @@
+package byzcoin
+
+import (
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+)
+
+func TestRosterHealth_ToEvict(t *testing.T) {
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(4, false)
+	scID := []byte("chain")
+	rh := newRosterHealth()
+	now := time.Now()
+
+	require.Nil(t, rh.toEvict(scID, *roster, time.Second, 2*time.Second, now))
+
+	rh.unresponsive(scID, now, roster.List[2])
+	rh.unresponsive(scID, now.Add(time.Second), roster.List[3])
+	// Already unresponsive nodes keep their time.
+	rh.unresponsive(scID, now.Add(time.Second), roster.List[2])
+	require.Nil(t, rh.toEvict(scID, *roster, time.Second, 2*time.Second, now))
+
+	// The node that is down for the longest time is evicted first, and no
+	// other node is proposed as long as the proposal is pending.
+	later := now.Add(3 * time.Second)
+	require.True(t, rh.toEvict(scID, *roster, time.Second, 2*time.Second, later).Equal(roster.List[2]))
+	require.Nil(t, rh.toEvict(scID, *roster, time.Second, 2*time.Second, later))
+	later = later.Add(2 * time.Second)
+	require.True(t, rh.toEvict(scID, *roster, time.Second, 2*time.Second, later).Equal(roster.List[2]))
+
+	// A node that is back is not evicted.
+	rh.alive(scID, roster.List[2])
+	require.True(t, rh.toEvict(scID, *roster, time.Second, 2*time.Second, later).Equal(roster.List[3]))
+
+	// Only nodes of the roster are evicted.
+	rh.unresponsive(scID, now, roster.List[2])
+	smaller := onet.NewRoster(roster.List[:3])
+	later = later.Add(time.Second)
+	require.True(t, rh.toEvict(scID, *smaller, time.Second, 2*time.Second, later).Equal(roster.List[2]))
+	require.Empty(t, rh.down[string(scID)][roster.List[3].ID])
+}
+
+func TestChainConfig_CheckEviction(t *testing.T) {
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, roster, _ := local.GenTree(4, false)
+	c := ChainConfig{Roster: *roster}
+
+	list := roster.List
+	si, err := c.checkEviction(*onet.NewRoster([]*network.ServerIdentity{list[0], list[2], list[3]}))
+	require.NoError(t, err)
+	require.True(t, si.Equal(list[1]))
+	si, err = c.checkEviction(*onet.NewRoster(list[:3]))
+	require.NoError(t, err)
+	require.True(t, si.Equal(list[3]))
+
+	_, err = c.checkEviction(*onet.NewRoster(list[:2]))
+	require.Error(t, err)
+	_, err = c.checkEviction(*onet.NewRoster([]*network.ServerIdentity{list[0], list[3], list[2]}))
+	require.Error(t, err)
+
+	// A roster of 3 nodes keeps all of them.
+	c = ChainConfig{Roster: *onet.NewRoster(list[:3])}
+	_, err = c.checkEviction(*onet.NewRoster(list[:2]))
+	require.Error(t, err)
+}
+
+// Makes sure that the leader proposes to evict a node that is down, and that
+// the node is evicted once the admins signed the proposal.
+func TestService_RosterHealth(t *testing.T) {
+	defer func(interval time.Duration) {
+		rosterHealthInterval = interval
+	}(rosterHealthInterval)
+	rosterHealthInterval = 500 * time.Millisecond
+
+	args := defaultBCTArgs
+	args.Nodes = 4
+	b := newBCT(t, &args)
+	b.AddGenesisRules("invoke:"+ContractDeferredID+".addProof",
+		"invoke:"+ContractDeferredID+".execProposedTx")
+	b.CreateByzCoin()
+	defer b.CloseAll()
+
+	// Don't wait for the stopped node when propagating the blocks.
+	for _, service := range b.Services {
+		service.SetPropagationTimeout(4 * b.PropagationInterval)
+	}
+
+	config, err := b.Services[0].LoadConfig(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	config.RosterHealth = &RosterHealth{
+		Threshold: time.Second,
+		Timeout:   time.Second,
+	}
+	configBuf, err := protobuf.Encode(config)
+	require.NoError(t, err)
+	b.SendInst(nil, Instruction{
+		InstanceID: NewInstanceID(nil),
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "update_config",
+			Args:       Arguments{{Name: "config", Value: configBuf}},
+		},
+	})
+
+	// The client would update its roster to the one of the config, so it
+	// must not wait for the propagation to the stopped node.
+	b.NodeStop(3)
+	b.Client = NewClient(b.Genesis.SkipChainID(), *onet.NewRoster(b.Roster.List[:3]))
+	txArgs := TxArgsDefault
+	txArgs.WaitPropagation = false
+
+	var proposal QueriedInstance
+	for i := 0; ; i++ {
+		require.True(t, i < 60, "no eviction proposed")
+		resp, err := b.Client.QueryInstances(QueryInstances{
+			ContractID: ContractDeferredID,
+		})
+		require.NoError(t, err)
+		if len(resp.Instances) > 0 {
+			proposal = resp.Instances[0]
+			break
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+	var data DeferredData
+	require.NoError(t, protobuf.Decode(proposal.Value, &data))
+
+	// The nodes cannot evict by themselves.
+	node := b.Servers[1].ServerIdentity
+	signer := darc.NewSignerEd25519(node.Public, node.GetPrivate())
+	counters, err := b.Client.GetSignerCounters(signer.Identity().String())
+	require.NoError(t, err)
+	evict := data.ProposedTransaction.Instructions[0]
+	evict.SignerCounter = []uint64{counters.Counters[0] + 1}
+	tx, err := combineInstrsAndSign(signer, evict)
+	require.NoError(t, err)
+	resp, err := b.Services[0].AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   b.Genesis.SkipChainID(),
+		Transaction:   tx,
+		InclusionWait: 10,
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp.Error, "instruction verification failed")
+
+	// The proposal is stored under the ID of the evicted node, and another
+	// node cannot propose it again while it is pending.
+	require.True(t, proposal.InstanceID.Equal(evictProposalID(b.Roster.List[3])))
+	propose := Instruction{
+		InstanceID: NewInstanceID(nil),
+		Invoke: &Invoke{
+			ContractID: ContractConfigID,
+			Command:    "view_change",
+			Args: Arguments{{Name: EvictProposalArgument,
+				Value: evict.Invoke.Args.Search(EvictArgument)}},
+		},
+		SignerCounter: []uint64{counters.Counters[0] + 1},
+	}
+	tx, err = combineInstrsAndSign(signer, propose)
+	require.NoError(t, err)
+	resp, err = b.Services[0].AddTransaction(&AddTxRequest{
+		Version:       CurrentVersion,
+		SkipchainID:   b.Genesis.SkipChainID(),
+		Transaction:   tx,
+		InclusionWait: 10,
+	})
+	require.NoError(t, err)
+	require.Contains(t, resp.Error, "already proposed")
+
+	identity := b.Signer.Identity()
+	identityBuf, err := protobuf.Encode(&identity)
+	require.NoError(t, err)
+	signature, err := b.Signer.Sign(data.InstructionHashes[0])
+	require.NoError(t, err)
+	indexBuf := make([]byte, 4)
+	binary.LittleEndian.PutUint32(indexBuf, 0)
+	b.SendInst(&txArgs, Instruction{
+		InstanceID: proposal.InstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractDeferredID,
+			Command:    "addProof",
+			Args: Arguments{
+				{Name: "identity", Value: identityBuf},
+				{Name: "signature", Value: signature},
+				{Name: "index", Value: indexBuf},
+			},
+		},
+	})
+	b.SendInst(&txArgs, Instruction{
+		InstanceID: proposal.InstanceID,
+		Invoke: &Invoke{
+			ContractID: ContractDeferredID,
+			Command:    "execProposedTx",
+		},
+	})
+
+	config, err = b.Services[0].LoadConfig(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, 3, len(config.Roster.List))
+	i, _ := config.Roster.Search(b.Roster.List[3].ID)
+	require.Equal(t, -1, i)
+
+	// The chain goes on without the evicted node.
+	b.SpawnDummy(nil)
+
+	// The connectivity checks with the stopped node leave broadcast
+	// instances behind.
+	b.Local.Check = onet.CheckNone
+}
@@ -0,0 +1,191 @@
+package byzcoin
+
+import (
+	"bytes"
+	"sort"
+	"sync"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
+)
+
+// schedulerIndex keeps the conditions of the scheduler instances with
+// executions left, so that the leader doesn't need to go through the global
+// state to find the ones that are due. The index of a chain is built in the
+// background when the chain starts, or when its trie is replaced, and is then
+// kept up to date with the state changes of the new blocks.
+type schedulerIndex struct {
+	sync.Mutex
+	chains map[string]*scheduledInstances
+}
+
+type scheduledInstances struct {
+	// st is the trie the index is built from. If the trie of the chain is
+	// replaced, e.g., by a snapshot, the index is built again.
+	st        *stateTrie
+	schedules map[InstanceID]SchedulerData
+	// building is true until the instances of the trie have been read.
+	// The state changes of the blocks added in the meantime are kept in
+	// pending, and applied once the index is built.
+	building bool
+	pending  []StateChanges
+}
+
+func newSchedulerIndex() schedulerIndex {
+	return schedulerIndex{chains: make(map[string]*scheduledInstances)}
+}
+
+// set updates the index with the given value of a scheduler instance. Only
+// the condition is kept.
+func (si *scheduledInstances) set(id InstanceID, value []byte) error {
+	var data SchedulerData
+	err := protobuf.Decode(value, &data)
+	if err != nil {
+		return xerrors.Errorf("decoding scheduler %x: %v", id[:], err)
+	}
+	if data.Remaining == 0 {
+		delete(si.schedules, id)
+		return nil
+	}
+	data.Instructions = nil
+	data.InstructionHashes = nil
+	si.schedules[id] = data
+	return nil
+}
+
+// apply updates the index with the state changes of a block.
+func (si *scheduledInstances) apply(scs StateChanges) error {
+	for _, sc := range scs {
+		if sc.ContractID != ContractSchedulerID {
+			continue
+		}
+		id := NewInstanceID(sc.InstanceID)
+		if sc.StateAction == Remove {
+			delete(si.schedules, id)
+			continue
+		}
+		err := si.set(id, sc.Value)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startBuild registers the index of the chain for the given trie, and
+// returns true if it needs to be built.
+func (idx *schedulerIndex) startBuild(scID skipchain.SkipBlockID,
+	st *stateTrie) bool {
+	idx.Lock()
+	defer idx.Unlock()
+
+	si, ok := idx.chains[string(scID)]
+	if ok && si.st == st {
+		return false
+	}
+	idx.chains[string(scID)] = &scheduledInstances{st: st,
+		schedules: make(map[InstanceID]SchedulerData), building: true}
+	return true
+}
+
+// build reads the scheduler instances of the trie for an index registered
+// by startBuild. The trie is read without holding any lock: the state
+// changes of the blocks added during the read are applied afterwards, which
+// gives the same result since they hold the new values of the instances.
+func (idx *schedulerIndex) build(scID skipchain.SkipBlockID, st *stateTrie) error {
+	read := &scheduledInstances{schedules: make(map[InstanceID]SchedulerData)}
+	err := st.ForEach(func(k, v []byte) error {
+		body, err := decodeStateChangeBody(v)
+		if err != nil {
+			return xerrors.Errorf("decoding body of %x: %v", k, err)
+		}
+		if body.ContractID != ContractSchedulerID {
+			return nil
+		}
+		return read.set(NewInstanceID(k), body.Value)
+	})
+
+	idx.Lock()
+	defer idx.Unlock()
+
+	si, ok := idx.chains[string(scID)]
+	if !ok || si.st != st || !si.building {
+		// The trie has been replaced in the meantime.
+		return nil
+	}
+	if err == nil {
+		for _, scs := range si.pending {
+			err = read.apply(scs)
+			if err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		// The index is built again the next time it is needed.
+		delete(idx.chains, string(scID))
+		return xerrors.Errorf("building index: %v", err)
+	}
+	si.schedules = read.schedules
+	si.building = false
+	si.pending = nil
+	return nil
+}
+
+// update applies the state changes of a new block to the index of the
+// chain, if it has been registered. It must be called with the state
+// changes of every block stored in st.
+func (idx *schedulerIndex) update(scID skipchain.SkipBlockID, st *stateTrie,
+	scs StateChanges) error {
+	idx.Lock()
+	defer idx.Unlock()
+
+	si, ok := idx.chains[string(scID)]
+	if !ok || si.st != st {
+		return nil
+	}
+	if si.building {
+		si.pending = append(si.pending, scs)
+		return nil
+	}
+	err := si.apply(scs)
+	if err != nil {
+		// The index is built again the next time it is needed.
+		delete(idx.chains, string(scID))
+		return err
+	}
+	return nil
+}
+
+// due returns the transactions executing the scheduler instances that are
+// due in a block with the given index and timestamp, ordered by instance
+// ID. It returns false if the index of the trie is not built yet.
+func (idx *schedulerIndex) due(scID skipchain.SkipBlockID, st *stateTrie,
+	index uint64, timestamp int64) ([]ClientTransaction, bool) {
+	idx.Lock()
+	defer idx.Unlock()
+
+	si, ok := idx.chains[string(scID)]
+	if !ok || si.st != st {
+		return nil, false
+	}
+	if si.building {
+		return nil, true
+	}
+
+	var ids []InstanceID
+	for id, data := range si.schedules {
+		if data.Due(index, timestamp) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return bytes.Compare(ids[i][:], ids[j][:]) < 0
+	})
+	txs := make([]ClientTransaction, len(ids))
+	for i, id := range ids {
+		txs[i] = newScheduledExecution(id, si.schedules[id].Executions)
+	}
+	return txs, true
+}
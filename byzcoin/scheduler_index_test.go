@@ -0,0 +1,72 @@
+package byzcoin
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/protobuf"
+)
+
+// Makes sure that the blocks added while the index is built are not missed.
+func TestSchedulerIndex_Build(t *testing.T) {
+	st, err := newMemStateTrie([]byte("nonce"))
+	require.NoError(t, err)
+	scID := skipchain.SkipBlockID("chain")
+
+	schedule := func(id string, remaining uint64) StateChange {
+		buf, err := protobuf.Encode(&SchedulerData{BlockIndex: 2,
+			Remaining: remaining})
+		require.NoError(t, err)
+		return StateChange{StateAction: Update, InstanceID: []byte(id),
+			ContractID: ContractSchedulerID, Value: buf}
+	}
+	ids := func(txs []ClientTransaction) []InstanceID {
+		var res []InstanceID
+		for _, tx := range txs {
+			res = append(res, tx.Instructions[0].InstanceID)
+		}
+		return res
+	}
+
+	scs := StateChanges{schedule("first", 1), schedule("second", 1)}
+	for i := range scs {
+		scs[i].StateAction = Create
+	}
+	require.NoError(t, st.StoreAll(scs, 1, CurrentVersion))
+
+	idx := newSchedulerIndex()
+	_, ok := idx.due(scID, st, 2, 0)
+	require.False(t, ok)
+
+	// A block added during the build is applied once the trie is read.
+	require.True(t, idx.startBuild(scID, st))
+	require.False(t, idx.startBuild(scID, st))
+	scs = StateChanges{schedule("first", 0)}
+	require.NoError(t, st.StoreAll(scs, 2, CurrentVersion))
+	require.NoError(t, idx.update(scID, st, scs))
+	txs, ok := idx.due(scID, st, 2, 0)
+	require.True(t, ok)
+	require.Empty(t, txs)
+
+	require.NoError(t, idx.build(scID, st))
+	txs, ok = idx.due(scID, st, 2, 0)
+	require.True(t, ok)
+	require.Equal(t, []InstanceID{NewInstanceID([]byte("second"))}, ids(txs))
+	txs, _ = idx.due(scID, st, 1, 0)
+	require.Empty(t, txs)
+
+	// The index is kept up to date once built.
+	scs = StateChanges{schedule("third", 1)}
+	scs[0].StateAction = Create
+	require.NoError(t, st.StoreAll(scs, 3, CurrentVersion))
+	require.NoError(t, idx.update(scID, st, scs))
+	txs, _ = idx.due(scID, st, 3, 0)
+	require.Len(t, txs, 2)
+
+	// A new trie needs a new index.
+	st2, err := newMemStateTrie([]byte("nonce"))
+	require.NoError(t, err)
+	_, ok = idx.due(scID, st2, 3, 0)
+	require.False(t, ok)
+}
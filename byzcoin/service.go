@@ -22,6 +22,7 @@ import (
 	"go.dedis.ch/cothority/v3/byzcoin/viewchange"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/cothority/v3/storage"
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/kyber/v3/sign/schnorr"
 	"go.dedis.ch/kyber/v3/suites"
@@ -55,6 +56,12 @@ var catchupFetchBlocks = 100
 // How many DB-entries to download in one go.
 var catchupFetchDBEntries = 10000
 
+// How many instances QueryInstances returns at most in one page.
+var queryInstancesLimit = 1000
+
+// How many keys GetMultiProof accepts at most.
+var multiProofMaxKeys = 1000
+
 const defaultRotationWindow = 10
 
 const noTimeout time.Duration = 0
@@ -97,6 +104,10 @@ func init() {
 	if err != nil {
 		panic(err)
 	}
+	err = RegisterGlobalContract(ContractSchedulerID, contractSchedulerFromBytes)
+	if err != nil {
+		panic(err)
+	}
 }
 
 // GenNonce returns a random nonce.
@@ -141,9 +152,19 @@ type Service struct {
 
 	stateChangeCache stateChangeCache
 
+	// receipts holds the receipts of the transactions of all the chains.
+	receipts storage.DB
+
+	// schedulerIndex is used by the leader to find the scheduled
+	// transactions to add to the next block.
+	schedulerIndex schedulerIndex
+
 	tasks         tasksWG
 	viewChangeMan viewChangeManager
 
+	// rosterHealth tracks the unresponsive nodes of the rosters.
+	rosterHealth *rosterHealth
+
 	streamingMan streamingManager
 
 	updateTrieMutex        sync.Mutex
@@ -153,6 +174,21 @@ type Service struct {
 
 	downloadState downloadState
 
+	// exports holds the state exports being served, by nonce.
+	exports      map[uint64]*stateExport
+	exportsMutex sync.Mutex
+	// imports holds the state imports waiting for their pages or their
+	// genesis block, by nonce.
+	imports      map[uint64]*stateImport
+	importsMutex sync.Mutex
+
+	// snapshotMutex makes sure that only one snapshot is added or
+	// downloaded at a time.
+	snapshotMutex sync.Mutex
+	// trieCopying is locked while a state trie is copied to a snapshot, so
+	// that the tries are only updated once the copy is done.
+	trieCopying sync.RWMutex
+
 	rotationWindow int
 
 	txErrorBuf ringBuf
@@ -187,10 +223,50 @@ type bcStorage struct {
 	// PropTimeout is used when sending the request to integrate a new block
 	// to all nodes.
 	PropTimeout time.Duration
+	// SnapshotInterval is the number of blocks between two snapshots of the
+	// state tries. If it is 0, the one of the NodeConfig is used, if it is
+	// negative, no snapshots are taken.
+	SnapshotInterval int
 
 	sync.Mutex
 }
 
+// NodeConfig holds the options of the ByzCoin service of a conode, which are
+// read from the ByzCoin section of its config.
+type NodeConfig struct {
+	// SnapshotInterval is the number of blocks between two snapshots of the
+	// state tries. If it is 0, defaultSnapshotInterval is used, if it is
+	// negative, no snapshots are taken. It can be overridden with
+	// Service.SetSnapshotInterval.
+	SnapshotInterval int
+	// ParallelWorkers is the number of go-routines executing the
+	// transactions of a block. If it is 1 or less, they are executed one
+	// after the other. Executing them in parallel only gives the same
+	// result if all the contracts read the state through the
+	// ReadOnlyStateTrie they are given, so it is off by default.
+	ParallelWorkers int
+}
+
+var nodeConfig = struct {
+	NodeConfig
+	sync.Mutex
+}{}
+
+// SetNodeConfig sets the options of the ByzCoin service. The conode calls it
+// before starting its services.
+func SetNodeConfig(c NodeConfig) {
+	nodeConfig.Lock()
+	nodeConfig.NodeConfig = c
+	nodeConfig.Unlock()
+}
+
+// GetNodeConfig returns the options set with SetNodeConfig.
+func GetNodeConfig() NodeConfig {
+	nodeConfig.Lock()
+	defer nodeConfig.Unlock()
+	return nodeConfig.NodeConfig
+}
+
 // GetProtocolVersion returns the version of the Byzcoin protocol for the current
 // conode.
 func (s *Service) GetProtocolVersion() Version {
@@ -594,6 +670,120 @@ func (s *Service) GetProof(req *GetProof) (*GetProofResponse, error) {
 	}, nil
 }
 
+// GetMultiProof searches for the keys and returns a proof of the presence or
+// the absence of all of them.
+func (s *Service) GetMultiProof(req *GetMultiProof) (*GetMultiProofResponse, error) {
+	if req.Version != CurrentVersion {
+		return nil, xerrors.New("version mismatch")
+	}
+	if len(req.Keys) == 0 {
+		return nil, xerrors.New("no keys given")
+	}
+	if len(req.Keys) > multiProofMaxKeys {
+		return nil, xerrors.Errorf("too many keys: %d > %d", len(req.Keys),
+			multiProofMaxKeys)
+	}
+
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+
+	sb := s.db().GetByID(req.ID)
+	if sb == nil {
+		return nil, xerrors.New("cannot find skipblock while getting proof")
+	}
+	st, err := s.getStateTrie(sb.SkipChainID())
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+	proof, err := newMultiProof(st, s.db(), req.ID, req.Keys)
+	if err != nil {
+		return nil, xerrors.Errorf("making proof: %v", err)
+	}
+	log.Lvlf2("%s: Returning proof for %d keys from chain %x", s.ServerIdentity(),
+		len(req.Keys), sb.SkipChainID())
+	return &GetMultiProofResponse{
+		Version: CurrentVersion,
+		Proof:   *proof,
+	}, nil
+}
+
+// SimulateTransaction runs the given transaction on a copy of the current
+// state of the chain and returns the resulting state changes, without
+// proposing a new block.
+func (s *Service) SimulateTransaction(req *SimulateTransaction) (*SimulateTransactionResponse, error) {
+	if !s.tasks.add(1) {
+		return nil, xerrors.New("node is closed")
+	}
+	defer s.tasks.done()
+
+	if len(req.Transaction.Instructions) == 0 {
+		return nil, xerrors.New("no instructions to simulate")
+	}
+
+	st, err := s.getStateTrie(req.SkipchainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+	latest, err := s.db().GetLatestByID(req.SkipchainID)
+	if err != nil {
+		return nil, xerrors.Errorf("couldn't get latest block: %v", err)
+	}
+	header, err := decodeBlockHeader(latest)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+
+	tx := req.Transaction.Clone()
+	tx.Instructions.SetVersion(header.Version)
+
+	trace := &txTrace{}
+	scs, _, err := s.traceOneTx(st.MakeStagingStateTrie(), tx,
+		req.SkipchainID, header.Timestamp, trace)
+	resp := &SimulateTransactionResponse{
+		Version:      CurrentVersion,
+		Accepted:     err == nil,
+		Instructions: trace.instructions,
+		StateChanges: scs,
+		Coins:        trace.coins,
+		Fee:          trace.fee,
+	}
+	if err != nil {
+		resp.Error = err.Error()
+	}
+	log.Lvlf2("%s: simulated transaction on chain %x: accepted=%t",
+		s.ServerIdentity(), req.SkipchainID, resp.Accepted)
+	return resp, nil
+}
+
+// GetMempool returns the transactions waiting in the mempool of the leader.
+// If this node is not the leader, the request is forwarded to it.
+func (s *Service) GetMempool(req *GetMempool) (*GetMempoolResponse, error) {
+	if !s.tasks.add(1) {
+		return nil, xerrors.New("node is closed")
+	}
+	defer s.tasks.done()
+
+	leader, err := s.getLeader(req.SkipchainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting leader: %v", err)
+	}
+	if !s.ServerIdentity().Equal(leader) {
+		leaderRoster := onet.NewRoster([]*network.ServerIdentity{leader})
+		return NewClient(req.SkipchainID, *leaderRoster).GetMempool()
+	}
+
+	s.txPipelinesMutex.Lock()
+	txp, ok := s.txPipeline[string(req.SkipchainID)]
+	s.txPipelinesMutex.Unlock()
+	if !ok {
+		return nil, xerrors.New("this pipeline is not available")
+	}
+	return &GetMempoolResponse{
+		Version:      CurrentVersion,
+		Transactions: txp.mempool.entries(),
+	}, nil
+}
+
 // CheckAuthorization verifies whether a given combination of identities can
 // fulfill a given rule of a given darc. Because all darcs are now used in
 // an online fashion, we need to offer this check.
@@ -696,9 +886,16 @@ func (s *Service) GetUpdates(pr *GetUpdatesRequest) (*GetUpdatesReply, error) {
 
 	sendVersion0 := pr.Flags&GUFSendVersion0 > 0
 	reply := &GetUpdatesReply{}
-	reply.Latest, err = s.db().GetLatestByID(scID)
+	// The links start at the block known to the client, if possible, so that
+	// it can verify the latest block.
+	from := scID
+	if sb := s.db().GetByID(pr.LatestBlockID); sb != nil &&
+		sb.SkipChainID().Equal(scID) {
+		from = sb.Hash
+	}
+	reply.Links, reply.Latest, err = newProofLinks(s.db(), from, st.GetIndex())
 	if err != nil {
-		return nil, xerrors.Errorf("couldn't get latest block: %v", err)
+		return nil, xerrors.Errorf("couldn't get links to latest block: %v", err)
 	}
 	for _, idv := range pr.Instances {
 		proof, err := st.GetProof(idv.ID[:])
@@ -756,24 +953,35 @@ func (s *Service) DownloadState(req *DownloadState) (resp *DownloadStateResponse
 		go func(ds downloadState) {
 			idStr := fmt.Sprintf("%x", ds.id)
 			db, bucketName := s.GetAdditionalBucket([]byte(idStr))
-			err := db.View(func(tx *bbolt.Tx) error {
-				bucket := tx.Bucket(bucketName)
-				total <- bucket.Stats().KeyN
-				return bucket.ForEach(func(k []byte, v []byte) error {
-					key := make([]byte, len(k))
-					copy(key, k)
-					value := make([]byte, len(v))
-					copy(value, v)
-					select {
-					case ds.read <- DBKeyValue{key, value}:
-					case <-ds.stop:
-						return xerrors.New("closed")
-					case <-time.After(time.Minute):
-						return xerrors.New("timed out while waiting for next read")
-					}
-					return nil
+			err := func() error {
+				store, err := storage.Open(db, bucketName)
+				if err != nil {
+					total <- 0
+					return err
+				}
+				stats, err := storage.GetStats(store)
+				if err != nil {
+					total <- 0
+					return err
+				}
+				total <- stats.Keys
+				return store.View(func(bucket storage.Bucket) error {
+					return bucket.ForEach(func(k []byte, v []byte) error {
+						key := make([]byte, len(k))
+						copy(key, k)
+						value := make([]byte, len(v))
+						copy(value, v)
+						select {
+						case ds.read <- DBKeyValue{key, value}:
+						case <-ds.stop:
+							return xerrors.New("closed")
+						case <-time.After(time.Minute):
+							return xerrors.New("timed out while waiting for next read")
+						}
+						return nil
+					})
 				})
-			})
+			}()
 			if err != nil {
 				log.Error("while serving current database:", err)
 			}
@@ -882,6 +1090,176 @@ func (s *Service) CheckStateChangeValidity(req *CheckStateChangeValidity) (*Chec
 	}, nil
 }
 
+// GetProofAt returns the last state change of an instance applied at or
+// before the given block, with the material to verify that the instance
+// didn't change until this block. It relies on the history of the state
+// changes, so it fails if the history of the instance has been cleaned up.
+func (s *Service) GetProofAt(req *GetProofAt) (*GetProofAtResponse, error) {
+	if req.Version != CurrentVersion {
+		return nil, xerrors.New("version mismatch")
+	}
+	// The trie and the history of the state changes are updated together.
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+
+	st, err := s.getStateTrie(req.SkipChainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+	if req.BlockIndex < 0 || req.BlockIndex > st.GetIndex() {
+		return nil, xerrors.New("unknown block index")
+	}
+	sces, err := s.stateChangeStorage.getAll(req.Key, req.SkipChainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state changes: %v", err)
+	}
+
+	// The entries are sorted by version, which starts over when an instance
+	// is spawned again, so the order in the chain is searched for.
+	before := func(a, b StateChangeEntry) bool {
+		if a.BlockIndex != b.BlockIndex {
+			return a.BlockIndex < b.BlockIndex
+		}
+		return a.TxIndex < b.TxIndex
+	}
+	var prev, next *StateChangeEntry
+	for i := range sces {
+		sce := &sces[i]
+		if sce.BlockIndex <= req.BlockIndex {
+			if prev == nil || before(*prev, *sce) {
+				prev = sce
+			}
+		} else if next == nil || before(*sce, *next) {
+			next = sce
+		}
+	}
+	if prev == nil {
+		return nil, xerrors.New("no state change of this instance found " +
+			"before the block")
+	}
+
+	resp := &GetProofAtResponse{}
+	change, err := s.stateChangeProof(req.SkipChainID, *prev)
+	if err != nil {
+		return nil, xerrors.Errorf("proving state change: %v", err)
+	}
+	resp.Change = *change
+	if next != nil {
+		resp.Next, err = s.stateChangeProof(req.SkipChainID, *next)
+		if err != nil {
+			return nil, xerrors.Errorf("proving next state change: %v", err)
+		}
+	} else {
+		resp.Latest, err = NewProof(st, s.db(), req.SkipChainID, req.Key)
+		if err != nil {
+			return nil, xerrors.Errorf("making proof: %v", err)
+		}
+	}
+	return resp, nil
+}
+
+// stateChangeProof returns all the state changes of the block of the entry,
+// together with the block and the forward links from the genesis block.
+func (s *Service) stateChangeProof(scID skipchain.SkipBlockID,
+	sce StateChangeEntry) (*StateChangeProof, error) {
+	sces, err := s.stateChangeStorage.getByBlock(scID, sce.BlockIndex)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state changes: %v", err)
+	}
+	p := &StateChangeProof{
+		Position:     -1,
+		StateChanges: make([]StateChange, len(sces)),
+	}
+	for i, e := range sces {
+		p.StateChanges[i] = e.StateChange
+		if e.TxIndex == sce.TxIndex {
+			p.Position = i
+		}
+	}
+	if p.Position < 0 {
+		return nil, xerrors.New("state change not found in its block")
+	}
+
+	links, sb, err := newProofLinks(s.db(), scID, sce.BlockIndex)
+	if err != nil {
+		return nil, xerrors.Errorf("getting links: %v", err)
+	}
+	p.Links = links
+	p.Block = *sb
+	return p, nil
+}
+
+// errPageFull stops the iteration over the trie once a page is full.
+var errPageFull = xerrors.New("page is full")
+
+// QueryInstances returns a page of the instances of the global state that
+// match the filters of the request. As the whole trie might need to be
+// scanned, pages are limited in size and not in the number of instances
+// visited.
+func (s *Service) QueryInstances(req *QueryInstances) (*QueryInstancesResponse, error) {
+	if req.Version != CurrentVersion {
+		return nil, xerrors.New("version mismatch")
+	}
+	limit := req.Limit
+	if limit <= 0 || limit > queryInstancesLimit {
+		limit = queryInstancesLimit
+	}
+	var cursor []byte
+	if len(req.Cursor) > 0 {
+		cursor = req.Cursor
+	}
+
+	s.updateTrieMutex.Lock()
+	defer s.updateTrieMutex.Unlock()
+
+	st, err := s.getStateTrie(req.SkipChainID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+	resp := &QueryInstancesResponse{Index: st.GetIndex()}
+	var keys [][]byte
+	err = st.ForEachAfter(cursor, func(k, v []byte) error {
+		if len(req.Prefix) > 0 && !bytes.HasPrefix(k, req.Prefix) {
+			return nil
+		}
+		body, err := decodeStateChangeBody(v)
+		if err != nil {
+			return xerrors.Errorf("decoding body of %x: %v", k, err)
+		}
+		if req.ContractID != "" && body.ContractID != req.ContractID {
+			return nil
+		}
+		if len(req.DarcID) > 0 && !req.DarcID.Equal(body.DarcID) {
+			return nil
+		}
+		if len(resp.Instances) == limit {
+			resp.Cursor = keys[len(keys)-1]
+			return errPageFull
+		}
+		// The buffers are only valid during the iteration.
+		keys = append(keys, append([]byte{}, k...))
+		resp.Instances = append(resp.Instances, QueriedInstance{
+			InstanceID: NewInstanceID(k),
+			ContractID: body.ContractID,
+			Value:      append([]byte{}, body.Value...),
+			Version:    body.Version,
+			DarcID:     append(darc.ID{}, body.DarcID...),
+		})
+		return nil
+	})
+	if err != nil && !xerrors.Is(err, errPageFull) {
+		return nil, xerrors.Errorf("iterating over the trie: %v", err)
+	}
+
+	if req.WithProof {
+		resp.Proof, err = newMultiProof(st, s.db(), req.SkipChainID, keys)
+		if err != nil {
+			return nil, xerrors.Errorf("making proof: %v", err)
+		}
+	}
+	return resp, nil
+}
+
 // ResolveInstanceID resolves the instance ID using the given request. The name
 // must be already set by calling the naming contract.
 func (s *Service) ResolveInstanceID(req *ResolveInstanceID) (*ResolvedInstanceID, error) {
@@ -1023,9 +1401,7 @@ func (s *Service) DebugRemove(req *DebugRemoveRequest) (*DebugResponse, error) {
 		if db == nil {
 			return nil, xerrors.New("didn't find trie for this byzcoin-ID")
 		}
-		err := db.Update(func(tx *bbolt.Tx) error {
-			return tx.DeleteBucket(bn)
-		})
+		err := storage.DeleteBucket(db, bn)
 		if err != nil {
 			return nil, xerrors.Errorf("deleting bucket: %v", err)
 		}
@@ -1087,11 +1463,7 @@ func (s *Service) createNewBlock(scID skipchain.SkipBlockID, r *onet.Roster, tx
 		// We have to register the verification functions in the genesis block
 		sb.VerifierIDs = []skipchain.VerifierID{skipchain.VerifyBase, Verify}
 
-		nonce, err := loadNonceFromTxs(tx)
-		if err != nil {
-			return nil, xerrors.Errorf("getting nonce: %v", err)
-		}
-		et, err := newMemStagingStateTrie(nonce)
+		et, err := s.newGenesisStagingTrie(tx)
 		if err != nil {
 			return nil, xerrors.Errorf("making trie: %v", err)
 		}
@@ -1273,9 +1645,7 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock, node int) error {
 		if err == nil {
 			// Suppose we _do_ have a statetrie
 			db, stBucket := s.GetAdditionalBucket(sb.SkipChainID())
-			err := db.Update(func(tx *bbolt.Tx) error {
-				return cothority.ErrorOrNil(tx.DeleteBucket(stBucket), "deleting bucket")
-			})
+			err := cothority.ErrorOrNil(storage.DeleteBucket(db, stBucket), "deleting bucket")
 			if err != nil {
 				return xerrors.Errorf("Cannot delete existing trie while trying to download: %v", err)
 			}
@@ -1292,6 +1662,7 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock, node int) error {
 		}
 		var db *bbolt.DB
 		var bucketName []byte
+		var store storage.DB
 		var nonce uint64
 		var cursor int
 		for {
@@ -1306,11 +1677,14 @@ func (s *Service) downloadDB(sb *skipchain.SkipBlock, node int) error {
 			cursor += len(resp.KeyValues)
 			if db == nil {
 				db, bucketName = s.GetAdditionalBucket([]byte(idStr))
+				store, err = storage.Open(db, bucketName)
+				if err != nil {
+					return xerrors.Errorf("couldn't open trie: %v", err)
+				}
 				nonce = resp.Nonce
 			}
 			// And store all entries in our local database.
-			err = db.Update(func(tx *bbolt.Tx) error {
-				bucket := tx.Bucket(bucketName)
+			err = store.Update(func(bucket storage.Bucket) error {
 				for _, kv := range resp.KeyValues {
 					err := bucket.Put(kv.Key, kv.Value)
 					if err != nil {
@@ -1516,8 +1890,10 @@ func (s *Service) catchupFromID(r *onet.Roster, scID skipchain.SkipBlockID, sbID
 
 // catchUp takes a skipblock as reference for the roster, the current index,
 // and the skipchainID to download either new blocks if it's less than
-// `catchupDownloadAll` behind, or calls downloadDB to start the download of
-// the full DB over the network.
+// `catchupDownloadAll` behind, or to bootstrap from the latest snapshot of
+// the other nodes and replay the blocks from there. If no snapshot can be
+// used, it calls downloadDB to start the download of the full DB over the
+// network.
 func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 	if !s.catchingUpWG.start() {
 		log.Lvlf2("Already in progress of catching up %x", sb.SkipChainID()[:])
@@ -1550,18 +1926,21 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 				log.Error(s.ServerIdentity(), "could not unmarshal body for genesis block", err)
 				return
 			}
-			nonce, err := loadNonceFromTxs(body.TxResults)
-			if err != nil {
-				log.Error(s.ServerIdentity(), "couldn't load nonce:", err)
-				return
-			}
-			// We don't care about the state trie that is returned in this
-			// function because we load the trie again in getStateTrie
-			// right afterwards.
-			st, err = s.createStateTrie(sb.SkipChainID(), nonce)
-			if err != nil {
-				log.Errorf("could not create trie: %+v", err)
-				return
+			if _, err := s.genesisImport(body.TxResults); err != nil {
+				// The imported state of the genesis block is only
+				// held by the nodes that imported it.
+				log.Warnf("%s: %v, will download the state",
+					s.ServerIdentity(), err)
+				download = true
+			} else {
+				// We don't care about the state trie that is returned in this
+				// function because we load the trie again in getStateTrie
+				// right afterwards.
+				st, err = s.createGenesisStateTrie(sb.SkipChainID(), body.TxResults)
+				if err != nil {
+					log.Errorf("could not create trie: %+v", err)
+					return
+				}
 			}
 		} else {
 			download = true
@@ -1571,6 +1950,24 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 	}
 
 	// Check if we are updating the right index.
+	var latest *skipchain.SkipBlock
+	if download {
+		// Prefer the verified snapshots, the remaining blocks are then
+		// replayed.
+		minIndex := -1
+		if st != nil {
+			minIndex = st.GetIndex()
+		}
+		latest, st, err = s.bootstrapFromSnapshot(sb, minIndex)
+		if err != nil {
+			log.Warnf("%s: couldn't bootstrap from snapshot: %v",
+				s.ServerIdentity(), err)
+		} else {
+			download = false
+			// The blocks up to the snapshot have not been applied.
+			s.rebuildReceiptsInBackground(sb.SkipChainID())
+		}
+	}
 	if download {
 		for i := range sb.Roster.List {
 			log.Lvl2(s.ServerIdentity(), "Downloading whole DB for catching up")
@@ -1590,28 +1987,30 @@ func (s *Service) catchUp(sb *skipchain.SkipBlock) {
 
 	// Get the latest block known and processed by the conode
 	trieIndex := st.GetIndex()
-	var reply *skipchain.GetSingleBlockByIndexReply
-	for trieIndex >= 0 {
-		reply, err = s.skService().GetSingleBlockByIndex(&skipchain.GetSingleBlockByIndex{
-			Genesis: sb.SkipChainID(),
-			Index:   trieIndex,
-		})
-		if err != nil {
-			trieIndex--
-			log.Errorf("%v cannot catch up from block %v: %v, retrying with block before", s.ServerIdentity(), trieIndex, err)
-		} else {
-			// Got it, exit loop.
-			break
+	if latest == nil {
+		var reply *skipchain.GetSingleBlockByIndexReply
+		for trieIndex >= 0 {
+			reply, err = s.skService().GetSingleBlockByIndex(&skipchain.GetSingleBlockByIndex{
+				Genesis: sb.SkipChainID(),
+				Index:   trieIndex,
+			})
+			if err != nil {
+				trieIndex--
+				log.Errorf("%v cannot catch up from block %v: %v, retrying with block before", s.ServerIdentity(), trieIndex, err)
+			} else {
+				// Got it, exit loop.
+				break
+			}
 		}
-	}
 
-	// All the trieIndex failed and we did not get a reply.
-	if reply == nil || err != nil {
-		log.Errorf("%v could not catch up, tried all previous blocks", s.ServerIdentity())
-		return
-	}
+		// All the trieIndex failed and we did not get a reply.
+		if reply == nil || err != nil {
+			log.Errorf("%v could not catch up, tried all previous blocks", s.ServerIdentity())
+			return
+		}
 
-	latest := reply.SkipBlock
+		latest = reply.SkipBlock
+	}
 
 	// Fetch all missing blocks to fill the hole
 	for trieIndex < sb.Index {
@@ -1684,14 +2083,10 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 			log.Error(s.ServerIdentity(), "could not unmarshal body for genesis block", err)
 			return xerrors.New("couldn't unmarshal body for genesis block")
 		}
-		nonce, err := loadNonceFromTxs(body.TxResults)
-		if err != nil {
-			return xerrors.Errorf("getting nonce: %v", err)
-		}
 		// We don't care about the state trie that is returned in this
 		// function because we load the trie again in getStateTrie
 		// right afterwards.
-		_, err = s.createStateTrie(sb.SkipChainID(), nonce)
+		_, err = s.createGenesisStateTrie(sb.SkipChainID(), body.TxResults)
 		if err != nil {
 			return xerrors.Errorf("could not create trie: %v", err)
 		}
@@ -1738,12 +2133,15 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	}
 
 	log.Lvlf2("%s Updating %d transactions for %x on index %v", s.ServerIdentity(), len(body.TxResults), sb.SkipChainID(), sb.Index)
-	_, _, scs, _ := s.createStateChanges(st.MakeStagingStateTrie(), sb.SkipChainID(), body.TxResults, noTimeout, header.Version, header.Timestamp)
+	_, txOut, scs, _ := s.createStateChanges(st.MakeStagingStateTrie(), sb.SkipChainID(), body.TxResults, noTimeout, header.Version, header.Timestamp)
 
 	log.Lvlf3("%s Storing index %d with %d state changes %v",
 		s.ServerIdentity(), sb.Index, len(scs), scs.ShortStrings())
 	// Update our global state using all state changes.
-	if err = st.VerifiedStoreAll(scs, sb.Index, header.Version, header.TrieRoot); err != nil {
+	s.trieCopying.RLock()
+	err = st.VerifiedStoreAll(scs, sb.Index, header.Version, header.TrieRoot)
+	s.trieCopying.RUnlock()
+	if err != nil {
 		return xerrors.Errorf("storing state changes: %v", err)
 	}
 
@@ -1754,6 +2152,20 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 			"mean that the db is broken.")
 	}
 
+	err = s.schedulerIndex.update(sb.SkipChainID(), st, scs)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't update the scheduler index:", err)
+	}
+
+	err = s.storeReceipts(sb, txOut)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't store the receipts:", err)
+	}
+
+	if interval := s.snapshotInterval(); interval > 0 && sb.Index%interval == 0 {
+		s.startSnapshot(sb)
+	}
+
 	// If we are adding a genesis block, then look into it for the darc ID
 	// and add it to the darcToSc hash map.
 	if sb.Index == 0 {
@@ -1808,6 +2220,7 @@ func (s *Service) updateTrieCallback(sbID skipchain.SkipBlockID) error {
 	if nodeInNew && !catchingUp {
 		// If it is a view-change transaction, confirm it's done
 		view := isViewChangeTx(body.TxResults)
+		s.updateRosterHealth(sb, view)
 
 		if s.viewChangeMan.started(sb.SkipChainID()) && view != nil {
 			s.viewChangeMan.done(*view)
@@ -1855,6 +2268,10 @@ func isViewChangeTx(txs TxResults) *viewchange.View {
 	if invoke.Command != "view_change" {
 		return nil
 	}
+	if invoke.Args.Search("newview") == nil {
+		// a handover is not a view-change
+		return nil
+	}
 	var req viewchange.NewViewReq
 	if err := protobuf.Decode(invoke.Args.Search("newview"), &req); err != nil {
 		log.Error("failed to decode new-view req")
@@ -2093,12 +2510,7 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 	// compute the Merkle root.
 	var sst *stagingStateTrie
 	if newSB.Index == 0 {
-		nonce, err := loadNonceFromTxs(body.TxResults)
-		if err != nil {
-			log.Error(s.ServerIdentity(), err)
-			return false
-		}
-		sst, err = newMemStagingStateTrie(nonce)
+		sst, err = s.newGenesisStagingTrie(body.TxResults)
 		if err != nil {
 			log.Error(s.ServerIdentity(), err)
 			return false
@@ -2133,6 +2545,10 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 			log.Lvl2(s.ServerIdentity(), "Client Transaction accept mismatch on tx", i)
 			return false
 		}
+		if !sameEvents(txOut[i].Events, body.TxResults[i].Events) {
+			log.Lvl2(s.ServerIdentity(), "Events mismatch on tx", i)
+			return false
+		}
 	}
 
 	// Check that the hashes in DataHeader are right.
@@ -2150,6 +2566,16 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 		return false
 	}
 
+	// The config before the block tells whether the leader must change.
+	var prevConfig *ChainConfig
+	if newSB.Index > 0 {
+		prevConfig, err = sst.LoadConfig()
+		if err != nil {
+			log.Error(s.ServerIdentity(), err)
+			return false
+		}
+	}
+
 	// Compute the new state and check whether the roster in newSB matches
 	// the config.
 	if err := sst.StoreAll(scs); err != nil {
@@ -2167,6 +2593,11 @@ func (s *Service) verifySkipBlock(newID []byte, newSB *skipchain.SkipBlock) bool
 			log.Error("Didn't accept the new roster:", err)
 			return false
 		}
+		if prevConfig.handoverDue(newSB.Index) &&
+			config.Roster.List[0].Equal(prevConfig.Roster.List[0]) {
+			log.Error(s.ServerIdentity(), "the epoch of the leader is over")
+			return false
+		}
 		previous := s.db().GetByID(newSB.BackLinkIDs[0])
 		if previous != nil {
 			var prevHeader DataHeader
@@ -2228,7 +2659,8 @@ func txSize(txr ...TxResult) (out int) {
 //
 // If timeout is not 0, createStateChanges will stop running instructions after
 // that long, in order for the caller to determine how many instructions fit in
-// a block interval.
+// a block interval. Without a timeout, the transactions are executed in
+// parallel, see processTxsParallel.
 //
 // State caching is implemented here, which is critical to performance, because
 // on the leader it reduces the number of contract executions by 1/3 and on
@@ -2258,6 +2690,20 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 
 	sstTemp = sst.Clone()
 
+	// Without a timeout all transactions are executed, so they can be run
+	// in parallel.
+	workers := GetNodeConfig().ParallelWorkers
+	if timeout == noTimeout && workers > 1 && len(txIn) > 1 {
+		txOut, states, sstTemp = s.processTxsParallel(sstTemp, scID, txIn,
+			timestamp, workers)
+		txOut.SetVersion(version)
+		merkleRoot = sstTemp.GetRoot()
+		if len(states) != 0 && len(txOut) != 0 {
+			s.stateChangeCache.update(scID, txOut.Hash(), merkleRoot, txOut, states)
+		}
+		return
+	}
+
 	for _, tx := range txIn {
 		txsz := txSize(tx)
 
@@ -2266,6 +2712,9 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 		statesTemp, sstTempC, err = s.processOneTx(sstTemp, tx.ClientTransaction, scID, timestamp)
 		if err != nil {
 			tx.Accepted = false
+			statesTemp, sstTemp = chargeRefusedTx(sstTemp, tx.ClientTransaction)
+			tx.setStateChanges(statesTemp)
+			states = append(states, tx.stateChanges...)
 			txOut = append(txOut, tx)
 			log.Warnf("%s: %+v", s.ServerIdentity(), err)
 		} else {
@@ -2299,9 +2748,10 @@ func (s *Service) createStateChanges(sst *stagingStateTrie, scID skipchain.SkipB
 			}
 
 			tx.Accepted = true
+			tx.setStateChanges(statesTemp)
 			sstTemp = sstTempC
 			blocksz += txsz
-			states = append(states, statesTemp...)
+			states = append(states, tx.stateChanges...)
 			txOut = append(txOut, tx)
 		}
 	}
@@ -2337,6 +2787,41 @@ func ComputeSeed(instr Instruction, index uint8) []byte {
 // from the trie should be read from sst and not the service.
 func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 	scID skipchain.SkipBlockID, timestamp int64) (StateChanges, *stagingStateTrie, error) {
+	return s.traceOneTx(sst, tx, scID, timestamp, nil)
+}
+
+// txTrace records the outcome of every instruction of a transaction. It is
+// used when simulating a transaction.
+type txTrace struct {
+	instructions []SimulatedInstruction
+	coins        []Coin
+	fee          uint64
+}
+
+// fail stores the error in the last executed instruction.
+func (t *txTrace) fail(err error) {
+	if len(t.instructions) > 0 {
+		t.instructions[len(t.instructions)-1].Error = err.Error()
+	}
+}
+
+// txFailed records the error of a transaction. If a trace is given, the error
+// is stored in the trace instead of the txErrorBuf, so that simulated
+// transactions don't interfere with the real ones.
+func (s *Service) txFailed(tx ClientTransaction, trace *txTrace, err error) error {
+	if trace != nil {
+		trace.fail(err)
+	} else {
+		s.addError(tx, err)
+	}
+	return err
+}
+
+// traceOneTx is like processOneTx, but if trace is non-nil, it records the
+// result of every instruction in it.
+func (s *Service) traceOneTx(sst *stagingStateTrie, tx ClientTransaction,
+	scID skipchain.SkipBlockID, timestamp int64, trace *txTrace) (StateChanges,
+	*stagingStateTrie, error) {
 
 	// Make a new trie for each instruction. If the instruction is
 	// sucessfully implemented and changes applied, then keep it
@@ -2347,6 +2832,14 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 	roSC := newROSkipChain(s.skService(), scID)
 	gs := globalState{sst, roSC, &currentBlockInfo{timestamp}}
 
+	// The configuration doesn't exist yet when the genesis block is
+	// created, so there are no fees to pay.
+	var fees *FeeConfig
+	if config, err := sst.LoadConfig(); err == nil {
+		fees = config.FeeConfig
+	}
+	var fee Coin
+
 	h := tx.Instructions.Hash()
 	var statesTemp StateChanges
 	var cin []Coin
@@ -2355,6 +2848,14 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 		log.Lvlf2("Processing instruction: %v", instr.Action())
 
 		scs, cout, err := s.executeInstruction(gs, cin, instr, h)
+		if trace != nil {
+			trace.instructions = append(trace.instructions,
+				SimulatedInstruction{
+					InstructionHash: instr.Hash(),
+					StateChanges:    append(StateChanges{}, scs...),
+					Coins:           cout,
+				})
+		}
 		if err != nil {
 			_, _, cid, _, err2 := sst.GetValues(instr.InstanceID.Slice())
 			if err2 != nil {
@@ -2362,16 +2863,27 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 			}
 			err = xerrors.Errorf("%s Contract %s got %x and returned error: %v",
 				s.ServerIdentity(), cid, instr.Hash(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			return nil, nil, s.txFailed(tx, trace, err)
+		}
+
+		if fees != nil {
+			var cost uint64
+			cost, err = fees.instructionCost(instr, scs)
+			if err == nil {
+				err = fee.SafeAdd(cost)
+			}
+			if err != nil {
+				err = xerrors.Errorf("%s couldn't compute fee: %v",
+					s.ServerIdentity(), err)
+				return nil, nil, s.txFailed(tx, trace, err)
+			}
 		}
 
 		counterScs, err := incrementSignerCounters(sst, instr.SignerIdentities)
 		if err != nil {
 			err = xerrors.Errorf("%s failed to update signature counters: %v",
 				s.ServerIdentity(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			return nil, nil, s.txFailed(tx, trace, err)
 		}
 
 		// Counter used in the seed provided to generated Spawn instructions.
@@ -2409,23 +2921,35 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 					err = xerrors.Errorf("%s couldn't get contractID from the "+
 						"following instruction: %x (with instanceID %x)",
 						s.ServerIdentity(), instr.Hash(), instr.InstanceID.Slice())
-					s.addError(tx, err)
-					return nil, nil, err
+					return nil, nil, s.txFailed(tx, trace, err)
 				}
 				err = xerrors.Errorf("%s: contract %s %s %x", s.ServerIdentity(),
 					contractID, reason, sc.InstanceID)
-				s.addError(tx, err)
-				return nil, nil, err
+				return nil, nil, s.txFailed(tx, trace, err)
 			}
 			log.Lvlf2("StateChange %s for id %x - contract: %s", sc.StateAction,
 				sc.InstanceID, sc.ContractID)
 
+			if sc.StateAction == EmitEvent {
+				err = protobuf.Decode(sc.Value, &Event{})
+				if err != nil {
+					return nil, nil, s.txFailed(tx, trace, xerrors.Errorf(
+						"failed to decode event: %v", err))
+				}
+				continue
+			}
+
 			if sc.StateAction == GenerateInstruction {
 				var newInstr Instruction
 				err = protobuf.Decode(sc.Value, &newInstr)
 				if err != nil {
-					return nil, nil, xerrors.Errorf("failed to decode "+
-						"new instruction: %v", err)
+					err = xerrors.Errorf("failed to decode new instruction: %v", err)
+					// This error has never been stored in the txErrorBuf,
+					// only simulations record it.
+					if trace != nil {
+						trace.fail(err)
+					}
+					return nil, nil, err
 				}
 
 				newInstr.synthetic = true
@@ -2451,8 +2975,7 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 			err = sst.StoreAll(StateChanges{sc})
 			if err != nil {
 				err = xerrors.Errorf("%s StoreAll failed: %v", s.ServerIdentity(), err)
-				s.addError(tx, err)
-				return nil, nil, err
+				return nil, nil, s.txFailed(tx, trace, err)
 			}
 		}
 
@@ -2466,8 +2989,7 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 		if err = sst.StoreAll(counterScs); err != nil {
 			err = xerrors.Errorf("%s StoreAll failed to add counter changes: %v",
 				s.ServerIdentity(), err)
-			s.addError(tx, err)
-			return nil, nil, err
+			return nil, nil, s.txFailed(tx, trace, err)
 		}
 
 		statesTemp = append(statesTemp, scs...)
@@ -2478,6 +3000,38 @@ func (s *Service) processOneTx(sst *stagingStateTrie, tx ClientTransaction,
 		log.Lvl2(s.ServerIdentity(), "Leftover coins detected, discarding.")
 	}
 
+	// The whole fee is only charged once all instructions succeeded.
+	// Refused transactions are charged by chargeRefusedTx.
+	if fees != nil {
+		tip, err := tx.FeeTip()
+		if err == nil {
+			err = fee.SafeAdd(tip)
+		}
+		if err != nil {
+			err = xerrors.Errorf("%s couldn't add fee tip: %v",
+				s.ServerIdentity(), err)
+			return nil, nil, s.txFailed(tx, trace, err)
+		}
+	}
+	if fee.Value > 0 {
+		feeScs, err := chargeFees(sst, tx, h, fees, fee.Value)
+		if err != nil {
+			err = xerrors.Errorf("%s couldn't charge fee: %v",
+				s.ServerIdentity(), err)
+			return nil, nil, s.txFailed(tx, trace, err)
+		}
+		if err = sst.StoreAll(feeScs); err != nil {
+			err = xerrors.Errorf("%s StoreAll failed to add fee changes: %v",
+				s.ServerIdentity(), err)
+			return nil, nil, s.txFailed(tx, trace, err)
+		}
+		statesTemp = append(statesTemp, feeScs...)
+	}
+	if trace != nil {
+		trace.coins = cin
+		trace.fee = fee.Value
+	}
+
 	return statesTemp, sst, nil
 }
 
@@ -2594,6 +3148,10 @@ func (s *Service) executeInstruction(gs GlobalState, cin []Coin,
 			log.Errorf("Found unknown contract ID \"%s\"", sc.ContractID)
 			return nil, nil, xerrors.New("unknown contract ID")
 		}
+		// Events don't change the instance, so they keep the version 0.
+		if sc.StateAction == EmitEvent {
+			continue
+		}
 
 		ver, ok := vv[hex.EncodeToString(sc.InstanceID)]
 		if !ok {
@@ -2679,6 +3237,8 @@ func (s *Service) cleanupGoroutines() {
 	log.Lvl1(s.ServerIdentity(), "closing go-routines")
 	s.viewChangeMan.closeAll()
 	s.streamingMan.stopAll()
+	s.stopExports()
+	s.stopImports()
 
 	s.stopTxPipelineMut.Lock()
 	for k, c := range s.stopTxPipeline {
@@ -2762,6 +3322,9 @@ func (s *Service) startAllChains() (chan struct{}, error) {
 		}
 	}
 	s.stateTries = make(map[string]*stateTrie)
+	if err := s.dropStateBuckets(); err != nil {
+		log.Error(s.ServerIdentity(), "couldn't delete the state buckets:", err)
+	}
 	s.notifications = bcNotifications{}
 	s.tasks.resume()
 
@@ -2859,6 +3422,8 @@ func (s *Service) startChain(genesisID skipchain.SkipBlockID) error {
 	if err := s.fixInconsistencyIfAny(genesisID, st); err != nil {
 		return xerrors.Errorf("fixing inconsistency: %v", err)
 	}
+	s.buildSchedulerIndex(genesisID, st)
+	s.rebuildReceiptsInBackground(genesisID)
 
 	// load the metadata to prepare for starting the managers (viewchange)
 	if s.db().GetByID(genesisID) == nil {
@@ -2910,6 +3475,25 @@ func (s *Service) startChain(genesisID skipchain.SkipBlockID) error {
 	return nil
 }
 
+// buildSchedulerIndex builds the index of the scheduler instances of the
+// trie in the background, if it isn't already built or being built.
+func (s *Service) buildSchedulerIndex(scID skipchain.SkipBlockID, st *stateTrie) {
+	if !s.schedulerIndex.startBuild(scID, st) {
+		return
+	}
+	go func() {
+		if !s.tasks.add(1) {
+			return
+		}
+		defer s.tasks.done()
+
+		err := s.schedulerIndex.build(scID, st)
+		if err != nil {
+			log.Error(s.ServerIdentity(), "couldn't build the scheduler index:", err)
+		}
+	}()
+}
+
 // checks that a given chain has a verifier we recognize
 func (s *Service) hasByzCoinVerification(gen skipchain.SkipBlockID) bool {
 	sb := s.db().GetByID(gen)
@@ -3036,7 +3620,10 @@ func (s *Service) repairStateTrie(from *skipchain.SkipBlock, st *stateTrie) erro
 		if st.GetIndex()+1 != from.Index {
 			return xerrors.New("unexpected index")
 		}
-		if err := st.VerifiedStoreAll(scs, from.Index, header.Version, header.TrieRoot); err != nil {
+		s.trieCopying.RLock()
+		err = st.VerifiedStoreAll(scs, from.Index, header.Version, header.TrieRoot)
+		s.trieCopying.RUnlock()
+		if err != nil {
 			return xerrors.Errorf("storing state changes: %v", err)
 		}
 		cnt++
@@ -3064,16 +3651,27 @@ var existingDB = regexp.MustCompile(`^ByzCoin_[0-9a-f]+$`)
 // be stored in memory for tests and simulations, and on disk for real
 // deployments.
 func newService(c *onet.Context) (onet.Service, error) {
+	db, bucket := c.GetAdditionalBucket(receiptBucket)
+	receipts, err := storage.Open(db, bucket)
+	if err != nil {
+		return nil, xerrors.Errorf("opening receipts: %v", err)
+	}
+
 	s := &Service{
 		ServiceProcessor:   onet.NewServiceProcessor(c),
 		contracts:          globalContractRegistry.clone(),
 		storage:            &bcStorage{},
 		darcToSc:           make(map[string]skipchain.SkipBlockID),
 		stateChangeCache:   newStateChangeCache(),
+		receipts:           receipts,
+		schedulerIndex:     newSchedulerIndex(),
 		stateChangeStorage: newStateChangeStorage(c),
 		viewChangeMan:      newViewChangeManager(),
+		rosterHealth:       newRosterHealth(),
 		streamingMan:       streamingManager{},
 		catchingUpHistory:  make(map[string]time.Time),
+		exports:            make(map[uint64]*stateExport),
+		imports:            make(map[uint64]*stateImport),
 		rotationWindow:     defaultRotationWindow,
 		defaultVersion:     CurrentVersion,
 		txPipeline:         make(map[string]*txPipeline),
@@ -3082,27 +3680,40 @@ func newService(c *onet.Context) (onet.Service, error) {
 		txErrorBuf: newRingBuf(2048),
 	}
 
-	err := s.RegisterHandlers(
+	err = s.RegisterHandlers(
 		s.GetAllByzCoinIDs,
 		s.CreateGenesisBlock,
 		s.AddTransaction,
 		s.GetProof,
+		s.SimulateTransaction,
+		s.GetMempool,
 		s.GetUpdates,
 		s.CheckAuthorization,
 		s.GetSignerCounters,
 		s.DownloadState,
+		s.ExportState,
+		s.ImportState,
+		s.CreateImportedGenesisBlock,
+		s.GetSnapshots,
+		s.DownloadSnapshot,
 		s.GetInstanceVersion,
 		s.GetLastInstanceVersion,
 		s.GetAllInstanceVersion,
 		s.CheckStateChangeValidity,
+		s.GetProofAt,
+		s.GetReceipt,
+		s.QueryInstances,
+		s.GetMultiProof,
 		s.ResolveInstanceID,
 		s.Debug,
-		s.DebugRemove)
+		s.DebugRemove,
+		s.Handover)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := s.RegisterStreamingHandlers(s.StreamTransactions, s.PaginateBlocks); err != nil {
+	if err := s.RegisterStreamingHandlers(s.StreamTransactions, s.PaginateBlocks,
+		s.SubscribeInstances); err != nil {
 		return nil, xerrors.Errorf("registering handlers: %v", err)
 	}
 	s.RegisterProcessorFunc(viewChangeMsgID, s.handleViewChangeReq)
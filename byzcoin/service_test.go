@@ -623,6 +623,105 @@ func TestService_GetProof(t *testing.T) {
 	require.Error(t, err)
 }
 
+func TestService_SimulateTransaction(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	_, err := b.Services[0].SimulateTransaction(&SimulateTransaction{
+		Version:     CurrentVersion,
+		SkipchainID: b.Genesis.SkipChainID(),
+	})
+	require.Error(t, err)
+
+	tx, err := createOneClientTxWithCounter(b.GenesisDarc.GetBaseID(),
+		DummyContractName, b.Value, b.Signer, 1)
+	require.NoError(t, err)
+	resp, err := b.Client.SimulateTransaction(tx)
+	require.NoError(t, err)
+	require.True(t, resp.Accepted)
+	require.Empty(t, resp.Error)
+	require.Equal(t, 1, len(resp.Instructions))
+	// One state change for the instance, one for the signer counter.
+	require.Equal(t, 2, len(resp.StateChanges))
+	require.Equal(t, Create, resp.StateChanges[0].StateAction)
+	require.Equal(t, b.Value, resp.StateChanges[0].Value)
+
+	// Nothing must be stored, and the counters must not be used up.
+	key := tx.Instructions[0].Hash()
+	pr, err := b.Client.GetProof(key)
+	require.NoError(t, err)
+	require.False(t, pr.Proof.InclusionProof.Match(key))
+	counters, err := b.Client.GetSignerCounters(b.Signer.Identity().String())
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), counters.Counters[0])
+
+	// A refused transaction returns the error of the failing instruction.
+	tx, err = createOneClientTxWithCounter(b.GenesisDarc.GetBaseID(),
+		invalidContract, b.Value, b.Signer, 1)
+	require.NoError(t, err)
+	resp, err = b.Client.SimulateTransaction(tx)
+	require.NoError(t, err)
+	require.False(t, resp.Accepted)
+	require.Contains(t, resp.Error, "this invalid contract always returns an error")
+	require.Equal(t, 1, len(resp.Instructions))
+	require.Contains(t, resp.Instructions[0].Error, "this invalid contract always returns an error")
+	require.Empty(t, resp.StateChanges)
+
+	// The simulation must work with the real transaction afterwards.
+	tx, err = createOneClientTxWithCounter(b.GenesisDarc.GetBaseID(),
+		DummyContractName, b.Value, b.Signer, 1)
+	require.NoError(t, err)
+	b.SendTx(nil, tx)
+}
+
+func TestService_GetMempool(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	resp, err := b.Client.GetMempool()
+	require.NoError(t, err)
+	require.Empty(t, resp.Transactions)
+
+	// Put the transaction directly in the mempool, so that it waits for the
+	// next incoming transaction.
+	tx1, err := createOneClientTxWithCounter(b.GenesisDarc.GetBaseID(),
+		DummyContractName, b.Value, b.Signer, 1)
+	require.NoError(t, err)
+	mtx, err := newMempoolTx(tx1, false)
+	require.NoError(t, err)
+	b.Services[0].txPipelinesMutex.Lock()
+	txp := b.Services[0].txPipeline[string(b.Genesis.SkipChainID())]
+	b.Services[0].txPipelinesMutex.Unlock()
+	require.NoError(t, txp.mempool.add(mtx))
+
+	// The followers forward the request to the leader.
+	resp, err = b.Services[1].GetMempool(&GetMempool{
+		Version:     CurrentVersion,
+		SkipchainID: b.Genesis.SkipChainID(),
+	})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.Transactions))
+	entry := resp.Transactions[0]
+	require.Equal(t, tx1.Instructions.Hash(), entry.TransactionHash)
+	require.Equal(t, b.Signer.Identity().String(), entry.Signer)
+	require.Equal(t, uint64(1), entry.Counter)
+	require.Equal(t, PriorityUser, entry.Priority)
+
+	tx2, err := createOneClientTxWithCounter(b.GenesisDarc.GetBaseID(),
+		DummyContractName, b.Value, b.Signer, 2)
+	require.NoError(t, err)
+	// The first transaction must be included before the second one.
+	b.SendTx(nil, tx2)
+	key := tx1.Instructions[0].Hash()
+	pr, err := b.Client.GetProof(key)
+	require.NoError(t, err)
+	require.True(t, pr.Proof.InclusionProof.Match(key))
+
+	resp, err = b.Client.GetMempool()
+	require.NoError(t, err)
+	require.Empty(t, resp.Transactions)
+}
+
 func TestService_DarcProxy(t *testing.T) {
 	b := newBCTRun(t, nil)
 	defer b.CloseAll()
@@ -1323,6 +1422,73 @@ func TestService_StateChangeVerification(t *testing.T) {
 	require.Equal(t, true, txOut[0].Accepted)
 }
 
+// TestService_ParallelStateChanges makes sure that executing the
+// transactions in parallel gives the same result as executing them one
+// after the other.
+func TestService_ParallelStateChanges(t *testing.T) {
+	SetNodeConfig(NodeConfig{ParallelWorkers: 4})
+	defer SetNodeConfig(NodeConfig{})
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	signer2 := darc.NewSignerEd25519(nil, nil)
+	id := []darc.Identity{signer2.Identity()}
+	darc2 := darc.NewDarc(darc.InitRules(id, id), []byte("second signer"))
+	darc2.Rules.AddRule("spawn:"+DummyContractName, darc2.Rules.GetSignExpr())
+	b.SpawnDarc(nil, darc2)
+
+	counter := b.SignerCounter
+	var txs []ClientTransaction
+	for _, args := range []struct {
+		darcID  darc.ID
+		signer  darc.Signer
+		counter uint64
+	}{
+		// independent of the other transactions
+		{b.GenesisDarc.GetBaseID(), b.Signer, counter},
+		{darc2.GetBaseID(), signer2, 1},
+		// conflicts with the counters of the first two transactions
+		{b.GenesisDarc.GetBaseID(), b.Signer, counter + 1},
+		{darc2.GetBaseID(), signer2, 2},
+		// refused because of a wrong counter
+		{darc2.GetBaseID(), signer2, 4},
+	} {
+		tx, err := createOneClientTxWithCounter(args.darcID, DummyContractName,
+			b.Value, args.signer, args.counter)
+		require.NoError(t, err)
+		txs = append(txs, tx)
+	}
+
+	st, err := b.Services[0].getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	timestamp := time.Now().UnixNano()
+
+	// Execute them one after the other as a reference.
+	sst := st.MakeStagingStateTrie()
+	var refScs StateChanges
+	var refAccepted []bool
+	for _, tx := range txs {
+		scs, sstTx, err := b.Services[0].processOneTx(sst, tx,
+			b.Genesis.SkipChainID(), timestamp)
+		refAccepted = append(refAccepted, err == nil)
+		if err == nil {
+			sst = sstTx
+			refScs = append(refScs, scs...)
+		}
+	}
+	require.Equal(t, []bool{true, true, true, true, false}, refAccepted)
+
+	root, txOut, scs, _ := b.Services[0].createStateChanges(
+		st.MakeStagingStateTrie(), b.Genesis.SkipChainID(),
+		NewTxResults(txs...), noTimeout, CurrentVersion, timestamp)
+	require.Equal(t, sst.GetRoot(), root)
+	require.Equal(t, refScs, scs)
+	require.Equal(t, len(txs), len(txOut))
+	for i := range txOut {
+		require.Equal(t, refAccepted[i], txOut[i].Accepted)
+	}
+}
+
 func TestService_DarcEvolutionFail(t *testing.T) {
 	b := newBCTRun(t, nil)
 	defer b.CloseAll()
@@ -2481,6 +2647,160 @@ func TestService_StateChangeStorage(t *testing.T) {
 }
 
 // Tests that the state change storage will be caught up by a new conode
+func TestService_GetProofAt(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	key := NewInstanceID(b.GenesisDarc.GetBaseID()).Slice()
+	evolved := b.GenesisDarc.Copy()
+	require.NoError(t, evolved.EvolveFrom(b.GenesisDarc))
+	evolved.Description = []byte("evolved")
+	b.EvolveDarc(nil, evolved)
+	addDummyTxs(b, 1, 1)
+	require.NoError(t, b.Client.WaitPropagation(-1))
+	p, err := b.Client.GetProof(key)
+	require.NoError(t, err)
+	latest := p.Proof.Latest.Index
+
+	// At the genesis block, the next version shows that it didn't change.
+	reply, err := b.Client.GetProofAt(key, 0)
+	require.NoError(t, err)
+	sc, err := reply.Verify(b.Genesis, key, 0)
+	require.NoError(t, err)
+	require.Equal(t, uint64(0), sc.Version)
+	require.Equal(t, 0, reply.Change.Block.Index)
+	require.NotNil(t, reply.Next)
+	require.Nil(t, reply.Latest)
+	d, err := darc.NewFromProtobuf(sc.Value)
+	require.NoError(t, err)
+	require.True(t, d.Equal(b.GenesisDarc))
+
+	// At the latest block, the current value proves it.
+	last, err := b.Client.GetProofAt(key, latest)
+	require.NoError(t, err)
+	sc, err = last.Verify(b.Genesis, key, latest)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), sc.Version)
+	require.Nil(t, last.Next)
+	require.NotNil(t, last.Latest)
+	d, err = darc.NewFromProtobuf(sc.Value)
+	require.NoError(t, err)
+	require.Equal(t, []byte("evolved"), d.Description)
+
+	// Missing versions are detected.
+	reply.Next, reply.Latest = nil, last.Latest
+	_, err = reply.Verify(b.Genesis, key, 0)
+	require.True(t, xerrors.Is(err, ErrorVersionGap))
+
+	// So are changed values.
+	last.Change.StateChanges[last.Change.Position].Value = []byte{}
+	_, err = last.Verify(b.Genesis, key, latest)
+	require.Error(t, err)
+
+	_, err = b.Client.GetProofAt(key, latest+10)
+	require.Error(t, err)
+	_, err = b.Client.GetProofAt(NewInstanceID([]byte("missing")).Slice(), latest)
+	require.Error(t, err)
+}
+
+func TestService_QueryInstances(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	addDummyTxs(b, 1, 5)
+	require.NoError(t, b.Client.WaitPropagation(-1))
+
+	// Getting all the darcs by pages of two, with the proofs.
+	query := QueryInstances{ContractID: ContractDarcID, Limit: 2, WithProof: true}
+	var darcs []QueriedInstance
+	for {
+		reply, err := b.Client.QueryInstances(query)
+		require.NoError(t, err)
+		require.True(t, len(reply.Instances) <= 2)
+		darcs = append(darcs, reply.Instances...)
+		if len(reply.Cursor) == 0 {
+			break
+		}
+		query.Cursor = reply.Cursor
+	}
+	require.Equal(t, 6, len(darcs))
+	ids := make(map[InstanceID]bool)
+	for _, inst := range darcs {
+		require.Equal(t, ContractDarcID, inst.ContractID)
+		ids[inst.InstanceID] = true
+	}
+	require.Equal(t, 6, len(ids))
+	require.True(t, ids[NewInstanceID(b.GenesisDarc.GetBaseID())])
+
+	// By controlling darc.
+	reply, err := b.Client.QueryInstances(QueryInstances{
+		DarcID: b.GenesisDarc.GetBaseID()})
+	require.NoError(t, err)
+	require.Empty(t, reply.Cursor)
+	var config bool
+	for _, inst := range reply.Instances {
+		require.True(t, inst.DarcID.Equal(b.GenesisDarc.GetBaseID()))
+		config = config || inst.InstanceID == ConfigInstanceID
+	}
+	require.True(t, config)
+
+	// By prefix.
+	prefix := darcs[3].InstanceID[:2]
+	reply, err = b.Client.QueryInstances(QueryInstances{Prefix: prefix})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(reply.Instances))
+	require.Equal(t, darcs[3].InstanceID, reply.Instances[0].InstanceID)
+
+	// An empty page still has a proof of the block it has been taken at.
+	reply, err = b.Client.QueryInstances(QueryInstances{
+		ContractID: "missing", WithProof: true})
+	require.NoError(t, err)
+	require.Empty(t, reply.Instances)
+	require.NotNil(t, reply.Proof)
+	require.Equal(t, reply.Index, reply.Proof.Latest.Index)
+}
+
+func TestService_GetMultiProof(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	addDummyTxs(b, 1, 5)
+	reply, err := b.Client.QueryInstances(QueryInstances{ContractID: ContractDarcID})
+	require.NoError(t, err)
+	keys := [][]byte{ConfigInstanceID.Slice(), NewInstanceID([]byte("missing")).Slice()}
+	for _, inst := range reply.Instances {
+		keys = append(keys, inst.InstanceID.Slice())
+	}
+
+	mp, err := b.Client.GetMultiProof(keys)
+	require.NoError(t, err)
+	exists, err := mp.Proof.InclusionProof.ExistsAll(keys)
+	require.NoError(t, err)
+	for i, k := range keys {
+		require.Equal(t, i != 1, exists[i])
+		if i > 1 {
+			_, version, contractID, _, err := mp.Proof.GetValues(k)
+			require.NoError(t, err)
+			require.Equal(t, ContractDarcID, contractID)
+			require.Equal(t, reply.Instances[i-2].Version, version)
+		}
+	}
+	_, _, _, _, err = mp.Proof.GetValues(keys[1])
+	require.Error(t, err)
+
+	// The proof must match the block.
+	mp.Proof.Latest.Data = append(mp.Proof.Latest.Data, 0)
+	require.Error(t, mp.Proof.VerifyFromBlock(b.Genesis))
+
+	mpmk := multiProofMaxKeys
+	defer func() {
+		multiProofMaxKeys = mpmk
+	}()
+	multiProofMaxKeys = 2
+	_, err = b.Client.GetMultiProof(keys)
+	require.Error(t, err)
+}
+
 func TestService_StateChangeStorageCatchUp(t *testing.T) {
 	cda := catchupDownloadAll
 	defer func() {
@@ -0,0 +1,699 @@
+package byzcoin
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/cothority/v3/storage"
+	"go.dedis.ch/kyber/v3/pairing"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// defaultSnapshotInterval is the number of blocks between two snapshots of a
+// state trie, unless another interval has been set in the NodeConfig or with
+// Service.SetSnapshotInterval.
+var defaultSnapshotInterval = 1000
+
+// How many snapshots are kept for every chain.
+var snapshotsKept = 2
+
+// How many bytes of a snapshot to download in one go.
+var snapshotFetchBytes = 1 << 20
+
+// How many key/value pairs of a state trie to copy to a snapshot in one read
+// transaction.
+var snapshotChunk = 10000
+
+// snapshotMagic starts every snapshot file, so that the format can evolve.
+var snapshotMagic = []byte("bcsnap1\n")
+
+// snapshotBucket holds the description of all the snapshots of this node.
+var snapshotBucket = []byte("snapshots")
+
+// SetSnapshotInterval sets the number of blocks between two snapshots of the
+// state tries, overriding the one of the NodeConfig. Zero sets the interval of
+// the NodeConfig back, a negative interval disables the snapshots.
+func (s *Service) SetSnapshotInterval(interval int) {
+	s.storage.Lock()
+	s.storage.SnapshotInterval = interval
+	s.storage.Unlock()
+	s.save()
+}
+
+func (s *Service) snapshotInterval() int {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	if s.storage.SnapshotInterval != 0 {
+		return s.storage.SnapshotInterval
+	}
+	if interval := GetNodeConfig().SnapshotInterval; interval != 0 {
+		return interval
+	}
+	return defaultSnapshotInterval
+}
+
+// snapshotDir returns the directory holding the snapshot files. It is next
+// to the database of the node.
+func (s *Service) snapshotDir() string {
+	db, _ := s.GetAdditionalBucket(snapshotBucket)
+	path := db.Path()
+	return strings.TrimSuffix(path, filepath.Ext(path)) + "_snapshots"
+}
+
+func snapshotKey(scID skipchain.SkipBlockID, index int) []byte {
+	key := make([]byte, len(scID)+8)
+	copy(key, scID)
+	binary.BigEndian.PutUint64(key[len(scID):], uint64(index))
+	return key
+}
+
+// loadSnapshots returns the snapshots of the chain, sorted by increasing
+// index.
+func (s *Service) loadSnapshots(scID skipchain.SkipBlockID) ([]Snapshot, error) {
+	var snaps []Snapshot
+	db, bucketName := s.GetAdditionalBucket(snapshotBucket)
+	err := db.View(func(tx *bbolt.Tx) error {
+		c := tx.Bucket(bucketName).Cursor()
+		for k, v := c.Seek(scID); k != nil && bytes.HasPrefix(k, scID); k, v = c.Next() {
+			var snap Snapshot
+			if err := protobuf.Decode(v, &snap); err != nil {
+				return xerrors.Errorf("decoding snapshot: %v", err)
+			}
+			snaps = append(snaps, snap)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, xerrors.Errorf("reading snapshots: %v", err)
+	}
+	return snaps, nil
+}
+
+// addSnapshot stores the description of a snapshot and removes the oldest
+// snapshots of the chain, so that only snapshotsKept are left.
+func (s *Service) addSnapshot(scID skipchain.SkipBlockID, snap Snapshot) error {
+	buf, err := protobuf.Encode(&snap)
+	if err != nil {
+		return xerrors.Errorf("encoding snapshot: %v", err)
+	}
+	db, bucketName := s.GetAdditionalBucket(snapshotBucket)
+	err = db.Update(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketName).Put(snapshotKey(scID, snap.Index), buf)
+	})
+	if err != nil {
+		return xerrors.Errorf("storing snapshot: %v", err)
+	}
+
+	snaps, err := s.loadSnapshots(scID)
+	if err != nil {
+		return err
+	}
+	if len(snaps) <= snapshotsKept {
+		return nil
+	}
+	old := snaps[:len(snaps)-snapshotsKept]
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, o := range old {
+			err := tx.Bucket(bucketName).Delete(snapshotKey(scID, o.Index))
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf("removing snapshots: %v", err)
+	}
+	for _, o := range old {
+		log.Lvlf2("%s: removing snapshot %d of %x", s.ServerIdentity(),
+			o.Index, scID)
+		err := os.Remove(filepath.Join(s.snapshotDir(), hex.EncodeToString(o.Hash)))
+		if err != nil && !os.IsNotExist(err) {
+			log.Error("couldn't remove snapshot:", err)
+		}
+	}
+	return nil
+}
+
+// startSnapshot takes a snapshot of the state trie of the chain of sb, which
+// must just have been updated with sb. The trie is copied in the background,
+// and it cannot be updated until the copy is done, so that the snapshot
+// holds the state right after sb.
+func (s *Service) startSnapshot(sb *skipchain.SkipBlock) {
+	scID := sb.SkipChainID()
+	dir := s.snapshotDir()
+	st, err := s.getStateTrie(scID)
+	if err != nil {
+		log.Error("couldn't start snapshot:", err)
+		return
+	}
+	if st.GetIndex() != sb.Index {
+		log.Warnf("%s: trie of %x moved on, not taking snapshot %d",
+			s.ServerIdentity(), scID, sb.Index)
+		return
+	}
+	if !s.tasks.add(1) {
+		return
+	}
+	s.trieCopying.Lock()
+
+	go func() {
+		defer s.tasks.done()
+		hash, size, err := writeSnapshot(dir, st.DB())
+		s.trieCopying.Unlock()
+		if err != nil {
+			log.Error("couldn't write snapshot:", err)
+			return
+		}
+
+		s.snapshotMutex.Lock()
+		defer s.snapshotMutex.Unlock()
+		err = s.addSnapshot(scID, Snapshot{
+			Hash:    hash,
+			Index:   sb.Index,
+			BlockID: sb.Hash,
+			Size:    size,
+		})
+		if err != nil {
+			log.Error(err)
+			return
+		}
+		log.Lvlf2("%s: stored snapshot %d of %x in %x", s.ServerIdentity(),
+			sb.Index, scID, hash)
+	}()
+}
+
+// errChunkFull stops the iteration over a chunk of readChunk.
+var errChunkFull = xerrors.New("chunk is full")
+
+// readChunk returns up to snapshotChunk key/value pairs of the DB, from the
+// key start on, in their byte order. It also returns the first key of the
+// next chunk, or nil if there is none.
+func readChunk(db storage.DB, start []byte) ([]DBKeyValue, []byte, error) {
+	var kvs []DBKeyValue
+	var next []byte
+	err := db.View(func(b storage.Bucket) error {
+		return storage.ForEachFrom(b, start, func(k, v []byte) error {
+			if len(kvs) == snapshotChunk {
+				next = append([]byte{}, k...)
+				return errChunkFull
+			}
+			kvs = append(kvs, DBKeyValue{
+				Key:   append([]byte{}, k...),
+				Value: append([]byte{}, v...),
+			})
+			return nil
+		})
+	})
+	if err != nil && err != errChunkFull {
+		return nil, nil, err
+	}
+	return kvs, next, nil
+}
+
+// forEachChunk calls f on all the key/value pairs of the DB, in their byte
+// order. Every chunk is read in its own transaction, so that no transaction
+// is kept open while the whole DB is gone through.
+func forEachChunk(db storage.DB, f func(k, v []byte) error) error {
+	var start []byte
+	for {
+		kvs, next, err := readChunk(db, start)
+		if err != nil {
+			return err
+		}
+		for _, kv := range kvs {
+			if err := f(kv.Key, kv.Value); err != nil {
+				return err
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		start = next
+	}
+}
+
+// copyDB puts all the key/value pairs of src in dst. Every chunk is read in
+// its own transaction, and written in another one once it has been read.
+func copyDB(src, dst storage.DB) error {
+	var start []byte
+	for {
+		kvs, next, err := readChunk(src, start)
+		if err != nil {
+			return err
+		}
+		err = dst.Update(func(b storage.Bucket) error {
+			for _, kv := range kvs {
+				if err := b.Put(kv.Key, kv.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil || next == nil {
+			return err
+		}
+		start = next
+	}
+}
+
+// writeSnapshot writes all the key/value pairs of the DB in a new file in
+// dir, named after the hash of its content. The file starts with
+// snapshotMagic, followed by the uvarint-prefixed keys and values. The DB
+// must not change while it is written.
+func writeSnapshot(dir string, db storage.DB) ([]byte, int64, error) {
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return nil, 0, xerrors.Errorf("creating directory: %v", err)
+	}
+	tmp, err := ioutil.TempFile(dir, ".snapshot")
+	if err != nil {
+		return nil, 0, xerrors.Errorf("creating file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	w := bufio.NewWriter(io.MultiWriter(tmp, h))
+	var size int64
+	write := func(buf []byte) error {
+		n, err := w.Write(buf)
+		size += int64(n)
+		return err
+	}
+	lenBuf := make([]byte, binary.MaxVarintLen64)
+	err = write(snapshotMagic)
+	if err == nil {
+		err = forEachChunk(db, func(k, v []byte) error {
+			for _, buf := range [][]byte{k, v} {
+				n := binary.PutUvarint(lenBuf, uint64(len(buf)))
+				if err := write(lenBuf[:n]); err != nil {
+					return err
+				}
+				if err := write(buf); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, 0, xerrors.Errorf("writing snapshot: %v", err)
+	}
+
+	hash := h.Sum(nil)
+	err = os.Rename(tmp.Name(), filepath.Join(dir, hex.EncodeToString(hash)))
+	if err != nil {
+		return nil, 0, xerrors.Errorf("renaming snapshot: %v", err)
+	}
+	return hash, size, nil
+}
+
+// readSnapshot puts all the key/value pairs of the snapshot file in the
+// bucket, which should be empty.
+func readSnapshot(path string, db *bbolt.DB, bucketName []byte) error {
+	store, err := storage.Open(db, bucketName)
+	if err != nil {
+		return xerrors.Errorf("opening trie: %v", err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return xerrors.Errorf("opening snapshot: %v", err)
+	}
+	defer f.Close()
+	stat, err := f.Stat()
+	if err != nil {
+		return xerrors.Errorf("reading snapshot: %v", err)
+	}
+
+	r := bufio.NewReader(f)
+	magic := make([]byte, len(snapshotMagic))
+	if _, err := io.ReadFull(r, magic); err != nil ||
+		!bytes.Equal(magic, snapshotMagic) {
+		return xerrors.New("not a snapshot")
+	}
+	read := func() ([]byte, error) {
+		l, err := binary.ReadUvarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if l > uint64(stat.Size()) {
+			return nil, xerrors.New("invalid length")
+		}
+		buf := make([]byte, l)
+		_, err = io.ReadFull(r, buf)
+		return buf, err
+	}
+
+	for done := false; !done; {
+		var kvs []DBKeyValue
+		for len(kvs) < catchupFetchDBEntries {
+			key, err := read()
+			if err == io.EOF {
+				done = true
+				break
+			}
+			if err != nil {
+				return xerrors.Errorf("reading key: %v", err)
+			}
+			value, err := read()
+			if err != nil {
+				return xerrors.Errorf("reading value: %v", err)
+			}
+			kvs = append(kvs, DBKeyValue{key, value})
+		}
+		err = store.Update(func(bucket storage.Bucket) error {
+			for _, kv := range kvs {
+				if err := bucket.Put(kv.Key, kv.Value); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return xerrors.Errorf("storing entries: %v", err)
+		}
+	}
+	return nil
+}
+
+// GetSnapshots returns the snapshots of the state trie this node holds for
+// the given chain.
+func (s *Service) GetSnapshots(req *GetSnapshots) (*GetSnapshotsResponse, error) {
+	if s.db().GetByID(req.SkipchainID) == nil {
+		return nil, xerrors.New("unknown skipchain")
+	}
+	snaps, err := s.loadSnapshots(req.SkipchainID)
+	if err != nil {
+		return nil, err
+	}
+	return &GetSnapshotsResponse{Snapshots: snaps}, nil
+}
+
+// DownloadSnapshot returns a part of one of the snapshots of this node.
+func (s *Service) DownloadSnapshot(req *DownloadSnapshot) (*DownloadSnapshotResponse, error) {
+	if req.Length <= 0 || req.Offset < 0 {
+		return nil, xerrors.New("invalid parameter")
+	}
+	snaps, err := s.loadSnapshots(req.ByzCoinID)
+	if err != nil {
+		return nil, err
+	}
+	found := false
+	for _, snap := range snaps {
+		found = found || bytes.Equal(snap.Hash, req.Hash)
+	}
+	if !found {
+		return nil, xerrors.New("unknown snapshot")
+	}
+
+	f, err := os.Open(filepath.Join(s.snapshotDir(), hex.EncodeToString(req.Hash)))
+	if err != nil {
+		return nil, xerrors.Errorf("opening snapshot: %v", err)
+	}
+	defer f.Close()
+	length := req.Length
+	if length > snapshotFetchBytes {
+		length = snapshotFetchBytes
+	}
+	buf := make([]byte, length)
+	n, err := f.ReadAt(buf, req.Offset)
+	if err != nil && err != io.EOF {
+		return nil, xerrors.Errorf("reading snapshot: %v", err)
+	}
+	return &DownloadSnapshotResponse{Data: buf[:n]}, nil
+}
+
+// bootstrapFromSnapshot replaces the state trie of the chain of sb with the
+// most recent snapshot held by the other nodes of the roster of sb that is
+// newer than minIndex. It returns the block of the snapshot, from which the
+// remaining blocks can be replayed, and the new trie.
+func (s *Service) bootstrapFromSnapshot(sb *skipchain.SkipBlock, minIndex int) (
+	*skipchain.SkipBlock, *stateTrie, error) {
+	scID := sb.SkipChainID()
+	var snaps []Snapshot
+	for _, si := range sb.Roster.List {
+		if si.Equal(s.ServerIdentity()) {
+			continue
+		}
+		reply, err := NewClient(scID,
+			*onet.NewRoster([]*network.ServerIdentity{si})).GetSnapshots()
+		if err != nil {
+			log.Lvlf2("%s: couldn't get snapshots of %s: %v",
+				s.ServerIdentity(), si, err)
+			continue
+		}
+		for _, snap := range reply.Snapshots {
+			if snap.Index > minIndex {
+				snaps = append(snaps, snap)
+			}
+		}
+	}
+	sort.SliceStable(snaps, func(i, j int) bool {
+		return snaps[i].Index > snaps[j].Index
+	})
+
+	tried := make(map[string]bool)
+	for _, snap := range snaps {
+		if tried[string(snap.Hash)] {
+			continue
+		}
+		tried[string(snap.Hash)] = true
+		latest, st, err := s.restoreSnapshot(sb.Roster, scID, snap)
+		if err == nil {
+			return latest, st, nil
+		}
+		log.Warnf("%s: couldn't restore snapshot %d: %v", s.ServerIdentity(),
+			snap.Index, err)
+	}
+	return nil, nil, xerrors.New("no valid snapshot available")
+}
+
+// restoreSnapshot downloads the snapshot and uses it as the state trie of
+// the chain, if its root is the trie root of the block of the snapshot.
+func (s *Service) restoreSnapshot(roster *onet.Roster, scID skipchain.SkipBlockID,
+	snap Snapshot) (*skipchain.SkipBlock, *stateTrie, error) {
+	path, err := s.getBlockPath(roster, scID, snap.Index)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting block: %v", err)
+	}
+	sb := path[len(path)-1]
+	if !sb.Hash.Equal(snap.BlockID) {
+		return nil, nil, xerrors.New("snapshot is not from this chain")
+	}
+	header, err := decodeBlockHeader(sb)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("decoding header: %v", err)
+	}
+
+	s.snapshotMutex.Lock()
+	defer s.snapshotMutex.Unlock()
+	file, err := s.downloadSnapshot(roster, scID, snap)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("downloading: %v", err)
+	}
+
+	st, err := s.replaceStateTrie(scID, file, sb, header)
+	if err != nil {
+		os.Remove(file)
+		return nil, nil, err
+	}
+
+	// The blocks are stored after the trie, so that the callback doesn't
+	// try to replay them.
+	if _, err := s.db().StoreBlocks(path); err != nil {
+		return nil, nil, xerrors.Errorf("storing blocks: %v", err)
+	}
+	d, err := s.LoadGenesisDarc(scID)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("getting darc: %v", err)
+	}
+	s.darcToScMut.Lock()
+	s.darcToSc[string(d.GetBaseID())] = scID
+	s.darcToScMut.Unlock()
+
+	if err := s.addSnapshot(scID, snap); err != nil {
+		log.Error("couldn't store snapshot:", err)
+	}
+	log.Lvlf1("%s: restored snapshot of %x at block %d", s.ServerIdentity(),
+		scID, sb.Index)
+	return sb, st, nil
+}
+
+// replaceStateTrie replaces the state trie of the chain with the content of
+// the snapshot file, if it matches the header of sb. There cannot be another
+// write-access to the trie because of catchingUpWG.
+func (s *Service) replaceStateTrie(scID skipchain.SkipBlockID, file string,
+	sb *skipchain.SkipBlock, header *DataHeader) (*stateTrie, error) {
+	idStr := fmt.Sprintf("%x", scID)
+	s.stateTriesMutex.Lock()
+	defer s.stateTriesMutex.Unlock()
+	delete(s.stateTries, idStr)
+
+	db, bucketName := s.GetAdditionalBucket([]byte(idStr))
+	clear := func() error {
+		if err := storage.DeleteBucket(db, bucketName); err != nil {
+			return err
+		}
+		return db.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucket(bucketName)
+			return err
+		})
+	}
+	if err := clear(); err != nil {
+		return nil, xerrors.Errorf("deleting trie: %v", err)
+	}
+
+	st, err := func() (*stateTrie, error) {
+		if err := readSnapshot(file, db, bucketName); err != nil {
+			return nil, err
+		}
+		st, err := loadStateTrie(db, bucketName)
+		if err != nil {
+			return nil, err
+		}
+		if err := st.VerifyNodes(); err != nil {
+			return nil, xerrors.Errorf("invalid trie: %v", err)
+		}
+		if !bytes.Equal(st.GetRoot(), header.TrieRoot) {
+			return nil, xerrors.New("merkle roots don't match")
+		}
+		if st.GetIndex() != sb.Index || st.GetVersion() != header.Version {
+			return nil, xerrors.New("wrong metadata")
+		}
+		return st, nil
+	}()
+	if err != nil {
+		if cerr := clear(); cerr != nil {
+			log.Error("couldn't delete invalid trie:", cerr)
+		}
+		return nil, err
+	}
+	s.stateTries[idStr] = st
+	return st, nil
+}
+
+// downloadSnapshot gets the snapshot from the nodes of the roster, checks
+// its hash and returns the path of the file.
+func (s *Service) downloadSnapshot(roster *onet.Roster, scID skipchain.SkipBlockID,
+	snap Snapshot) (string, error) {
+	dir := s.snapshotDir()
+	if err := os.MkdirAll(dir, 0750); err != nil {
+		return "", xerrors.Errorf("creating directory: %v", err)
+	}
+	tmp, err := ioutil.TempFile(dir, ".snapshot")
+	if err != nil {
+		return "", xerrors.Errorf("creating file: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	cl := NewClient(scID, *roster)
+	cl.DontContact(s.ServerIdentity())
+	h := sha256.New()
+	w := io.MultiWriter(tmp, h)
+	var offset int64
+	for offset < snap.Size {
+		resp, err := cl.DownloadSnapshot(snap.Hash, offset, snapshotFetchBytes)
+		if err != nil {
+			tmp.Close()
+			return "", xerrors.Errorf("downloading: %v", err)
+		}
+		if len(resp.Data) == 0 {
+			break
+		}
+		log.Lvlf2("Downloaded bytes %d..%d of %d of snapshot %x", offset,
+			offset+int64(len(resp.Data)), snap.Size, snap.Hash)
+		if _, err := w.Write(resp.Data); err != nil {
+			tmp.Close()
+			return "", xerrors.Errorf("writing: %v", err)
+		}
+		offset += int64(len(resp.Data))
+	}
+	if err := tmp.Close(); err != nil {
+		return "", xerrors.Errorf("writing: %v", err)
+	}
+	if offset != snap.Size || !bytes.Equal(h.Sum(nil), snap.Hash) {
+		return "", xerrors.New("got wrong snapshot content")
+	}
+	path := filepath.Join(dir, hex.EncodeToString(snap.Hash))
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return "", xerrors.Errorf("renaming snapshot: %v", err)
+	}
+	return path, nil
+}
+
+// getBlockPath returns the blocks linking the genesis block to the block
+// with the given index, using the highest forward links. All the forward
+// links are verified, so that the last block is known to be collectively
+// signed and part of the chain.
+func (s *Service) getBlockPath(roster *onet.Roster, scID skipchain.SkipBlockID,
+	index int) ([]*skipchain.SkipBlock, error) {
+	cl := skipchain.NewClient()
+	cl.DontContact(s.ServerIdentity())
+	reply, err := cl.GetSingleBlockByIndex(roster, scID, index)
+	if err != nil {
+		return nil, xerrors.Errorf("getting block: %v", err)
+	}
+	if len(reply.Links) == 0 {
+		return nil, xerrors.New("missing forward links")
+	}
+
+	path := make([]*skipchain.SkipBlock, 0, len(reply.Links))
+	for _, l := range reply.Links[:len(reply.Links)-1] {
+		sb := s.db().GetByID(l.To)
+		if sb == nil {
+			sb, err = cl.GetSingleBlock(roster, l.To)
+			if err != nil {
+				return nil, xerrors.Errorf("getting block: %v", err)
+			}
+		}
+		path = append(path, sb)
+	}
+	path = append(path, reply.SkipBlock)
+
+	if path[0].Index != 0 || !path[0].CalculateHash().Equal(scID) {
+		return nil, xerrors.New("path doesn't start with the genesis block")
+	}
+	for i, sb := range path[1:] {
+		prev := path[i]
+		if !sb.CalculateHash().Equal(sb.Hash) {
+			return nil, xerrors.New("wrong block hash")
+		}
+		var link *skipchain.ForwardLink
+		for _, fl := range prev.ForwardLink {
+			if fl.From.Equal(prev.Hash) && fl.To.Equal(sb.Hash) {
+				link = fl
+			}
+		}
+		if link == nil {
+			return nil, xerrors.Errorf("block %d is not linked to block %d",
+				prev.Index, sb.Index)
+		}
+		err := link.VerifyWithScheme(pairing.NewSuiteBn256(),
+			prev.Roster.ServicePublics(skipchain.ServiceName), prev.SignatureScheme)
+		if err != nil {
+			return nil, xerrors.Errorf("invalid forward link: %v", err)
+		}
+	}
+	return path, nil
+}
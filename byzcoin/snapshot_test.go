@@ -0,0 +1,171 @@
+package byzcoin
+
+import (
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.etcd.io/bbolt"
+)
+
+// waitSnapshots waits until the service holds a snapshot with the given
+// index.
+func waitSnapshots(t *testing.T, s *Service, b *BCTest, index int) []Snapshot {
+	for i := 0; i < 50; i++ {
+		snaps, err := s.loadSnapshots(b.Genesis.SkipChainID())
+		require.NoError(t, err)
+		if len(snaps) > 0 && snaps[len(snaps)-1].Index >= index {
+			return snaps
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Fail(t, "snapshot not found")
+	return nil
+}
+
+func TestService_SnapshotBootstrap(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	cda := catchupDownloadAll
+	defer func() {
+		catchupDownloadAll = cda
+	}()
+	catchupDownloadAll = 1
+
+	for _, s := range b.Services {
+		s.SetSnapshotInterval(2)
+	}
+	ids := []darc.Identity{b.Signer.Identity()}
+	testDarc := darc.NewDarc(darc.InitRules(ids, ids), []byte("testDarc"))
+	testDarcBuf, err := testDarc.ToProto()
+	require.NoError(t, err)
+	instr := createSpawnInstr(b.GenesisDarc.GetBaseID(), ContractDarcID, "darc", testDarcBuf)
+	b.SendInst(nil, instr)
+	addDummyTxs(b, 4, 1)
+
+	// Honest nodes create the same snapshots, and only keep the latest ones.
+	snaps := waitSnapshots(t, b.Services[0], b, 4)
+	require.Equal(t, snapshotsKept, len(snaps))
+	other := waitSnapshots(t, b.Services[1], b, 4)
+	require.Equal(t, snaps, other)
+
+	reply, err := b.Client.GetSnapshots()
+	require.NoError(t, err)
+	require.Equal(t, snapshotsKept, len(reply.Snapshots))
+	latest := snaps[len(snaps)-1]
+	buf, err := ioutil.ReadFile(filepath.Join(b.Services[0].snapshotDir(),
+		hex.EncodeToString(latest.Hash)))
+	require.NoError(t, err)
+	require.Equal(t, latest.Size, int64(len(buf)))
+	part, err := b.Client.DownloadSnapshot(latest.Hash, 1, 10)
+	require.NoError(t, err)
+	require.Equal(t, buf[1:11], part.Data)
+
+	// The new node doesn't take snapshots itself, so that it only holds the
+	// one it bootstrapped from.
+	servers, newRoster, _ := b.Local.MakeSRS(cothority.Suite, 1, ByzCoinID)
+	newService := b.Local.GetServices(servers, ByzCoinID)[0].(*Service)
+	newService.SetSnapshotInterval(-1)
+
+	newRoster = onet.NewRoster(append(b.Roster.List, newRoster.List...))
+	ctx, _ := createConfigTxWithCounter(b, b.PropagationInterval, *newRoster,
+		defaultMaxBlockSize)
+	b.SendTx(nil, ctx)
+	require.NoError(t, b.Client.WaitPropagation(-1))
+
+	log.Lvl1("Creating two dummy blocks for the new node to catch up")
+	addDummyTxs(b, 2, 1)
+
+	bootstrap := waitSnapshots(t, newService, b, latest.Index)
+	require.Equal(t, 1, len(bootstrap))
+	snaps, err = b.Services[0].loadSnapshots(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.Contains(t, snaps, bootstrap[0])
+
+	log.Lvl1("And getting proof from new node that the testDarc exists")
+	leanClient := onet.NewClient(cothority.Suite, ServiceName)
+	proof := &GetProofResponse{}
+	err = leanClient.SendProtobuf(newRoster.List[len(newRoster.List)-1], &GetProof{
+		Version: CurrentVersion,
+		ID:      b.Genesis.Hash,
+		Key:     testDarc.GetBaseID(),
+	}, proof)
+	require.NoError(t, err)
+	require.True(t, proof.Proof.InclusionProof.Match(testDarc.GetBaseID()))
+}
+
+// countingDB counts the read transactions of a DB.
+type countingDB struct {
+	trie.DB
+	views int
+}
+
+func (db *countingDB) View(f func(trie.Bucket) error) error {
+	db.views++
+	return db.DB.View(f)
+}
+
+// TestWriteSnapshot_Chunks checks that the key/value pairs are copied in
+// chunks, and that the snapshot doesn't depend on their size.
+func TestWriteSnapshot_Chunks(t *testing.T) {
+	defer func(chunk int) {
+		snapshotChunk = chunk
+	}(snapshotChunk)
+	dir, err := ioutil.TempDir("", "snapshot")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	bdb, err := bbolt.Open(filepath.Join(dir, "db"), 0600, nil)
+	require.NoError(t, err)
+	defer bdb.Close()
+	bucket := []byte("trie")
+	err = bdb.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucket(bucket)
+		return err
+	})
+	require.NoError(t, err)
+
+	db := &countingDB{DB: trie.NewDiskDB(bdb, bucket)}
+	snapDir := filepath.Join(dir, "snapshots")
+	err = db.Update(func(b trie.Bucket) error {
+		for i := byte(0); i < 7; i++ {
+			if err := b.Put([]byte{i}, []byte{i, i}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+
+	hash, size, err := writeSnapshot(snapDir, db)
+	require.NoError(t, err)
+	require.Equal(t, 1, db.views)
+	require.Equal(t, int64(len(snapshotMagic)+7*5), size)
+
+	snapshotChunk = 2
+	db.views = 0
+	chunked, _, err := writeSnapshot(snapDir, db)
+	require.NoError(t, err)
+	require.Equal(t, 4, db.views)
+	require.Equal(t, hash, chunked)
+}
+
+func TestService_SnapshotInterval(t *testing.T) {
+	defer SetNodeConfig(NodeConfig{})
+	s := &Service{storage: &bcStorage{}}
+	require.Equal(t, defaultSnapshotInterval, s.snapshotInterval())
+	SetNodeConfig(NodeConfig{SnapshotInterval: 10})
+	require.Equal(t, 10, s.snapshotInterval())
+	s.storage.SnapshotInterval = -1
+	require.Equal(t, -1, s.snapshotInterval())
+}
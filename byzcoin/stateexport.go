@@ -0,0 +1,1049 @@
+package byzcoin
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin/trie"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/cothority/v3/storage"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/util/random"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+// StateExportFormat is the format of the files written by StateExportWriter.
+const StateExportFormat = "byzcoin-state-export/2"
+
+// stateBucket prefixes the names of the buckets holding the tries of the
+// exports and the imports.
+const stateBucket = "statetmp"
+
+// exportStateLength is the maximum number of nodes in a page of an export.
+var exportStateLength = 1000
+
+// maxStateExports is the maximum number of exports a node serves at the same
+// time, and maxStateImports the maximum number of imports it holds.
+var maxStateExports = 4
+var maxStateImports = 4
+
+// exportTimeout is how long an export waits for its next page to be requested
+// before it is aborted.
+var exportTimeout = time.Minute
+
+// importTimeout is how long an import waits for its next page, and then for
+// its genesis block, before it is aborted.
+var importTimeout = 10 * time.Minute
+
+// exportSignatureWindow is how far the timestamp of the request starting an
+// export can be from the time of the node.
+var exportSignatureWindow = time.Minute
+
+// stateExport is an export being served. The state trie is copied to its own
+// bucket when the export starts, so that the pages can be read in short
+// transactions and still come from the same state.
+type stateExport struct {
+	sync.Mutex
+	header StateExportHeader
+	db     *bbolt.DB
+	bucket []byte
+	st     *stateTrie
+	// next is the path of the last node sent, after which the next page
+	// starts.
+	next  []bool
+	timer *time.Timer
+	ended bool
+}
+
+// stateImport is an export being imported. Its trie is rebuilt in its own
+// bucket and, once it has the root of the header, it waits there for the
+// genesis block of the new chain.
+type stateImport struct {
+	sync.Mutex
+	nonce   uint64
+	header  StateExportHeader
+	db      *bbolt.DB
+	bucket  []byte
+	builder *trie.Builder
+	// st is set once the trie has been rebuilt.
+	st    *stateTrie
+	timer *time.Timer
+	ended bool
+}
+
+// isStateImport returns whether the instruction creates the genesis state of
+// a chain from an imported state.
+func isStateImport(instr Instruction) bool {
+	return instr.Spawn != nil && ConfigInstanceID.Equal(instr.InstanceID) &&
+		instr.Spawn.Args.Search("import_root") != nil
+}
+
+// ExportState returns a page of the nodes of the state trie. The first page
+// starts a new export, which holds the state of the last block applied to
+// the trie of this node, and must be signed by the node.
+func (s *Service) ExportState(req *ExportState) (*ExportStateResponse, error) {
+	if req.Version != CurrentVersion {
+		return nil, xerrors.New("version mismatch")
+	}
+	length := req.Length
+	if length <= 0 || length > exportStateLength {
+		length = exportStateLength
+	}
+
+	nonce := req.Nonce
+	var ex *stateExport
+	if nonce == 0 {
+		if err := s.verifyExportSignature(req); err != nil {
+			return nil, err
+		}
+		var err error
+		nonce, ex, err = s.startExport(req.ByzCoinID)
+		if err != nil {
+			return nil, xerrors.Errorf("starting export: %v", err)
+		}
+	} else {
+		s.exportsMutex.Lock()
+		ex = s.exports[nonce]
+		s.exportsMutex.Unlock()
+		if ex == nil {
+			return nil, xerrors.New("unknown or aborted export")
+		}
+	}
+
+	resp, err := ex.page(req.ByzCoinID, length)
+	// The export ends with an empty page.
+	if err != nil || len(resp.Nodes) == 0 {
+		s.endExport(nonce)
+	}
+	if err != nil {
+		return nil, xerrors.Errorf("reading state: %v", err)
+	}
+	resp.Nonce = nonce
+	return resp, nil
+}
+
+// verifyExportSignature checks that the request starting an export has been
+// signed by this node, recently enough not to be a replay.
+func (s *Service) verifyExportSignature(req *ExportState) error {
+	if d := time.Since(time.Unix(req.Timestamp, 0)); d > exportSignatureWindow ||
+		d < -exportSignatureWindow {
+		return xerrors.New("the signature is too old")
+	}
+	err := schnorr.Verify(cothority.Suite, s.ServerIdentity().Public,
+		exportMessage(req.ByzCoinID, req.Timestamp), req.Signature)
+	if err != nil {
+		return xerrors.Errorf("verifying signature: %v", err)
+	}
+	return nil
+}
+
+// exportMessage returns the message signed to start an export.
+func exportMessage(scID skipchain.SkipBlockID, timestamp int64) []byte {
+	msg := append(append([]byte{}, scID...), make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(msg[len(scID):], uint64(timestamp))
+	return msg
+}
+
+// startExport copies the state trie of the chain to a new bucket. The trie
+// cannot be updated during the copy, like for a snapshot.
+func (s *Service) startExport(scID skipchain.SkipBlockID) (uint64, *stateExport, error) {
+	st, err := s.getStateTrie(scID)
+	if err != nil {
+		return 0, nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+
+	nonce := newStateNonce()
+	ex := &stateExport{}
+	ex.Lock()
+	defer ex.Unlock()
+	s.exportsMutex.Lock()
+	if len(s.exports) >= maxStateExports {
+		s.exportsMutex.Unlock()
+		return 0, nil, xerrors.New("too many exports, try again later")
+	}
+	s.exports[nonce] = ex
+	s.exportsMutex.Unlock()
+
+	err = func() error {
+		var store storage.DB
+		var err error
+		ex.db, ex.bucket, store, err = s.openStateBucket(nonce)
+		if err != nil {
+			return err
+		}
+		s.trieCopying.Lock()
+		err = copyDB(st.DB(), store)
+		s.trieCopying.Unlock()
+		if err != nil {
+			return xerrors.Errorf("copying trie: %v", err)
+		}
+		ex.st, err = loadStateTrie(ex.db, ex.bucket)
+		if err != nil {
+			return err
+		}
+		header, err := s.exportHeader(scID, ex.st)
+		if err != nil {
+			return err
+		}
+		ex.header = *header
+		return nil
+	}()
+	if err != nil {
+		s.exportsMutex.Lock()
+		delete(s.exports, nonce)
+		s.exportsMutex.Unlock()
+		ex.end()
+		return 0, nil, err
+	}
+	ex.timer = time.AfterFunc(exportTimeout, func() { s.endExport(nonce) })
+	return nonce, ex, nil
+}
+
+// exportHeader describes the state of the trie, whose root must be the one
+// of its last block.
+func (s *Service) exportHeader(scID skipchain.SkipBlockID, st *stateTrie) (*StateExportHeader, error) {
+	index := st.GetIndex()
+	reply, err := s.skService().GetSingleBlockByIndex(
+		&skipchain.GetSingleBlockByIndex{Genesis: scID, Index: index})
+	if err != nil {
+		return nil, xerrors.Errorf("getting block %d: %v", index, err)
+	}
+	var dh DataHeader
+	if err := protobuf.Decode(reply.SkipBlock.Data, &dh); err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	root := st.GetRoot()
+	if !bytes.Equal(root, dh.TrieRoot) {
+		return nil, xerrors.Errorf("the state doesn't match block %d", index)
+	}
+	nonce, err := st.GetNonce()
+	if err != nil {
+		return nil, xerrors.Errorf("getting nonce: %v", err)
+	}
+	config, err := st.LoadConfig()
+	if err != nil {
+		return nil, xerrors.Errorf("reading config: %v", err)
+	}
+	return &StateExportHeader{
+		ByzCoinID:  scID,
+		BlockIndex: index,
+		TrieRoot:   root,
+		TrieNonce:  nonce,
+		Config:     *config,
+		Block:      reply.SkipBlock,
+	}, nil
+}
+
+// page reads the nodes that come after the last page, in one transaction.
+func (ex *stateExport) page(scID skipchain.SkipBlockID, length int) (*ExportStateResponse, error) {
+	ex.Lock()
+	defer ex.Unlock()
+	if ex.ended || !ex.header.ByzCoinID.Equal(scID) {
+		return nil, xerrors.New("unknown or aborted export")
+	}
+	ex.timer.Reset(exportTimeout)
+
+	resp := &ExportStateResponse{Header: ex.header}
+	err := ex.st.ForEachNodeAfter(ex.next, func(prefix []bool, k, v []byte) error {
+		if len(resp.Nodes) == length {
+			return errPageFull
+		}
+		// The buffers are only valid during the transaction.
+		ex.next = append([]bool{}, prefix...)
+		if k == nil {
+			resp.Nodes = append(resp.Nodes, ExportedNode{Empty: ex.next})
+			return nil
+		}
+		body, err := decodeStateChangeBody(v)
+		if err != nil {
+			return xerrors.Errorf("decoding body of %x: %v", k, err)
+		}
+		resp.Nodes = append(resp.Nodes, ExportedNode{
+			Instance: &ExportedInstance{
+				InstanceID:  NewInstanceID(k),
+				ContractID:  body.ContractID,
+				DarcID:      append(darc.ID{}, body.DarcID...),
+				Version:     body.Version,
+				Value:       append([]byte{}, body.Value...),
+				StateAction: body.StateAction,
+			},
+		})
+		return nil
+	})
+	if err != nil && !xerrors.Is(err, errPageFull) {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// endExport aborts the export and deletes its trie.
+func (s *Service) endExport(nonce uint64) {
+	s.exportsMutex.Lock()
+	ex := s.exports[nonce]
+	delete(s.exports, nonce)
+	s.exportsMutex.Unlock()
+	if ex != nil {
+		ex.Lock()
+		ex.end()
+		ex.Unlock()
+	}
+}
+
+// end stops the timer of the export and deletes its trie. The export must be
+// locked.
+func (ex *stateExport) end() {
+	if ex.ended {
+		return
+	}
+	ex.ended = true
+	if ex.timer != nil {
+		ex.timer.Stop()
+	}
+	if ex.bucket != nil {
+		if err := storage.DeleteBucket(ex.db, ex.bucket); err != nil {
+			log.Error("couldn't delete the trie of an export:", err)
+		}
+	}
+}
+
+// stopExports aborts the exports being served.
+func (s *Service) stopExports() {
+	s.exportsMutex.Lock()
+	var nonces []uint64
+	for nonce := range s.exports {
+		nonces = append(nonces, nonce)
+	}
+	s.exportsMutex.Unlock()
+	for _, nonce := range nonces {
+		s.endExport(nonce)
+	}
+}
+
+// ImportState adds a page of a state export to an import. The first page
+// starts a new import, and after the last one, the rebuilt trie must have the
+// root of the block of the export.
+func (s *Service) ImportState(req *ImportState) (*ImportStateResponse, error) {
+	if req.Version != CurrentVersion {
+		return nil, xerrors.New("version mismatch")
+	}
+
+	nonce := req.Nonce
+	var imp *stateImport
+	if nonce == 0 {
+		if req.Header == nil {
+			return nil, xerrors.New("the first page needs the header")
+		}
+		if err := verifyExportHeader(req.Header); err != nil {
+			return nil, xerrors.Errorf("invalid header: %v", err)
+		}
+		var err error
+		nonce, imp, err = s.startImport(*req.Header)
+		if err != nil {
+			return nil, xerrors.Errorf("starting import: %v", err)
+		}
+	} else {
+		s.importsMutex.Lock()
+		imp = s.imports[nonce]
+		s.importsMutex.Unlock()
+		if imp == nil {
+			return nil, xerrors.New("unknown or aborted import")
+		}
+	}
+
+	if err := imp.add(req.Nodes, req.End); err != nil {
+		s.endImport(nonce)
+		return nil, xerrors.Errorf("importing state: %v", err)
+	}
+	return &ImportStateResponse{Nonce: nonce}, nil
+}
+
+// verifyExportHeader checks that the block of the header is the exported
+// one, and that it holds the trie root of the export. The ID of the block
+// can then be checked against the exported chain.
+func verifyExportHeader(h *StateExportHeader) error {
+	sb := h.Block
+	if sb == nil {
+		return xerrors.New("missing block")
+	}
+	if !sb.CalculateHash().Equal(sb.Hash) {
+		return xerrors.New("wrong hash of block")
+	}
+	if !sb.SkipChainID().Equal(h.ByzCoinID) || sb.Index != h.BlockIndex {
+		return xerrors.New("the block is not the exported one")
+	}
+	var dh DataHeader
+	if err := protobuf.Decode(sb.Data, &dh); err != nil {
+		return xerrors.Errorf("decoding header: %v", err)
+	}
+	if !bytes.Equal(dh.TrieRoot, h.TrieRoot) {
+		return xerrors.New("the trie root is not the one of the block")
+	}
+	return nil
+}
+
+// startImport creates an import in a new bucket.
+func (s *Service) startImport(header StateExportHeader) (uint64, *stateImport, error) {
+	nonce := newStateNonce()
+	s.importsMutex.Lock()
+	defer s.importsMutex.Unlock()
+	if len(s.imports) >= maxStateImports {
+		return 0, nil, xerrors.New("too many imports, try again later")
+	}
+
+	db, bucket, store, err := s.openStateBucket(nonce)
+	if err != nil {
+		return 0, nil, err
+	}
+	imp := &stateImport{
+		nonce:  nonce,
+		header: header,
+		db:     db,
+		bucket: bucket,
+	}
+	imp.builder, err = trie.NewBuilder(store, header.TrieNonce)
+	if err != nil {
+		imp.end()
+		return 0, nil, xerrors.Errorf("creating trie: %v", err)
+	}
+	imp.timer = time.AfterFunc(importTimeout, func() { s.endImport(nonce) })
+	s.imports[nonce] = imp
+	return nonce, imp, nil
+}
+
+// add adds the nodes to the trie and, at the end, checks its root.
+func (imp *stateImport) add(nodes []ExportedNode, end bool) error {
+	imp.Lock()
+	defer imp.Unlock()
+	if imp.ended || imp.st != nil {
+		return xerrors.New("the import is over")
+	}
+	imp.timer.Reset(importTimeout)
+
+	for _, node := range nodes {
+		var err error
+		if inst := node.Instance; inst != nil {
+			sc := StateChange{
+				StateAction: inst.StateAction,
+				InstanceID:  inst.InstanceID.Slice(),
+				ContractID:  inst.ContractID,
+				Value:       inst.Value,
+				Version:     inst.Version,
+				DarcID:      inst.DarcID,
+			}
+			err = imp.builder.AddLeaf(sc.InstanceID, sc.Val())
+		} else {
+			err = imp.builder.AddEmpty(node.Empty)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if !end {
+		return nil
+	}
+
+	t, err := imp.builder.Finish()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(t.GetRoot(), imp.header.TrieRoot) {
+		return xerrors.Errorf("the state doesn't match block %d",
+			imp.header.BlockIndex)
+	}
+	imp.st = &stateTrie{Trie: *t}
+	return nil
+}
+
+// endImport aborts the import and deletes its trie.
+func (s *Service) endImport(nonce uint64) {
+	s.importsMutex.Lock()
+	imp := s.imports[nonce]
+	delete(s.imports, nonce)
+	s.importsMutex.Unlock()
+	if imp != nil {
+		imp.Lock()
+		imp.end()
+		imp.Unlock()
+	}
+}
+
+// end stops the timer of the import and deletes its trie. The import must be
+// locked.
+func (imp *stateImport) end() {
+	if imp.ended {
+		return
+	}
+	imp.ended = true
+	if imp.timer != nil {
+		imp.timer.Stop()
+	}
+	if err := storage.DeleteBucket(imp.db, imp.bucket); err != nil {
+		log.Error("couldn't delete the trie of an import:", err)
+	}
+}
+
+// stopImports aborts the imports.
+func (s *Service) stopImports() {
+	s.importsMutex.Lock()
+	var nonces []uint64
+	for nonce := range s.imports {
+		nonces = append(nonces, nonce)
+	}
+	s.importsMutex.Unlock()
+	for _, nonce := range nonces {
+		s.endImport(nonce)
+	}
+}
+
+// completeImport returns the import whose trie has been rebuilt with the
+// given root, or nil.
+func (s *Service) completeImport(root []byte) *stateImport {
+	s.importsMutex.Lock()
+	defer s.importsMutex.Unlock()
+	for _, imp := range s.imports {
+		imp.Lock()
+		ok := !imp.ended && imp.st != nil && bytes.Equal(imp.header.TrieRoot, root)
+		imp.Unlock()
+		if ok {
+			return imp
+		}
+	}
+	return nil
+}
+
+// genesisImport returns the import holding the state of a genesis block with
+// the given transactions, or nil if the block doesn't import a state.
+func (s *Service) genesisImport(txs TxResults) (*stateImport, error) {
+	if len(txs) == 0 || len(txs[0].ClientTransaction.Instructions) == 0 {
+		return nil, nil
+	}
+	instr := txs[0].ClientTransaction.Instructions[0]
+	if !isStateImport(instr) {
+		return nil, nil
+	}
+	imp := s.completeImport(instr.Spawn.Args.Search("import_root"))
+	if imp == nil {
+		return nil, xerrors.New("the imported state is unknown to this node")
+	}
+	return imp, nil
+}
+
+// newGenesisStagingTrie returns the staging trie a genesis block with the
+// given transactions is applied to. It is empty, unless the block imports a
+// state.
+func (s *Service) newGenesisStagingTrie(txs TxResults) (*stagingStateTrie, error) {
+	imp, err := s.genesisImport(txs)
+	if err != nil {
+		return nil, err
+	}
+	if imp != nil {
+		return imp.st.MakeStagingStateTrie(), nil
+	}
+	nonce, err := loadNonceFromTxs(txs)
+	if err != nil {
+		return nil, xerrors.Errorf("getting nonce: %v", err)
+	}
+	return newMemStagingStateTrie(nonce)
+}
+
+// createGenesisStateTrie creates the state trie of a chain, before its
+// genesis block with the given transactions is applied. If the block imports
+// a state, the trie of the import is copied and the import is over.
+func (s *Service) createGenesisStateTrie(id skipchain.SkipBlockID, txs TxResults) (*stateTrie, error) {
+	imp, err := s.genesisImport(txs)
+	if err != nil {
+		return nil, err
+	}
+	if imp == nil {
+		nonce, err := loadNonceFromTxs(txs)
+		if err != nil {
+			return nil, xerrors.Errorf("getting nonce: %v", err)
+		}
+		return s.createStateTrie(id, nonce)
+	}
+
+	s.stateTriesMutex.Lock()
+	defer s.stateTriesMutex.Unlock()
+	idStr := fmt.Sprintf("%x", id)
+	if s.stateTries[idStr] != nil {
+		return nil, xerrors.New("state trie already exists")
+	}
+	db, name := s.GetAdditionalBucket([]byte(idStr))
+	store, err := storage.Open(db, name)
+	if err != nil {
+		return nil, xerrors.Errorf("opening trie: %v", err)
+	}
+	imp.Lock()
+	if imp.ended {
+		err = xerrors.New("the import has been aborted")
+	} else {
+		err = copyDB(imp.st.DB(), store)
+	}
+	imp.Unlock()
+	if err != nil {
+		return nil, xerrors.Errorf("copying imported trie: %v", err)
+	}
+	st, err := loadStateTrie(db, name)
+	if err != nil {
+		return nil, err
+	}
+	s.stateTries[idStr] = st
+	go s.endImport(imp.nonce)
+	return st, nil
+}
+
+// CreateImportedGenesisBlock creates a new chain whose genesis state is an
+// import, which must be complete on every node of the roster.
+func (s *Service) CreateImportedGenesisBlock(req *CreateImportedGenesisBlock) (*CreateGenesisBlockResponse, error) {
+	s.createSkipChainMut.Lock()
+	defer s.createSkipChainMut.Unlock()
+
+	if req.Version != CurrentVersion {
+		return nil, xerrors.New("version mismatch")
+	}
+	if req.Roster.List == nil {
+		return nil, xerrors.New("must provide a roster")
+	}
+	imp := s.completeImport(req.TrieRoot)
+	if imp == nil {
+		return nil, xerrors.New("no complete import with this root")
+	}
+	rosterBuf, err := protobuf.Encode(&req.Roster)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding roster: %v", err)
+	}
+
+	args := Arguments{
+		{Name: "roster", Value: rosterBuf},
+		{Name: "trie_nonce", Value: imp.header.TrieNonce},
+		{Name: "import_root", Value: req.TrieRoot},
+	}
+	if req.BlockInterval != 0 {
+		intervalBuf := make([]byte, 8)
+		binary.PutVarint(intervalBuf, int64(req.BlockInterval))
+		args = append(args, Argument{Name: "block_interval", Value: intervalBuf})
+	}
+	ctx := ClientTransaction{
+		Instructions: []Instruction{
+			{
+				InstanceID: ConfigInstanceID,
+				Spawn: &Spawn{
+					ContractID: ContractConfigID,
+					Args:       args,
+				},
+			},
+		},
+	}
+
+	sb, err := s.createNewBlock(nil, &req.Roster, NewTxResults(ctx))
+	if err != nil {
+		return nil, xerrors.Errorf("creating block: %v", err)
+	}
+	var body DataBody
+	if err := protobuf.Decode(sb.Payload, &body); err != nil {
+		return nil, xerrors.Errorf("decoding body: %v", err)
+	}
+	if len(body.TxResults) != 1 || !body.TxResults[0].Accepted {
+		return nil, xerrors.New("the state has been refused")
+	}
+	return &CreateGenesisBlockResponse{
+		Version:   CurrentVersion,
+		Skipblock: sb,
+	}, nil
+}
+
+// spawnImport updates the config of an imported state for the new chain. The
+// imported state is the trie the genesis block is applied to, see
+// newGenesisStagingTrie. It expects those arguments:
+//   - import_root    []byte
+//   - trie_nonce     []byte
+//   - roster         onet.Roster
+//   - block_interval int64, optional
+func (c *contractConfig) spawnImport(rst ReadOnlyStateTrie, inst Instruction, coins []Coin) ([]StateChange, []Coin, error) {
+	if rst.GetIndex() >= 0 {
+		return nil, nil, xerrors.New("a state can only be imported by a genesis block")
+	}
+	configBuf, _, contractID, darcID, err := rst.GetValues(ConfigInstanceID.Slice())
+	if err != nil || contractID != ContractConfigID {
+		return nil, nil, xerrors.New("the state has no config")
+	}
+	err = protobuf.DecodeWithConstructors(configBuf, &c.ChainConfig,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("decoding config: %v", err)
+	}
+	if _, err := rst.LoadDarc(darcID); err != nil {
+		return nil, nil, xerrors.Errorf("the state has no darc for the config: %v", err)
+	}
+
+	rosterBuf := inst.Spawn.Args.Search("roster")
+	c.Roster = onet.Roster{}
+	err = protobuf.DecodeWithConstructors(rosterBuf, &c.Roster,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return nil, nil, xerrors.Errorf("decoding roster: %v", err)
+	}
+	if intervalBuf := inst.Spawn.Args.Search("block_interval"); intervalBuf != nil {
+		interval, _ := binary.Varint(intervalBuf)
+		c.BlockInterval = time.Duration(interval)
+	}
+	if err = c.sanityCheck(nil, rst.GetVersion()); err != nil {
+		return nil, nil, xerrors.Errorf("sanity check: %v", err)
+	}
+	// The new chain has its own roster, so the first epoch starts with it.
+	c.startEpoch(nil, 0)
+	configBuf, err = protobuf.Encode(&c.ChainConfig)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("encoding config: %v", err)
+	}
+	return []StateChange{
+		NewStateChange(Update, ConfigInstanceID, ContractConfigID, configBuf, darcID),
+	}, coins, nil
+}
+
+// newStateNonce returns the nonce of a new export or import.
+func newStateNonce() uint64 {
+	return binary.LittleEndian.Uint64(random.Bits(64, true, random.New()))
+}
+
+// openStateBucket returns a new bucket for the trie of an export or an
+// import.
+func (s *Service) openStateBucket(nonce uint64) (*bbolt.DB, []byte, storage.DB, error) {
+	db, bucket := s.GetAdditionalBucket([]byte(fmt.Sprintf("%s_%x", stateBucket, nonce)))
+	store, err := storage.Open(db, bucket)
+	if err != nil {
+		return nil, nil, nil, xerrors.Errorf("opening bucket: %v", err)
+	}
+	return db, bucket, store, nil
+}
+
+// dropStateBuckets deletes the buckets of the exports and the imports that
+// have been left by a previous run of the node. The buckets are found by
+// their names, as GetAdditionalBucket would create the one it is given.
+func (s *Service) dropStateBuckets() error {
+	db := s.stateChangeStorage.db
+	prefix := []byte(fmt.Sprintf("%s_%s_", ServiceName, stateBucket))
+	var names [][]byte
+	err := db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			if bytes.HasPrefix(name, prefix) {
+				names = append(names, append([]byte{}, name...))
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		return xerrors.Errorf("listing buckets: %v", err)
+	}
+	for _, name := range names {
+		if err := storage.DeleteBucket(db, name); err != nil {
+			return xerrors.Errorf("deleting bucket %s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// The lines of a state export file are JSON objects with one of these fields
+// set. The first line holds the header, the last line the end, and every line
+// in between a node of the state trie.
+type stateExportLine struct {
+	Header   *stateExportHeaderJSON `json:"header,omitempty"`
+	Instance *exportedInstanceJSON  `json:"instance,omitempty"`
+	Empty    string                 `json:"empty,omitempty"`
+	End      *stateExportEndJSON    `json:"end,omitempty"`
+}
+
+type stateExportHeaderJSON struct {
+	Format     string          `json:"format"`
+	ByzCoinID  string          `json:"byzcoin_id"`
+	BlockIndex int             `json:"block_index"`
+	TrieRoot   string          `json:"trie_root"`
+	TrieNonce  string          `json:"trie_nonce"`
+	Config     chainConfigJSON `json:"config"`
+	// Block is the protobuf encoding of the block.
+	Block string `json:"block"`
+}
+
+// chainConfigJSON describes the config of the exported chain. The config
+// itself is exported as an instance.
+type chainConfigJSON struct {
+	BlockInterval   string   `json:"block_interval"`
+	MaxBlockSize    int      `json:"max_block_size"`
+	DarcContractIDs []string `json:"darc_contract_ids"`
+	Roster          []string `json:"roster"`
+}
+
+type exportedInstanceJSON struct {
+	InstanceID  string `json:"instance_id"`
+	ContractID  string `json:"contract_id"`
+	DarcID      string `json:"darc_id"`
+	Version     uint64 `json:"version"`
+	Value       []byte `json:"value"`
+	StateAction string `json:"state_action"`
+}
+
+type stateExportEndJSON struct {
+	Instances  int `json:"instances"`
+	EmptyNodes int `json:"empty_nodes"`
+}
+
+// StateExportWriter writes a state export as newline-delimited JSON. The file
+// starts with the header, followed by one line per node of the state trie:
+// an instance, or the path of an empty node, as a string of 0s and 1s. It
+// ends with the number of instances and empty nodes.
+type StateExportWriter struct {
+	enc        *json.Encoder
+	instances  int
+	emptyNodes int
+}
+
+// NewStateExportWriter writes the header of a state export to w.
+func NewStateExportWriter(w io.Writer, h StateExportHeader) (*StateExportWriter, error) {
+	blockBuf, err := protobuf.Encode(h.Block)
+	if err != nil {
+		return nil, xerrors.Errorf("encoding block: %v", err)
+	}
+	header := stateExportHeaderJSON{
+		Format:     StateExportFormat,
+		ByzCoinID:  hex.EncodeToString(h.ByzCoinID),
+		BlockIndex: h.BlockIndex,
+		TrieRoot:   hex.EncodeToString(h.TrieRoot),
+		TrieNonce:  hex.EncodeToString(h.TrieNonce),
+		Config: chainConfigJSON{
+			BlockInterval:   h.Config.BlockInterval.String(),
+			MaxBlockSize:    h.Config.MaxBlockSize,
+			DarcContractIDs: h.Config.DarcContractIDs,
+		},
+		Block: hex.EncodeToString(blockBuf),
+	}
+	for _, si := range h.Config.Roster.List {
+		header.Config.Roster = append(header.Config.Roster, si.Address.String())
+	}
+	sw := &StateExportWriter{enc: json.NewEncoder(w)}
+	if err := sw.enc.Encode(stateExportLine{Header: &header}); err != nil {
+		return nil, xerrors.Errorf("writing header: %v", err)
+	}
+	return sw, nil
+}
+
+// Write writes a node of the state trie to the export.
+func (sw *StateExportWriter) Write(node ExportedNode) error {
+	line := stateExportLine{}
+	if inst := node.Instance; inst != nil {
+		line.Instance = &exportedInstanceJSON{
+			InstanceID:  hex.EncodeToString(inst.InstanceID[:]),
+			ContractID:  inst.ContractID,
+			DarcID:      hex.EncodeToString(inst.DarcID),
+			Version:     inst.Version,
+			Value:       inst.Value,
+			StateAction: inst.StateAction.String(),
+		}
+		sw.instances++
+	} else {
+		line.Empty = encodePath(node.Empty)
+		if line.Empty == "" {
+			return xerrors.New("empty node without path")
+		}
+		sw.emptyNodes++
+	}
+	if err := sw.enc.Encode(line); err != nil {
+		return xerrors.Errorf("writing node: %v", err)
+	}
+	return nil
+}
+
+// Close writes the end of the export.
+func (sw *StateExportWriter) Close() error {
+	err := sw.enc.Encode(stateExportLine{End: &stateExportEndJSON{
+		Instances:  sw.instances,
+		EmptyNodes: sw.emptyNodes,
+	}})
+	if err != nil {
+		return xerrors.Errorf("writing end: %v", err)
+	}
+	return nil
+}
+
+// StateExportReader reads a state export written by StateExportWriter, one
+// node at a time.
+type StateExportReader struct {
+	// Header of the export. Its block holds the trie root of the export.
+	Header     StateExportHeader
+	dec        *json.Decoder
+	line       int
+	instances  int
+	emptyNodes int
+	done       bool
+}
+
+// NewStateExportReader reads the header of a state export and checks it
+// against its block. The nodes are only checked against the trie root of the
+// block when they are imported.
+func NewStateExportReader(r io.Reader) (*StateExportReader, error) {
+	sr := &StateExportReader{dec: json.NewDecoder(r), line: 1}
+	var line stateExportLine
+	if err := sr.dec.Decode(&line); err != nil {
+		return nil, xerrors.Errorf("reading header: %v", err)
+	}
+	if line.Header == nil || line.Header.Format != StateExportFormat {
+		return nil, xerrors.New("not a state export")
+	}
+	if err := line.Header.decode(&sr.Header); err != nil {
+		return nil, xerrors.Errorf("decoding header: %v", err)
+	}
+	if err := verifyExportHeader(&sr.Header); err != nil {
+		return nil, xerrors.Errorf("invalid header: %v", err)
+	}
+	return sr, nil
+}
+
+// Next returns the next node of the export. After the last one, it checks
+// the end of the export and returns io.EOF.
+func (sr *StateExportReader) Next() (*ExportedNode, error) {
+	if sr.done {
+		return nil, io.EOF
+	}
+	sr.line++
+	var line stateExportLine
+	if err := sr.dec.Decode(&line); err != nil {
+		if err == io.EOF {
+			return nil, xerrors.New("the export is truncated")
+		}
+		return nil, xerrors.Errorf("reading line %d: %v", sr.line, err)
+	}
+
+	switch {
+	case line.End != nil:
+		if line.End.Instances != sr.instances ||
+			line.End.EmptyNodes != sr.emptyNodes {
+			return nil, xerrors.Errorf("the export has %d instances and %d "+
+				"empty nodes instead of %d and %d", sr.instances,
+				sr.emptyNodes, line.End.Instances, line.End.EmptyNodes)
+		}
+		sr.done = true
+		return nil, io.EOF
+	case line.Instance != nil:
+		inst, err := line.Instance.decode()
+		if err != nil {
+			return nil, xerrors.Errorf("decoding line %d: %v", sr.line, err)
+		}
+		sr.instances++
+		return &ExportedNode{Instance: inst}, nil
+	case line.Empty != "":
+		path, err := decodePath(line.Empty)
+		if err != nil {
+			return nil, xerrors.Errorf("decoding line %d: %v", sr.line, err)
+		}
+		sr.emptyNodes++
+		return &ExportedNode{Empty: path}, nil
+	}
+	return nil, xerrors.Errorf("line %d is not a node", sr.line)
+}
+
+func (hj *stateExportHeaderJSON) decode(h *StateExportHeader) error {
+	var err error
+	h.BlockIndex = hj.BlockIndex
+	h.ByzCoinID, err = hex.DecodeString(hj.ByzCoinID)
+	if err == nil {
+		h.TrieRoot, err = hex.DecodeString(hj.TrieRoot)
+	}
+	if err == nil {
+		h.TrieNonce, err = hex.DecodeString(hj.TrieNonce)
+	}
+	if err != nil {
+		return err
+	}
+	h.Config.MaxBlockSize = hj.Config.MaxBlockSize
+	h.Config.DarcContractIDs = hj.Config.DarcContractIDs
+	if hj.Config.BlockInterval != "" {
+		h.Config.BlockInterval, err = time.ParseDuration(hj.Config.BlockInterval)
+		if err != nil {
+			return xerrors.Errorf("decoding block interval: %v", err)
+		}
+	}
+
+	blockBuf, err := hex.DecodeString(hj.Block)
+	if err != nil {
+		return xerrors.Errorf("decoding block: %v", err)
+	}
+	h.Block = &skipchain.SkipBlock{}
+	err = protobuf.DecodeWithConstructors(blockBuf, h.Block,
+		network.DefaultConstructors(cothority.Suite))
+	if err != nil {
+		return xerrors.Errorf("decoding block: %v", err)
+	}
+	return nil
+}
+
+func (ij *exportedInstanceJSON) decode() (*ExportedInstance, error) {
+	id, err := hex.DecodeString(ij.InstanceID)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding instance ID: %v", err)
+	}
+	if len(id) != len(InstanceID{}) {
+		return nil, xerrors.New("wrong length of instance ID")
+	}
+	darcID, err := hex.DecodeString(ij.DarcID)
+	if err != nil {
+		return nil, xerrors.Errorf("decoding darc ID: %v", err)
+	}
+	inst := &ExportedInstance{
+		InstanceID: NewInstanceID(id),
+		ContractID: ij.ContractID,
+		DarcID:     darcID,
+		Version:    ij.Version,
+		Value:      ij.Value,
+	}
+	// Removed instances are not in the trie.
+	switch ij.StateAction {
+	case Create.String():
+		inst.StateAction = Create
+	case Update.String():
+		inst.StateAction = Update
+	default:
+		return nil, xerrors.Errorf("invalid state action %s", ij.StateAction)
+	}
+	return inst, nil
+}
+
+// encodePath returns the path of a node of the trie as a string of 0s and
+// 1s.
+func encodePath(path []bool) string {
+	var b strings.Builder
+	for _, bit := range path {
+		if bit {
+			b.WriteByte('1')
+		} else {
+			b.WriteByte('0')
+		}
+	}
+	return b.String()
+}
+
+func decodePath(s string) ([]bool, error) {
+	path := make([]bool, len(s))
+	for i, c := range s {
+		switch c {
+		case '0':
+		case '1':
+			path[i] = true
+		default:
+			return nil, xerrors.Errorf("invalid path %s", s)
+		}
+	}
+	return path, nil
+}
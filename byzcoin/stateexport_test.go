@@ -0,0 +1,161 @@
+package byzcoin
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3/network"
+	bbolt "go.etcd.io/bbolt"
+)
+
+// Exports the state of a chain in one page and by small pages, and creates a
+// new chain with it, which must hold the same instances and accept the same
+// signers.
+func TestService_ExportImportState(t *testing.T) {
+	b := newBCTRun(t, nil)
+	defer b.CloseAll()
+
+	b.SpawnDummy(nil)
+	newDarc := b.GenesisDarc.Copy()
+	require.NoError(t, newDarc.EvolveFrom(b.GenesisDarc))
+	b.EvolveDarc(nil, newDarc)
+
+	// No bucket is created for the exports until one starts.
+	s := b.Services[0]
+	require.NoError(t, s.stateChangeStorage.db.View(func(tx *bbolt.Tx) error {
+		return tx.ForEach(func(name []byte, _ *bbolt.Bucket) error {
+			require.NotContains(t, string(name), stateBucket)
+			return nil
+		})
+	}))
+
+	// Only the node can start an export.
+	signed := func(si *network.ServerIdentity) *ExportState {
+		req := &ExportState{
+			Version:   CurrentVersion,
+			ByzCoinID: b.Genesis.SkipChainID(),
+			Length:    1,
+			Timestamp: time.Now().Unix(),
+		}
+		var err error
+		req.Signature, err = schnorr.Sign(cothority.Suite, si.GetPrivate(),
+			exportMessage(req.ByzCoinID, req.Timestamp))
+		require.NoError(t, err)
+		return req
+	}
+	req := signed(b.Servers[1].ServerIdentity)
+	_, err := s.ExportState(req)
+	require.Error(t, err)
+	req = signed(b.Servers[0].ServerIdentity)
+	req.Timestamp -= 2 * int64(exportSignatureWindow/time.Second)
+	_, err = s.ExportState(req)
+	require.Error(t, err)
+
+	// The exports are limited.
+	defer func(m int) { maxStateExports = m }(maxStateExports)
+	maxStateExports = 1
+	resp, err := s.ExportState(signed(b.Servers[0].ServerIdentity))
+	require.NoError(t, err)
+	require.Len(t, resp.Nodes, 1)
+	_, err = s.ExportState(signed(b.Servers[0].ServerIdentity))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "too many exports")
+	s.endExport(resp.Nonce)
+	_, err = s.ExportState(&ExportState{
+		Version:   CurrentVersion,
+		ByzCoinID: b.Genesis.SkipChainID(),
+		Nonce:     resp.Nonce,
+	})
+	require.Error(t, err)
+
+	var onePage bytes.Buffer
+	_, err = b.Client.ExportState(&onePage, b.Servers[0].ServerIdentity)
+	require.NoError(t, err)
+
+	defer func(l int) { exportStateLength = l }(exportStateLength)
+	exportStateLength = 2
+	var buf bytes.Buffer
+	header, err := b.Client.ExportState(&buf, b.Servers[0].ServerIdentity)
+	require.NoError(t, err)
+	require.Equal(t, onePage.Bytes(), buf.Bytes())
+	require.Empty(t, s.exports)
+	st, err := s.getStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	require.Equal(t, st.GetRoot(), header.TrieRoot)
+	require.Equal(t, st.GetIndex(), header.BlockIndex)
+	require.True(t, b.Roster.ID.Equal(header.Config.Roster.ID))
+
+	sr, err := NewStateExportReader(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	require.Equal(t, header.TrieNonce, sr.Header.TrieNonce)
+	instances := 0
+	for {
+		node, err := sr.Next()
+		if err != nil {
+			require.Equal(t, io.EOF, err)
+			break
+		}
+		if node.Instance != nil {
+			instances++
+		}
+	}
+	count := 0
+	require.NoError(t, st.ForEach(func(k, v []byte) error {
+		count++
+		return nil
+	}))
+	require.Equal(t, count, instances)
+
+	// A changed instance doesn't match the trie root of the block.
+	tampered := bytes.Replace(buf.Bytes(), []byte(`"version":1`),
+		[]byte(`"version":2`), 1)
+	require.NotEqual(t, buf.Bytes(), tampered)
+	_, _, err = ImportLedger(bytes.NewReader(tampered), *b.Roster,
+		b.PropagationInterval, false)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "doesn't match")
+	require.Empty(t, s.imports)
+
+	cl, resp2, err := ImportLedger(bytes.NewReader(buf.Bytes()), *b.Roster,
+		b.PropagationInterval, false)
+	require.NoError(t, err)
+	require.False(t, resp2.Skipblock.SkipChainID().Equal(b.Genesis.SkipChainID()))
+
+	// The instances keep their values and versions.
+	oldSt, err := s.GetReadOnlyStateTrie(b.Genesis.SkipChainID())
+	require.NoError(t, err)
+	newSt, err := s.GetReadOnlyStateTrie(cl.ID)
+	require.NoError(t, err)
+	darcID := b.GenesisDarc.GetBaseID()
+	oldVal, oldVer, _, _, err := oldSt.GetValues(darcID)
+	require.NoError(t, err)
+	newVal, newVer, contractID, newDarcID, err := newSt.GetValues(darcID)
+	require.NoError(t, err)
+	require.Equal(t, uint64(1), newVer)
+	require.Equal(t, oldVer, newVer)
+	require.Equal(t, oldVal, newVal)
+	require.Equal(t, ContractDarcID, contractID)
+	require.Equal(t, darcID, newDarcID)
+
+	// The signer keeps its counter on the new chain.
+	ctx, err := cl.CreateTransaction(Instruction{
+		InstanceID: NewInstanceID(darcID),
+		Spawn: &Spawn{
+			ContractID: DummyContractName,
+			Args:       Arguments{{Name: "data", Value: []byte("imported")}},
+		},
+		SignerIdentities: []darc.Identity{b.Signer.Identity()},
+		SignerCounter:    []uint64{b.SignerCounter},
+	})
+	require.NoError(t, err)
+	require.NoError(t, ctx.FillSignersAndSignWith(b.Signer))
+	_, err = cl.AddTransactionAndWait(ctx, 10)
+	require.NoError(t, err)
+	require.NoError(t, cl.WaitPropagation(1))
+}
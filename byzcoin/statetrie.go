@@ -14,6 +14,7 @@ import (
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/cothority/v3/storage"
 	"go.etcd.io/bbolt"
 	"golang.org/x/xerrors"
 )
@@ -83,6 +84,9 @@ type stagingStateTrie struct {
 	trie.StagingTrie
 	trieCache
 	sync.Mutex
+	// reads, if set, records all keys that are read from the trie. It is
+	// shared with the clones.
+	reads *accessSet
 }
 
 // Clone makes a copy of the staged data of the structure, the source Trie is
@@ -90,9 +94,35 @@ type stagingStateTrie struct {
 func (t *stagingStateTrie) Clone() *stagingStateTrie {
 	return &stagingStateTrie{
 		StagingTrie: *t.StagingTrie.Clone(),
+		reads:       t.reads,
 	}
 }
 
+// Get returns the value stored under the key and records the read.
+func (t *stagingStateTrie) Get(key []byte) ([]byte, error) {
+	if t.reads != nil {
+		t.reads.add(key)
+	}
+	return t.StagingTrie.Get(key)
+}
+
+// GetProof returns the proof for the key and records the read.
+func (t *stagingStateTrie) GetProof(key []byte) (*trie.Proof, error) {
+	if t.reads != nil {
+		t.reads.add(key)
+	}
+	return t.StagingTrie.GetProof(key)
+}
+
+// ForEach calls the callback on all key/value pairs. If reads are recorded,
+// the whole trie is marked as read.
+func (t *stagingStateTrie) ForEach(cb func(k, v []byte) error) error {
+	if t.reads != nil {
+		t.reads.addAll()
+	}
+	return t.StagingTrie.ForEach(cb)
+}
+
 // StoreAll puts all the state changes and the index in the staging area.
 func (t *stagingStateTrie) StoreAll(scs StateChanges) error {
 	t.Lock()
@@ -286,9 +316,13 @@ type stateTrie struct {
 }
 
 // loadStateTrie loads an existing StateTrie, an error is returned if no trie
-// exists in db
+// exists in db. The trie is kept by the storage engine of the conode.
 func loadStateTrie(db *bbolt.DB, bucket []byte) (*stateTrie, error) {
-	t, err := trie.LoadTrie(trie.NewDiskDB(db, bucket))
+	store, err := storage.Open(db, bucket)
+	if err != nil {
+		return nil, xerrors.Errorf("opening trie: %v", err)
+	}
+	t, err := trie.LoadTrie(store)
 	if err != nil {
 		return nil, xerrors.Errorf("loading trie: %v", err)
 	}
@@ -296,9 +330,14 @@ func loadStateTrie(db *bbolt.DB, bucket []byte) (*stateTrie, error) {
 }
 
 // newStateTrie creates a new, disk-based trie.Trie, an error is returned if
-// the db already contains a trie.
+// the db already contains a trie. The trie is kept by the storage engine of
+// the conode.
 func newStateTrie(db *bbolt.DB, bucket, nonce []byte) (*stateTrie, error) {
-	t, err := trie.NewTrie(trie.NewDiskDB(db, bucket), nonce)
+	store, err := storage.Open(db, bucket)
+	if err != nil {
+		return nil, xerrors.Errorf("opening trie: %v", err)
+	}
+	t, err := trie.NewTrie(store, nonce)
 	if err != nil {
 		return nil, xerrors.Errorf("creating trie: %v", err)
 	}
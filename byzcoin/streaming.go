@@ -4,8 +4,12 @@ import (
 	"fmt"
 	"sync"
 
+	"go.dedis.ch/cothority/v3/darc"
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
+	"golang.org/x/xerrors"
 )
 
 const (
@@ -29,10 +33,62 @@ func init() {
 		&PaginateRequest{}, &PaginateResponse{})
 }
 
+// streamingQueueSize is the number of new blocks that can wait to be handled
+// by a listener. A listener that falls further behind, because its client
+// doesn't read fast enough, is stopped.
+var streamingQueueSize = 64
+
 type streamingManager struct {
 	sync.Mutex
 	// key: skipchain ID, value: slice of listeners
-	listeners map[string][]chan *StreamingResponse
+	listeners map[string][]*streamingListener
+}
+
+// streamingListener is a stream of blocks sent to a client. The new blocks
+// are queued by the streamingManager, and handled by the go-routine of the
+// listener, so that a slow client never blocks the service.
+type streamingListener struct {
+	// blocks holds the new blocks until they are handled.
+	blocks chan *skipchain.SkipBlock
+	// done is closed when the listener is stopped.
+	done chan struct{}
+	// next is the index of the next block to handle. The blocks with a
+	// lower index have already been handled. It is only used by the
+	// go-routine of the listener.
+	next int
+}
+
+// handleBlock calls handle with the block, unless it has already been
+// handled. It returns false if the listener must stop.
+func (l *streamingListener) handleBlock(block *skipchain.SkipBlock,
+	handle func(*skipchain.SkipBlock) bool) bool {
+	if block.Index < l.next {
+		return true
+	}
+	l.next = block.Index + 1
+	return handle(block)
+}
+
+// run first calls catchUp, which handles the older blocks using handleBlock,
+// and then handles the queued blocks until the listener is stopped. Finally,
+// stop is called to close the channel to the client. handle must return
+// false if the listener is stopped while it waits for the client.
+func (l *streamingListener) run(catchUp func() bool,
+	handle func(*skipchain.SkipBlock) bool, stop func()) {
+	defer stop()
+	if catchUp != nil && !catchUp() {
+		return
+	}
+	for {
+		select {
+		case <-l.done:
+			return
+		case block := <-l.blocks:
+			if !l.handleBlock(block, handle) {
+				return
+			}
+		}
+	}
 }
 
 func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock) {
@@ -44,29 +100,40 @@ func (s *streamingManager) notify(scID string, block *skipchain.SkipBlock) {
 		return
 	}
 
-	for _, c := range ls {
-		c <- &StreamingResponse{
-			Block: block,
+	for i := 0; i < len(ls); i++ {
+		select {
+		case ls[i].blocks <- block:
+		default:
+			log.Warnf("stopping a stream of %x that is too slow", scID)
+			close(ls[i].done)
+			ls = append(ls[:i], ls[i+1:]...)
+			i--
 		}
 	}
+	s.listeners[scID] = ls
 }
 
-func (s *streamingManager) newListener(scID string) chan *StreamingResponse {
+// newListener registers a listener for the blocks starting at index next.
+// The new blocks are queued until they are handled by the run method of the
+// listener.
+func (s *streamingManager) newListener(scID string, next int) *streamingListener {
 	s.Lock()
 	defer s.Unlock()
 
 	if s.listeners == nil {
-		s.listeners = make(map[string][]chan *StreamingResponse)
+		s.listeners = make(map[string][]*streamingListener)
 	}
 
-	ls := s.listeners[scID]
-	outChan := make(chan *StreamingResponse)
-	ls = append(ls, outChan)
-	s.listeners[scID] = ls
-	return outChan
+	l := &streamingListener{
+		blocks: make(chan *skipchain.SkipBlock, streamingQueueSize),
+		done:   make(chan struct{}),
+		next:   next,
+	}
+	s.listeners[scID] = append(s.listeners[scID], l)
+	return l
 }
 
-func (s *streamingManager) stopListener(scID string, outChan chan *StreamingResponse) {
+func (s *streamingManager) stopListener(scID string, l *streamingListener) {
 	s.Lock()
 	defer s.Unlock()
 
@@ -76,8 +143,8 @@ func (s *streamingManager) stopListener(scID string, outChan chan *StreamingResp
 	}
 
 	for i, listener := range ls {
-		if listener == outChan {
-			close(listener)
+		if listener == l {
+			close(listener.done)
 			s.listeners[scID] = append(ls[:i], ls[i+1:]...)
 			return
 		}
@@ -91,7 +158,7 @@ func (s *streamingManager) stopAll() {
 	for key, l := range s.listeners {
 		for _, c := range l {
 			// Force the streaming connection in Onet to close.
-			close(c)
+			close(c.done)
 		}
 
 		delete(s.listeners, key)
@@ -99,11 +166,72 @@ func (s *streamingManager) stopAll() {
 }
 
 // StreamTransactions will stream all transactions IDs to the client until the
-// client closes the connection.
+// client closes the connection. If the request has a filter, only the blocks
+// with matching transactions are sent, without their payload, and the
+// response holds the matching transactions. If the request has a start index,
+// the blocks from this index on are sent first. If the start index is beyond
+// the latest block, the stream starts once the block with this index is
+// added.
 func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResponse, chan bool, error) {
+	if msg.StartIndex < 0 {
+		return nil, nil, xerrors.New("start index must not be negative")
+	}
+	filter, err := s.streamingFilter(msg.ID, msg.Filter)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("invalid filter: %v", err)
+	}
+
+	outChan := make(chan *StreamingResponse)
 	stopChan := make(chan bool)
 	key := string(msg.ID)
-	outChan := s.streamingMan.newListener(key)
+	// The listener is registered before looking for the start block, so
+	// that the blocks added in between are queued and not lost.
+	l := s.streamingMan.newListener(key, msg.StartIndex)
+
+	start, err := s.streamingStart(msg.ID, msg.StartIndex)
+	if err != nil {
+		s.streamingMan.stopListener(key, l)
+		return nil, nil, xerrors.Errorf("getting start block: %v", err)
+	}
+
+	handle := func(sb *skipchain.SkipBlock) bool {
+		resp := filter(sb)
+		if resp == nil {
+			return true
+		}
+		select {
+		case outChan <- resp:
+			return true
+		case <-l.done:
+			return false
+		}
+	}
+	// If the start block doesn't exist yet, the listener only waits for
+	// it.
+	var catchUp func() bool
+	if start != nil {
+		catchUp = func() bool {
+			for sb := start; sb != nil; {
+				if !l.handleBlock(sb, handle) {
+					return false
+				}
+				if len(sb.ForwardLink) == 0 {
+					break
+				}
+				sb = s.db().GetByID(sb.ForwardLink[0].To)
+			}
+			return true
+		}
+	}
+
+	go func() {
+		if !s.tasks.add(1) {
+			close(outChan)
+			return
+		}
+		defer s.tasks.done()
+		l.run(catchUp, handle, func() { close(outChan) })
+	}()
 
 	go func() {
 		if !s.tasks.add(1) {
@@ -115,11 +243,148 @@ func (s *Service) StreamTransactions(msg *StreamingRequest) (chan *StreamingResp
 		// the streaming connection is closed upfront.
 		<-stopChan
 		// In both cases we clean the listener.
-		s.streamingMan.stopListener(key, outChan)
+		s.streamingMan.stopListener(key, l)
 	}()
 	return outChan, stopChan, nil
 }
 
+// streamingStart returns the block of the chain at the given index. If the
+// index is 0 or bigger than the one of the latest block, it returns nil.
+func (s *Service) streamingStart(scID skipchain.SkipBlockID,
+	index int) (*skipchain.SkipBlock, error) {
+	if index == 0 {
+		return nil, nil
+	}
+	latest, err := s.db().GetLatestByID(scID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting latest block: %v", err)
+	}
+	if index > latest.Index {
+		return nil, nil
+	}
+	reply, err := s.skService().GetSingleBlockByIndex(
+		&skipchain.GetSingleBlockByIndex{Genesis: scID, Index: index})
+	if err != nil {
+		return nil, xerrors.Errorf("getting block: %v", err)
+	}
+	return reply.SkipBlock, nil
+}
+
+// streamingFilter returns the function that creates the response for a block
+// of a stream with the given filter.
+func (s *Service) streamingFilter(scID skipchain.SkipBlockID,
+	f *StreamingFilter) (func(*skipchain.SkipBlock) *StreamingResponse, error) {
+	if f == nil {
+		return func(sb *skipchain.SkipBlock) *StreamingResponse {
+			return &StreamingResponse{Block: sb}
+		}, nil
+	}
+	if f.AcceptedOnly && f.RefusedOnly {
+		return nil, xerrors.New("cannot ask for accepted and refused " +
+			"transactions only")
+	}
+
+	return func(sb *skipchain.SkipBlock) *StreamingResponse {
+		header, err := decodeBlockHeader(sb)
+		if err != nil {
+			log.Errorf("decoding header of block %d: %v", sb.Index, err)
+			return nil
+		}
+		var body DataBody
+		if err := protobuf.Decode(sb.Payload, &body); err != nil {
+			log.Errorf("decoding body of block %d: %v", sb.Index, err)
+			return nil
+		}
+		body.TxResults.SetVersion(header.Version)
+
+		// The darcs are looked up in the current state, as it doesn't
+		// matter for the darcs of the instructions in most cases.
+		darcs := make(map[InstanceID]darc.ID)
+		darcOf := func(id InstanceID) darc.ID {
+			d, ok := darcs[id]
+			if !ok {
+				st, err := s.getStateTrie(scID)
+				if err == nil {
+					_, _, _, d, _ = st.GetValues(id[:])
+				}
+				darcs[id] = d
+			}
+			return d
+		}
+
+		resp := &StreamingResponse{}
+		for i, tx := range body.TxResults {
+			resp.TxSummaries = append(resp.TxSummaries, TxSummary{
+				InstructionsHash: tx.ClientTransaction.Instructions.Hash(),
+				Accepted:         tx.Accepted,
+			})
+			if f.matches(tx, darcOf) {
+				resp.Transactions = append(resp.Transactions, StreamingTx{
+					Index:    i,
+					TxResult: tx,
+				})
+			}
+		}
+		if len(resp.Transactions) == 0 {
+			return nil
+		}
+		block := *sb
+		block.Payload = nil
+		resp.Block = &block
+		return resp
+	}, nil
+}
+
+// matches returns true if the transaction is accepted by the filter.
+func (f StreamingFilter) matches(tx TxResult, darcOf func(InstanceID) darc.ID) bool {
+	if (f.AcceptedOnly && !tx.Accepted) || (f.RefusedOnly && tx.Accepted) {
+		return false
+	}
+	for _, instr := range tx.ClientTransaction.Instructions {
+		if len(f.ContractIDs) > 0 && !containsString(f.ContractIDs, instr.ContractID()) {
+			continue
+		}
+		if len(f.InstanceIDs) > 0 && !containsInstanceID(f.InstanceIDs, instr.InstanceID) {
+			continue
+		}
+		if len(f.DarcIDs) > 0 && !containsDarcID(f.DarcIDs, darcOf(instr.InstanceID)) {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
+
+func containsInstanceID(list []InstanceID, id InstanceID) bool {
+	for _, e := range list {
+		if e == id {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDarcID(list []darc.ID, id darc.ID) bool {
+	if len(id) == 0 {
+		return false
+	}
+	for _, e := range list {
+		if e.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
+
 // PaginateBlocks returns blocks with pagination, ie. N asynchronous requests
 // that contain each K consecutive block. The caller is responsible for closing
 // the close chan when the caller wants to close the connection.
@@ -392,3 +392,49 @@ func TestStreamingService_PaginateBlocks(t *testing.T) {
 
 	close(closeChan)
 }
+
+// Makes sure that a listener that doesn't handle its blocks is stopped once
+// its queue is full, without blocking the other listeners.
+func TestStreamingManager_Overflow(t *testing.T) {
+	defer func(size int) { streamingQueueSize = size }(streamingQueueSize)
+	streamingQueueSize = 2
+
+	var sm streamingManager
+	slow := sm.newListener("chain", 0)
+	fast := sm.newListener("chain", 0)
+
+	handled := make(chan int, 10)
+	stopped := make(chan struct{})
+	go fast.run(nil, func(sb *skipchain.SkipBlock) bool {
+		handled <- sb.Index
+		return true
+	}, func() { close(stopped) })
+
+	for i := 0; i < 3; i++ {
+		sb := skipchain.NewSkipBlock()
+		sb.Index = i
+		sm.notify("chain", sb)
+		select {
+		case index := <-handled:
+			require.Equal(t, i, index)
+		case <-time.After(chanTimeout):
+			t.Fatal("didn't handle the block")
+		}
+	}
+
+	select {
+	case <-slow.done:
+	default:
+		t.Fatal("slow listener is not stopped")
+	}
+	sm.Lock()
+	require.Equal(t, []*streamingListener{fast}, sm.listeners["chain"])
+	sm.Unlock()
+
+	sm.stopListener("chain", fast)
+	select {
+	case <-stopped:
+	case <-time.After(chanTimeout):
+		t.Fatal("fast listener is not stopped")
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"encoding/binary"
 	"fmt"
+	"math/bits"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 	"sort"
@@ -32,8 +33,16 @@ const cleanThreshold = 0.8
 const notificationQueueLenght = 8
 
 var bucketStateChangeStorage = []byte("statechangestorage")
+
+// blockIndexSuffix is appended to the name of the bucket of the state change
+// storage to get the name of the bucket indexing the state changes by block.
+var blockIndexSuffix = []byte("_byblock")
 var errLengthInstanceID = xerrors.New("InstanceID must have 32 bytes")
 
+// MaxCoinLocks is the maximum number of locks a coin can hold, so that the
+// locked transfers of other accounts can't make it grow without limit.
+var MaxCoinLocks = 16
+
 // StateChangeEntry is the object stored to keep track of instance history. It
 // contains the state change and the block index
 type StateChangeEntry struct {
@@ -119,6 +128,62 @@ func (s *stateChangeStorage) getBucket(tx *bbolt.Tx, sid skipchain.SkipBlockID)
 	return b.Bucket(sid)
 }
 
+// getIndexBucket gets the bucket indexing the state changes of the given
+// skipchain by block. Its keys are the index of the block followed by the key
+// of the state change. If the transaction is writable and the bucket doesn't
+// exist yet, it is created and filled with the state changes already stored.
+// Otherwise it returns nil if the bucket doesn't exist.
+func (s *stateChangeStorage) getIndexBucket(tx *bbolt.Tx,
+	sid skipchain.SkipBlockID) (*bbolt.Bucket, error) {
+	name := append(append([]byte{}, s.bucket...), blockIndexSuffix...)
+	if !tx.Writable() {
+		ib := tx.Bucket(name)
+		if ib == nil {
+			return nil, nil
+		}
+		return ib.Bucket(sid), nil
+	}
+
+	ib, err := tx.CreateBucketIfNotExists(name)
+	if err != nil {
+		return nil, xerrors.Errorf("creating index: %v", err)
+	}
+	if sib := ib.Bucket(sid); sib != nil {
+		return sib, nil
+	}
+	sib, err := ib.CreateBucket(sid)
+	if err != nil {
+		return nil, xerrors.Errorf("creating index: %v", err)
+	}
+	if b := tx.Bucket(s.bucket).Bucket(sid); b != nil {
+		err = b.ForEach(func(k, _ []byte) error {
+			return sib.Put(s.indexKey(k), []byte{})
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("filling index: %v", err)
+		}
+	}
+	return sib, nil
+}
+
+// deleteIndexBucket removes the index of the given skipchain.
+func (s *stateChangeStorage) deleteIndexBucket(tx *bbolt.Tx,
+	sid skipchain.SkipBlockID) error {
+	name := append(append([]byte{}, s.bucket...), blockIndexSuffix...)
+	ib := tx.Bucket(name)
+	if ib == nil || ib.Bucket(sid) == nil {
+		return nil
+	}
+	return ib.DeleteBucket(sid)
+}
+
+// indexKey returns the key of the index by block of the state change stored
+// with the given key.
+func (s *stateChangeStorage) indexKey(key []byte) []byte {
+	idx := key[len(key)-8:]
+	return append(append([]byte{}, idx...), key...)
+}
+
 // setMaxSize enables the cleaning of old state changes when the storage
 // size is above a given threshold. Note that the value is not strict.
 func (s *stateChangeStorage) setMaxSize(size int) {
@@ -209,6 +274,11 @@ func (s *stateChangeStorage) cleanBySize() error {
 					k, _ = c.Seek(s.keyOfLast(k[:prefixLength]))
 				}
 
+				ib, err := s.getIndexBucket(tx, scid)
+				if err != nil {
+					return xerrors.Errorf("getting index: %v", err)
+				}
+
 				// ... and we clean it
 				k, v := c.First()
 				for k != nil {
@@ -220,6 +290,9 @@ func (s *stateChangeStorage) cleanBySize() error {
 					}
 
 					if oldestIndex == idx {
+						if err := ib.Delete(s.indexKey(k)); err != nil {
+							return xerrors.Errorf("deleting index: %v", err)
+						}
 						if err := c.Delete(); err != nil {
 							return xerrors.Errorf("deleting pair: %v", err)
 						}
@@ -236,6 +309,9 @@ func (s *stateChangeStorage) cleanBySize() error {
 					if err := b.DeleteBucket(scid); err != nil {
 						return xerrors.Errorf("deleting bucket: %v", err)
 					}
+					if err := s.deleteIndexBucket(tx, scid); err != nil {
+						return xerrors.Errorf("deleting index: %v", err)
+					}
 				}
 
 				return nil
@@ -269,6 +345,10 @@ func (s *stateChangeStorage) cleanByBlock(scs StateChanges, sb *skipchain.SkipBl
 
 	err := s.db.Update(func(tx *bbolt.Tx) error {
 		b := s.getBucket(tx, sb.SkipChainID())
+		ib, err := s.getIndexBucket(tx, sb.SkipChainID())
+		if err != nil {
+			return xerrors.Errorf("getting index: %v", err)
+		}
 
 		// Prevent from cleaning the same instance twice
 		done := map[string]bool{}
@@ -290,6 +370,9 @@ func (s *stateChangeStorage) cleanByBlock(scs StateChanges, sb *skipchain.SkipBl
 				c := b.Cursor()
 				for k, v := c.Seek(sc.InstanceID); k != nil && bytes.HasPrefix(k, sc.InstanceID); k, v = c.Next() {
 					if bytes.Compare(k[len(k)-len(index):], index) <= 0 {
+						if err := ib.Delete(s.indexKey(k)); err != nil {
+							return xerrors.Errorf("deleting index: %v", err)
+						}
 						if err := c.Delete(); err != nil {
 							return xerrors.Errorf("deleting item: %v", err)
 						}
@@ -355,6 +438,10 @@ func (s *stateChangeStorage) append(scs StateChanges, sb *skipchain.SkipBlock) e
 
 	err = s.db.Update(func(tx *bbolt.Tx) error {
 		b := s.getBucket(tx, sb.SkipChainID())
+		ib, err := s.getIndexBucket(tx, sb.SkipChainID())
+		if err != nil {
+			return xerrors.Errorf("getting index: %v", err)
+		}
 
 		// append each list of state changes (or create the entry)
 		for i, sc := range scs {
@@ -387,6 +474,10 @@ func (s *stateChangeStorage) append(scs StateChanges, sb *skipchain.SkipBlock) e
 			if err != nil {
 				return xerrors.Errorf("writing item: %v", err)
 			}
+			err = ib.Put(s.indexKey(key), []byte{})
+			if err != nil {
+				return xerrors.Errorf("writing index: %v", err)
+			}
 
 			size += len(buf)
 		}
@@ -477,36 +568,54 @@ func (s *stateChangeStorage) getByVersion(iid []byte,
 }
 
 // getByBlock looks for the state changes associated with a given
-// skipblock
+// skipblock, using the index by block.
 func (s *stateChangeStorage) getByBlock(sid skipchain.SkipBlockID, idx int) (entries StateChangeEntries, err error) {
 	s.Lock()
 	defer s.Unlock()
-	err = s.db.View(func(tx *bbolt.Tx) error {
+	var indexed bool
+	read := func(tx *bbolt.Tx) error {
 		b := s.getBucket(tx, sid)
 		if b == nil {
 			// No bucket means that the chain hasn't been processed yet.
+			indexed = true
+			return nil
+		}
+		ib, err := s.getIndexBucket(tx, sid)
+		if err != nil {
+			return xerrors.Errorf("getting index: %v", err)
+		}
+		if ib == nil {
 			return nil
 		}
+		indexed = true
 
-		var suffix bytes.Buffer
+		var prefix bytes.Buffer
 		// The key is built using BigEndian order
-		binary.Write(&suffix, binary.BigEndian, int64(idx))
-
-		c := b.Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if bytes.HasSuffix(k, suffix.Bytes()) {
-				var sce StateChangeEntry
-				err = protobuf.Decode(v, &sce)
-				if err != nil {
-					return xerrors.Errorf("decoding: %v", err)
-				}
+		binary.Write(&prefix, binary.BigEndian, int64(idx))
 
-				entries = append(entries, sce)
+		c := ib.Cursor()
+		for k, _ := c.Seek(prefix.Bytes()); bytes.HasPrefix(k, prefix.Bytes()); k, _ = c.Next() {
+			v := b.Get(k[prefix.Len():])
+			if v == nil {
+				return xerrors.Errorf("missing state change %x", k)
+			}
+			var sce StateChangeEntry
+			err = protobuf.Decode(v, &sce)
+			if err != nil {
+				return xerrors.Errorf("decoding: %v", err)
 			}
-		}
 
+			entries = append(entries, sce)
+		}
 		return nil
-	})
+	}
+
+	err = s.db.View(read)
+	if err == nil && !indexed {
+		// The state changes of the chain have been stored before the
+		// index existed, so it is created first.
+		err = s.db.Update(read)
+	}
 
 	sort.Sort(entries)
 	err = cothority.ErrorOrNil(err, "tx error")
@@ -561,15 +670,91 @@ func (c *Coin) SafeAdd(a uint64) error {
 }
 
 // SafeSub subtracts a from the value of the coin if there
-// will be no underflow.
+// will be no underflow. Locked coins cannot be subtracted.
 func (c *Coin) SafeSub(a uint64) error {
 	if a <= c.Value {
+		if a > c.Value-c.Locked() {
+			return xerrors.Errorf("only %d coins are unlocked",
+				c.Value-c.Locked())
+		}
 		c.Value -= a
 		return nil
 	}
 	return xerrors.New("uint64 underflow")
 }
 
+// Locked returns the number of coins that are still locked.
+func (c *Coin) Locked() uint64 {
+	var locked uint64
+	for _, l := range c.Locks {
+		locked += l.Total - l.Released
+	}
+	return locked
+}
+
+// SafeLock adds the lock to the coin, if enough coins are unlocked and the
+// coin holds less than MaxCoinLocks locks.
+func (c *Coin) SafeLock(l CoinLock) error {
+	if l.Total == 0 {
+		return xerrors.New("cannot lock 0 coins")
+	}
+	if l.Cliff > l.Duration {
+		return xerrors.New("cliff is after the end of the release")
+	}
+	if l.Start+l.Duration < l.Start {
+		return xerrors.New("release ends after the end of times")
+	}
+	if l.Total > c.Value-c.Locked() {
+		return xerrors.Errorf("only %d coins are unlocked", c.Value-c.Locked())
+	}
+	if len(c.Locks) >= MaxCoinLocks {
+		return xerrors.Errorf("cannot hold more than %d locks", MaxCoinLocks)
+	}
+	l.Released = 0
+	c.Locks = append(c.Locks, l)
+	return nil
+}
+
+// Release unlocks the coins that are due at the given block index and
+// timestamp, and returns their number. The locks that are fully released
+// are removed.
+func (c *Coin) Release(index uint64, timestamp int64) uint64 {
+	var released uint64
+	locks := []CoinLock{}
+	for _, l := range c.Locks {
+		now := index
+		if l.Timestamp {
+			now = uint64(timestamp)
+		}
+		due := l.Due(now)
+		released += due - l.Released
+		l.Released = due
+		if l.Released < l.Total {
+			locks = append(locks, l)
+		}
+	}
+	if len(locks) == 0 {
+		locks = nil
+	}
+	c.Locks = locks
+	return released
+}
+
+// Due returns the number of coins of the lock that are released at now,
+// which is a block index or a timestamp.
+func (l CoinLock) Due(now uint64) uint64 {
+	switch {
+	case now < l.Start+l.Cliff:
+		return 0
+	case now >= l.Start+l.Duration:
+		return l.Total
+	}
+	// As now-l.Start < l.Duration, the division cannot overflow.
+	hi, lo := bits.Mul64(l.Total, now-l.Start)
+	due, _ := bits.Div64(hi, lo, l.Duration)
+	return due
+}
+
 // SafeTransfer takes val from one coin and puts it to another.
 // It checks that the source coin has enough,
 // and that the destination coin will not overflow.
@@ -672,6 +857,21 @@ func (c ChainConfig) sanityCheck(old *ChainConfig, version Version) error {
 	if len(c.Roster.List) < 3 {
 		return xerrors.New("need at least 3 nodes to have a majority")
 	}
+	if c.FeeConfig != nil {
+		if err := c.FeeConfig.sanityCheck(); err != nil {
+			return xerrors.Errorf("fee config: %v", err)
+		}
+	}
+	if c.LeaderRotation != nil {
+		if err := c.LeaderRotation.sanityCheck(); err != nil {
+			return xerrors.Errorf("leader rotation: %v", err)
+		}
+	}
+	if c.RosterHealth != nil {
+		if err := c.RosterHealth.sanityCheck(); err != nil {
+			return xerrors.Errorf("roster health: %v", err)
+		}
+	}
 
 	if version >= VersionRosterCheck {
 		for i, si := range c.Roster.List {
@@ -761,6 +961,15 @@ func (c ChainConfig) String() string {
 	for i, darcID := range c.DarcContractIDs {
 		fmt.Fprintf(res, "--- darc contract ID %d: %s\n", i, darcID)
 	}
+	if c.FeeConfig != nil {
+		res.WriteString(c.FeeConfig.String())
+	}
+	if c.LeaderRotation != nil {
+		res.WriteString(c.LeaderRotation.String())
+	}
+	if c.RosterHealth != nil {
+		res.WriteString(c.RosterHealth.String())
+	}
 	return res.String()
 }
 
@@ -146,6 +146,49 @@ func TestStateChangeStorage_GetByBlock(t *testing.T) {
 	require.Equal(t, k, len(sce))
 }
 
+// Checks that the index by block is created for state changes stored
+// without it, and that it follows the cleaning of the state changes.
+func TestStateChangeStorage_BlockIndex(t *testing.T) {
+	store, name := generateDB(t)
+	defer os.Remove(name)
+
+	sb := createBlock()
+	iid := genID().Slice()
+	for i := 0; i < 2; i++ {
+		sb.Index = i
+		err := store.append(StateChanges{{InstanceID: iid,
+			Version: uint64(i), Value: []byte{}}}, sb)
+		require.NoError(t, err)
+	}
+
+	// Remove the index to get the storage of an older node.
+	indexName := append(append([]byte{}, store.bucket...), blockIndexSuffix...)
+	err := store.db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(indexName)
+	})
+	require.NoError(t, err)
+	sces, err := store.getByBlock(sb.SkipChainID(), 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(sces))
+	require.Equal(t, uint64(1), sces[0].StateChange.Version)
+
+	// Old state changes are removed from the index, too.
+	store.maxNbrBlock = 2
+	sb.Index = 3
+	err = store.append(StateChanges{{InstanceID: iid, Version: 2,
+		Value: []byte{}}}, sb)
+	require.NoError(t, err)
+	sces, err = store.getByBlock(sb.SkipChainID(), 1)
+	require.NoError(t, err)
+	require.Equal(t, 0, len(sces))
+	err = store.db.View(func(tx *bbolt.Tx) error {
+		ib := tx.Bucket(indexName).Bucket(sb.SkipChainID())
+		require.Equal(t, 1, ib.Stats().KeyN)
+		return nil
+	})
+	require.NoError(t, err)
+}
+
 // Checks the independance of the skipchains for the state changes
 func TestStateChangeStorage_MultiSkipChain(t *testing.T) {
 	store, name := generateDB(t)
@@ -343,3 +386,40 @@ func generateDB(t *testing.T) (*stateChangeStorage, string) {
 
 	return &scs, tmpDB.Name()
 }
+
+func TestCoin_Locks(t *testing.T) {
+	c := Coin{Value: 100}
+	require.Error(t, c.SafeLock(CoinLock{Total: 101, Duration: 10}))
+	require.Error(t, c.SafeLock(CoinLock{Total: 10, Cliff: 11, Duration: 10}))
+	require.NoError(t, c.SafeLock(CoinLock{Total: 80, Start: 10, Cliff: 2,
+		Duration: 8}))
+	require.Equal(t, uint64(80), c.Locked())
+	require.Error(t, c.SafeSub(21))
+	require.NoError(t, c.SafeSub(20))
+
+	// Nothing is released before the cliff, then linearly.
+	require.Equal(t, uint64(0), c.Release(11, 0))
+	require.Equal(t, uint64(20), c.Release(12, 0))
+	require.Equal(t, uint64(0), c.Release(12, 0))
+	require.Equal(t, uint64(30), c.Release(15, 0))
+	require.NoError(t, c.SafeSub(50))
+	require.Error(t, c.SafeSub(1))
+	require.Equal(t, uint64(30), c.Release(100, 0))
+	require.Nil(t, c.Locks)
+	require.NoError(t, c.SafeSub(30))
+
+	// Timestamps are used instead of the block index.
+	c = Coin{Value: 10}
+	require.NoError(t, c.SafeLock(CoinLock{Total: 10, Start: 1000,
+		Duration: 1000, Timestamp: true}))
+	require.Equal(t, uint64(0), c.Release(5000, 1000))
+	require.Equal(t, uint64(5), c.Release(0, 1500))
+	require.Equal(t, uint64(5), c.Release(0, 2000))
+
+	// The number of locks is limited.
+	c = Coin{Value: uint64(MaxCoinLocks) + 1}
+	for i := 0; i < MaxCoinLocks; i++ {
+		require.NoError(t, c.SafeLock(CoinLock{Total: 1, Duration: 10}))
+	}
+	require.Error(t, c.SafeLock(CoinLock{Total: 1, Duration: 10}))
+}
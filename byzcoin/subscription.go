@@ -0,0 +1,170 @@
+package byzcoin
+
+import (
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"golang.org/x/xerrors"
+)
+
+func init() {
+	network.RegisterMessages(&SubscribeInstances{}, &SubscribeInstancesResponse{})
+}
+
+// How many instances a subscription can follow at most.
+var subscriptionMaxInstances = 1000
+
+// instanceSubscription holds the versions of the instances as last sent to
+// the client. A version of -1 means that the instance doesn't exist.
+type instanceSubscription struct {
+	scID     skipchain.SkipBlockID
+	ids      []InstanceID
+	versions map[InstanceID]int64
+}
+
+// SubscribeInstances streams a proof of the instances of the request every
+// time one of them gets a new version, until the client closes the
+// connection. It uses the same listeners as StreamTransactions.
+func (s *Service) SubscribeInstances(req *SubscribeInstances) (chan *SubscribeInstancesResponse, chan bool, error) {
+	if len(req.Instances) == 0 {
+		return nil, nil, xerrors.New("no instances given")
+	}
+	if len(req.Instances) > subscriptionMaxInstances {
+		return nil, nil, xerrors.Errorf("too many instances: %d > %d",
+			len(req.Instances), subscriptionMaxInstances)
+	}
+
+	if _, err := s.getStateTrie(req.SkipChainID); err != nil {
+		return nil, nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+	// The versions start with the ones known by the client, and only the
+	// newer instances are sent the first time.
+	sub := &instanceSubscription{
+		scID:     req.SkipChainID,
+		versions: make(map[InstanceID]int64),
+	}
+	for _, idv := range req.Instances {
+		if _, ok := sub.versions[idv.ID]; ok {
+			continue
+		}
+		sub.ids = append(sub.ids, idv.ID)
+		sub.versions[idv.ID] = int64(idv.Version)
+	}
+
+	outChan := make(chan *SubscribeInstancesResponse)
+	stopChan := make(chan bool)
+	key := string(req.SkipChainID)
+	// The listener is registered before the first versions are read, so
+	// that no version can be missed. Every new block makes the listener
+	// look for new versions.
+	l := s.streamingMan.newListener(key, 0)
+	send := func(initial bool) bool {
+		resp, err := s.instanceUpdates(sub, initial)
+		if err != nil {
+			log.Errorf("%v: couldn't get updates: %v", s.ServerIdentity(), err)
+			return true
+		}
+		if len(resp.Updates) == 0 {
+			return true
+		}
+		select {
+		case outChan <- resp:
+			return true
+		case <-l.done:
+			return false
+		}
+	}
+
+	go func() {
+		if !s.tasks.add(1) {
+			close(outChan)
+			return
+		}
+		defer s.tasks.done()
+		l.run(func() bool { return send(true) },
+			func(*skipchain.SkipBlock) bool { return send(false) },
+			func() { close(outChan) })
+	}()
+
+	go func() {
+		if !s.tasks.add(1) {
+			return
+		}
+		defer s.tasks.done()
+
+		<-stopChan
+		s.streamingMan.stopListener(key, l)
+	}()
+	return outChan, stopChan, nil
+}
+
+// How many times instanceUpdates reads the trie before giving up, if new
+// blocks keep changing it during the read.
+var subscriptionReadTries = 5
+
+// instanceUpdates returns the proofs of the instances of the subscription
+// that changed since the last call. For the initial call, only the instances
+// with a bigger version than the one known by the client are returned.
+//
+// It doesn't hold the updateTrieMutex, so the trie is read again if its
+// index changed during the read, until the proofs are all from the same
+// trie.
+func (s *Service) instanceUpdates(sub *instanceSubscription, initial bool) (*SubscribeInstancesResponse, error) {
+	st, err := s.getStateTrie(sub.scID)
+	if err != nil {
+		return nil, xerrors.Errorf("getting state trie: %v", err)
+	}
+	for i := 0; i < subscriptionReadTries; i++ {
+		index := st.GetIndex()
+		resp, versions, err := s.readInstanceUpdates(st, sub, initial)
+		if st.GetIndex() != index {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for id, version := range versions {
+			sub.versions[id] = version
+		}
+		return resp, nil
+	}
+	return nil, xerrors.New("the trie changed during every read")
+}
+
+// readInstanceUpdates returns the proofs of the instances of the subscription
+// that changed, and all their new versions.
+func (s *Service) readInstanceUpdates(st ReadOnlyStateTrie, sub *instanceSubscription,
+	initial bool) (*SubscribeInstancesResponse, map[InstanceID]int64, error) {
+	resp := &SubscribeInstancesResponse{}
+	versions := make(map[InstanceID]int64)
+	for _, id := range sub.ids {
+		version, err := instanceVersion(st, id)
+		if err != nil {
+			return nil, nil, xerrors.Errorf("getting version: %v", err)
+		}
+		versions[id] = version
+		if version == sub.versions[id] ||
+			(initial && version < sub.versions[id]) {
+			continue
+		}
+		proof, err := NewProof(st, s.db(), sub.scID, id[:])
+		if err != nil {
+			return nil, nil, xerrors.Errorf("making proof: %v", err)
+		}
+		resp.Updates = append(resp.Updates, InstanceUpdate{ID: id, Proof: *proof})
+	}
+	return resp, versions, nil
+}
+
+// instanceVersion returns the version of the instance, or -1 if it doesn't
+// exist.
+func instanceVersion(st ReadOnlyStateTrie, id InstanceID) (int64, error) {
+	_, version, _, _, err := st.GetValues(id[:])
+	if err != nil {
+		if xerrors.Is(err, errKeyNotSet) {
+			return -1, nil
+		}
+		return 0, err
+	}
+	return int64(version), nil
+}
@@ -13,6 +13,7 @@ import (
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
@@ -374,6 +375,9 @@ func (instr Instruction) GetIdentityStrings() []string {
 type VerificationOptions struct {
 	IgnoreCounters bool
 	EvalAttr       darc.AttrInterpreters
+	// Expression, if set, is evaluated instead of the rule of the darc, so
+	// that a contract can decide who is allowed to sign an instruction.
+	Expression expression.Expr
 }
 
 // Verify will look up the darc of the instance pointed to by the instruction
@@ -440,8 +444,12 @@ func (instr Instruction) VerifyWithOption(st ReadOnlyStateTrie, msg []byte, ops
 	}
 
 	// check the action
-	if !d.Rules.Contains(darc.Action(instr.Action())) {
-		return xerrors.Errorf("action '%v' does not exist", instr.Action())
+	expr := ops.Expression
+	if expr == nil {
+		if !d.Rules.Contains(darc.Action(instr.Action())) {
+			return xerrors.Errorf("action '%v' does not exist", instr.Action())
+		}
+		expr = d.Rules.Get(darc.Action(instr.Action()))
 	}
 
 	if instr.usesForbiddenIdentities() {
@@ -479,10 +487,10 @@ func (instr Instruction) VerifyWithOption(st ReadOnlyStateTrie, msg []byte, ops
 	}
 
 	if ops.EvalAttr != nil {
-		err := darc.EvalExprAttr(d.Rules.Get(darc.Action(instr.Action())), getDarc, ops.EvalAttr, identitiesWithCorrectSignatures...)
+		err := darc.EvalExprAttr(expr, getDarc, ops.EvalAttr, identitiesWithCorrectSignatures...)
 		return cothority.ErrorOrNil(err, "evaluating darc")
 	}
-	err = darc.EvalExpr(d.Rules.Get(darc.Action(instr.Action())), getDarc, identitiesWithCorrectSignatures...)
+	err = darc.EvalExpr(expr, getDarc, identitiesWithCorrectSignatures...)
 	return cothority.ErrorOrNil(err, "evaluating darc")
 }
 
@@ -602,6 +610,23 @@ func NewStateChange(sa StateAction, iID InstanceID, contractID string, value []b
 	}
 }
 
+// NewEvent returns a state change emitting an event of the given instance.
+// Contracts return it together with their other state changes. The event is
+// stored in the block with the result of the transaction, but only if the
+// transaction is accepted.
+func NewEvent(iID InstanceID, contractID, topic string, attrs ...Argument) StateChange {
+	buf, err := protobuf.Encode(&Event{
+		InstanceID: iID,
+		ContractID: contractID,
+		Topic:      topic,
+		Attributes: attrs,
+	})
+	if err != nil {
+		log.Error("failed to encode event")
+	}
+	return NewStateChange(EmitEvent, iID, contractID, buf, nil)
+}
+
 func (sc StateChange) toString(withValue bool) string {
 	var out string
 	out += "\nstatechange\n"
@@ -655,7 +680,7 @@ func (sc *StateChange) Op() trie.OpType {
 		return trie.OpSet
 	case Remove:
 		return trie.OpDel
-	case GenerateInstruction:
+	case GenerateInstruction, EmitEvent:
 		return trie.Nop
 	}
 	return 0
@@ -704,6 +729,9 @@ const (
 	Remove
 	// GenerateInstruction allows to generate an instruction
 	GenerateInstruction
+	// EmitEvent adds an event to the receipt of the transaction, without
+	// changing the trie.
+	EmitEvent
 )
 
 // String returns a readable output of the action.
@@ -717,6 +745,8 @@ func (sc StateAction) String() string {
 		return "Remove"
 	case GenerateInstruction:
 		return "GenerateInstruction"
+	case EmitEvent:
+		return "EmitEvent"
 	default:
 		return "Invalid stateChange"
 	}
@@ -8,6 +8,7 @@ import (
 	"github.com/stretchr/testify/require"
 	"go.dedis.ch/cothority/v3/byzcoin/trie"
 	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/darc/expression"
 	"go.dedis.ch/protobuf"
 )
 
@@ -27,7 +28,7 @@ func TestTransaction_Signing(t *testing.T) {
 	mdb := trie.NewMemDB()
 	tr, err := trie.NewTrie(mdb, []byte("my nonce"))
 	require.NoError(t, err)
-	sst := &stagingStateTrie{*tr.MakeStagingTrie(), trieCache{}, sync.Mutex{}}
+	sst := &stagingStateTrie{*tr.MakeStagingTrie(), trieCache{}, sync.Mutex{}, nil}
 
 	// verification should fail because trie is empty
 	ctxHash := ctx.Instructions.Hash()
@@ -76,6 +77,48 @@ func TestTransaction_Signing(t *testing.T) {
 	require.NoError(t, ctx.Instructions[0].Verify(sst, ctxHash))
 }
 
+func TestInstruction_VerifyExpression(t *testing.T) {
+	signer := darc.NewSignerEd25519(nil, nil)
+	other := darc.NewSignerEd25519(nil, nil)
+	ids := []darc.Identity{signer.Identity()}
+	d := darc.NewDarc(darc.InitRules(ids, ids), []byte("genesis darc"))
+
+	mdb := trie.NewMemDB()
+	tr, err := trie.NewTrie(mdb, []byte("my nonce"))
+	require.NoError(t, err)
+	sst := &stagingStateTrie{*tr.MakeStagingTrie(), trieCache{}, sync.Mutex{}, nil}
+	configBuf, err := protobuf.Encode(&ChainConfig{DarcContractIDs: []string{"darc"}})
+	require.NoError(t, err)
+	darcBuf, err := d.ToProto()
+	require.NoError(t, err)
+	require.NoError(t, sst.StoreAll([]StateChange{
+		{InstanceID: NewInstanceID(nil).Slice(), StateAction: Create,
+			ContractID: ContractConfigID, Value: configBuf},
+		{InstanceID: d.GetBaseID(), StateAction: Create,
+			ContractID: ContractDarcID, Value: darcBuf, DarcID: d.GetBaseID()},
+	}))
+	require.NoError(t, setSignerCounter(sst, signer.Identity().String(), 0))
+	require.NoError(t, setSignerCounter(sst, other.Identity().String(), 0))
+
+	// The darc has no rule for the action, so only the expression can
+	// allow it.
+	verify := func(s darc.Signer, expr expression.Expr) error {
+		ctx, err := createOneClientTx(d.GetBaseID(), "dummy_kind", nil, s)
+		require.NoError(t, err)
+		return ctx.Instructions[0].VerifyWithOption(sst,
+			ctx.Instructions.Hash(), &VerificationOptions{Expression: expr})
+	}
+	err = verify(other, nil)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "does not exist")
+	require.NoError(t, verify(other, expression.InitOrExpr(other.Identity().String())))
+
+	// The expression replaces the rules of the darc.
+	require.Error(t, verify(signer, expression.InitOrExpr(other.Identity().String())))
+	require.NoError(t, verify(signer, expression.InitOrExpr(
+		other.Identity().String(), signer.Identity().String())))
+}
+
 func TestInstruction_DeriveIDArg(t *testing.T) {
 	inst := Instruction{
 		InstanceID: NewInstanceID([]byte("new instance")),
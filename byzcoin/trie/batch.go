@@ -0,0 +1,390 @@
+package trie
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"sort"
+	"sync"
+
+	"golang.org/x/xerrors"
+)
+
+// batchParallelDepth is the depth up to which the new subtrees of a batch are
+// hashed in parallel, so at most 2^batchParallelDepth goroutines are used.
+const batchParallelDepth = 4
+
+// batchOp is an instruction of a batch together with the path of its key in
+// the trie and its position in the batch.
+type batchOp struct {
+	instr
+	path []byte
+	bits []bool
+	seq  int
+}
+
+// memNode is a node of the trie that is held in memory while a batch is
+// applied. The nodes of the trie are only loaded from the bucket when the
+// batch needs them.
+type memNode struct {
+	ty nodeType
+	// hash is the hash of the node, it is nil if the node has been changed
+	// and its hash must be computed again.
+	hash []byte
+	// stored is the hash under which the node is in the bucket, it is nil
+	// if the node is not in the bucket.
+	stored []byte
+	// buf is the encoding of a node that must be written to the bucket.
+	buf []byte
+
+	prefix      []bool
+	key, value  []byte
+	left, right *memNode
+}
+
+// trieBatch applies instructions to the trie in memory and writes the
+// resulting nodes to the bucket at once.
+type trieBatch struct {
+	t *Trie
+	b Bucket
+	// removed holds the hashes of the stored nodes that have been replaced.
+	removed [][]byte
+}
+
+// applyInstrs applies the instructions to the trie in the bucket. The result
+// is the same as calling SetWithBucket and DeleteWithBucket for every
+// instruction in turn, but every node is loaded, hashed and written at most
+// once.
+//
+// The instructions are sorted by the path of their key, so that the ones
+// going to the same subtree are next to each other. The subtrees that exist
+// before the batch are visited only once, and the instructions reaching the
+// same leaf or empty node are applied in their original order, as their order
+// decides the shape of the new subtree. Finally the new nodes are hashed, in
+// parallel for the top of the trie, and written to the bucket.
+func (t *Trie) applyInstrs(instrs []instr, b Bucket) error {
+	if len(instrs) == 0 {
+		return nil
+	}
+	ops := make([]batchOp, len(instrs))
+	for i, in := range instrs {
+		if in.ty != OpSet && in.ty != OpDel {
+			return xerrors.New("invalid instruction in batch")
+		}
+		ops[i] = batchOp{instr: in, path: t.pathOf(in.k), seq: i}
+		ops[i].bits = toBinSlice(ops[i].path)
+	}
+	sort.SliceStable(ops, func(i, j int) bool {
+		return bytes.Compare(ops[i].path, ops[j].path) < 0
+	})
+
+	rootKey := t.GetRootWithBucket(b)
+	if rootKey == nil {
+		return xerrors.New("no root key")
+	}
+	tb := trieBatch{t: t, b: b}
+	root, err := tb.apply(&memNode{hash: clone(rootKey)}, 0, ops)
+	if err != nil {
+		return err
+	}
+	if root.hash != nil {
+		// nothing changed
+		return nil
+	}
+	if err := tb.hashNode(root, 0); err != nil {
+		return err
+	}
+	return tb.write(root)
+}
+
+// pathOf returns the path of the key in the trie, as bytes.
+func (t *Trie) pathOf(key []byte) []byte {
+	if t.noHashKey {
+		return key
+	}
+	hashKey := sha256.Sum256(key)
+	return hashKey[:]
+}
+
+// load reads the node from the bucket if it has not been read yet.
+func (tb *trieBatch) load(n *memNode) error {
+	if n.ty != 0 {
+		return nil
+	}
+	nodeVal := tb.b.Get(n.hash)
+	if len(nodeVal) == 0 {
+		return xerrors.New("node key does not exist in batch")
+	}
+	switch nodeType(nodeVal[0]) {
+	case typeEmpty:
+		node, err := decodeEmptyNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		n.prefix = node.Prefix
+	case typeLeaf:
+		node, err := decodeLeafNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		n.prefix = node.Prefix
+		n.key = node.Key
+		n.value = node.Value
+	case typeInterior:
+		node, err := decodeInteriorNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		n.left = &memNode{hash: node.Left}
+		n.right = &memNode{hash: node.Right}
+	default:
+		return xerrors.New("invalid node type")
+	}
+	n.ty = nodeType(nodeVal[0])
+	n.stored = n.hash
+	return nil
+}
+
+// drop marks the node as changed. If it was stored, it has to be removed
+// from the bucket.
+func (tb *trieBatch) drop(n *memNode) {
+	if n.stored != nil {
+		tb.removed = append(tb.removed, n.stored)
+		n.stored = nil
+	}
+	n.hash = nil
+}
+
+// apply applies the sorted operations to the subtree of the node at the
+// given depth and returns the new node.
+func (tb *trieBatch) apply(n *memNode, depth int, ops []batchOp) (*memNode, error) {
+	if err := tb.load(n); err != nil {
+		return nil, err
+	}
+	if n.ty != typeInterior {
+		// The operations are applied in their original order from
+		// here on.
+		sort.Slice(ops, func(i, j int) bool {
+			return ops[i].seq < ops[j].seq
+		})
+		var err error
+		for i := range ops {
+			switch ops[i].ty {
+			case OpSet:
+				n, err = tb.set(n, depth, &ops[i])
+			case OpDel:
+				n, _, err = tb.del(n, depth, &ops[i])
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return n, nil
+	}
+
+	// The operations with a false bit come first.
+	split := sort.Search(len(ops), func(i int) bool {
+		return ops[i].bits[depth]
+	})
+	var err error
+	if split > 0 {
+		n.right, err = tb.apply(n.right, depth+1, ops[:split])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if split < len(ops) {
+		n.left, err = tb.apply(n.left, depth+1, ops[split:])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if n.left.hash == nil || n.right.hash == nil {
+		tb.drop(n)
+	}
+	return n, nil
+}
+
+// set works like Trie.set on the nodes in memory.
+func (tb *trieBatch) set(n *memNode, depth int, op *batchOp) (*memNode, error) {
+	if err := tb.load(n); err != nil {
+		return nil, err
+	}
+	switch n.ty {
+	case typeEmpty:
+		tb.drop(n)
+		return &memNode{ty: typeLeaf, prefix: n.prefix, key: op.k,
+			value: op.v}, nil
+	case typeLeaf:
+		tb.drop(n)
+		if bytes.Equal(n.key, op.k) {
+			return &memNode{ty: typeLeaf, prefix: n.prefix, key: op.k,
+				value: op.v}, nil
+		}
+		return tb.extendLeaf(n.prefix, n, tb.t.binSlice(n.key), op), nil
+	default:
+		var err error
+		if op.bits[depth] {
+			n.left, err = tb.set(n.left, depth+1, op)
+		} else {
+			n.right, err = tb.set(n.right, depth+1, op)
+		}
+		if err != nil {
+			return nil, err
+		}
+		tb.drop(n)
+		return n, nil
+	}
+}
+
+// extendLeaf works like Trie.extendLeaf on the nodes in memory, but returns
+// the interior node that replaces the leaf.
+func (tb *trieBatch) extendLeaf(currPrefix []bool, leaf *memNode,
+	bits1 []bool, op *batchOp) *memNode {
+	i := len(currPrefix)
+	var child1, child2 *memNode
+	if bits1[i] != op.bits[i] {
+		child1 = &memNode{ty: typeLeaf, prefix: appendBit(currPrefix, bits1[i]),
+			key: leaf.key, value: leaf.value}
+		child2 = &memNode{ty: typeLeaf, prefix: appendBit(currPrefix, op.bits[i]),
+			key: op.k, value: op.v}
+	} else {
+		child1 = tb.extendLeaf(appendBit(currPrefix, bits1[i]), leaf, bits1, op)
+		child2 = &memNode{ty: typeEmpty, prefix: appendBit(currPrefix, !bits1[i])}
+	}
+	if bits1[i] {
+		return &memNode{ty: typeInterior, left: child1, right: child2}
+	}
+	return &memNode{ty: typeInterior, left: child2, right: child1}
+}
+
+// del works like Trie.del on the nodes in memory. It returns whether the key
+// has been deleted.
+func (tb *trieBatch) del(n *memNode, depth int, op *batchOp) (*memNode, bool, error) {
+	if err := tb.load(n); err != nil {
+		return nil, false, err
+	}
+	switch n.ty {
+	case typeEmpty:
+		return n, false, nil
+	case typeLeaf:
+		if !bytes.Equal(n.key, op.k) {
+			return n, false, nil
+		}
+		tb.drop(n)
+		return &memNode{ty: typeEmpty, prefix: n.prefix}, true, nil
+	default:
+		var child *memNode
+		var deleted bool
+		var err error
+		if op.bits[depth] {
+			child, deleted, err = tb.del(n.left, depth+1, op)
+			n.left = child
+		} else {
+			child, deleted, err = tb.del(n.right, depth+1, op)
+			n.right = child
+		}
+		if err != nil || !deleted {
+			return n, false, err
+		}
+		tb.drop(n)
+		return n, true, nil
+	}
+}
+
+// hashNode computes the hashes and the encodings of the changed nodes of the
+// subtree.
+func (tb *trieBatch) hashNode(n *memNode, depth int) error {
+	if n.hash != nil {
+		return nil
+	}
+	var err error
+	switch n.ty {
+	case typeEmpty:
+		node := newEmptyNode(n.prefix)
+		n.hash = node.hash(tb.t.nonce)
+		n.buf, err = node.encode()
+	case typeLeaf:
+		node := newLeafNode(n.prefix, n.key, n.value)
+		n.hash = node.hash(tb.t.nonce)
+		n.buf, err = node.encode()
+	case typeInterior:
+		if depth < batchParallelDepth && n.left.hash == nil && n.right.hash == nil {
+			var wg sync.WaitGroup
+			var errLeft error
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				errLeft = tb.hashNode(n.left, depth+1)
+			}()
+			err = tb.hashNode(n.right, depth+1)
+			wg.Wait()
+			if errLeft != nil {
+				return errLeft
+			}
+		} else {
+			if err = tb.hashNode(n.left, depth+1); err == nil {
+				err = tb.hashNode(n.right, depth+1)
+			}
+		}
+		if err != nil {
+			return err
+		}
+		node := newInteriorNode(n.left.hash, n.right.hash)
+		n.hash = node.hash()
+		n.buf, err = node.encode()
+	default:
+		return xerrors.New("invalid node type")
+	}
+	return err
+}
+
+// write stores the new nodes of the trie, removes the replaced ones and
+// updates the root.
+func (tb *trieBatch) write(root *memNode) error {
+	var nodes []*memNode
+	var collect func(n *memNode)
+	collect = func(n *memNode) {
+		if n.buf == nil {
+			return
+		}
+		nodes = append(nodes, n)
+		if n.ty == typeInterior {
+			collect(n.left)
+			collect(n.right)
+		}
+	}
+	collect(root)
+
+	written := make(map[string]bool, len(nodes))
+	for _, n := range nodes {
+		written[string(n.hash)] = true
+	}
+	// Writing the keys in their order is much faster for bbolt, which keeps
+	// the keys of a page sorted until the transaction is committed.
+	sort.Slice(tb.removed, func(i, j int) bool {
+		return bytes.Compare(tb.removed[i], tb.removed[j]) < 0
+	})
+	sort.Slice(nodes, func(i, j int) bool {
+		return bytes.Compare(nodes[i].hash, nodes[j].hash) < 0
+	})
+	// A replaced node may come back in the same batch, for example when a
+	// key is set and deleted again.
+	for _, h := range tb.removed {
+		if written[string(h)] {
+			continue
+		}
+		if err := tb.b.Delete(h); err != nil {
+			return err
+		}
+	}
+	for _, n := range nodes {
+		if err := tb.b.Put(n.hash, n.buf); err != nil {
+			return err
+		}
+	}
+	return tb.b.Put([]byte(entryKey), root.hash)
+}
+
+func appendBit(prefix []bool, bit bool) []bool {
+	return append(append(make([]bool, 0, len(prefix)+1), prefix...), bit)
+}
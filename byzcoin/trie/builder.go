@@ -0,0 +1,194 @@
+package trie
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// builderFlush is the number of nodes a Builder keeps before writing them.
+const builderFlush = 1000
+
+// Builder creates a trie from the leaves and the empty nodes of another one,
+// given in the order of ForEachNodeAfter. As the nodes of a trie are not
+// merged back when keys are deleted, its shape, and so its root, depends on
+// its past changes. With the empty nodes, the new trie has the same shape as
+// the original one, so that their roots can be compared.
+//
+// The depth of a node depends on the node given after it, so every node is
+// written when the next one is added.
+type Builder struct {
+	t Trie
+	// last is the node given before, and lastCommon is the length of the
+	// path it has in common with the node before it.
+	last       *builderNode
+	lastCommon int
+	// stack holds the subtrees whose sibling hasn't been built yet.
+	stack  []builtNode
+	writes [][2][]byte
+}
+
+type builderNode struct {
+	// path is the path of the key of a leaf, or the prefix of an empty
+	// node.
+	path  []bool
+	empty bool
+	key   []byte
+	value []byte
+}
+
+type builtNode struct {
+	prefix []bool
+	hash   []byte
+}
+
+// NewBuilder returns a Builder that writes the trie to db, which must be
+// empty.
+func NewBuilder(db DB, nonce []byte) (*Builder, error) {
+	err := db.View(func(b Bucket) error {
+		if b.Get([]byte(nonceKey)) != nil {
+			return xerrors.New("nonce already exists")
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &Builder{
+		t:          Trie{nonce: nonce, db: db},
+		lastCommon: -1,
+	}, nil
+}
+
+// AddLeaf adds the next leaf of the trie.
+func (bd *Builder) AddLeaf(key, value []byte) error {
+	return bd.add(&builderNode{
+		path:  bd.t.binSlice(key),
+		key:   clone(key),
+		value: clone(value),
+	})
+}
+
+// AddEmpty adds the next empty node of the trie, with its path.
+func (bd *Builder) AddEmpty(prefix []bool) error {
+	if len(prefix) == 0 {
+		return xerrors.New("the root cannot be empty")
+	}
+	return bd.add(&builderNode{
+		path:  append([]bool{}, prefix...),
+		empty: true,
+	})
+}
+
+func (bd *Builder) add(n *builderNode) error {
+	if bd.last == nil {
+		bd.last = n
+		return nil
+	}
+	common := 0
+	for common < len(n.path) && common < len(bd.last.path) &&
+		n.path[common] == bd.last.path[common] {
+		common++
+	}
+	if common == len(n.path) || common == len(bd.last.path) {
+		return xerrors.New("a node is given twice or below another one")
+	}
+	// dfs goes left, the true bits, first.
+	if !bd.last.path[common] || n.path[common] {
+		return xerrors.New("the nodes are not in order")
+	}
+
+	depth := common + 1
+	if bd.lastCommon >= common {
+		depth = bd.lastCommon + 1
+	}
+	if err := bd.place(bd.last, depth); err != nil {
+		return err
+	}
+	bd.last = n
+	bd.lastCommon = common
+	return nil
+}
+
+// place writes the node at the given depth and merges the subtrees that
+// are complete.
+func (bd *Builder) place(n *builderNode, depth int) error {
+	prefix := append([]bool{}, n.path[:depth]...)
+	var hash, buf []byte
+	var err error
+	if n.empty {
+		if depth != len(n.path) {
+			return xerrors.Errorf("the empty node at depth %d should be "+
+				"at depth %d", len(n.path), depth)
+		}
+		node := newEmptyNode(prefix)
+		hash = node.hash(bd.t.nonce)
+		buf, err = node.encode()
+	} else {
+		node := newLeafNode(prefix, n.key, n.value)
+		hash = node.hash(bd.t.nonce)
+		buf, err = node.encode()
+	}
+	if err != nil {
+		return err
+	}
+	bd.writes = append(bd.writes, [2][]byte{hash, buf})
+	bd.stack = append(bd.stack, builtNode{prefix: prefix, hash: hash})
+
+	for len(bd.stack) >= 2 {
+		left := bd.stack[len(bd.stack)-2]
+		right := bd.stack[len(bd.stack)-1]
+		d := len(right.prefix)
+		if d == 0 || len(left.prefix) != d || !left.prefix[d-1] ||
+			right.prefix[d-1] || !equal(left.prefix[:d-1], right.prefix[:d-1]) {
+			break
+		}
+		node := newInteriorNode(left.hash, right.hash)
+		buf, err := node.encode()
+		if err != nil {
+			return err
+		}
+		bd.writes = append(bd.writes, [2][]byte{node.hash(), buf})
+		bd.stack = append(bd.stack[:len(bd.stack)-2],
+			builtNode{prefix: right.prefix[:d-1], hash: node.hash()})
+	}
+
+	if len(bd.writes) >= builderFlush {
+		return bd.flush()
+	}
+	return nil
+}
+
+func (bd *Builder) flush() error {
+	err := bd.t.db.Update(func(b Bucket) error {
+		for _, w := range bd.writes {
+			if err := b.Put(w[0], w[1]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	bd.writes = nil
+	return err
+}
+
+// Finish writes the last nodes and returns the new trie. It returns an error
+// if the nodes don't make a complete trie.
+func (bd *Builder) Finish() (*Trie, error) {
+	if bd.last == nil || bd.lastCommon < 0 {
+		return nil, xerrors.New("a trie has at least two nodes")
+	}
+	if err := bd.place(bd.last, bd.lastCommon+1); err != nil {
+		return nil, err
+	}
+	bd.last = nil
+	if len(bd.stack) != 1 || len(bd.stack[0].prefix) != 0 {
+		return nil, xerrors.New("the nodes don't make a complete trie")
+	}
+	bd.writes = append(bd.writes,
+		[2][]byte{[]byte(nonceKey), bd.t.nonce},
+		[2][]byte{[]byte(entryKey), bd.stack[0].hash})
+	if err := bd.flush(); err != nil {
+		return nil, err
+	}
+	t := bd.t
+	return &t, nil
+}
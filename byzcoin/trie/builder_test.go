@@ -0,0 +1,102 @@
+package trie
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/xerrors"
+)
+
+type builtTestNode struct {
+	prefix []bool
+	key    []byte
+	value  []byte
+}
+
+// Copies a trie whose keys have been deleted, by pages of ForEachNodeAfter,
+// and checks that the copy has the same root.
+func TestBuilder(t *testing.T) {
+	nonce := genNonce()
+	src, err := NewTrie(NewMemDB(), nonce)
+	require.NoError(t, err)
+	for i := 0; i < 100; i++ {
+		require.NoError(t, src.Set([]byte{byte(i)}, []byte{byte(i), 1}))
+	}
+	for i := 0; i < 100; i += 3 {
+		require.NoError(t, src.Delete([]byte{byte(i)}))
+	}
+
+	var nodes []builtTestNode
+	var path []bool
+	for {
+		var page []builtTestNode
+		err := src.ForEachNodeAfter(path, func(prefix []bool, k, v []byte) error {
+			if len(page) == 7 {
+				return errStop
+			}
+			page = append(page, builtTestNode{prefix, clone(k), clone(v)})
+			return nil
+		})
+		if err != errStop {
+			require.NoError(t, err)
+		}
+		if len(page) == 0 {
+			break
+		}
+		nodes = append(nodes, page...)
+		path = page[len(page)-1].prefix
+	}
+	var leaves int
+	for _, n := range nodes {
+		if n.key != nil {
+			leaves++
+		}
+	}
+	require.Equal(t, 66, leaves)
+	require.True(t, len(nodes) > leaves)
+
+	build := func(nodes []builtTestNode) (*Trie, error) {
+		bd, err := NewBuilder(NewMemDB(), nonce)
+		require.NoError(t, err)
+		for _, n := range nodes {
+			if n.key != nil {
+				err = bd.AddLeaf(n.key, n.value)
+			} else {
+				err = bd.AddEmpty(n.prefix)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+		return bd.Finish()
+	}
+	dst, err := build(nodes)
+	require.NoError(t, err)
+	require.Equal(t, src.GetRoot(), dst.GetRoot())
+	require.NoError(t, dst.IsValid())
+	val, err := dst.Get([]byte{1})
+	require.NoError(t, err)
+	require.Equal(t, []byte{1, 1}, val)
+
+	// A missing empty node changes the shape, and so the root.
+	for i, n := range nodes {
+		if n.key == nil {
+			missing := append(append([]builtTestNode{}, nodes[:i]...), nodes[i+1:]...)
+			other, err := build(missing)
+			require.NoError(t, err)
+			require.NotEqual(t, src.GetRoot(), other.GetRoot())
+			break
+		}
+	}
+
+	// The nodes must be in order.
+	swapped := append([]builtTestNode{}, nodes...)
+	swapped[1], swapped[2] = swapped[2], swapped[1]
+	_, err = build(swapped)
+	require.Error(t, err)
+
+	_, err = build(nodes[:1])
+	require.Error(t, err)
+}
+
+var errStop = xerrors.New("stop")
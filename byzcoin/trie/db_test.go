@@ -100,4 +100,8 @@ func testMemAndDisk(t *testing.T, f func(*testing.T, DB)) {
 	disk := newDiskDB(t)
 	defer delDiskDB(t, disk)
 	f(t, disk)
+
+	level := newLevelDB(t)
+	defer delLevelDB(t, level)
+	f(t, level)
 }
@@ -68,6 +68,26 @@ func (p *countNodeProcessor) OnInterior(n interiorNode, k, v []byte) error {
 	return nil
 }
 
+// hashSetProcessor collects the hashes of all the nodes.
+type hashSetProcessor struct {
+	hashes map[string]bool
+}
+
+func (p *hashSetProcessor) OnEmpty(n emptyNode, k, v []byte) error {
+	p.hashes[string(k)] = true
+	return nil
+}
+
+func (p *hashSetProcessor) OnLeaf(n leafNode, k, v []byte) error {
+	p.hashes[string(k)] = true
+	return nil
+}
+
+func (p *hashSetProcessor) OnInterior(n interiorNode, k, v []byte) error {
+	p.hashes[string(k)] = true
+	return nil
+}
+
 type leafCallbackProcessor struct {
 	cb func(k, v []byte) error
 }
@@ -83,3 +103,20 @@ func (p *leafCallbackProcessor) OnLeaf(n leafNode, k, v []byte) error {
 func (p *leafCallbackProcessor) OnInterior(n interiorNode, k, v []byte) error {
 	return nil
 }
+
+// nodeCallbackProcessor calls cb on the leaves and the empty nodes.
+type nodeCallbackProcessor struct {
+	cb func(prefix []bool, k, v []byte) error
+}
+
+func (p *nodeCallbackProcessor) OnEmpty(n emptyNode, k, v []byte) error {
+	return p.cb(n.Prefix, nil, nil)
+}
+
+func (p *nodeCallbackProcessor) OnLeaf(n leafNode, k, v []byte) error {
+	return p.cb(n.Prefix, n.Key, n.Value)
+}
+
+func (p *nodeCallbackProcessor) OnInterior(n interiorNode, k, v []byte) error {
+	return nil
+}
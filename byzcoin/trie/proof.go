@@ -170,3 +170,191 @@ func equal(a []bool, b []bool) bool {
 	}
 	return true
 }
+
+// GetMultiProof gets the inclusion/absence proofs of all the given keys. The
+// proofs are taken from the same version of the trie. Without keys, the proof
+// only holds the root node, so that it still shows the root of the trie.
+func (t *Trie) GetMultiProof(keys [][]byte) (*MultiProof, error) {
+	mp := &MultiProof{noHashKey: t.noHashKey}
+	seen := make(map[string]bool)
+	err := t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		mp.Nonce = clone(t.nonce)
+		if len(keys) == 0 {
+			root, err := decodeInteriorNode(clone(b.Get(rootKey)))
+			if err != nil {
+				return xerrors.Errorf("decoding root: %v", err)
+			}
+			mp.Interiors = append(mp.Interiors, root)
+			return nil
+		}
+		for _, key := range keys {
+			p := &Proof{}
+			if err := t.getProof(0, rootKey, t.binSlice(key), p, b); err != nil {
+				return err
+			}
+			for _, interior := range p.Interiors {
+				h := string(interior.hash())
+				if !seen[h] {
+					seen[h] = true
+					mp.Interiors = append(mp.Interiors, interior)
+				}
+			}
+			if p.Leaf.Key != nil {
+				h := string(p.Leaf.hash(mp.Nonce))
+				if !seen[h] {
+					seen[h] = true
+					mp.Leaves = append(mp.Leaves, p.Leaf)
+				}
+			} else {
+				h := string(p.Empty.hash(mp.Nonce))
+				if !seen[h] {
+					seen[h] = true
+					mp.Empties = append(mp.Empties, p.Empty)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mp, nil
+}
+
+// GetRoot returns the Merkle root.
+func (p *MultiProof) GetRoot() []byte {
+	if len(p.Interiors) == 0 {
+		return nil
+	}
+	return p.Interiors[0].hash()
+}
+
+// Exists checks the proof for inclusion/absence of one of its keys. An error
+// is returned if the proof doesn't hold the nodes needed for this key.
+func (p *MultiProof) Exists(key []byte) (bool, error) {
+	leaf, err := p.find(key, p.index())
+	if err != nil {
+		return false, err
+	}
+	return leaf != nil, nil
+}
+
+// ExistsAll is like Exists, but checks all the given keys at once, which is
+// faster than calling Exists for every key. An error is returned if the proof
+// is not valid for one of the keys.
+func (p *MultiProof) ExistsAll(keys [][]byte) ([]bool, error) {
+	idx := p.index()
+	exists := make([]bool, len(keys))
+	for i, key := range keys {
+		leaf, err := p.find(key, idx)
+		if err != nil {
+			return nil, xerrors.Errorf("key %x: %v", key, err)
+		}
+		exists[i] = leaf != nil
+	}
+	return exists, nil
+}
+
+// Match returns true if the proof is an existence proof for the given key.
+// It returns false on any error, or if the key is absent.
+func (p *MultiProof) Match(key []byte) bool {
+	ok, err := p.Exists(key)
+	if err != nil {
+		return false
+	}
+	return ok
+}
+
+// Get returns the value associated with the given key in the proof. If the
+// key is absent, or the proof is not valid for this key, nil is returned.
+func (p *MultiProof) Get(key []byte) []byte {
+	leaf, err := p.find(key, p.index())
+	if err != nil || leaf == nil {
+		return nil
+	}
+	return leaf.Value
+}
+
+// multiProofIndex maps the hashes of the nodes of a MultiProof to the nodes.
+type multiProofIndex struct {
+	interiors map[string]*interiorNode
+	leaves    map[string]*leafNode
+	empties   map[string]*emptyNode
+}
+
+func (p *MultiProof) index() multiProofIndex {
+	idx := multiProofIndex{
+		interiors: make(map[string]*interiorNode),
+		leaves:    make(map[string]*leafNode),
+		empties:   make(map[string]*emptyNode),
+	}
+	for i := range p.Interiors {
+		idx.interiors[string(p.Interiors[i].hash())] = &p.Interiors[i]
+	}
+	for i := range p.Leaves {
+		idx.leaves[string(p.Leaves[i].hash(p.Nonce))] = &p.Leaves[i]
+	}
+	for i := range p.Empties {
+		idx.empties[string(p.Empties[i].hash(p.Nonce))] = &p.Empties[i]
+	}
+	return idx
+}
+
+// find follows the path of the key from the root of the proof. It returns the
+// leaf node of the key, or nil if the proof shows that the key is absent.
+func (p *MultiProof) find(key []byte, idx multiProofIndex) (*leafNode, error) {
+	if key == nil {
+		return nil, xerrors.New("key is nil")
+	}
+	if len(p.Interiors) == 0 {
+		return nil, xerrors.New("no interior nodes")
+	}
+
+	bits := p.binSlice(key)
+	expectedHash := p.Interiors[0].hash()
+	var depth int
+	for {
+		n, ok := idx.interiors[string(expectedHash)]
+		if !ok {
+			break
+		}
+		if depth >= len(bits) {
+			return nil, xerrors.New("invalid hash chain")
+		}
+		if bits[depth] {
+			expectedHash = n.Left
+		} else {
+			expectedHash = n.Right
+		}
+		depth++
+	}
+
+	if leaf, ok := idx.leaves[string(expectedHash)]; ok {
+		if !equal(bits[:depth], leaf.Prefix) {
+			return nil, xerrors.New("invalid prefix in leaf node")
+		}
+		if !bytes.Equal(leaf.Key, key) {
+			return nil, nil
+		}
+		return leaf, nil
+	}
+	if empty, ok := idx.empties[string(expectedHash)]; ok {
+		if !equal(bits[:depth], empty.Prefix) {
+			return nil, xerrors.New("invalid prefix in empty node")
+		}
+		return nil, nil
+	}
+	return nil, xerrors.New("missing edge node")
+}
+
+func (p *MultiProof) binSlice(buf []byte) []bool {
+	if p.noHashKey {
+		return toBinSlice(buf)
+	}
+	hashKey := sha256.Sum256(buf)
+	return toBinSlice(hashKey[:])
+}
@@ -71,6 +71,63 @@ func testProof(t *testing.T, db DB) {
 
 }
 
+func TestMultiProof(t *testing.T) {
+	testMemAndDisk(t, testMultiProof)
+}
+
+func testMultiProof(t *testing.T, db DB) {
+	testTrie, err := NewTrie(db, genNonce())
+	require.NoError(t, err)
+	var keys [][]byte
+	for i := 0; i < 20; i++ {
+		k := []byte{byte(i)}
+		if i >= 10 {
+			require.NoError(t, testTrie.Set(k, k))
+		}
+		keys = append(keys, k)
+	}
+
+	mp, err := testTrie.GetMultiProof(keys)
+	require.NoError(t, err)
+	require.Equal(t, testTrie.GetRoot(), mp.GetRoot())
+	var single int
+	for i, k := range keys {
+		ok, err := mp.Exists(k)
+		require.NoError(t, err)
+		require.Equal(t, i >= 10, ok)
+		if ok {
+			require.Equal(t, k, mp.Get(k))
+		}
+		p, err := testTrie.GetProof(k)
+		require.NoError(t, err)
+		single += len(p.Interiors)
+	}
+	require.True(t, len(mp.Interiors) < single)
+	exists, err := mp.ExistsAll(keys)
+	require.NoError(t, err)
+	for i := range keys {
+		require.Equal(t, i >= 10, exists[i])
+	}
+
+	// Keys that are not covered by the proof, or a tampered value, are refused.
+	partial, err := testTrie.GetMultiProof(keys[10:12])
+	require.NoError(t, err)
+	_, err = partial.Exists(keys[15])
+	require.Error(t, err)
+	_, err = partial.ExistsAll(keys[10:16])
+	require.Error(t, err)
+	mp.Leaves[0].Value = []byte{0xff}
+	_, err = mp.Exists(mp.Leaves[0].Key)
+	require.Error(t, err)
+
+	// Without keys, the proof still shows the root.
+	empty, err := testTrie.GetMultiProof(nil)
+	require.NoError(t, err)
+	require.Equal(t, testTrie.GetRoot(), empty.GetRoot())
+	_, err = empty.Exists(keys[0])
+	require.Error(t, err)
+}
+
 type disjointSet struct {
 	A [][]byte
 	B [][]byte
@@ -28,3 +28,13 @@ type Proof struct {
 	Nonce     []byte
 	noHashKey bool
 }
+
+// MultiProof contains the inclusion/absence proofs of several keys. The nodes
+// that are shared between the proofs are only stored once.
+type MultiProof struct {
+	Interiors []interiorNode
+	Leaves    []leafNode
+	Empties   []emptyNode
+	Nonce     []byte
+	noHashKey bool
+}
@@ -149,26 +149,13 @@ func (t *StagingTrie) Batch(pairs []KVPair) error {
 }
 
 // Commit commits all operations performed on the StagingTrie since creation
-// or the previous commit to the source Trie.
+// or the previous commit to the source Trie. The operations are applied in a
+// single batch, which gives the same root as applying them one by one.
 func (t *StagingTrie) Commit() error {
 	t.Lock()
 	defer t.Unlock()
 	err := t.source.db.Update(func(b Bucket) error {
-		for _, instr := range t.instrList {
-			switch instr.ty {
-			case OpSet:
-				if err := t.source.SetWithBucket(instr.k, instr.v, b); err != nil {
-					return err
-				}
-			case OpDel:
-				if err := t.source.DeleteWithBucket(instr.k, b); err != nil {
-					return err
-				}
-			default:
-				return xerrors.New("invalid instruction during commit")
-			}
-		}
-		return nil
+		return t.source.applyInstrs(t.instrList, b)
 	})
 	if err != nil {
 		return err
@@ -185,19 +172,8 @@ func (t *StagingTrie) GetRoot() []byte {
 	defer t.Unlock()
 	var root []byte
 	err := t.source.db.UpdateDryRun(func(b Bucket) error {
-		for _, instr := range t.instrList {
-			switch instr.ty {
-			case OpSet:
-				if err := t.source.SetWithBucket(instr.k, instr.v, b); err != nil {
-					return err
-				}
-			case OpDel:
-				if err := t.source.DeleteWithBucket(instr.k, b); err != nil {
-					return err
-				}
-			default:
-				return xerrors.New("invalid instruction during get root")
-			}
+		if err := t.source.applyInstrs(t.instrList, b); err != nil {
+			return err
 		}
 		root = clone(t.source.GetRootWithBucket(b))
 		return nil
@@ -215,19 +191,8 @@ func (t *StagingTrie) GetProof(key []byte) (*Proof, error) {
 	p := &Proof{}
 	err := t.source.db.UpdateDryRun(func(b Bucket) error {
 		// run the pending instructions
-		for _, instr := range t.instrList {
-			switch instr.ty {
-			case OpSet:
-				if err := t.source.SetWithBucket(instr.k, instr.v, b); err != nil {
-					return err
-				}
-			case OpDel:
-				if err := t.source.DeleteWithBucket(instr.k, b); err != nil {
-					return err
-				}
-			default:
-				return xerrors.New("invalid instruction during get proof")
-			}
+		if err := t.source.applyInstrs(t.instrList, b); err != nil {
+			return err
 		}
 		// create the proof
 		rootKey := t.source.GetRootWithBucket(b)
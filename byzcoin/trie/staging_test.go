@@ -3,6 +3,7 @@ package trie
 import (
 	"bytes"
 	"fmt"
+	"math/rand"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -459,3 +460,57 @@ func testStagingBatch(t *testing.T, db DB) {
 	require.NoError(t, sTrie2.Batch(pairs))
 	require.Equal(t, root1, sTrie2.GetRoot())
 }
+
+func TestStagingCommitSameAsSequential(t *testing.T) {
+	testMemAndDisk(t, func(t *testing.T, db DB) {
+		testStagingCommitSameAsSequential(t, db, true)
+	})
+	testMemAndDisk(t, func(t *testing.T, db DB) {
+		testStagingCommitSameAsSequential(t, db, false)
+	})
+}
+
+// testStagingCommitSameAsSequential checks that committing a staging trie
+// gives the same nodes as setting and deleting the keys one by one, even when
+// the same keys are set and deleted many times in a batch.
+func testStagingCommitSameAsSequential(t *testing.T, db DB, noHashKey bool) {
+	nonce := genNonce()
+	batchTrie, err := NewTrie(db, nonce)
+	require.NoError(t, err)
+	batchTrie.noHashKey = noHashKey
+	seqTrie, err := NewTrie(NewMemDB(), nonce)
+	require.NoError(t, err)
+	seqTrie.noHashKey = noHashKey
+
+	rnd := rand.New(rand.NewSource(1))
+	sTrie := batchTrie.MakeStagingTrie()
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 500; i++ {
+			k := []byte{byte(rnd.Intn(256)), byte(rnd.Intn(4))}
+			if rnd.Intn(3) == 0 {
+				require.NoError(t, sTrie.Delete(k))
+				require.NoError(t, seqTrie.Delete(k))
+			} else {
+				v := []byte{byte(round), byte(i)}
+				require.NoError(t, sTrie.Set(k, v))
+				require.NoError(t, seqTrie.Set(k, v))
+			}
+		}
+		require.Equal(t, seqTrie.GetRoot(), sTrie.GetRoot())
+		require.NoError(t, sTrie.Commit())
+		require.Equal(t, seqTrie.GetRoot(), batchTrie.GetRoot())
+		require.Equal(t, dumpDB(t, seqTrie.DB()), dumpDB(t, batchTrie.DB()))
+		require.NoError(t, batchTrie.IsValid())
+	}
+}
+
+func dumpDB(t *testing.T, db DB) map[string]string {
+	kvs := make(map[string]string)
+	require.NoError(t, db.View(func(b Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			kvs[string(k)] = string(v)
+			return nil
+		})
+	}))
+	return kvs
+}
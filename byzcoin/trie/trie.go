@@ -2,7 +2,6 @@ package trie
 
 import (
 	"bytes"
-	"crypto/sha256"
 
 	"golang.org/x/xerrors"
 )
@@ -572,16 +571,136 @@ func (t *Trie) del(depth int, nodeKey []byte, bits []bool, key []byte, b Bucket)
 // iteration stops and the function returns an error when the callback returns
 // an error.
 func (t *Trie) ForEach(cb func(k, v []byte) error) error {
+	return t.db.View(func(b Bucket) error {
+		return t.ForEachWithBucket(cb, b)
+	})
+}
+
+// ForEachWithBucket runs the callback cb on every key/value pair of the trie
+// in an existing transaction. The buffers given to the callback are only valid
+// during the transaction.
+func (t *Trie) ForEachWithBucket(cb func(k, v []byte) error, b Bucket) error {
 	p := leafCallbackProcessor{cb}
+	rootKey := t.GetRootWithBucket(b)
+	if rootKey == nil {
+		return xerrors.New("no root key")
+	}
+	return t.dfs(&p, rootKey, b)
+}
+
+// ForEachAfter runs the callback cb on the key/value pairs that come after the
+// given key, in the same order as ForEach. The key itself doesn't need to be
+// in the trie, so that an iteration can be resumed even if the last key it
+// visited has been deleted since. If key is nil, all the pairs are visited.
+func (t *Trie) ForEachAfter(key []byte, cb func(k, v []byte) error) error {
+	if key == nil {
+		return t.ForEach(cb)
+	}
 	return t.db.View(func(b Bucket) error {
 		rootKey := t.GetRootWithBucket(b)
 		if rootKey == nil {
 			return xerrors.New("no root key")
 		}
-		return t.dfs(&p, rootKey, b)
+		return t.forEachAfter(0, rootKey, t.binSlice(key), cb, b)
+	})
+}
+
+// forEachAfter visits the leaves below nodeKey whose path comes after bits.
+// The nodes that are entirely before bits are skipped without being read.
+func (t *Trie) forEachAfter(depth int, nodeKey []byte, bits []bool,
+	cb func(k, v []byte) error, b Bucket) error {
+	nodeVal := b.Get(nodeKey)
+	if len(nodeVal) == 0 {
+		return xerrors.New("invalid node key")
+	}
+	switch nodeType(nodeVal[0]) {
+	case typeEmpty:
+		return nil
+	case typeLeaf:
+		node, err := decodeLeafNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		if !pathAfter(t.binSlice(node.Key), bits) {
+			return nil
+		}
+		return cb(node.Key, node.Value)
+	case typeInterior:
+		node, err := decodeInteriorNode(nodeVal)
+		if err != nil {
+			return err
+		}
+		if !bits[depth] {
+			// everything on the left comes before
+			return t.forEachAfter(depth+1, node.Right, bits, cb, b)
+		}
+		if err := t.forEachAfter(depth+1, node.Left, bits, cb, b); err != nil {
+			return err
+		}
+		p := leafCallbackProcessor{cb}
+		return t.dfs(&p, node.Right, b)
+	}
+	return xerrors.New("invalid node type")
+}
+
+// pathAfter returns true if the path a is visited after the path b by dfs,
+// which goes left, the true bits, first.
+func pathAfter(a, b []bool) bool {
+	for i := range a {
+		if i >= len(b) {
+			return false
+		}
+		if a[i] != b[i] {
+			return b[i]
+		}
+	}
+	return false
+}
+
+// ForEachNodeAfter runs the callback cb on the leaves and the empty nodes
+// whose path comes after the given one, in the same order as ForEach. The
+// callback gets the path of the node, and a nil key and value for an empty
+// node. Giving the path of the last node visited resumes the iteration. If
+// path is nil, all the nodes are visited. The nodes can be given to a
+// Builder to copy the trie with its shape.
+func (t *Trie) ForEachNodeAfter(path []bool, cb func(prefix []bool, k, v []byte) error) error {
+	p := nodeCallbackProcessor{cb}
+	return t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		if path == nil {
+			return t.dfs(&p, rootKey, b)
+		}
+		return t.forEachNodeAfter(0, rootKey, path, &p, b)
 	})
 }
 
+// forEachNodeAfter visits the nodes below nodeKey whose path comes after the
+// given one. The nodes on the path itself are not visited.
+func (t *Trie) forEachNodeAfter(depth int, nodeKey []byte, path []bool,
+	p *nodeCallbackProcessor, b Bucket) error {
+	nodeVal := b.Get(nodeKey)
+	if len(nodeVal) == 0 {
+		return xerrors.New("invalid node key")
+	}
+	if nodeType(nodeVal[0]) != typeInterior || depth >= len(path) {
+		return nil
+	}
+	node, err := decodeInteriorNode(nodeVal)
+	if err != nil {
+		return err
+	}
+	if !path[depth] {
+		return t.forEachNodeAfter(depth+1, node.Right, path, p, b)
+	}
+	if err := t.forEachNodeAfter(depth+1, node.Left, path, p, b); err != nil {
+		return err
+	}
+	return t.dfs(p, node.Right, b)
+}
+
 // IsValid checks whether the trie is valid.
 func (t *Trie) IsValid() error {
 	p := countNodeProcessor{}
@@ -632,6 +751,43 @@ func (t *Trie) IsValid() error {
 	return nil
 }
 
+// VerifyNodes checks that every node of the trie is stored under its hash and
+// that the database holds no other node. Unlike IsValid, it accepts metadata
+// entries, whose keys are always shorter than a hash. It is meant to be used
+// on a trie that has been copied from an untrusted source, before comparing
+// its root with a trusted one.
+func (t *Trie) VerifyNodes() error {
+	p := hashSetProcessor{hashes: make(map[string]bool)}
+	return t.db.View(func(b Bucket) error {
+		rootKey := t.GetRootWithBucket(b)
+		if rootKey == nil {
+			return xerrors.New("no root key")
+		}
+		if err := t.dfs(&p, rootKey, b); err != nil {
+			return err
+		}
+
+		var nodes int
+		err := b.ForEach(func(k, v []byte) error {
+			if len(k) <= metaMaxLen {
+				return nil
+			}
+			if !p.hashes[string(k)] {
+				return xerrors.Errorf("node %x is not stored under its hash", k)
+			}
+			nodes++
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if nodes != len(p.hashes) {
+			return xerrors.New("some nodes are not stored under their hash")
+		}
+		return nil
+	})
+}
+
 // getRaw gets the value, it returns nil if the value does not exist.
 func (t *Trie) getRaw(key []byte) ([]byte, error) {
 	var val []byte
@@ -647,11 +803,7 @@ func (t *Trie) getRaw(key []byte) ([]byte, error) {
 }
 
 func (t *Trie) binSlice(buf []byte) []bool {
-	if t.noHashKey {
-		return toBinSlice(buf)
-	}
-	hashKey := sha256.Sum256(buf)
-	return toBinSlice(hashKey[:])
+	return toBinSlice(t.pathOf(buf))
 }
 
 func toBinSlice(buf []byte) []bool {
@@ -8,12 +8,15 @@ import (
 	"testing/quick"
 
 	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+	"go.dedis.ch/cothority/v3/storage"
 	bbolt "go.etcd.io/bbolt"
 	"golang.org/x/xerrors"
 )
 
 const testDBName = "test_trie.db"
 const bucketName = "test_trie_bucket"
+const testLevelDBName = "test_trie.leveldb"
 
 func TestNewTrie(t *testing.T) {
 	testMemAndDisk(t, testNewTrie)
@@ -330,6 +333,83 @@ func TestIsValid(t *testing.T) {
 	require.NotNil(t, testTrie.IsValid())
 }
 
+func TestVerifyNodes(t *testing.T) {
+	mem := NewMemDB()
+	defer mem.Close()
+
+	testTrie, err := NewTrie(mem, genNonce())
+	require.NoError(t, err)
+	require.NoError(t, testTrie.Set([]byte{0xff}, []byte{0xff}))
+	require.NoError(t, testTrie.Set([]byte{0xdf}, []byte{0xdf}))
+	require.NoError(t, testTrie.SetMetadata([]byte("index"), []byte{1}))
+	require.NoError(t, testTrie.VerifyNodes())
+
+	// A node that is not reachable from the root is refused.
+	dangling := newEmptyNode([]bool{true, true, true})
+	buf, err := dangling.encode()
+	require.NoError(t, err)
+	err = mem.Update(func(b Bucket) error {
+		return b.Put(dangling.hash(testTrie.nonce), buf)
+	})
+	require.NoError(t, err)
+	require.Error(t, testTrie.VerifyNodes())
+	err = mem.Update(func(b Bucket) error {
+		return b.Delete(dangling.hash(testTrie.nonce))
+	})
+	require.NoError(t, err)
+	require.NoError(t, testTrie.VerifyNodes())
+
+	// A node stored under the wrong hash is refused.
+	p, err := testTrie.GetProof([]byte{0xff})
+	require.NoError(t, err)
+	err = mem.Update(func(b Bucket) error {
+		leaf := p.Leaf
+		leaf.Value = []byte{0xdf}
+		buf, err := leaf.encode()
+		if err != nil {
+			return err
+		}
+		return b.Put(p.Leaf.hash(testTrie.nonce), buf)
+	})
+	require.NoError(t, err)
+	require.Error(t, testTrie.VerifyNodes())
+}
+
+func TestForEachAfter(t *testing.T) {
+	mem := NewMemDB()
+	defer mem.Close()
+
+	testTrie, err := NewTrie(mem, genNonce())
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, testTrie.Set([]byte{byte(i)}, []byte{byte(i)}))
+	}
+	var all [][]byte
+	require.NoError(t, testTrie.ForEach(func(k, v []byte) error {
+		all = append(all, k)
+		return nil
+	}))
+	require.Equal(t, 50, len(all))
+
+	after := func(key []byte) [][]byte {
+		var keys [][]byte
+		require.NoError(t, testTrie.ForEachAfter(key, func(k, v []byte) error {
+			keys = append(keys, k)
+			return nil
+		}))
+		return keys
+	}
+	require.Equal(t, all, after(nil))
+	for i, k := range all[:len(all)-1] {
+		require.Equal(t, all[i+1:], after(k), "after key %d", i)
+	}
+	require.Empty(t, after(all[len(all)-1]))
+
+	// The iteration resumes at the right place even if the key is gone.
+	require.NoError(t, testTrie.Delete(all[10]))
+	require.Equal(t, all[11:], after(all[10]))
+}
+
 func TestQuickCheck(t *testing.T) {
 	mem := NewMemDB()
 	defer mem.Close()
@@ -530,6 +610,17 @@ func delDiskDB(t *testing.T, db DB) {
 	require.NoError(t, os.Remove(testDBName))
 }
 
+func newLevelDB(t *testing.T) DB {
+	db, err := leveldb.OpenFile(testLevelDBName, nil)
+	require.NoError(t, err)
+	return storage.NewLevelDB(db, []byte(bucketName))
+}
+
+func delLevelDB(t *testing.T, db DB) {
+	require.NoError(t, db.Close())
+	require.NoError(t, os.RemoveAll(testLevelDBName))
+}
+
 func getRootNode(t *testing.T, db DB) interiorNode {
 	var root interiorNode
 	err := db.View(func(b Bucket) error {
@@ -2,41 +2,57 @@ package byzcoin
 
 import (
 	"bytes"
+	"sync"
+	"time"
+
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
 	"go.dedis.ch/protobuf"
 	"golang.org/x/xerrors"
-	"sync"
 )
 
 // maxTxHashes of ClientTransactions are kept to early reject already sent
 // ClientTransactions.
 var maxTxHashes = 1000
 
+// forwardRetries is how often a ClientTransaction is sent to the new leader
+// after a handover, before giving up.
+var forwardRetries = 3
+
+// scheduledCheckInterval is how often the leader looks for scheduled
+// transactions that are due.
+var scheduledCheckInterval = time.Second
+
 // txPipeline gathers new ClientTransactions and VersionUpdate requests,
-// and queues them up to be proposed as new blocks.
+// and keeps them in a mempool until they are proposed as new blocks.
 // With VersionRollup and newer,
 // the nodes send the ClientTransactions directly to the leader,
 // which queues them up, and proposes them to the nodes for signing.
 type txPipeline struct {
-	ctxChan     chan ClientTransaction
-	needUpgrade chan Version
-	stopCollect chan bool
-	newVersion  Version
-	txQueue     []ClientTransaction
-	wg          sync.WaitGroup
-	processor   txProcessor
+	ctxChan      chan ClientTransaction
+	needUpgrade  chan Version
+	needHandover chan bool
+	stopCollect  chan bool
+	newVersion   Version
+	handover     bool
+	mempool      *mempool
+	wg           sync.WaitGroup
+	processor    txProcessor
 }
 
 // newTxPipeline returns an initialized txPipeLine with a byzcoin-service
 // enabled txProcessor.
 func newTxPipeline(s *Service, latest *skipchain.SkipBlock) *txPipeline {
 	return &txPipeline{
-		ctxChan:     make(chan ClientTransaction, 200),
-		needUpgrade: make(chan Version, 1),
-		stopCollect: make(chan bool),
-		wg:          sync.WaitGroup{},
+		ctxChan:      make(chan ClientTransaction, 200),
+		needUpgrade:  make(chan Version, 1),
+		needHandover: make(chan bool, 1),
+		stopCollect:  make(chan bool),
+		mempool:      newMempool(),
+		wg:           sync.WaitGroup{},
 		processor: &defaultTxProcessor{
 			Service: s,
 			scID:    latest.SkipChainID(),
@@ -45,7 +61,7 @@ func newTxPipeline(s *Service, latest *skipchain.SkipBlock) *txPipeline {
 	}
 }
 
-// start listens for new ClientTransactions and queues them up.
+// start listens for new ClientTransactions and adds them to the mempool.
 // It also listens for update requests and puts them in the queue.
 // If a block is pending for approval,
 // the next block is already in the channel.
@@ -57,6 +73,13 @@ func (p *txPipeline) start(currentState *proposedTransactions,
 	// Stores the known transaction-hashes to avoid double-inclusion of the
 	// same transaction.
 	var txHashes [][]byte
+	// Stores the hashes of the scheduled transactions added since the last
+	// block, as they are only executed once the block is stored.
+	scheduled := make(map[string]bool)
+	scheduledTicker := time.NewTicker(scheduledCheckInterval)
+	defer scheduledTicker.Stop()
+	healthTicker := time.NewTicker(rosterHealthInterval)
+	defer healthTicker.Stop()
 
 	// newBlock also serves as cache for the latest proposedTransactions: if the
 	// new block hasn't been produced, it is legit to read the channel,
@@ -77,6 +100,26 @@ leaderLoop:
 		case <-blockSent:
 			// A block has been proposed and either accepted or rejected.
 			p.newVersion = 0
+			p.handover = false
+			scheduled = make(map[string]bool)
+
+		case <-p.needHandover:
+			// The leader is asked to hand over before its epoch is over.
+			p.handover = true
+
+		case <-scheduledTicker.C:
+			// Scheduled transactions are not signed, so they always have
+			// the same hash for the same execution.
+			for _, tx := range p.processor.GetScheduledTransactions() {
+				txh := string(tx.Instructions.Hash())
+				if !scheduled[txh] {
+					scheduled[txh] = true
+					p.addToMempool(tx)
+				}
+			}
+
+		case <-healthTicker.C:
+			p.processor.CheckRosterHealth()
 
 		case version := <-p.needUpgrade:
 			// An upgrade of the system-version is needed.
@@ -91,7 +134,7 @@ leaderLoop:
 
 		case tx := <-p.ctxChan:
 			// A new ClientTransaction comes in - check if it's unique and
-			// put it in the queue if it is.
+			// put it in the mempool if it is.
 			txh := tx.Instructions.HashWithSignatures()
 			for _, txHash := range txHashes {
 				if bytes.Equal(txHash, txh) {
@@ -104,7 +147,7 @@ leaderLoop:
 				txHashes = txHashes[len(txHashes)-maxTxHashes:]
 			}
 
-			p.txQueue = append(p.txQueue, tx)
+			p.addToMempool(tx)
 		}
 
 		// Check if a block is pending, fetch it if it's the case
@@ -123,23 +166,61 @@ leaderLoop:
 				sst: currentState.sst}
 			// This will be mostly a no-op in case there are no transactions
 			// waiting...
-			txs := make([]ClientTransaction, len(currentState.txs))
-			for i, txRes := range currentState.txs {
-				txs[i] = txRes.ClientTransaction
+			for _, txRes := range currentState.txs {
+				p.addToMempool(txRes.ClientTransaction)
+			}
+			continue
+		}
+
+		// Same for a handover, the ClientTransactions will go to the
+		// new leader.
+		if p.handover {
+			newBlock <- &proposedTransactions{handover: true,
+				sst: currentState.sst}
+			for _, txRes := range currentState.txs {
+				p.addToMempool(txRes.ClientTransaction)
 			}
-			p.txQueue = append(txs, p.txQueue...)
 			continue
 		}
 
 		// Add as many ClientTransactions as possible to the proposedTransactions
 		// before the block gets too big, then put it in the channel.
-		p.txQueue = currentState.addTransactions(p.processor, p.txQueue)
+		pending := p.mempool.ordered()
+		txs := make([]ClientTransaction, len(pending))
+		for i, mtx := range pending {
+			txs[i] = mtx.tx
+		}
+		left := currentState.addTransactions(p.processor, txs)
+		p.mempool.remove(pending[:len(pending)-len(left)])
 		if !currentState.isEmpty() {
 			newBlock <- currentState.copy()
 			currentState.reset()
 		}
 	}
 	p.wg.Wait()
+
+	// If another node is the leader now, it gets the waiting
+	// ClientTransactions.
+	pending := p.mempool.ordered()
+	if len(pending) > 0 {
+		txs := make([]ClientTransaction, len(pending))
+		for i, mtx := range pending {
+			txs[i] = mtx.tx
+		}
+		p.processor.ForwardTransactions(txs)
+	}
+}
+
+// addToMempool adds the transaction to the mempool. If it is refused, the
+// reason is only logged, as the client doesn't wait for the pipeline.
+func (p *txPipeline) addToMempool(tx ClientTransaction) {
+	mtx, err := newMempoolTx(tx, p.processor.GetFeeConfig() != nil)
+	if err == nil {
+		err = p.mempool.add(mtx)
+	}
+	if err != nil {
+		log.Warnf("Refusing transaction %x: %v", tx.Instructions.Hash(), err)
+	}
 }
 
 // createBlocks is the background routine that listens for new blocks and
@@ -149,13 +230,35 @@ leaderLoop:
 func (p *txPipeline) createBlocks(newBlock chan *proposedTransactions,
 	blockSent chan struct{}) {
 	defer p.wg.Done()
+	// Once the leadership is handed over, no more blocks are proposed by
+	// this node.
+	handedOver := false
 	for {
 		inState, ok := <-newBlock
 		if !ok {
 			break
 		}
 
-		if inState.isVersionUpdate() {
+		if handedOver || inState.handover || p.processor.HandoverDue() {
+			// The ClientTransactions wait in the mempool until the
+			// pipeline stops, and are then forwarded to the new leader.
+			for _, txRes := range inState.txs {
+				p.addToMempool(txRes.ClientTransaction)
+			}
+			if handedOver {
+				// Don't signal, or the same transactions would be
+				// proposed over and over until the pipeline stops.
+				continue
+			}
+			err := p.processor.ProposeHandover()
+			if err != nil {
+				// If the next node cannot take over, the other
+				// nodes will start a view-change.
+				log.Error("failed to hand over:", err)
+			} else {
+				handedOver = true
+			}
+		} else if inState.isVersionUpdate() {
 			// Create an upgrade block for the next version
 			err := p.processor.ProposeUpgradeBlock(inState.newVersion)
 			if err != nil {
@@ -199,6 +302,25 @@ type txProcessor interface {
 	GetBlockSize() int
 	// Returns the current version of ByzCoin as per the stateTrie
 	GetVersion() (Version, error)
+	// GetFeeConfig returns the fee configuration of the chain, or nil if
+	// no fees are charged.
+	GetFeeConfig() *FeeConfig
+	// GetScheduledTransactions returns the transactions executing the
+	// scheduled instructions that are due in the next block.
+	GetScheduledTransactions() []ClientTransaction
+	// HandoverDue returns true if the epoch of the leader is over, so
+	// that the next block must be proposed by the next node.
+	HandoverDue() bool
+	// ProposeHandover should create a block giving the leadership to the
+	// next node of the roster.
+	ProposeHandover() error
+	// ForwardTransactions sends the transactions to the leader, if this
+	// node is not the leader anymore.
+	ForwardTransactions([]ClientTransaction)
+	// CheckRosterHealth checks the connectivity of the nodes of the
+	// roster in the background, and proposes to evict the unresponsive
+	// ones.
+	CheckRosterHealth()
 }
 
 // defaultTxProcessor is an implementation of txProcessor that uses a
@@ -208,6 +330,9 @@ type defaultTxProcessor struct {
 	*Service
 	scID skipchain.SkipBlockID
 	sync.Mutex
+	// healthCheck makes sure that only one connectivity check runs at a
+	// time.
+	healthCheck runSingleWG
 }
 
 func (s *defaultTxProcessor) ProcessTx(sst *stagingStateTrie,
@@ -252,6 +377,100 @@ func (s *defaultTxProcessor) GetBlockSize() int {
 	return bcConfig.MaxBlockSize
 }
 
+func (s *defaultTxProcessor) GetFeeConfig() *FeeConfig {
+	bcConfig, err := s.LoadConfig(s.scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get configuration:", err)
+		return nil
+	}
+	return bcConfig.FeeConfig
+}
+
+func (s *defaultTxProcessor) GetScheduledTransactions() []ClientTransaction {
+	st, err := s.getStateTrie(s.scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get state trie:", err)
+		return nil
+	}
+	txs, ok := s.schedulerIndex.due(s.scID, st, uint64(st.GetIndex()+1),
+		time.Now().UnixNano())
+	if !ok {
+		// The trie has been replaced since the index has been built.
+		s.buildSchedulerIndex(s.scID, st)
+	}
+	return txs
+}
+
+func (s *defaultTxProcessor) HandoverDue() bool {
+	st, err := s.getStateTrie(s.scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get state trie:", err)
+		return false
+	}
+	bcConfig, err := st.LoadConfig()
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get configuration:", err)
+		return false
+	}
+	return bcConfig.handoverDue(st.GetIndex() + 1)
+}
+
+func (s *defaultTxProcessor) ProposeHandover() error {
+	return cothority.ErrorOrNil(s.createHandoverBlock(s.scID), "handover")
+}
+
+func (s *defaultTxProcessor) ForwardTransactions(txs []ClientTransaction) {
+	leader, err := s.getLeader(s.scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get leader:", err)
+		return
+	}
+	if leader.Equal(s.ServerIdentity()) {
+		return
+	}
+	interval, _, err := s.LoadBlockInfo(s.scID)
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't get block info:", err)
+		return
+	}
+	if !s.tasks.add(1) {
+		return
+	}
+	go func() {
+		defer s.tasks.done()
+		cl := NewClient(s.scID, *onet.NewRoster([]*network.ServerIdentity{leader}))
+		for _, tx := range txs {
+			// The new leader might not have started its pipeline yet.
+			var err error
+			for i := 0; i < forwardRetries; i++ {
+				if _, err = cl.AddTransaction(tx); err == nil {
+					break
+				}
+				time.Sleep(interval)
+			}
+			if err != nil {
+				log.Warnf("%s couldn't forward transaction %x to %s: %v",
+					s.ServerIdentity(), tx.Instructions.Hash(), leader, err)
+			}
+		}
+	}()
+}
+
+func (s *defaultTxProcessor) CheckRosterHealth() {
+	if !s.healthCheck.start() {
+		return
+	}
+	if !s.tasks.add(1) {
+		s.healthCheck.done()
+		return
+	}
+	go func() {
+		defer s.tasks.done()
+		defer s.healthCheck.done()
+		s.checkRosterHealth(s.scID)
+	}()
+}
+
 func (s *defaultTxProcessor) GetVersion() (Version, error) {
 	st, err := s.Service.getStateTrie(s.scID)
 	if err != nil {
@@ -268,6 +487,7 @@ type proposedTransactions struct {
 	scs        StateChanges
 	txs        TxResults
 	newVersion Version
+	handover   bool
 }
 
 // size returns the size of the transactions in this state,
@@ -305,6 +525,7 @@ func (s proposedTransactions) copy() *proposedTransactions {
 		append([]StateChange{}, s.scs...),
 		append([]TxResult{}, s.txs...),
 		0,
+		false,
 	}
 }
 
@@ -325,10 +546,18 @@ func (s *proposedTransactions) addTransactions(p txProcessor,
 	for len(txs) > 0 {
 		tx := txs[0]
 		newScs, newSst, err := p.ProcessTx(s.sst, tx)
+		if err != nil {
+			// A refused transaction still pays for its instructions, like
+			// in createStateChanges, so that the next transactions of its
+			// signers see the same counters and fee coins.
+			newScs, newSst = chargeRefusedTx(s.sst, tx)
+		}
 		txRes := TxResult{
 			ClientTransaction: tx,
 			Accepted:          err == nil,
 		}
+		// The events are part of the block.
+		txRes.setStateChanges(newScs)
 
 		// If the resulting block would be too big,
 		// simply skip this and all remaining transactions.
@@ -336,10 +565,8 @@ func (s *proposedTransactions) addTransactions(p txProcessor,
 			break
 		}
 
-		if txRes.Accepted {
-			s.sst = newSst
-			s.scs = append(s.scs, newScs...)
-		}
+		s.sst = newSst
+		s.scs = append(s.scs, newScs...)
 		s.txs = append(s.txs, txRes)
 		txs = txs[1:]
 	}
@@ -35,7 +35,7 @@ func (w Write) String() string {
 	fmt.Fprintf(out, "-- C: %s\n", w.C)
 	fmt.Fprintf(out, "-- ExtraData: %s\n", w.ExtraData)
 	fmt.Fprintf(out, "-- LTSID: %s\n", w.LTSID)
-	fmt.Fprintf(out, "-- Cost: %x\n", w.Cost)
+	fmt.Fprintf(out, "-- Cost: {%x %x}\n", w.Cost.Name, w.Cost.Value)
 
 	return out.String()
 }
@@ -23,12 +23,16 @@ import (
 	"reflect"
 	"time"
 
+	"github.com/BurntSushi/toml"
 	cli "github.com/urfave/cli"
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
 	_ "go.dedis.ch/cothority/v3/evoting/service"
 	_ "go.dedis.ch/cothority/v3/personhood"
 	_ "go.dedis.ch/cothority/v3/skipchain"
 	status "go.dedis.ch/cothority/v3/status/service"
+	"go.dedis.ch/cothority/v3/storage"
+	_ "go.dedis.ch/cothority/v3/wasm"
 	"go.dedis.ch/kyber/v3/util/encoding"
 	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3/app"
@@ -151,10 +155,42 @@ func runServer(ctx *cli.Context) error {
 	if raiseFdLimit != nil {
 		raiseFdLimit()
 	}
+	if err := configureServices(config); err != nil {
+		return err
+	}
 	app.RunServer(config)
 	return nil
 }
 
+// servicesConfig holds the options of the services in the config file of the
+// conode, next to the ones of onet. They are applied before the services are
+// started.
+type servicesConfig struct {
+	Storage struct {
+		// Engine is the storage engine of the skipblocks, and of the state
+		// tries and the receipts of ByzCoin, either bbolt or leveldb.
+		Engine string
+	}
+	ByzCoin byzcoin.NodeConfig
+}
+
+// configureServices reads the options of the services from the config file.
+// A missing file is reported by app.RunServer.
+func configureServices(config string) error {
+	if _, err := os.Stat(config); os.IsNotExist(err) {
+		return nil
+	}
+	var cfg servicesConfig
+	if _, err := toml.DecodeFile(config, &cfg); err != nil {
+		return fmt.Errorf("reading %s: %v", config, err)
+	}
+	if err := storage.SetEngine(cfg.Storage.Engine); err != nil {
+		return fmt.Errorf("in section Storage of %s: %v", config, err)
+	}
+	byzcoin.SetNodeConfig(cfg.ByzCoin)
+	return nil
+}
+
 // checkConfig contacts all servers and verifies if it receives a valid
 // signature from each.
 func checkConfig(c *cli.Context) error {
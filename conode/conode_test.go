@@ -1,10 +1,15 @@
 package main
 
 import (
+	"io/ioutil"
+	"path/filepath"
 	"testing"
 
 	"os"
 
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/storage"
 	"go.dedis.ch/onet/v3/log"
 )
 
@@ -16,3 +21,28 @@ func TestRun(t *testing.T) {
 	os.Args = []string{os.Args[0], "--help"}
 	main()
 }
+
+func TestConfigureServices(t *testing.T) {
+	defer storage.SetEngine(storage.EngineBBolt)
+	defer byzcoin.SetNodeConfig(byzcoin.NodeConfig{})
+	dir, err := ioutil.TempDir("", "conode")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	config := filepath.Join(dir, "private.toml")
+
+	require.NoError(t, configureServices(config))
+	require.Equal(t, storage.EngineBBolt, storage.Engine())
+
+	err = ioutil.WriteFile(config, []byte("Address = \"tls://localhost:7770\"\n"+
+		"\n[Storage]\n  Engine = \"leveldb\"\n"+
+		"\n[ByzCoin]\n  SnapshotInterval = 100\n  ParallelWorkers = 4\n"), 0600)
+	require.NoError(t, err)
+	require.NoError(t, configureServices(config))
+	require.Equal(t, storage.EngineLevelDB, storage.Engine())
+	require.Equal(t, byzcoin.NodeConfig{SnapshotInterval: 100,
+		ParallelWorkers: 4}, byzcoin.GetNodeConfig())
+
+	err = ioutil.WriteFile(config, []byte("[Storage]\n  Engine = \"badger\"\n"), 0600)
+	require.NoError(t, err)
+	require.Error(t, configureServices(config))
+}
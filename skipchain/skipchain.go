@@ -1162,6 +1162,13 @@ func (s *Service) TestClose() {
 		close(s.closing)
 		s.closedMutex.Unlock()
 		s.working.Wait()
+		if s.db != nil && s.db.DB == nil {
+			// Unlike the bbolt file, the blocks of another storage engine
+			// are not closed by onet.
+			if err := s.db.Close(); err != nil {
+				log.Error("couldn't close skipblock db:", err)
+			}
+		}
 	} else {
 		s.closedMutex.Unlock()
 	}
@@ -1172,7 +1179,11 @@ func (s *Service) TestClose() {
 func (s *Service) TestRestart() error {
 	s.TestClose()
 	db, bucket := s.GetAdditionalBucket([]byte("skipblocks"))
-	s.db = NewSkipBlockDB(db, bucket)
+	sdb, err := OpenSkipBlockDB(db, bucket)
+	if err != nil {
+		return xerrors.Errorf("opening skipblock db: %v", err)
+	}
+	s.db = sdb
 	s.Storage = &Storage{}
 	// Don't reset the verifiers, keep them
 	//s.verifiers = map[VerifierID]SkipBlockVerifier{}
@@ -1991,9 +2002,13 @@ func sliceToArr(msg []byte) [32]byte {
 
 func newSkipchainService(c *onet.Context) (onet.Service, error) {
 	db, bucket := c.GetAdditionalBucket([]byte("skipblocks"))
+	sdb, err := OpenSkipBlockDB(db, bucket)
+	if err != nil {
+		return nil, xerrors.Errorf("opening skipblock db: %v", err)
+	}
 	s := &Service{
 		ServiceProcessor: onet.NewServiceProcessor(c),
-		db:               NewSkipBlockDB(db, bucket),
+		db:               sdb,
 		Storage:          &Storage{},
 		verifiers:        map[VerifierID]SkipBlockVerifier{},
 		propTimeout:      defaultPropagateTimeout,
@@ -2017,7 +2032,6 @@ func newSkipchainService(c *onet.Context) (onet.Service, error) {
 		return nil, err
 	}
 
-	var err error
 	s.propagateGenesis, err = messaging.NewPropagationFunc(c, "SkipchainPropagate", s.propagateGenesisHandler, -1)
 	if err != nil {
 		return nil, err
@@ -16,6 +16,7 @@ import (
 	"go.dedis.ch/cothority/v3/blscosi/bdnproto"
 	"go.dedis.ch/cothority/v3/blscosi/protocol"
 	"go.dedis.ch/cothority/v3/byzcoinx"
+	"go.dedis.ch/cothority/v3/storage"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/kyber/v3/pairing"
 	"go.dedis.ch/onet/v3"
@@ -27,6 +28,10 @@ import (
 	bbolt "go.etcd.io/bbolt"
 )
 
+// errFound stops the iteration over the blocks once the one searched for is
+// found.
+var errFound = errors.New("found")
+
 // ErrorInconsistentForwardLink is triggered when the target of a forward-link
 // doesn't respect the consistency of the chain.
 var ErrorInconsistentForwardLink = errors.New("found inconsistent forward-link")
@@ -795,10 +800,14 @@ func (fl *ForwardLink) IsEmpty() bool {
 
 // SkipBlockDB holds the database to the skipblocks.
 // This is used for verification, so that all links can be followed.
-// It is a wrapper to embed bolt.DB.
+// It is a wrapper to embed bolt.DB. The blocks are read and written through
+// BlockStore, so that they can be kept by another storage engine, in which case
+// the embedded bolt.DB is nil.
 type SkipBlockDB struct {
 	*bbolt.DB
 	bucketName []byte
+	// BlockStore holds the blocks, indexed by their hash.
+	BlockStore storage.DB
 	// latestBlocks is used as a simple caching mechanism
 	latestBlocks map[string]SkipBlockID
 	latestMutex  sync.Mutex
@@ -810,26 +819,49 @@ func NewSkipBlockDB(db *bbolt.DB, bn []byte) *SkipBlockDB {
 	return &SkipBlockDB{
 		DB:           db,
 		bucketName:   bn,
+		BlockStore:   storage.NewBoltDB(db, bn),
 		latestBlocks: map[string]SkipBlockID{},
 	}
 }
 
+// NewSkipBlockDBFromStore returns an initialized SkipBlockDB structure
+// keeping the blocks in the given store.
+func NewSkipBlockDBFromStore(store storage.DB) *SkipBlockDB {
+	return &SkipBlockDB{
+		BlockStore:   store,
+		latestBlocks: map[string]SkipBlockID{},
+	}
+}
+
+// OpenSkipBlockDB returns a SkipBlockDB keeping the blocks in the given
+// bucket, using the storage engine selected by storage.SetEngine.
+func OpenSkipBlockDB(db *bbolt.DB, bn []byte) (*SkipBlockDB, error) {
+	if storage.Engine() == storage.EngineBBolt {
+		return NewSkipBlockDB(db, bn), nil
+	}
+	store, err := storage.Open(db, bn)
+	if err != nil {
+		return nil, xerrors.Errorf("opening %s: %v", storage.Engine(), err)
+	}
+	return NewSkipBlockDBFromStore(store), nil
+}
+
+// Close closes the store of the blocks. If the blocks are kept in the
+// embedded bolt.DB, it is closed too.
+func (db *SkipBlockDB) Close() error {
+	return db.BlockStore.Close()
+}
+
 // GetStatus is a function that returns the status report of the db.
 func (db *SkipBlockDB) GetStatus() *onet.Status {
 	out := make(map[string]string)
-	err := db.DB.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
-		s := b.Stats()
-		out["Blocks"] = strconv.Itoa(s.KeyN)
-
-		total := s.BranchInuse + s.LeafInuse
-		out["Bytes"] = strconv.Itoa(total)
-		return nil
-	})
+	stats, err := storage.GetStats(db.BlockStore)
 	if err != nil {
 		log.Error(err)
 		return nil
 	}
+	out["Blocks"] = strconv.Itoa(stats.Keys)
+	out["Bytes"] = strconv.Itoa(stats.Bytes)
 	return &onet.Status{Field: out}
 }
 
@@ -839,8 +871,8 @@ func (db *SkipBlockDB) GetByID(sbID SkipBlockID) *SkipBlock {
 	if sbID == nil {
 		return nil
 	}
-	err := db.View(func(tx *bbolt.Tx) error {
-		sb, err := db.getFromTx(tx, sbID)
+	err := db.BlockStore.View(func(tx storage.Bucket) error {
+		sb, err := db.getFromBucket(tx, sbID)
 		if err != nil {
 			return err
 		}
@@ -858,10 +890,10 @@ func (db *SkipBlockDB) GetByID(sbID SkipBlockID) *SkipBlock {
 // so that the db is consistent at every moment.
 func (db *SkipBlockDB) StoreBlocks(blocks []*SkipBlock) ([]SkipBlockID, error) {
 	var result []SkipBlockID
-	err := db.Update(func(tx *bbolt.Tx) error {
+	err := db.BlockStore.Update(func(tx storage.Bucket) error {
 		for i, sb := range blocks {
 			log.Lvlf2("Storing skipblock %d / %x", sb.Index, sb.Hash)
-			sbOld, err := db.getFromTx(tx, sb.Hash)
+			sbOld, err := db.getFromBucket(tx, sb.Hash)
 			if err != nil {
 				return errors.New("failed to get skipblock with error: " + err.Error())
 			}
@@ -884,7 +916,7 @@ func (db *SkipBlockDB) StoreBlocks(blocks []*SkipBlock) ([]SkipBlockID, error) {
 							continue
 						}
 
-						target, err := db.getFromTx(tx, fl.To)
+						target, err := db.getFromBucket(tx, fl.To)
 						if err != nil {
 							return err
 						}
@@ -904,7 +936,7 @@ func (db *SkipBlockDB) StoreBlocks(blocks []*SkipBlock) ([]SkipBlockID, error) {
 						}
 					}
 				}
-				err := db.storeToTx(tx, sbOld)
+				err := db.storeToBucket(tx, sbOld)
 				if err != nil {
 					return err
 				}
@@ -948,7 +980,7 @@ func (db *SkipBlockDB) StoreBlocks(blocks []*SkipBlock) ([]SkipBlockID, error) {
 					}
 				}
 
-				err := db.storeToTx(tx, sb)
+				err := db.storeToBucket(tx, sb)
 				if err != nil {
 					return err
 				}
@@ -1025,13 +1057,8 @@ func (db *SkipBlockDB) latestUpdate(sb *SkipBlock) {
 
 // Length returns the actual length using mutexes
 func (db *SkipBlockDB) Length() int {
-	var i int
-	_ = db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
-		i = b.Stats().KeyN
-		return nil
-	})
-	return i
+	stats, _ := storage.GetStats(db.BlockStore)
+	return stats.Keys
 }
 
 // GetResponsible searches for the block that is responsible for sb
@@ -1167,21 +1194,22 @@ func (db *SkipBlockDB) GetFuzzy(id string) (*SkipBlock, error) {
 	}
 
 	var sb *SkipBlock
-	err = db.View(func(tx *bbolt.Tx) error {
-		c := tx.Bucket([]byte(db.bucketName)).Cursor()
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if bytes.HasPrefix(k, match) {
-				_, msg, err := network.Unmarshal(v, suite)
-				if err != nil {
-					return errors.New("Unmarshal failed with error: " + err.Error())
+	err = db.BlockStore.View(func(b storage.Bucket) error {
+		for _, has := range []func(s, fix []byte) bool{bytes.HasPrefix,
+			bytes.HasSuffix} {
+			var found []byte
+			err := b.ForEach(func(k, v []byte) error {
+				if has(k, match) {
+					found = append([]byte{}, v...)
+					return errFound
 				}
-				sb = msg.(*SkipBlock).Copy()
 				return nil
+			})
+			if err != nil && err != errFound {
+				return err
 			}
-		}
-		for k, v := c.First(); k != nil; k, v = c.Next() {
-			if bytes.HasSuffix(k, match) {
-				_, msg, err := network.Unmarshal(v, suite)
+			if found != nil {
+				_, msg, err := network.Unmarshal(found, suite)
 				if err != nil {
 					return errors.New("Unmarshal failed with error: " + err.Error())
 				}
@@ -1199,8 +1227,8 @@ func (db *SkipBlockDB) GetFuzzy(id string) (*SkipBlock, error) {
 // If dest < 0, search up to the latest block.
 func (db *SkipBlockDB) GetProofFromIndex(sid SkipBlockID,
 	dest int) (pr Proof, err error) {
-	err = db.View(func(tx *bbolt.Tx) error {
-		sb, err := db.getFromTx(tx, sid)
+	err = db.BlockStore.View(func(tx storage.Bucket) error {
+		sb, err := db.getFromBucket(tx, sid)
 		if err != nil {
 			return err
 		}
@@ -1220,7 +1248,7 @@ func (db *SkipBlockDB) GetProofFromIndex(sid SkipBlockID,
 
 // Iterate over all blocks until it's either the last block or the
 // one required by the call.
-func (db *SkipBlockDB) getPath(tx *bbolt.Tx, sb *SkipBlock,
+func (db *SkipBlockDB) getPath(tx storage.Bucket, sb *SkipBlock,
 	dest int) (pr Proof, err error) {
 	pr = append(pr, sb)
 	if dest == 0 {
@@ -1247,14 +1275,14 @@ func (db *SkipBlockDB) getPath(tx *bbolt.Tx, sb *SkipBlock,
 
 // Search for the closest block that is reachable before or at the destination.
 // dest < 0 indicates to chose the highest forward-link.
-func (db *SkipBlockDB) getHighestJump(tx *bbolt.Tx, start *SkipBlock,
+func (db *SkipBlockDB) getHighestJump(tx storage.Bucket, start *SkipBlock,
 	dest int) (*SkipBlock, error) {
 	for i := len(start.ForwardLink) - 1; i >= 0; i-- {
 		// We can have holes in the forward links
 		if start.ForwardLink[i].IsEmpty() {
 			continue
 		}
-		sb, err := db.getFromTx(tx, start.ForwardLink[i].To)
+		sb, err := db.getFromBucket(tx, start.ForwardLink[i].To)
 		if err != nil {
 			return nil, xerrors.Errorf("while fetching block from db: %v",
 				err)
@@ -1303,18 +1331,13 @@ func (db *SkipBlockDB) GetSkipchains() (map[string]*SkipBlock, error) {
 // If the skipchain is only partial, it can skip missing blocks, as long as the
 // forwardlinks are present.
 func (db *SkipBlockDB) RemoveSkipchain(scid SkipBlockID) error {
-	return db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
-		sb, err := db.getFromTx(tx, scid)
+	return db.BlockStore.Update(func(tx storage.Bucket) error {
+		sb, err := db.getFromBucket(tx, scid)
 		if err != nil {
 			return err
 		}
-		if b == nil {
-			return xerrors.Errorf("cannot remove non-existent bucket %s",
-				string(db.bucketName))
-		}
 		for {
-			err := b.Delete(sb.Hash)
+			err := tx.Delete(sb.Hash)
 			if err != nil {
 				return err
 			}
@@ -1324,7 +1347,7 @@ func (db *SkipBlockDB) RemoveSkipchain(scid SkipBlockID) error {
 
 			var next *SkipBlock
 			for _, fl := range sb.ForwardLink {
-				n, err := db.getFromTx(tx, fl.To)
+				n, err := db.getFromBucket(tx, fl.To)
 				if err == nil {
 					next = n
 					break
@@ -1341,34 +1364,39 @@ func (db *SkipBlockDB) RemoveSkipchain(scid SkipBlockID) error {
 
 // RemoveBlock removes the given block from the database.
 func (db *SkipBlockDB) RemoveBlock(blockID SkipBlockID) error {
-	return db.Update(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
-		return b.Delete(blockID)
+	return db.BlockStore.Update(func(tx storage.Bucket) error {
+		return tx.Delete(blockID)
 	})
 }
 
-// storeToTx stores the skipblock into the database.
+// storeToTx stores the skipblock into the bbolt database.
 // An error is returned on failure.
 // The caller must ensure that this function is called from within a valid transaction.
 func (db *SkipBlockDB) storeToTx(tx *bbolt.Tx, sb *SkipBlock) error {
+	return db.storeToBucket(storage.NewBoltBucket(tx.Bucket(db.bucketName)), sb)
+}
+
+// storeToBucket stores the skipblock into the bucket of a transaction.
+// An error is returned on failure.
+func (db *SkipBlockDB) storeToBucket(tx storage.Bucket, sb *SkipBlock) error {
 	key := sb.Hash
 	val, err := network.Marshal(sb)
 	if err != nil {
 		return err
 	}
-	return tx.Bucket([]byte(db.bucketName)).Put(key, val)
+	return tx.Put(key, val)
 }
 
-// getFromTx returns the skipblock identified by sbID.
+// getFromBucket returns the skipblock identified by sbID.
 // nil is returned if the key does not exist.
 // An error is thrown if marshalling fails.
 // The caller must ensure that this function is called from within a valid transaction.
-func (db *SkipBlockDB) getFromTx(tx *bbolt.Tx, sbID SkipBlockID) (*SkipBlock, error) {
+func (db *SkipBlockDB) getFromBucket(tx storage.Bucket, sbID SkipBlockID) (*SkipBlock, error) {
 	if sbID == nil {
 		return nil, xerrors.New("cannot look up skipblock with ID == nil")
 	}
 
-	val := tx.Bucket(db.bucketName).Get(sbID)
+	val := tx.Get(sbID)
 	if val == nil {
 		return nil, nil
 	}
@@ -1391,8 +1419,7 @@ func (db *SkipBlockDB) getFromTx(tx *bbolt.Tx, sbID SkipBlockID) (*SkipBlock, er
 // database that is consistent at the time of the function call.
 func (db *SkipBlockDB) getAll() (map[string]*SkipBlock, error) {
 	data := map[string]*SkipBlock{}
-	err := db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
+	err := db.BlockStore.View(func(b storage.Bucket) error {
 		return b.ForEach(func(k, v []byte) error {
 			_, sbMsg, err := network.Unmarshal(v, suite)
 			if err != nil {
@@ -1432,8 +1459,7 @@ func (db *SkipBlockDB) getAllSkipchains() (map[string]*SkipBlock, error) {
 	// Loop over all blocks. If we see a new genesis block we
 	// have not seen, remember it. If we see a higher Index than what
 	// we have, replace it.
-	err := db.View(func(tx *bbolt.Tx) error {
-		b := tx.Bucket([]byte(db.bucketName))
+	err := db.BlockStore.View(func(b storage.Bucket) error {
 		return b.ForEach(func(k, v []byte) error {
 			var sbs skipBlockShort
 			err := protobuf.Decode(v[16:], &sbs)
@@ -0,0 +1,116 @@
+package storage
+
+import (
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+var errDryRun = xerrors.New("this is a dry-run")
+
+// boltDB is the DB implementation for boltdb.
+type boltDB struct {
+	db     *bbolt.DB
+	bucket []byte
+}
+
+// NewBoltDB creates a new boltdb-backed database, holding the keys of the
+// given bucket, which must already exist.
+func NewBoltDB(db *bbolt.DB, bucket []byte) DB {
+	return &boltDB{
+		db:     db,
+		bucket: bucket,
+	}
+}
+
+func (r *boltDB) Update(f func(Bucket) error) error {
+	return r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket)
+		if b == nil {
+			return xerrors.New("bucket does not exist")
+		}
+		return f(&boltBucket{b})
+	})
+}
+
+func (r *boltDB) View(f func(Bucket) error) error {
+	return r.db.View(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket)
+		if b == nil {
+			return xerrors.New("bucket does not exist")
+		}
+		return f(&boltBucket{b})
+	})
+}
+
+// UpdateDryRun executes the given transaction and then performs a rollback at
+// the end to return the database to its earlier state (before UpdateDryRun is
+// called). It is useful for seeing the intermediate values. If they need to be
+// used after doing the dry-run, they should be copied.
+func (r *boltDB) UpdateDryRun(f func(Bucket) error) error {
+	err := r.db.Update(func(tx *bbolt.Tx) error {
+		b := tx.Bucket(r.bucket)
+		if b == nil {
+			return xerrors.New("bucket does not exist")
+		}
+		if err := f(&boltBucket{b}); err != nil {
+			return err
+		}
+		return errDryRun
+	})
+	if err != errDryRun {
+		return err
+	}
+	return nil
+}
+
+func (r *boltDB) Close() error {
+	return r.db.Close()
+}
+
+// Stats returns the statistics kept by bbolt for the bucket.
+func (r *boltDB) Stats() (Stats, error) {
+	var stats Stats
+	err := r.View(func(b Bucket) error {
+		s := b.(*boltBucket).b.Stats()
+		stats.Keys = s.KeyN
+		stats.Bytes = s.BranchInuse + s.LeafInuse
+		return nil
+	})
+	return stats, err
+}
+
+// NewBoltBucket returns the Bucket of a bbolt bucket, which is only valid
+// during the transaction it comes from.
+func NewBoltBucket(b *bbolt.Bucket) Bucket {
+	return &boltBucket{b}
+}
+
+type boltBucket struct {
+	b *bbolt.Bucket
+}
+
+func (r *boltBucket) Delete(k []byte) error {
+	return r.b.Delete(k)
+}
+
+func (r *boltBucket) Put(k, v []byte) error {
+	return r.b.Put(k, v)
+}
+
+func (r *boltBucket) Get(k []byte) []byte {
+	return r.b.Get(k)
+}
+
+func (r *boltBucket) ForEach(f func(k, v []byte) error) error {
+	return r.b.ForEach(f)
+}
+
+func (r *boltBucket) ForEachFrom(start []byte, f func(k, v []byte) error) error {
+	c := r.b.Cursor()
+	for k, v := c.Seek(start); k != nil; k, v = c.Next() {
+		if err := f(k, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
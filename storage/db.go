@@ -0,0 +1,106 @@
+// Package storage holds the key/value databases the services of a conode
+// keep their data in, like the skipblocks and the state tries of ByzCoin.
+// Every DB holds the keys of one bucket, and is kept by one of the storage
+// engines: the bbolt file of the conode, or a leveldb database next to it.
+package storage
+
+// DB is the interface for the underlying storage system of a bucket.
+type DB interface {
+	// Update executes a function within the context of a read-write
+	// transaction. If no error is returned from the function then every
+	// operation performed on the bucket is committed. If an error is
+	// returned then nothing gets committed. Any error that is returned
+	// from the function or returned from the commit is returned from the
+	// method.
+	Update(func(Bucket) error) error
+	// View executes a function within the context of a read-only
+	// transaction. Any error that is returned from the function is
+	// returned from the method.
+	View(func(Bucket) error) error
+	// UpdateDryRun is similar to Update but the operations performed in
+	// the function is never committed.
+	UpdateDryRun(func(Bucket) error) error
+	// Close releases all database resources. It will block waiting for any
+	// open transactions to finish before closing the database and
+	// returning.
+	Close() error
+}
+
+// Bucket is the interface that enables raw operations on key/value pairs.
+// It is invalid if it is used outside of a transaction, e.g., outside of
+// DB.Update.
+type Bucket interface {
+	// Delete removes a key from the bucket. If the key does not exist
+	// then nothing is done and a nil is returned. Returns an error if the
+	// bucket was created from a read-only transaction.
+	Delete([]byte) error
+	// Put sets the value for a key in the bucket. If the key exist then
+	// its previous value will be overwritten. Supplied value must remain
+	// valid for the life of the transaction. Returns an error if an issue
+	// occurs, e.g., the bucket was created from a read-only transaction.
+	Put([]byte, []byte) error
+	// Get retrieves the value for a key in the bucket. Returns a nil value
+	// if the key does not exist or if the key is a nested bucket. The
+	// returned value is only valid for the life of the transaction.
+	Get([]byte) []byte
+	// ForEach executes the given function for each key/value pair. If the
+	// provided function returns an error then the iteration is stopped and
+	// the error is returned to the caller.
+	ForEach(func(k, v []byte) error) error
+}
+
+// Stats are the statistics of the keys of a bucket.
+type Stats struct {
+	// Keys is the number of keys in the bucket.
+	Keys int
+	// Bytes is the space used by the keys and values. It is approximated by
+	// leveldb.
+	Bytes int
+}
+
+// statser is implemented by the DBs that know their Stats without going
+// through all their keys.
+type statser interface {
+	Stats() (Stats, error)
+}
+
+// GetStats returns the statistics of the bucket of the DB. The DBs of this
+// package keep them up to date, for the other DBs all the keys are gone
+// through.
+func GetStats(db DB) (Stats, error) {
+	if s, ok := db.(statser); ok {
+		return s.Stats()
+	}
+	var stats Stats
+	err := db.View(func(b Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			stats.Keys++
+			stats.Bytes += len(k) + len(v)
+			return nil
+		})
+	})
+	return stats, err
+}
+
+// seeker is implemented by the buckets that can start an iteration at any
+// key.
+type seeker interface {
+	ForEachFrom(start []byte, f func(k, v []byte) error) error
+}
+
+// ForEachFrom is like Bucket.ForEach, but starts at the first key that is
+// equal to or bigger than start. The buckets of this package go through the
+// keys in their byte order and start right at start. The other buckets are
+// gone through from their first key, so they must go through the keys in
+// their byte order, too.
+func ForEachFrom(b Bucket, start []byte, f func(k, v []byte) error) error {
+	if s, ok := b.(seeker); ok {
+		return s.ForEachFrom(start, f)
+	}
+	return b.ForEach(func(k, v []byte) error {
+		if string(k) < string(start) {
+			return nil
+		}
+		return f(k, v)
+	})
+}
@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"sync"
+
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+const (
+	// EngineBBolt stores the buckets in the bbolt file of the conode. It is
+	// the default engine.
+	EngineBBolt = "bbolt"
+	// EngineLevelDB stores the buckets in a leveldb database next to the
+	// bbolt file of the conode.
+	EngineLevelDB = "leveldb"
+)
+
+// ErrNotMigrated is returned by Open if the keys of a bucket are still in
+// the bbolt file, while another storage engine is used.
+var ErrNotMigrated = xerrors.New("the bucket has not been migrated")
+
+var engine = struct {
+	sync.Mutex
+	name string
+}{name: EngineBBolt}
+
+// SetEngine selects the storage engine of the DBs returned by Open. The
+// conode sets it from the Storage.Engine option of its config, before the
+// services are started. An empty name selects the default engine.
+func SetEngine(name string) error {
+	switch name {
+	case "":
+		name = EngineBBolt
+	case EngineBBolt, EngineLevelDB:
+	default:
+		return xerrors.Errorf("unknown storage engine %s", name)
+	}
+	engine.Lock()
+	engine.name = name
+	engine.Unlock()
+	return nil
+}
+
+// Engine returns the storage engine selected by SetEngine.
+func Engine() string {
+	engine.Lock()
+	defer engine.Unlock()
+	return engine.name
+}
+
+// Open returns the DB of a bucket of the conode database, using the storage
+// engine selected by SetEngine. The bucket must already exist in the bbolt
+// file, as returned by onet.Context.GetAdditionalBucket. If the keys of the
+// bucket are in the bbolt file, but not in the selected engine,
+// ErrNotMigrated is returned, so that a conode never starts without the data
+// it had before.
+func Open(db *bbolt.DB, bucket []byte) (DB, error) {
+	if Engine() == EngineBBolt {
+		return NewBoltDB(db, bucket), nil
+	}
+	level, err := OpenLevelDB(LevelDBPath(db.Path()), bucket)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkMigrated(db, bucket, level); err != nil {
+		level.Close()
+		return nil, err
+	}
+	return level, nil
+}
+
+// errStop stops an iteration after its first key.
+var errStop = xerrors.New("stop")
+
+// checkMigrated returns ErrNotMigrated if the bucket of the bbolt file holds
+// keys, but the bucket of dst doesn't.
+func checkMigrated(db *bbolt.DB, bucket []byte, dst DB) error {
+	var inBolt bool
+	err := db.View(func(tx *bbolt.Tx) error {
+		if b := tx.Bucket(bucket); b != nil {
+			k, _ := b.Cursor().First()
+			inBolt = k != nil
+		}
+		return nil
+	})
+	if err != nil {
+		return xerrors.Errorf("reading bbolt: %v", err)
+	}
+	if !inBolt {
+		return nil
+	}
+	var inDst bool
+	err = dst.View(func(b Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			inDst = true
+			return errStop
+		})
+	})
+	if err != nil && err != errStop {
+		return xerrors.Errorf("reading %s: %v", Engine(), err)
+	}
+	if !inDst {
+		return xerrors.Errorf("bucket %s of %s: %w", bucket, db.Path(),
+			ErrNotMigrated)
+	}
+	return nil
+}
+
+// clearBatch is the number of keys deleted by every transaction of
+// DeleteBucket.
+const clearBatch = 10000
+
+// DeleteBucket deletes the bucket from the bbolt file of the conode. If
+// another storage engine is selected, the keys of the bucket are deleted from
+// it, too.
+func DeleteBucket(db *bbolt.DB, bucket []byte) error {
+	err := db.Update(func(tx *bbolt.Tx) error {
+		return tx.DeleteBucket(bucket)
+	})
+	if err != nil || Engine() == EngineBBolt {
+		return err
+	}
+	level, err := OpenLevelDB(LevelDBPath(db.Path()), bucket)
+	if err != nil {
+		return err
+	}
+	defer level.Close()
+	for deleted := clearBatch; deleted == clearBatch; {
+		deleted = 0
+		err := level.Update(func(b Bucket) error {
+			var keys [][]byte
+			err := b.ForEach(func(k, v []byte) error {
+				keys = append(keys, append([]byte{}, k...))
+				if len(keys) == clearBatch {
+					return errStop
+				}
+				return nil
+			})
+			if err != nil && err != errStop {
+				return err
+			}
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			deleted = len(keys)
+			return nil
+		})
+		if err != nil {
+			return xerrors.Errorf("deleting keys: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	bbolt "go.etcd.io/bbolt"
+	"golang.org/x/xerrors"
+)
+
+func TestSetEngine(t *testing.T) {
+	defer SetEngine(EngineBBolt)
+
+	require.Equal(t, EngineBBolt, Engine())
+	require.NoError(t, SetEngine(EngineLevelDB))
+	require.Equal(t, EngineLevelDB, Engine())
+	require.Error(t, SetEngine("badger"))
+	require.Equal(t, EngineLevelDB, Engine())
+	require.NoError(t, SetEngine(""))
+	require.Equal(t, EngineBBolt, Engine())
+}
+
+// TestOpen checks that the buckets are opened with the selected engine, and
+// that a bucket that has not been migrated is refused.
+func TestOpen(t *testing.T) {
+	defer SetEngine(EngineBBolt)
+
+	dir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bolt, err := bbolt.Open(filepath.Join(dir, "conode.db"), 0600, nil)
+	require.NoError(t, err)
+	defer bolt.Close()
+	for _, bucket := range []string{"blocks", "trie"} {
+		err = bolt.Update(func(tx *bbolt.Tx) error {
+			_, err := tx.CreateBucket([]byte(bucket))
+			return err
+		})
+		require.NoError(t, err)
+	}
+
+	db, err := Open(bolt, []byte("blocks"))
+	require.NoError(t, err)
+	require.IsType(t, &boltDB{}, db)
+	put(t, db, "a", "b")
+	stats, err := GetStats(db)
+	require.NoError(t, err)
+	require.Equal(t, 2, stats.Keys)
+
+	require.NoError(t, SetEngine(EngineLevelDB))
+	_, err = Open(bolt, []byte("blocks"))
+	require.True(t, xerrors.Is(err, ErrNotMigrated))
+
+	// Empty buckets don't need to be migrated, and the buckets of the same
+	// database share it until they are all closed.
+	trie, err := Open(bolt, []byte("trie"))
+	require.NoError(t, err)
+	put(t, trie, "c")
+	level, err := OpenLevelDB(LevelDBPath(bolt.Path()), []byte("blocks"))
+	require.NoError(t, err)
+	put(t, level, "a")
+	require.NoError(t, trie.Close())
+	require.NoError(t, level.Close())
+
+	db, err = Open(bolt, []byte("blocks"))
+	require.NoError(t, err)
+	require.IsType(t, &levelDB{}, db)
+	require.NoError(t, db.Close())
+}
+
+func TestDeleteBucket(t *testing.T) {
+	defer SetEngine(EngineBBolt)
+	require.NoError(t, SetEngine(EngineLevelDB))
+
+	dir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	bolt, err := bbolt.Open(filepath.Join(dir, "conode.db"), 0600, nil)
+	require.NoError(t, err)
+	defer bolt.Close()
+	err = bolt.Update(func(tx *bbolt.Tx) error {
+		_, err := tx.CreateBucket([]byte("trie"))
+		return err
+	})
+	require.NoError(t, err)
+
+	db, err := Open(bolt, []byte("trie"))
+	require.NoError(t, err)
+	put(t, db, "a", "b", "c")
+	require.NoError(t, DeleteBucket(bolt, []byte("trie")))
+	stats, err := GetStats(db)
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.Keys)
+	require.NoError(t, db.Close())
+	require.Error(t, DeleteBucket(bolt, []byte("trie")))
+}
@@ -0,0 +1,375 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/syndtr/goleveldb/leveldb"
+	"github.com/syndtr/goleveldb/leveldb/opt"
+	"github.com/syndtr/goleveldb/leveldb/util"
+	"golang.org/x/xerrors"
+)
+
+// levelDB is the DB implementation for leveldb. As leveldb has no buckets,
+// all the keys of a bucket start with the same prefix. A transaction writes
+// to a batch which is only written to leveldb when it is committed, and
+// reads from a snapshot taken at its start.
+//
+// The number of keys of the bucket is stored next to it, under countKey, and
+// updated by every transaction.
+type levelDB struct {
+	db       *leveldb.DB
+	prefix   []byte
+	countKey []byte
+	// lock serializes the read-write transactions of the bucket. The
+	// read-only transactions are never blocked.
+	lock *sync.Mutex
+	// release closes the database, or gives it back to OpenLevelDB.
+	release func() error
+}
+
+// levelLocks holds one lock per bucket of every leveldb database, so that
+// all the DBs of the same bucket share it.
+var levelLocks = struct {
+	sync.Mutex
+	buckets map[*leveldb.DB]map[string]*sync.Mutex
+}{buckets: make(map[*leveldb.DB]map[string]*sync.Mutex)}
+
+func levelLock(db *leveldb.DB, bucket []byte) *sync.Mutex {
+	levelLocks.Lock()
+	defer levelLocks.Unlock()
+	locks, ok := levelLocks.buckets[db]
+	if !ok {
+		locks = make(map[string]*sync.Mutex)
+		levelLocks.buckets[db] = locks
+	}
+	lock, ok := locks[string(bucket)]
+	if !ok {
+		lock = &sync.Mutex{}
+		locks[string(bucket)] = lock
+	}
+	return lock
+}
+
+func forgetLevelLocks(db *leveldb.DB) {
+	levelLocks.Lock()
+	delete(levelLocks.buckets, db)
+	levelLocks.Unlock()
+}
+
+// NewLevelDB creates a new leveldb-backed database, holding the keys of the
+// given bucket, whose name must not be empty. Many buckets can share the
+// same leveldb database, which is closed when one of them is closed.
+func NewLevelDB(db *leveldb.DB, bucket []byte) DB {
+	return newLevelDB(db, bucket, func() error {
+		forgetLevelLocks(db)
+		return db.Close()
+	})
+}
+
+func newLevelDB(db *leveldb.DB, bucket []byte, release func() error) *levelDB {
+	prefix := make([]byte, binary.MaxVarintLen64)
+	prefix = append(prefix[:binary.PutUvarint(prefix, uint64(len(bucket)))],
+		bucket...)
+	// The prefixes of the buckets never start with 0, as their names are
+	// not empty.
+	countKey := append([]byte{0}, prefix...)
+	return &levelDB{
+		db:       db,
+		prefix:   prefix,
+		countKey: countKey,
+		lock:     levelLock(db, bucket),
+		release:  release,
+	}
+}
+
+// LevelDBPath returns the directory of the leveldb database that goes with
+// the given bbolt file.
+func LevelDBPath(boltPath string) string {
+	return strings.TrimSuffix(boltPath, filepath.Ext(boltPath)) + ".leveldb"
+}
+
+// levelDBs holds the databases opened by OpenLevelDB, as a database can only
+// be opened once.
+var levelDBs = struct {
+	sync.Mutex
+	paths map[string]*openedLevelDB
+}{paths: make(map[string]*openedLevelDB)}
+
+type openedLevelDB struct {
+	db   *leveldb.DB
+	refs int
+}
+
+// OpenLevelDB returns the DB of the bucket in the leveldb database in the
+// given directory. The database is opened by the first call, and closed once
+// all the DBs returned for it are closed.
+func OpenLevelDB(path string, bucket []byte) (DB, error) {
+	levelDBs.Lock()
+	defer levelDBs.Unlock()
+	opened, ok := levelDBs.paths[path]
+	if !ok {
+		db, err := leveldb.OpenFile(path, nil)
+		if err != nil {
+			return nil, xerrors.Errorf("opening leveldb: %v", err)
+		}
+		opened = &openedLevelDB{db: db}
+		levelDBs.paths[path] = opened
+	}
+	opened.refs++
+	return newLevelDB(opened.db, bucket, func() error {
+		levelDBs.Lock()
+		defer levelDBs.Unlock()
+		opened.refs--
+		if opened.refs > 0 {
+			return nil
+		}
+		delete(levelDBs.paths, path)
+		forgetLevelLocks(opened.db)
+		return opened.db.Close()
+	}), nil
+}
+
+func (r *levelDB) Update(f func(Bucket) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	b, err := r.newBucket(true)
+	if err != nil {
+		return err
+	}
+	defer b.snap.Release()
+	if err := f(b); err != nil {
+		return err
+	}
+	if err := r.updateCount(b); err != nil {
+		return err
+	}
+	return r.db.Write(b.batch, &opt.WriteOptions{Sync: true})
+}
+
+func (r *levelDB) View(f func(Bucket) error) error {
+	b, err := r.newBucket(false)
+	if err != nil {
+		return err
+	}
+	defer b.snap.Release()
+	return f(b)
+}
+
+// UpdateDryRun executes the given transaction without writing its batch to
+// leveldb. It is useful for seeing the intermediate values. If they need to
+// be used after doing the dry-run, they should be copied.
+func (r *levelDB) UpdateDryRun(f func(Bucket) error) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	b, err := r.newBucket(true)
+	if err != nil {
+		return err
+	}
+	defer b.snap.Release()
+	return f(b)
+}
+
+// Close closes the DB. A database returned by NewLevelDB is closed with all
+// its other buckets.
+func (r *levelDB) Close() error {
+	return r.release()
+}
+
+// Stats returns the number of keys of the bucket, and the approximate size
+// of the bucket in the files of leveldb.
+func (r *levelDB) Stats() (Stats, error) {
+	snap, err := r.db.GetSnapshot()
+	if err != nil {
+		return Stats{}, xerrors.Errorf("getting snapshot: %v", err)
+	}
+	defer snap.Release()
+	keys, err := r.count(snap)
+	if err != nil {
+		return Stats{}, err
+	}
+	sizes, err := r.db.SizeOf([]util.Range{*util.BytesPrefix(r.prefix)})
+	if err != nil {
+		return Stats{}, xerrors.Errorf("getting size: %v", err)
+	}
+	return Stats{Keys: keys, Bytes: int(sizes.Sum())}, nil
+}
+
+// count returns the number of keys of the bucket in the snapshot. The
+// buckets written before the keys were counted are counted once.
+func (r *levelDB) count(snap *leveldb.Snapshot) (int, error) {
+	buf, err := snap.Get(r.countKey, nil)
+	if err == nil {
+		count, n := binary.Uvarint(buf)
+		if n <= 0 {
+			return 0, xerrors.New("invalid key count")
+		}
+		return int(count), nil
+	}
+	if err != leveldb.ErrNotFound {
+		return 0, xerrors.Errorf("reading key count: %v", err)
+	}
+	var count int
+	it := snap.NewIterator(util.BytesPrefix(r.prefix), nil)
+	defer it.Release()
+	for it.Next() {
+		count++
+	}
+	if err := it.Error(); err != nil {
+		return 0, xerrors.Errorf("counting keys: %v", err)
+	}
+	return count, nil
+}
+
+// updateCount adds the new number of keys of the bucket to the batch of the
+// transaction.
+func (r *levelDB) updateCount(b *levelBucket) error {
+	if len(b.writes) == 0 {
+		return nil
+	}
+	count, err := r.count(b.snap)
+	if err != nil {
+		return err
+	}
+	for k, w := range b.writes {
+		had, err := b.snap.Has(b.key([]byte(k)), nil)
+		if err != nil {
+			return xerrors.Errorf("counting keys: %v", err)
+		}
+		switch {
+		case w.value != nil && !had:
+			count++
+		case w.value == nil && had:
+			count--
+		}
+	}
+	buf := make([]byte, binary.MaxVarintLen64)
+	b.batch.Put(r.countKey, buf[:binary.PutUvarint(buf, uint64(count))])
+	return nil
+}
+
+func (r *levelDB) newBucket(writable bool) (*levelBucket, error) {
+	snap, err := r.db.GetSnapshot()
+	if err != nil {
+		return nil, xerrors.Errorf("getting snapshot: %v", err)
+	}
+	b := &levelBucket{
+		snap:   snap,
+		prefix: r.prefix,
+	}
+	if writable {
+		b.batch = new(leveldb.Batch)
+		b.writes = make(map[string]levelWrite)
+	}
+	return b, nil
+}
+
+// levelWrite is a value written by a transaction. A deleted key has a nil
+// value.
+type levelWrite struct {
+	value []byte
+}
+
+type levelBucket struct {
+	snap   *leveldb.Snapshot
+	prefix []byte
+	// batch and writes are nil in a read-only transaction.
+	batch  *leveldb.Batch
+	writes map[string]levelWrite
+}
+
+func (r *levelBucket) key(k []byte) []byte {
+	return append(append([]byte{}, r.prefix...), k...)
+}
+
+func (r *levelBucket) Delete(k []byte) error {
+	if r.batch == nil {
+		return xerrors.New("trying to use Delete in a read-only transaction")
+	}
+	r.batch.Delete(r.key(k))
+	r.writes[string(k)] = levelWrite{}
+	return nil
+}
+
+func (r *levelBucket) Put(k, v []byte) error {
+	if r.batch == nil {
+		return xerrors.New("trying to use Put in a read-only transaction")
+	}
+	if v == nil {
+		v = []byte{}
+	}
+	r.batch.Put(r.key(k), v)
+	r.writes[string(k)] = levelWrite{value: v}
+	return nil
+}
+
+func (r *levelBucket) Get(k []byte) []byte {
+	if w, ok := r.writes[string(k)]; ok {
+		return w.value
+	}
+	v, err := r.snap.Get(r.key(k), nil)
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+// ForEach goes through the keys in their byte order, with the values written
+// by the transaction replacing the ones of the snapshot.
+func (r *levelBucket) ForEach(f func(k, v []byte) error) error {
+	return r.ForEachFrom(nil, f)
+}
+
+// ForEachFrom is like ForEach, but starts at the first key that is equal to
+// or bigger than start.
+func (r *levelBucket) ForEachFrom(start []byte, f func(k, v []byte) error) error {
+	written := make([]string, 0, len(r.writes))
+	for k := range r.writes {
+		if k >= string(start) {
+			written = append(written, k)
+		}
+	}
+	sort.Strings(written)
+
+	// flush calls f on the written keys that come before k, or all of them
+	// if k is nil.
+	flush := func(k []byte) error {
+		for len(written) > 0 && (k == nil || written[0] < string(k)) {
+			if v := r.writes[written[0]].value; v != nil {
+				if err := f([]byte(written[0]), v); err != nil {
+					return err
+				}
+			}
+			written = written[1:]
+		}
+		return nil
+	}
+
+	keys := util.BytesPrefix(r.prefix)
+	keys.Start = r.key(start)
+	it := r.snap.NewIterator(keys, nil)
+	defer it.Release()
+	for it.Next() {
+		k := bytes.TrimPrefix(it.Key(), r.prefix)
+		if err := flush(k); err != nil {
+			return err
+		}
+		if len(written) > 0 && written[0] == string(k) {
+			// The value of the transaction is used by flush.
+			continue
+		}
+		if err := f(k, it.Value()); err != nil {
+			return err
+		}
+	}
+	if err := it.Error(); err != nil {
+		return xerrors.Errorf("iterating over the bucket: %v", err)
+	}
+	return flush(nil)
+}
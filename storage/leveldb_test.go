@@ -0,0 +1,125 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/syndtr/goleveldb/leveldb"
+)
+
+func newTestLevelDB(t *testing.T, bucket string) (DB, func()) {
+	dir, err := ioutil.TempDir("", "storage")
+	require.NoError(t, err)
+	ldb, err := leveldb.OpenFile(filepath.Join(dir, "test.leveldb"), nil)
+	require.NoError(t, err)
+	db := NewLevelDB(ldb, []byte(bucket))
+	return db, func() {
+		require.NoError(t, db.Close())
+		require.NoError(t, os.RemoveAll(dir))
+	}
+}
+
+func put(t *testing.T, db DB, keys ...string) {
+	err := db.Update(func(b Bucket) error {
+		for _, k := range keys {
+			if err := b.Put([]byte(k), []byte(k)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	require.NoError(t, err)
+}
+
+// TestLevelDB_ForEach checks that the iteration in a transaction merges its
+// writes with the stored keys, and that the buckets are kept apart.
+func TestLevelDB_ForEach(t *testing.T) {
+	db, cleanup := newTestLevelDB(t, "bucket")
+	defer cleanup()
+	other := NewLevelDB(db.(*levelDB).db, []byte("bucket2"))
+
+	put(t, db, "a", "c", "e")
+	err := other.Update(func(b Bucket) error {
+		return b.Put([]byte("b"), []byte("other"))
+	})
+	require.NoError(t, err)
+
+	var keys []string
+	err = db.UpdateDryRun(func(b Bucket) error {
+		if err := b.Put([]byte("d"), []byte("d")); err != nil {
+			return err
+		}
+		if err := b.Put([]byte("a"), []byte("A")); err != nil {
+			return err
+		}
+		if err := b.Delete([]byte("c")); err != nil {
+			return err
+		}
+		err := b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k)+string(v))
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		return ForEachFrom(b, []byte("b"), func(k, v []byte) error {
+			keys = append(keys, string(k)+string(v))
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"aA", "dd", "ee", "dd", "ee"}, keys)
+
+	keys = nil
+	err = db.View(func(b Bucket) error {
+		return b.ForEach(func(k, v []byte) error {
+			keys = append(keys, string(k)+string(v))
+			return nil
+		})
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"aa", "cc", "ee"}, keys)
+}
+
+// TestLevelDB_Stats checks that the number of keys is kept up to date by the
+// transactions.
+func TestLevelDB_Stats(t *testing.T) {
+	db, cleanup := newTestLevelDB(t, "bucket")
+	defer cleanup()
+
+	stats, err := GetStats(db)
+	require.NoError(t, err)
+	require.Equal(t, 0, stats.Keys)
+
+	put(t, db, "a", "b", "c")
+	put(t, db, "b", "c", "d")
+	err = db.Update(func(b Bucket) error {
+		if err := b.Delete([]byte("a")); err != nil {
+			return err
+		}
+		return b.Delete([]byte("x"))
+	})
+	require.NoError(t, err)
+	err = db.UpdateDryRun(func(b Bucket) error {
+		return b.Put([]byte("y"), []byte("y"))
+	})
+	require.NoError(t, err)
+
+	stats, err = GetStats(db)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Keys)
+
+	// A bucket written without its count is counted once.
+	ldb := db.(*levelDB)
+	require.NoError(t, ldb.db.Delete(ldb.countKey, nil))
+	stats, err = GetStats(db)
+	require.NoError(t, err)
+	require.Equal(t, 3, stats.Keys)
+	put(t, db, "e")
+	stats, err = GetStats(db)
+	require.NoError(t, err)
+	require.Equal(t, 4, stats.Keys)
+}
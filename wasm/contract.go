@@ -0,0 +1,192 @@
+package wasm
+
+import (
+	"encoding/binary"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/wasm/vm"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// ContractWasmID identifies the ByzCoin contract that runs WebAssembly
+// modules.
+var ContractWasmID = "wasm"
+
+// ContractWasmValueID identifies the ByzCoin contract that holds the values
+// stored by the WebAssembly modules.
+var ContractWasmValueID = "wasm_value"
+
+// InitFunc is the exported function run when the module is spawned, if it
+// exists.
+const InitFunc = "init"
+
+// DefaultFuel is the fuel given to an execution if the instruction doesn't
+// have a "fuel" argument.
+var DefaultFuel uint64 = 1000000
+
+// MaxFuel is the maximum fuel an instruction can ask for.
+var MaxFuel uint64 = 100000000
+
+func init() {
+	log.ErrFatal(byzcoin.RegisterGlobalContract(ContractWasmID,
+		contractWasmFromBytes))
+	log.ErrFatal(byzcoin.RegisterGlobalContract(ContractWasmValueID,
+		contractWasmValueFromBytes))
+}
+
+// contractWasm holds the binary of a WebAssembly module.
+//
+// Spawning it with the module in the "module" argument stores the module and
+// runs its init function, if it exports one. The other exported functions are
+// executed by invoking the instance with the name of the function as command,
+// so that every function has its own rule in the darc:
+// invoke:wasm.<function>. The functions called this way must have no
+// parameters and no results, and the init function can't be called this way.
+//
+// Both spawn and invoke take an optional "fuel" argument, a 64-bit uint in
+// LittleEndian, which limits the execution.
+type contractWasm struct {
+	byzcoin.BasicContract
+	code []byte
+}
+
+func contractWasmFromBytes(in []byte) (byzcoin.Contract, error) {
+	return &contractWasm{code: in}, nil
+}
+
+// contractWasmValue holds a value stored by a module. It can only be changed
+// by the module.
+type contractWasmValue struct {
+	byzcoin.BasicContract
+}
+
+func contractWasmValueFromBytes(in []byte) (byzcoin.Contract, error) {
+	return &contractWasmValue{}, nil
+}
+
+// Spawn implements the byzcoin.Contract interface.
+func (c *contractWasm) Spawn(rst byzcoin.ReadOnlyStateTrie,
+	inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange,
+	cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	code := inst.Spawn.Args.Search("module")
+	m, err := vm.Decode(code)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("invalid module: %v", err)
+	}
+	fuel, err := getFuel(inst.Spawn.Args)
+	if err != nil {
+		return
+	}
+
+	var id byzcoin.InstanceID
+	if rst.GetVersion() >= byzcoin.VersionPreID {
+		id, err = inst.DeriveIDArg("", "preID")
+		if err != nil {
+			return nil, nil, xerrors.Errorf("couldn't get deriveID: %v", err)
+		}
+	} else {
+		id = inst.DeriveID("")
+	}
+
+	rt := newRuntime(rst, id, darcID, inst.Spawn.Args, coins)
+	if _, ok := m.ExportedFunc(InitFunc); ok {
+		err = rt.execute(m, InitFunc, fuel)
+		if err != nil {
+			return
+		}
+	}
+
+	sc = append([]byzcoin.StateChange{
+		byzcoin.NewStateChange(byzcoin.Create, id, ContractWasmID, code,
+			darcID),
+	}, rt.stateChanges()...)
+	cout = rt.cout
+	return
+}
+
+// Invoke implements the byzcoin.Contract interface.
+func (c *contractWasm) Invoke(rst byzcoin.ReadOnlyStateTrie,
+	inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange,
+	cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	if inst.Invoke.Command == InitFunc {
+		return nil, nil, xerrors.Errorf("%s is only run when spawning",
+			InitFunc)
+	}
+	m, err := vm.Decode(c.code)
+	if err != nil {
+		return nil, nil, xerrors.Errorf("invalid module: %v", err)
+	}
+	ft, ok := m.ExportedFunc(inst.Invoke.Command)
+	if !ok {
+		return nil, nil, xerrors.Errorf("module doesn't export %s",
+			inst.Invoke.Command)
+	}
+	if len(ft.Params) > 0 || len(ft.Results) > 0 {
+		return nil, nil, xerrors.Errorf("%s must not have parameters or "+
+			"results", inst.Invoke.Command)
+	}
+	fuel, err := getFuel(inst.Invoke.Args)
+	if err != nil {
+		return
+	}
+
+	rt := newRuntime(rst, inst.InstanceID, darcID, inst.Invoke.Args, coins)
+	err = rt.execute(m, inst.Invoke.Command, fuel)
+	if err != nil {
+		return
+	}
+	return rt.stateChanges(), rt.cout, nil
+}
+
+// Delete implements the byzcoin.Contract interface. Only the module is
+// removed, the values and the coins it holds stay in the global state.
+func (c *contractWasm) Delete(rst byzcoin.ReadOnlyStateTrie,
+	inst byzcoin.Instruction, coins []byzcoin.Coin) (sc []byzcoin.StateChange,
+	cout []byzcoin.Coin, err error) {
+	cout = coins
+
+	var darcID darc.ID
+	_, _, _, darcID, err = rst.GetValues(inst.InstanceID.Slice())
+	if err != nil {
+		return
+	}
+
+	sc = byzcoin.StateChanges{
+		byzcoin.NewStateChange(byzcoin.Remove, inst.InstanceID,
+			ContractWasmID, nil, darcID),
+	}
+	return
+}
+
+func getFuel(args byzcoin.Arguments) (uint64, error) {
+	buf := args.Search("fuel")
+	if buf == nil {
+		return DefaultFuel, nil
+	}
+	if len(buf) != 8 {
+		return 0, xerrors.New("fuel must be a 64-bit uint")
+	}
+	fuel := binary.LittleEndian.Uint64(buf)
+	if fuel > MaxFuel {
+		return 0, xerrors.Errorf("fuel is limited to %d", MaxFuel)
+	}
+	return fuel, nil
+}
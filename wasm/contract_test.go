@@ -0,0 +1,166 @@
+package wasm
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/byzcoin/contracts"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/protobuf"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func u64(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	return buf
+}
+
+func getValue(t *testing.T, b *byzcoin.BCTest, id byzcoin.InstanceID) []byte {
+	resp, err := b.Client.GetProofFromLatest(id[:])
+	require.NoError(t, err)
+	if !resp.Proof.InclusionProof.Match(id[:]) {
+		return nil
+	}
+	value, _, _, err := resp.Proof.Get(id[:])
+	require.NoError(t, err)
+	return value
+}
+
+func TestContractWasm(t *testing.T) {
+	b := byzcoin.NewBCTestDefault(t)
+	defer b.CloseAll()
+	b.AddGenesisRules("spawn:wasm", "invoke:wasm.init", "invoke:wasm.inc",
+		"invoke:wasm.spin", "invoke:wasm.deposit", "invoke:wasm.withdraw",
+		"spawn:coin", "invoke:coin.mint", "invoke:coin.fetch",
+		"invoke:coin.store")
+	b.CreateByzCoin()
+
+	code, err := ioutil.ReadFile("testdata/counter.wasm")
+	require.NoError(t, err)
+
+	// Invalid modules are refused.
+	_, resp := b.SendInst(&byzcoin.TxArgs{Wait: 10}, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractWasmID,
+			Args:       byzcoin.Arguments{{Name: "module", Value: code[:100]}},
+		},
+	})
+	require.Contains(t, resp.Error, "invalid module")
+
+	ctx, _ := b.SendInst(nil, byzcoin.Instruction{
+		InstanceID: byzcoin.NewInstanceID(b.GenesisDarc.GetBaseID()),
+		Spawn: &byzcoin.Spawn{
+			ContractID: ContractWasmID,
+			Args:       byzcoin.Arguments{{Name: "module", Value: code}},
+		},
+	})
+	id := ctx.Instructions[0].DeriveID("")
+	require.Equal(t, code, getValue(t, b, id))
+	require.Equal(t, u64(0), getValue(t, b, ValueID(id, []byte("counter"))))
+
+	inc := func(args byzcoin.Arguments) byzcoin.Instruction {
+		return byzcoin.Instruction{
+			InstanceID: id,
+			Invoke: &byzcoin.Invoke{
+				ContractID: ContractWasmID,
+				Command:    "inc",
+				Args:       args,
+			},
+		}
+	}
+	b.SendInst(nil, inc(byzcoin.Arguments{{Name: "step", Value: u64(5)}}),
+		inc(byzcoin.Arguments{{Name: "step", Value: u64(3)}}))
+	require.Equal(t, u64(8), getValue(t, b, ValueID(id, []byte("counter"))))
+
+	// The init function only runs when spawning.
+	_, resp = b.SendInst(&byzcoin.TxArgs{Wait: 10}, byzcoin.Instruction{
+		InstanceID: id,
+		Invoke:     &byzcoin.Invoke{ContractID: ContractWasmID, Command: InitFunc},
+	})
+	require.Contains(t, resp.Error, "only run when spawning")
+	require.Equal(t, u64(8), getValue(t, b, ValueID(id, []byte("counter"))))
+
+	// The module can abort the instruction.
+	_, resp = b.SendInst(&byzcoin.TxArgs{Wait: 10}, inc(nil))
+	require.Contains(t, resp.Error, "aborted: no step")
+
+	// The fuel limits the execution.
+	_, resp = b.SendInst(&byzcoin.TxArgs{Wait: 10}, byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractWasmID,
+			Command:    "spin",
+			Args:       byzcoin.Arguments{{Name: "fuel", Value: u64(10000)}},
+		},
+	})
+	require.Contains(t, resp.Error, "out of fuel")
+	_, resp = b.SendInst(&byzcoin.TxArgs{Wait: 10}, byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractWasmID,
+			Command:    "spin",
+			Args:       byzcoin.Arguments{{Name: "fuel", Value: u64(MaxFuel + 1)}},
+		},
+	})
+	require.Contains(t, resp.Error, "fuel is limited")
+
+	// The module keeps and gives coins.
+	coinID := b.CreateCoin(nil, 1000)
+	b.SendInst(nil, byzcoin.Instruction{
+		InstanceID: coinID,
+		Invoke: &byzcoin.Invoke{
+			ContractID: contracts.ContractCoinID,
+			Command:    "fetch",
+			Args:       byzcoin.Arguments{{Name: "coins", Value: u64(100)}},
+		},
+	}, byzcoin.Instruction{
+		InstanceID: id,
+		Invoke:     &byzcoin.Invoke{ContractID: ContractWasmID, Command: "deposit"},
+	})
+	balanceID := BalanceID(id, contracts.CoinName)
+	require.Equal(t, u64(100), getValue(t, b, balanceID))
+
+	b.SendInst(nil, byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractWasmID,
+			Command:    "withdraw",
+			Args: byzcoin.Arguments{
+				{Name: "coin", Value: contracts.CoinName[:]},
+				{Name: "amount", Value: u64(40)},
+			},
+		},
+	}, byzcoin.Instruction{
+		InstanceID: coinID,
+		Invoke: &byzcoin.Invoke{
+			ContractID: contracts.ContractCoinID,
+			Command:    "store",
+		},
+	})
+	require.Equal(t, u64(60), getValue(t, b, balanceID))
+	var coin byzcoin.Coin
+	require.NoError(t, protobuf.Decode(getValue(t, b, coinID), &coin))
+	require.Equal(t, uint64(940), coin.Value)
+
+	// It can't give more than it has.
+	_, resp = b.SendInst(&byzcoin.TxArgs{Wait: 10}, byzcoin.Instruction{
+		InstanceID: id,
+		Invoke: &byzcoin.Invoke{
+			ContractID: ContractWasmID,
+			Command:    "withdraw",
+			Args: byzcoin.Arguments{
+				{Name: "coin", Value: contracts.CoinName[:]},
+				{Name: "amount", Value: u64(61)},
+			},
+		},
+	})
+	require.Contains(t, resp.Error, "not enough coins")
+}
@@ -0,0 +1,387 @@
+package wasm
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/wasm/vm"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+// HostModule is the name of the module the host functions are imported from.
+const HostModule = "byzcoin"
+
+// The fuel used by the host functions, in addition to one unit for every
+// hostBytesPerFuel bytes copied.
+const (
+	hostCallFuel     = 100
+	stateReadFuel    = 1000
+	stateWriteFuel   = 5000
+	hostBytesPerFuel = 16
+)
+
+// maxValueSize is the maximum size of a value stored by a module.
+const maxValueSize = 1 << 20
+
+// runtime is the state of one execution of a module. The writes to the values
+// are kept until the end of the execution, when they are turned into state
+// changes.
+type runtime struct {
+	rst    byzcoin.ReadOnlyStateTrie
+	id     byzcoin.InstanceID
+	darcID darc.ID
+	args   byzcoin.Arguments
+	cout   []byzcoin.Coin
+	values map[byzcoin.InstanceID][]byte
+	// written is the list of the values written, in the order of the first
+	// write, so that the state changes are always the same.
+	written []byzcoin.InstanceID
+}
+
+func newRuntime(rst byzcoin.ReadOnlyStateTrie, id byzcoin.InstanceID,
+	darcID darc.ID, args byzcoin.Arguments, coins []byzcoin.Coin) *runtime {
+	return &runtime{
+		rst:    rst,
+		id:     id,
+		darcID: darcID,
+		args:   args,
+		cout:   append([]byzcoin.Coin{}, coins...),
+		values: make(map[byzcoin.InstanceID][]byte),
+	}
+}
+
+// ValueID returns the ID of the instance holding the value stored by a module
+// under the key.
+func ValueID(id byzcoin.InstanceID, key []byte) byzcoin.InstanceID {
+	return subID(id, 0, key)
+}
+
+// BalanceID returns the ID of the instance holding the coins of the given
+// type held by a module.
+func BalanceID(id byzcoin.InstanceID, coin byzcoin.InstanceID) byzcoin.InstanceID {
+	return subID(id, 1, coin[:])
+}
+
+func subID(id byzcoin.InstanceID, domain byte, key []byte) byzcoin.InstanceID {
+	h := sha256.New()
+	h.Write(id[:])
+	h.Write([]byte{domain})
+	h.Write(key)
+	return byzcoin.NewInstanceID(h.Sum(nil))
+}
+
+// execute runs the exported function of the module with the given fuel.
+func (rt *runtime) execute(m *vm.Module, name string, fuel uint64) error {
+	instance, err := vm.Instantiate(m, rt.imports(), fuel)
+	if err != nil {
+		return xerrors.Errorf("instantiating module: %v", err)
+	}
+	_, err = instance.Invoke(name)
+	if err != nil {
+		return xerrors.Errorf("executing %s: %v", name, err)
+	}
+	return nil
+}
+
+// get returns the value of the instance, with the writes of this execution,
+// or nil if it doesn't exist.
+func (rt *runtime) get(id byzcoin.InstanceID) []byte {
+	if v, ok := rt.values[id]; ok {
+		return v
+	}
+	v, _, _, _, err := rt.rst.GetValues(id[:])
+	if err != nil {
+		return nil
+	}
+	return v
+}
+
+func (rt *runtime) set(id byzcoin.InstanceID, value []byte) {
+	if _, ok := rt.values[id]; !ok {
+		rt.written = append(rt.written, id)
+	}
+	rt.values[id] = value
+}
+
+func (rt *runtime) balance(coin byzcoin.InstanceID) uint64 {
+	buf := rt.get(BalanceID(rt.id, coin))
+	if len(buf) != 8 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(buf)
+}
+
+func (rt *runtime) setBalance(coin byzcoin.InstanceID, value uint64) {
+	if value == 0 {
+		rt.set(BalanceID(rt.id, coin), nil)
+		return
+	}
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, value)
+	rt.set(BalanceID(rt.id, coin), buf)
+}
+
+// stateChanges returns the state changes for the values written during the
+// execution.
+func (rt *runtime) stateChanges() byzcoin.StateChanges {
+	var scs byzcoin.StateChanges
+	for _, id := range rt.written {
+		value := rt.values[id]
+		_, _, _, _, err := rt.rst.GetValues(id[:])
+		exists := err == nil
+		switch {
+		case value == nil && exists:
+			scs = append(scs, byzcoin.NewStateChange(byzcoin.Remove, id,
+				ContractWasmValueID, nil, rt.darcID))
+		case value != nil && exists:
+			scs = append(scs, byzcoin.NewStateChange(byzcoin.Update, id,
+				ContractWasmValueID, value, rt.darcID))
+		case value != nil:
+			scs = append(scs, byzcoin.NewStateChange(byzcoin.Create, id,
+				ContractWasmValueID, value, rt.darcID))
+		}
+	}
+	return scs
+}
+
+var (
+	i32 = vm.I32
+	i64 = vm.I64
+)
+
+func hostFunc(params []vm.ValueType, results []vm.ValueType,
+	call func(*vm.Instance, []uint64) ([]uint64, error)) vm.HostFunc {
+	return vm.HostFunc{
+		Type: vm.FuncType{Params: params, Results: results},
+		Call: call,
+	}
+}
+
+// read copies a buffer out of the memory of the module and uses the fuel for
+// it.
+func read(instance *vm.Instance, ptr, n uint64) ([]byte, error) {
+	if n > maxValueSize {
+		return nil, xerrors.New("buffer too big")
+	}
+	if err := instance.UseFuel(n / hostBytesPerFuel); err != nil {
+		return nil, err
+	}
+	return instance.Read(uint32(ptr), uint32(n))
+}
+
+// readID reads an instance ID from the memory of the module.
+func readID(instance *vm.Instance, ptr uint64) (byzcoin.InstanceID, error) {
+	buf, err := instance.Read(uint32(ptr), 32)
+	if err != nil {
+		return byzcoin.InstanceID{}, err
+	}
+	return byzcoin.NewInstanceID(buf), nil
+}
+
+// writeValue copies as much of the value as fits in the buffer of the module
+// and returns the size of the value, or -1 if there is no value.
+func writeValue(instance *vm.Instance, value []byte, ptr, n uint64) ([]uint64, error) {
+	if value == nil {
+		return []uint64{uint64(uint32(0xffffffff))}, nil
+	}
+	if uint64(len(value)) < n {
+		n = uint64(len(value))
+	}
+	if err := instance.UseFuel(n / hostBytesPerFuel); err != nil {
+		return nil, err
+	}
+	if err := instance.Write(uint32(ptr), value[:n]); err != nil {
+		return nil, err
+	}
+	return []uint64{uint64(len(value))}, nil
+}
+
+// imports returns the host functions given to the module.
+func (rt *runtime) imports() vm.Imports {
+	return vm.Imports{HostModule: {
+		// arg(name_ptr, name_len, buf_ptr, buf_len i32) i32 copies the
+		// argument of the instruction with the given name.
+		"arg": hostFunc([]vm.ValueType{i32, i32, i32, i32}, []vm.ValueType{i32},
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(hostCallFuel); err != nil {
+					return nil, err
+				}
+				name, err := read(instance, a[0], a[1])
+				if err != nil {
+					return nil, err
+				}
+				return writeValue(instance, rt.args.Search(string(name)), a[2], a[3])
+			}),
+		// get(key_ptr, key_len, buf_ptr, buf_len i32) i32 copies the value
+		// stored under the key.
+		"get": hostFunc([]vm.ValueType{i32, i32, i32, i32}, []vm.ValueType{i32},
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(stateReadFuel); err != nil {
+					return nil, err
+				}
+				key, err := read(instance, a[0], a[1])
+				if err != nil {
+					return nil, err
+				}
+				return writeValue(instance, rt.get(ValueID(rt.id, key)), a[2], a[3])
+			}),
+		// set(key_ptr, key_len, value_ptr, value_len i32) stores the value
+		// under the key.
+		"set": hostFunc([]vm.ValueType{i32, i32, i32, i32}, nil,
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(stateWriteFuel); err != nil {
+					return nil, err
+				}
+				key, err := read(instance, a[0], a[1])
+				if err != nil {
+					return nil, err
+				}
+				value, err := read(instance, a[2], a[3])
+				if err != nil {
+					return nil, err
+				}
+				rt.set(ValueID(rt.id, key), value)
+				return nil, nil
+			}),
+		// remove(key_ptr, key_len i32) removes the value stored under the
+		// key.
+		"remove": hostFunc([]vm.ValueType{i32, i32}, nil,
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(stateWriteFuel); err != nil {
+					return nil, err
+				}
+				key, err := read(instance, a[0], a[1])
+				if err != nil {
+					return nil, err
+				}
+				rt.set(ValueID(rt.id, key), nil)
+				return nil, nil
+			}),
+		// read_instance(id_ptr, buf_ptr, buf_len i32) i32 copies the value
+		// of any instance of the global state.
+		"read_instance": hostFunc([]vm.ValueType{i32, i32, i32}, []vm.ValueType{i32},
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(stateReadFuel); err != nil {
+					return nil, err
+				}
+				id, err := readID(instance, a[0])
+				if err != nil {
+					return nil, err
+				}
+				return writeValue(instance, rt.get(id), a[1], a[2])
+			}),
+		// coin_count() i32 returns the number of coins given to the
+		// instruction.
+		"coin_count": hostFunc(nil, []vm.ValueType{i32},
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(hostCallFuel); err != nil {
+					return nil, err
+				}
+				return []uint64{uint64(len(rt.cout))}, nil
+			}),
+		// coin_get(index, name_ptr i32) i64 copies the name of the coin to
+		// name_ptr and returns its value.
+		"coin_get": hostFunc([]vm.ValueType{i32, i32}, []vm.ValueType{i64},
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(hostCallFuel); err != nil {
+					return nil, err
+				}
+				if a[0] >= uint64(len(rt.cout)) {
+					return nil, xerrors.New("invalid coin index")
+				}
+				c := rt.cout[a[0]]
+				if err := instance.Write(uint32(a[1]), c.Name[:]); err != nil {
+					return nil, err
+				}
+				return []uint64{c.Value}, nil
+			}),
+		// coin_take(index i32, amount i64) moves coins given to the
+		// instruction to the balance of the module.
+		"coin_take": hostFunc([]vm.ValueType{i32, i64}, nil,
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(stateWriteFuel); err != nil {
+					return nil, err
+				}
+				if a[0] >= uint64(len(rt.cout)) {
+					return nil, xerrors.New("invalid coin index")
+				}
+				c := &rt.cout[a[0]]
+				if c.Value < a[1] {
+					return nil, xerrors.New("not enough coins")
+				}
+				balance := rt.balance(c.Name)
+				if balance+a[1] < balance {
+					return nil, xerrors.New("balance overflow")
+				}
+				c.Value -= a[1]
+				rt.setBalance(c.Name, balance+a[1])
+				return nil, nil
+			}),
+		// coin_balance(name_ptr i32) i64 returns the coins of the given type
+		// held by the module.
+		"coin_balance": hostFunc([]vm.ValueType{i32}, []vm.ValueType{i64},
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(stateReadFuel); err != nil {
+					return nil, err
+				}
+				name, err := readID(instance, a[0])
+				if err != nil {
+					return nil, err
+				}
+				return []uint64{rt.balance(name)}, nil
+			}),
+		// coin_give(name_ptr i32, amount i64) moves coins from the balance
+		// of the module to the coins returned by the instruction.
+		"coin_give": hostFunc([]vm.ValueType{i32, i64}, nil,
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(stateWriteFuel); err != nil {
+					return nil, err
+				}
+				name, err := readID(instance, a[0])
+				if err != nil {
+					return nil, err
+				}
+				balance := rt.balance(name)
+				if balance < a[1] {
+					return nil, xerrors.New("not enough coins")
+				}
+				rt.setBalance(name, balance-a[1])
+				for i := range rt.cout {
+					if rt.cout[i].Name.Equal(name) {
+						if rt.cout[i].Value+a[1] < a[1] {
+							return nil, xerrors.New("coin overflow")
+						}
+						rt.cout[i].Value += a[1]
+						return nil, nil
+					}
+				}
+				rt.cout = append(rt.cout, byzcoin.Coin{Name: name, Value: a[1]})
+				return nil, nil
+			}),
+		// log(ptr, len i32) prints the message in the logs of the node.
+		"log": hostFunc([]vm.ValueType{i32, i32}, nil,
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				if err := instance.UseFuel(hostCallFuel); err != nil {
+					return nil, err
+				}
+				msg, err := read(instance, a[0], a[1])
+				if err != nil {
+					return nil, err
+				}
+				log.Lvlf2("wasm %x: %s", rt.id[:], msg)
+				return nil, nil
+			}),
+		// abort(ptr, len i32) stops the execution with the message as error.
+		"abort": hostFunc([]vm.ValueType{i32, i32}, nil,
+			func(instance *vm.Instance, a []uint64) ([]uint64, error) {
+				msg, err := read(instance, a[0], a[1])
+				if err != nil {
+					return nil, err
+				}
+				return nil, xerrors.Errorf("aborted: %s", msg)
+			}),
+	}}
+}
@@ -0,0 +1,43 @@
+;; Source of counter.wasm, used by the tests of the wasm contract.
+(module
+  (import "byzcoin" "get" (func $get (param i32 i32 i32 i32) (result i32)))
+  (import "byzcoin" "set" (func $set (param i32 i32 i32 i32)))
+  (import "byzcoin" "arg" (func $arg (param i32 i32 i32 i32) (result i32)))
+  (import "byzcoin" "abort" (func $abort (param i32 i32)))
+  (import "byzcoin" "coin_get" (func $coin_get (param i32 i32) (result i64)))
+  (import "byzcoin" "coin_take" (func $coin_take (param i32 i64)))
+  (import "byzcoin" "coin_give" (func $coin_give (param i32 i64)))
+  (memory 1)
+  (data (i32.const 0) "counter")
+  (data (i32.const 16) "step")
+  (data (i32.const 32) "no step")
+  (data (i32.const 48) "coin")
+  (data (i32.const 56) "amount")
+
+  ;; Sets the counter to 0.
+  (func (export "init")
+    (call $set (i32.const 0) (i32.const 7) (i32.const 64) (i32.const 8)))
+
+  ;; Adds the "step" argument to the counter.
+  (func (export "inc")
+    (if (i32.ne (call $arg (i32.const 16) (i32.const 4) (i32.const 72) (i32.const 8))
+                (i32.const 8))
+      (then (call $abort (i32.const 32) (i32.const 7))))
+    (drop (call $get (i32.const 0) (i32.const 7) (i32.const 64) (i32.const 8)))
+    (i64.store (i32.const 64)
+      (i64.add (i64.load (i32.const 64)) (i64.load (i32.const 72))))
+    (call $set (i32.const 0) (i32.const 7) (i32.const 64) (i32.const 8)))
+
+  ;; Never ends.
+  (func (export "spin")
+    (loop (br 0)))
+
+  ;; Keeps all the coins of the first input.
+  (func (export "deposit")
+    (call $coin_take (i32.const 0) (call $coin_get (i32.const 0) (i32.const 128))))
+
+  ;; Gives "amount" coins of the type "coin".
+  (func (export "withdraw")
+    (drop (call $arg (i32.const 48) (i32.const 4) (i32.const 128) (i32.const 32)))
+    (drop (call $arg (i32.const 56) (i32.const 6) (i32.const 160) (i32.const 8)))
+    (call $coin_give (i32.const 128) (i64.load (i32.const 160)))))
@@ -0,0 +1,612 @@
+package vm
+
+import (
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// MaxPages is the maximum number of pages of 64KiB of memory an instance can
+// use, whatever the limits of the module say.
+const MaxPages = 16
+
+const (
+	pageSize     = 65536
+	maxCallDepth = 256
+	maxStackSize = 1 << 16
+	// maxFrameLocals is the maximum number of locals of all the functions
+	// being called, so that a deep recursion of functions with many locals
+	// can't use too much memory.
+	maxFrameLocals = 1 << 16
+	// localsPerFuel is the number of locals of a called function for one
+	// unit of fuel.
+	localsPerFuel = 8
+	// growFuel is the fuel used for every page added by memory.grow.
+	growFuel = 1000
+	// bulkBytesPerFuel is the number of bytes copied or filled by the bulk
+	// memory instructions for one unit of fuel.
+	bulkBytesPerFuel = 64
+)
+
+// ErrOutOfFuel is returned when the execution needs more fuel than it has
+// been given.
+var ErrOutOfFuel = xerrors.New("out of fuel")
+
+// HostFunc is a function given by the host to the module.
+type HostFunc struct {
+	Type FuncType
+	// Call gets the arguments of the function and returns its results. An
+	// error stops the execution of the module.
+	Call func(vm *Instance, args []uint64) ([]uint64, error)
+}
+
+// Imports are the host functions that can be imported by a module, by module
+// name and then by function name.
+type Imports map[string]map[string]HostFunc
+
+// trap is used to unwind the stack of the interpreter when the execution
+// fails.
+type trap struct {
+	err error
+}
+
+func trapf(format string, args ...interface{}) trap {
+	return trap{xerrors.Errorf(format, args...)}
+}
+
+// label is the target of a branch.
+type label struct {
+	// height of the value stack when entering the block.
+	height int
+	// arity is the number of values kept on the stack by a branch.
+	arity int
+	// next is the position of the next instruction after a branch.
+	next int
+}
+
+// Instance is a module with its memory, globals and table, ready to execute
+// its functions. The execution is deterministic: the same calls with the same
+// host functions always give the same results and use the same fuel. An
+// Instance must not be used concurrently.
+type Instance struct {
+	module   *Module
+	hosts    []HostFunc
+	memory   []byte
+	maxPages uint32
+	globals  []uint64
+	// table holds the function indexes, -1 being a null reference.
+	table   []int64
+	dropped []bool
+	stack   []uint64
+	depth   int
+	// locals is the number of locals of the functions being called.
+	locals int
+	fuel   uint64
+}
+
+// Instantiate links the module to the host functions, initializes its memory
+// and table, and runs its start function, if any. The fuel is used by all
+// the executions of the instance.
+func Instantiate(m *Module, imports Imports, fuel uint64) (*Instance, error) {
+	vm := &Instance{module: m, fuel: fuel}
+	for _, imp := range m.Imports {
+		h, ok := imports[imp.Module][imp.Name]
+		if !ok {
+			return nil, xerrors.Errorf("unknown import %s.%s", imp.Module, imp.Name)
+		}
+		if !h.Type.equal(m.Types[imp.Type]) {
+			return nil, xerrors.Errorf("import %s.%s: wrong signature",
+				imp.Module, imp.Name)
+		}
+		vm.hosts = append(vm.hosts, h)
+	}
+
+	for _, g := range m.globals {
+		vm.globals = append(vm.globals, g.Init)
+	}
+
+	if m.memory != nil {
+		vm.maxPages = MaxPages
+		if m.memory.HasMax && m.memory.Max < vm.maxPages {
+			vm.maxPages = m.memory.Max
+		}
+		vm.memory = make([]byte, int(m.memory.Min)*pageSize)
+	}
+
+	if m.table != nil {
+		vm.table = make([]int64, m.table.Min)
+		for i := range vm.table {
+			vm.table[i] = -1
+		}
+	}
+	for _, e := range m.elems {
+		if uint64(e.Offset)+uint64(len(e.Funcs)) > uint64(len(vm.table)) {
+			return nil, xerrors.New("element segment out of bounds")
+		}
+		for i, idx := range e.Funcs {
+			vm.table[int(e.Offset)+i] = int64(idx)
+		}
+	}
+
+	vm.dropped = make([]bool, len(m.datas))
+	for i, d := range m.datas {
+		if d.Passive {
+			continue
+		}
+		if uint64(d.Offset)+uint64(len(d.Init)) > uint64(len(vm.memory)) {
+			return nil, xerrors.New("data segment out of bounds")
+		}
+		copy(vm.memory[d.Offset:], d.Init)
+		vm.dropped[i] = true
+	}
+
+	if m.start != nil {
+		err := vm.protect(func() {
+			vm.call(*m.start)
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("start function: %v", err)
+		}
+	}
+	return vm, nil
+}
+
+// Invoke calls the exported function with the given arguments. The 32-bit
+// arguments and results are stored in the lower bits.
+func (vm *Instance) Invoke(name string, args ...uint64) ([]uint64, error) {
+	var idx uint32
+	found := false
+	for _, e := range vm.module.Exports {
+		if e.Name == name {
+			idx, found = e.Index, true
+			break
+		}
+	}
+	if !found {
+		return nil, xerrors.Errorf("no exported function %s", name)
+	}
+	ft := vm.module.funcType(idx)
+	if len(args) != len(ft.Params) {
+		return nil, xerrors.Errorf("%s needs %d arguments, got %d", name,
+			len(ft.Params), len(args))
+	}
+
+	vm.stack = vm.stack[:0]
+	vm.depth = 0
+	vm.locals = 0
+	for i, arg := range args {
+		if ft.Params[i] == I32 {
+			arg = uint64(uint32(arg))
+		}
+		vm.stack = append(vm.stack, arg)
+	}
+	err := vm.protect(func() {
+		vm.call(idx)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(vm.stack) != len(ft.Results) {
+		return nil, xerrors.New("wrong number of results")
+	}
+	return append([]uint64{}, vm.stack...), nil
+}
+
+// Fuel returns the fuel left.
+func (vm *Instance) Fuel() uint64 {
+	return vm.fuel
+}
+
+// UseFuel is used by the host functions to pay for their work.
+func (vm *Instance) UseFuel(n uint64) error {
+	if n > vm.fuel {
+		vm.fuel = 0
+		return ErrOutOfFuel
+	}
+	vm.fuel -= n
+	return nil
+}
+
+// Read returns a copy of the memory of the instance between ptr and ptr+n.
+func (vm *Instance) Read(ptr, n uint32) ([]byte, error) {
+	if uint64(ptr)+uint64(n) > uint64(len(vm.memory)) {
+		return nil, xerrors.New("memory access out of bounds")
+	}
+	return append([]byte{}, vm.memory[ptr:ptr+n]...), nil
+}
+
+// Write copies the buffer to the memory of the instance at ptr.
+func (vm *Instance) Write(ptr uint32, buf []byte) error {
+	if uint64(ptr)+uint64(len(buf)) > uint64(len(vm.memory)) {
+		return xerrors.New("memory access out of bounds")
+	}
+	copy(vm.memory[ptr:], buf)
+	return nil
+}
+
+// protect runs f and returns the error of a trap. The code of the functions
+// is checked when decoding the module, but not the types of the values on
+// the stack, so a wrong module can also make the interpreter panic.
+func (vm *Instance) protect(f func()) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if t, ok := r.(trap); ok {
+				err = t.err
+			} else {
+				err = xerrors.Errorf("trap: %v", r)
+			}
+		}
+	}()
+	f()
+	return nil
+}
+
+func (vm *Instance) useFuel(n uint64) {
+	if err := vm.UseFuel(n); err != nil {
+		panic(trap{err})
+	}
+}
+
+func (vm *Instance) push(v uint64) {
+	if len(vm.stack) >= maxStackSize {
+		panic(trapf("stack overflow"))
+	}
+	vm.stack = append(vm.stack, v)
+}
+
+func (vm *Instance) pop() uint64 {
+	if len(vm.stack) == 0 {
+		panic(trapf("stack underflow"))
+	}
+	v := vm.stack[len(vm.stack)-1]
+	vm.stack = vm.stack[:len(vm.stack)-1]
+	return v
+}
+
+// unwind keeps the arity values on top of the stack and removes the values
+// between them and the height.
+func (vm *Instance) unwind(height, arity int) {
+	top := len(vm.stack) - arity
+	if top < height {
+		panic(trapf("stack underflow"))
+	}
+	copy(vm.stack[height:], vm.stack[top:])
+	vm.stack = vm.stack[:height+arity]
+}
+
+// call calls the function with the given index, its arguments being on the
+// stack.
+func (vm *Instance) call(idx uint32) {
+	ft := vm.module.funcType(idx)
+	n := len(ft.Params)
+	if len(vm.stack) < n {
+		panic(trapf("stack underflow"))
+	}
+	args := append([]uint64{}, vm.stack[len(vm.stack)-n:]...)
+	vm.stack = vm.stack[:len(vm.stack)-n]
+
+	if idx < uint32(len(vm.hosts)) {
+		res, err := vm.hosts[idx].Call(vm, args)
+		if err != nil {
+			panic(trap{err})
+		}
+		if len(res) != len(ft.Results) {
+			panic(trapf("host function returned %d results", len(res)))
+		}
+		for _, v := range res {
+			vm.push(v)
+		}
+		return
+	}
+
+	vm.depth++
+	if vm.depth > maxCallDepth {
+		panic(trapf("call stack exhausted"))
+	}
+	f := &vm.module.funcs[idx-uint32(len(vm.hosts))]
+	nLocals := n + len(f.Locals)
+	vm.useFuel(uint64(nLocals / localsPerFuel))
+	vm.locals += nLocals
+	if vm.locals > maxFrameLocals {
+		panic(trapf("call stack exhausted"))
+	}
+	locals := make([]uint64, nLocals)
+	copy(locals, args)
+	vm.run(f, locals, len(ft.Results))
+	vm.locals -= nLocals
+	vm.depth--
+}
+
+func blockArity(bt byte) int {
+	if bt == blockTypeEmpty {
+		return 0
+	}
+	return 1
+}
+
+// run executes the code of the function until it returns.
+func (vm *Instance) run(f *function, locals []uint64, arity int) {
+	base := len(vm.stack)
+	r := &reader{buf: f.Code}
+	var labels []label
+
+	// branch jumps to the label at the given depth and returns true if it is
+	// the function itself.
+	branch := func(depth uint32) bool {
+		if int(depth) >= len(labels) {
+			vm.unwind(base, arity)
+			return true
+		}
+		l := labels[len(labels)-1-int(depth)]
+		vm.unwind(l.height, l.arity)
+		labels = labels[:len(labels)-1-int(depth)]
+		r.pos = l.next
+		return false
+	}
+
+	for {
+		vm.useFuel(1)
+		pos := r.pos
+		op := vm.must8(r)
+		switch op {
+		case opUnreachable:
+			panic(trapf("unreachable"))
+		case opNop:
+		case opBlock:
+			bt := vm.must8(r)
+			labels = append(labels, label{height: len(vm.stack),
+				arity: blockArity(bt), next: f.blocks[pos].endPos + 1})
+		case opLoop:
+			vm.must8(r)
+			// A branch to a loop starts it again.
+			labels = append(labels, label{height: len(vm.stack), next: pos})
+		case opIf:
+			bt := vm.must8(r)
+			bi := f.blocks[pos]
+			l := label{height: len(vm.stack) - 1, arity: blockArity(bt),
+				next: bi.endPos + 1}
+			if vm.pop() != 0 {
+				labels = append(labels, l)
+			} else if bi.elsePos >= 0 {
+				labels = append(labels, l)
+				r.pos = bi.elsePos + 1
+			} else {
+				r.pos = bi.endPos + 1
+			}
+		case opElse:
+			// The end of the then branch.
+			l := labels[len(labels)-1]
+			labels = labels[:len(labels)-1]
+			r.pos = l.next
+		case opEnd:
+			if len(labels) == 0 {
+				vm.unwind(base, arity)
+				return
+			}
+			labels = labels[:len(labels)-1]
+		case opBr:
+			if branch(vm.mustU32(r)) {
+				return
+			}
+		case opBrIf:
+			depth := vm.mustU32(r)
+			if vm.pop() != 0 && branch(depth) {
+				return
+			}
+		case opBrTable:
+			n := vm.mustU32(r)
+			i := uint32(vm.pop())
+			var depth uint32
+			for j := uint32(0); j <= n; j++ {
+				d := vm.mustU32(r)
+				if j == i || j == n {
+					depth = d
+					if j == i {
+						break
+					}
+				}
+			}
+			if branch(depth) {
+				return
+			}
+		case opReturn:
+			vm.unwind(base, arity)
+			return
+		case opCall:
+			vm.call(vm.mustU32(r))
+		case opCallIndirect:
+			ft := vm.module.Types[vm.mustU32(r)]
+			vm.must8(r)
+			i := uint32(vm.pop())
+			if i >= uint32(len(vm.table)) || vm.table[i] < 0 {
+				panic(trapf("undefined element %d", i))
+			}
+			idx := uint32(vm.table[i])
+			if !vm.module.funcType(idx).equal(ft) {
+				panic(trapf("indirect call type mismatch"))
+			}
+			vm.call(idx)
+		case opDrop:
+			vm.pop()
+		case opSelect, opSelectTyped:
+			if op == opSelectTyped {
+				if _, err := r.valueTypes(); err != nil {
+					panic(trap{err})
+				}
+			}
+			c, b, a := vm.pop(), vm.pop(), vm.pop()
+			if c != 0 {
+				vm.push(a)
+			} else {
+				vm.push(b)
+			}
+		case opLocalGet:
+			vm.push(locals[vm.mustU32(r)])
+		case opLocalSet:
+			locals[vm.mustU32(r)] = vm.pop()
+		case opLocalTee:
+			v := vm.pop()
+			locals[vm.mustU32(r)] = v
+			vm.push(v)
+		case opGlobalGet:
+			vm.push(vm.globals[vm.mustU32(r)])
+		case opGlobalSet:
+			vm.globals[vm.mustU32(r)] = vm.pop()
+		case opMemorySize:
+			vm.must8(r)
+			vm.push(uint64(len(vm.memory) / pageSize))
+		case opMemoryGrow:
+			vm.must8(r)
+			vm.push(vm.grow(uint32(vm.pop())))
+		case opI32Const:
+			v, err := r.sleb(32)
+			if err != nil {
+				panic(trap{err})
+			}
+			vm.push(uint64(uint32(v)))
+		case opI64Const:
+			v, err := r.sleb(64)
+			if err != nil {
+				panic(trap{err})
+			}
+			vm.push(uint64(v))
+		case opPrefixFC:
+			vm.bulk(r)
+		default:
+			if op >= opI32Load && op <= opI64Store32 {
+				vm.memoryOp(op, r)
+			} else {
+				vm.numeric(op)
+			}
+		}
+	}
+}
+
+func (vm *Instance) must8(r *reader) byte {
+	b, err := r.byte()
+	if err != nil {
+		panic(trap{err})
+	}
+	return b
+}
+
+func (vm *Instance) mustU32(r *reader) uint32 {
+	v, err := r.u32()
+	if err != nil {
+		panic(trap{err})
+	}
+	return v
+}
+
+// grow adds n pages to the memory and returns the previous number of pages,
+// or -1 if the memory can't grow.
+func (vm *Instance) grow(n uint32) uint64 {
+	pages := uint32(len(vm.memory) / pageSize)
+	if uint64(pages)+uint64(n) > uint64(vm.maxPages) {
+		return uint64(uint32(0xffffffff))
+	}
+	vm.useFuel(uint64(n) * growFuel)
+	vm.memory = append(vm.memory, make([]byte, int(n)*pageSize)...)
+	return uint64(pages)
+}
+
+// address returns the memory slice accessed by a load or a store.
+func (vm *Instance) address(base uint64, offset uint32, size int) []byte {
+	ea := uint64(uint32(base)) + uint64(offset)
+	if ea+uint64(size) > uint64(len(vm.memory)) {
+		panic(trapf("memory access out of bounds"))
+	}
+	return vm.memory[ea : ea+uint64(size)]
+}
+
+func (vm *Instance) memoryOp(op byte, r *reader) {
+	vm.mustU32(r)
+	offset := vm.mustU32(r)
+	le := binary.LittleEndian
+	if op >= opI32Store {
+		v := vm.pop()
+		switch op {
+		case opI32Store:
+			le.PutUint32(vm.address(vm.pop(), offset, 4), uint32(v))
+		case opI64Store:
+			le.PutUint64(vm.address(vm.pop(), offset, 8), v)
+		case opI32Store8, opI64Store8:
+			vm.address(vm.pop(), offset, 1)[0] = byte(v)
+		case opI32Store16, opI64Store16:
+			le.PutUint16(vm.address(vm.pop(), offset, 2), uint16(v))
+		case opI64Store32:
+			le.PutUint32(vm.address(vm.pop(), offset, 4), uint32(v))
+		default:
+			panic(trapf("unsupported instruction 0x%x", op))
+		}
+		return
+	}
+
+	addr := vm.pop()
+	var v uint64
+	switch op {
+	case opI32Load:
+		v = uint64(le.Uint32(vm.address(addr, offset, 4)))
+	case opI64Load:
+		v = le.Uint64(vm.address(addr, offset, 8))
+	case opI32Load8S:
+		v = uint64(uint32(int8(vm.address(addr, offset, 1)[0])))
+	case opI32Load8U, opI64Load8U:
+		v = uint64(vm.address(addr, offset, 1)[0])
+	case opI32Load16S:
+		v = uint64(uint32(int16(le.Uint16(vm.address(addr, offset, 2)))))
+	case opI32Load16U, opI64Load16U:
+		v = uint64(le.Uint16(vm.address(addr, offset, 2)))
+	case opI64Load8S:
+		v = uint64(int8(vm.address(addr, offset, 1)[0]))
+	case opI64Load16S:
+		v = uint64(int16(le.Uint16(vm.address(addr, offset, 2))))
+	case opI64Load32S:
+		v = uint64(int32(le.Uint32(vm.address(addr, offset, 4))))
+	case opI64Load32U:
+		v = uint64(le.Uint32(vm.address(addr, offset, 4)))
+	default:
+		panic(trapf("unsupported instruction 0x%x", op))
+	}
+	vm.push(v)
+}
+
+// bulk executes the bulk memory instructions.
+func (vm *Instance) bulk(r *reader) {
+	sub := vm.mustU32(r)
+	switch sub {
+	case fcMemoryInit:
+		idx := vm.mustU32(r)
+		vm.must8(r)
+		n, src, dst := uint32(vm.pop()), uint32(vm.pop()), uint32(vm.pop())
+		var seg []byte
+		if !vm.dropped[idx] {
+			seg = vm.module.datas[idx].Init
+		}
+		if uint64(src)+uint64(n) > uint64(len(seg)) {
+			panic(trapf("memory.init out of bounds"))
+		}
+		vm.useFuel(uint64(n) / bulkBytesPerFuel)
+		copy(vm.address(uint64(dst), 0, int(n)), seg[src:src+n])
+	case fcDataDrop:
+		vm.dropped[vm.mustU32(r)] = true
+	case fcMemoryCopy:
+		vm.must8(r)
+		vm.must8(r)
+		n, src, dst := uint32(vm.pop()), uint32(vm.pop()), uint32(vm.pop())
+		from := vm.address(uint64(src), 0, int(n))
+		to := vm.address(uint64(dst), 0, int(n))
+		vm.useFuel(uint64(n) / bulkBytesPerFuel)
+		copy(to, from)
+	case fcMemoryFill:
+		vm.must8(r)
+		n, v, dst := uint32(vm.pop()), byte(vm.pop()), uint32(vm.pop())
+		to := vm.address(uint64(dst), 0, int(n))
+		vm.useFuel(uint64(n) / bulkBytesPerFuel)
+		for i := range to {
+			to[i] = v
+		}
+	default:
+		panic(trapf("unsupported instruction 0xfc %d", sub))
+	}
+}
@@ -0,0 +1,180 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3/log"
+	"golang.org/x/xerrors"
+)
+
+func TestMain(m *testing.M) {
+	log.MainTest(m)
+}
+
+func testInstance(t *testing.T, fuel uint64) *Instance {
+	i32s := func(n int) []ValueType {
+		vts := make([]ValueType, n)
+		for i := range vts {
+			vts[i] = I32
+		}
+		return vts
+	}
+	tm := testModule{
+		types: []FuncType{
+			voidType,
+			{Params: []ValueType{I64}, Results: []ValueType{I64}},
+			{Params: i32s(1), Results: i32s(1)},
+			{Params: i32s(2), Results: i32s(1)},
+			{Params: []ValueType{I64, I64}, Results: []ValueType{I64}},
+		},
+		imports: []Import{{Module: "env", Name: "add", Type: 4}},
+		funcs: []testFunc{
+			// 1: factorial
+			{typ: 1, code: code(opLocalGet, 0, opI64Eqz, opIf, byte(I64),
+				i64c(1), opElse, opLocalGet, 0, opLocalGet, 0, i64c(1), opI64Sub,
+				opCall, 1, opI64Mul, opEnd, opEnd)},
+			// 2: sum from 1 to n
+			{typ: 2, locals: []ValueType{I32}, code: code(opBlock, blockTypeEmpty,
+				opLoop, blockTypeEmpty, opLocalGet, 0, opI32Eqz, opBrIf, 1,
+				opLocalGet, 1, opLocalGet, 0, opI32Add, opLocalSet, 1,
+				opLocalGet, 0, i32c(1), opI32Sub, opLocalSet, 0, opBr, 0, opEnd,
+				opEnd, opLocalGet, 1, opEnd)},
+			// 3: store as 32 bits and load as signed 16 bits
+			{typ: 2, code: code(i32c(100), opLocalGet, 0, opI32Store, 2, 0,
+				i32c(100), opI32Load16S, 1, 0, opEnd)},
+			// 4: switch
+			{typ: 2, code: code(opBlock, blockTypeEmpty, opBlock, blockTypeEmpty,
+				opBlock, blockTypeEmpty, opLocalGet, 0, opBrTable, 2, 0, 1, 2,
+				opEnd, i32c(10), opReturn, opEnd, i32c(20), opReturn, opEnd,
+				i32c(30), opEnd)},
+			// 5: signed division
+			{typ: 3, code: code(opLocalGet, 0, opLocalGet, 1, opI32DivS, opEnd)},
+			// 6: infinite loop
+			{typ: 0, code: code(opLoop, blockTypeEmpty, opBr, 0, opEnd, opEnd)},
+			// 7: host call
+			{typ: 4, code: code(opLocalGet, 0, opLocalGet, 1, opCall, 0, opEnd)},
+			// 8: indirect call of sum(10)
+			{typ: 2, code: code(i32c(10), opLocalGet, 0, opCallIndirect, 2, 0,
+				opEnd)},
+			// 9: memory.grow
+			{typ: 2, code: code(opLocalGet, 0, opMemoryGrow, 0, opEnd)},
+			// 10: infinite recursion
+			{typ: 0, code: code(opCall, 10, opEnd)},
+			// 11: unreachable
+			{typ: 0, code: code(opUnreachable, opEnd)},
+			// 12: recursion with many locals
+			{typ: 0, locals: i32s(2000), code: code(opCall, 12, opEnd)},
+			// 13: many locals
+			{typ: 0, locals: i32s(8000), code: code(opEnd)},
+		},
+		memory: &limits{Min: 1, Max: 2, HasMax: true},
+		exports: []Export{{Name: "fac", Index: 1}, {Name: "sum", Index: 2},
+			{Name: "mem", Index: 3}, {Name: "switch", Index: 4},
+			{Name: "div", Index: 5}, {Name: "spin", Index: 6},
+			{Name: "host", Index: 7}, {Name: "indirect", Index: 8},
+			{Name: "grow", Index: 9}, {Name: "recurse", Index: 10},
+			{Name: "trap", Index: 11}, {Name: "deep", Index: 12},
+			{Name: "locals", Index: 13}},
+		table: []uint32{2, 1},
+		datas: []data{{Offset: 8, Init: []byte("hello")}},
+	}
+	m, err := Decode(tm.encode())
+	require.NoError(t, err)
+
+	add := HostFunc{
+		Type: tm.types[4],
+		Call: func(vm *Instance, args []uint64) ([]uint64, error) {
+			if err := vm.UseFuel(10); err != nil {
+				return nil, err
+			}
+			if args[0] == 0 {
+				return nil, xerrors.New("host error")
+			}
+			return []uint64{args[0] + args[1]}, nil
+		},
+	}
+	vm, err := Instantiate(m, Imports{"env": {"add": add}}, fuel)
+	require.NoError(t, err)
+	return vm
+}
+
+func TestInstance_Invoke(t *testing.T) {
+	vm := testInstance(t, 100000)
+
+	check := func(name string, exp uint64, args ...uint64) {
+		res, err := vm.Invoke(name, args...)
+		require.NoError(t, err, name)
+		require.Equal(t, []uint64{exp}, res, name)
+	}
+	check("fac", 3628800, 10)
+	check("sum", 5050, 100)
+	check("mem", 0xffffffff, 0xffff)
+	check("mem", 0x7fff, 0x17fff)
+	check("switch", 10, 0)
+	check("switch", 20, 1)
+	check("switch", 30, 2)
+	check("switch", 30, 100)
+	check("div", uint64(uint32(0xfffffffd)), uint64(uint32(0xfffffffa)), 2)
+	check("host", 7, 3, 4)
+	check("indirect", 55, 0)
+	check("grow", 1, 1)
+	check("grow", 0xffffffff, 1)
+
+	buf, err := vm.Read(8, 5)
+	require.NoError(t, err)
+	require.Equal(t, []byte("hello"), buf)
+	_, err = vm.Read(2*pageSize-2, 5)
+	require.Error(t, err)
+
+	_, err = vm.Invoke("fac")
+	require.Error(t, err)
+	_, err = vm.Invoke("missing")
+	require.Error(t, err)
+}
+
+func TestInstance_Traps(t *testing.T) {
+	vm := testInstance(t, 100000)
+
+	trap := func(msg, name string, args ...uint64) {
+		_, err := vm.Invoke(name, args...)
+		require.Error(t, err, name)
+		require.Contains(t, err.Error(), msg, name)
+	}
+	trap("divide by zero", "div", 1, 0)
+	trap("overflow", "div", 0x80000000, 0xffffffff)
+	trap("host error", "host", 0, 1)
+	trap("type mismatch", "indirect", 1)
+	trap("undefined element", "indirect", 2)
+	trap("call stack exhausted", "recurse")
+	trap("call stack exhausted", "deep")
+	trap("unreachable", "trap")
+
+	// The instance can still be used after a trap.
+	res, err := vm.Invoke("sum", 3)
+	require.NoError(t, err)
+	require.Equal(t, []uint64{6}, res)
+}
+
+func TestInstance_Fuel(t *testing.T) {
+	vm := testInstance(t, 10000)
+	_, err := vm.Invoke("spin")
+	require.True(t, xerrors.Is(err, ErrOutOfFuel))
+	require.Equal(t, uint64(0), vm.Fuel())
+
+	// The same execution always uses the same fuel.
+	vm1 := testInstance(t, 10000)
+	vm2 := testInstance(t, 10000)
+	_, err = vm1.Invoke("fac", 20)
+	require.NoError(t, err)
+	_, err = vm2.Invoke("fac", 20)
+	require.NoError(t, err)
+	require.Equal(t, vm1.Fuel(), vm2.Fuel())
+	require.True(t, vm1.Fuel() < 10000)
+
+	// The locals of a function are paid when it is called.
+	fuel := vm1.Fuel()
+	_, err = vm1.Invoke("locals")
+	require.NoError(t, err)
+	require.True(t, fuel-vm1.Fuel() > 8000/localsPerFuel)
+}
@@ -0,0 +1,565 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"golang.org/x/xerrors"
+)
+
+// ValueType is the type of a value on the stack. Only the integer types are
+// supported, as the floating point instructions are not guaranteed to give
+// the same results on every platform.
+type ValueType byte
+
+const (
+	// I32 is a 32-bit integer.
+	I32 ValueType = 0x7f
+	// I64 is a 64-bit integer.
+	I64 ValueType = 0x7e
+)
+
+// FuncType is the signature of a function.
+type FuncType struct {
+	Params  []ValueType
+	Results []ValueType
+}
+
+func (ft FuncType) equal(other FuncType) bool {
+	return bytes.Equal(valueTypes(ft.Params), valueTypes(other.Params)) &&
+		bytes.Equal(valueTypes(ft.Results), valueTypes(other.Results))
+}
+
+func valueTypes(vts []ValueType) []byte {
+	buf := make([]byte, len(vts))
+	for i, vt := range vts {
+		buf[i] = byte(vt)
+	}
+	return buf
+}
+
+// Import is a function imported by the module. Only functions can be
+// imported.
+type Import struct {
+	Module string
+	Name   string
+	Type   uint32
+}
+
+// Export is a function exported by the module. The exports of other kinds
+// are ignored.
+type Export struct {
+	Name  string
+	Index uint32
+}
+
+type global struct {
+	Type    ValueType
+	Mutable bool
+	Init    uint64
+}
+
+type limits struct {
+	Min uint32
+	Max uint32
+	// HasMax is false if there is no maximum.
+	HasMax bool
+}
+
+type element struct {
+	Offset uint32
+	Funcs  []uint32
+}
+
+type data struct {
+	// Passive segments are only copied by memory.init.
+	Passive bool
+	Offset  uint32
+	Init    []byte
+}
+
+type function struct {
+	Type   uint32
+	Locals []ValueType
+	Code   []byte
+	// blocks maps the position of every block, loop and if instruction to
+	// the position of its else and end instructions.
+	blocks map[int]blockInfo
+}
+
+type blockInfo struct {
+	elsePos int
+	endPos  int
+}
+
+// Module is a decoded WebAssembly module.
+type Module struct {
+	Types   []FuncType
+	Imports []Import
+	Exports []Export
+	funcs   []function
+	table   *limits
+	memory  *limits
+	globals []global
+	elems   []element
+	datas   []data
+	start   *uint32
+}
+
+const (
+	wasmMagic   = "\x00asm"
+	wasmVersion = 1
+)
+
+const (
+	sectionCustom byte = iota
+	sectionType
+	sectionImport
+	sectionFunction
+	sectionTable
+	sectionMemory
+	sectionGlobal
+	sectionExport
+	sectionStart
+	sectionElement
+	sectionCode
+	sectionData
+	sectionDataCount
+)
+
+// Decode parses the binary format of a module and checks that it only uses
+// the supported features.
+func Decode(buf []byte) (*Module, error) {
+	if len(buf) < 8 || string(buf[:4]) != wasmMagic {
+		return nil, xerrors.New("not a wasm module")
+	}
+	if binary.LittleEndian.Uint32(buf[4:8]) != wasmVersion {
+		return nil, xerrors.New("unsupported wasm version")
+	}
+
+	m := &Module{}
+	r := &reader{buf: buf, pos: 8}
+	var funcTypes []uint32
+	var last byte
+	for !r.done() {
+		id, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		size, err := r.u32()
+		if err != nil {
+			return nil, err
+		}
+		content, err := r.bytes(int(size))
+		if err != nil {
+			return nil, err
+		}
+		if id != sectionCustom {
+			// The data count section comes before the code section.
+			order := id
+			if id == sectionDataCount {
+				order = sectionCode - 1
+			} else if id >= sectionCode {
+				order = id + 1
+			}
+			if order <= last {
+				return nil, xerrors.Errorf("section %d out of order", id)
+			}
+			last = order
+		}
+
+		sr := &reader{buf: content}
+		switch id {
+		case sectionCustom, sectionDataCount:
+		case sectionType:
+			err = m.decodeTypes(sr)
+		case sectionImport:
+			err = m.decodeImports(sr)
+		case sectionFunction:
+			funcTypes, err = sr.u32s()
+		case sectionTable:
+			err = m.decodeTable(sr)
+		case sectionMemory:
+			err = m.decodeMemory(sr)
+		case sectionGlobal:
+			err = m.decodeGlobals(sr)
+		case sectionExport:
+			err = m.decodeExports(sr)
+		case sectionStart:
+			var idx uint32
+			idx, err = sr.u32()
+			m.start = &idx
+		case sectionElement:
+			err = m.decodeElements(sr)
+		case sectionCode:
+			err = m.decodeCode(sr, funcTypes)
+		case sectionData:
+			err = m.decodeData(sr)
+		default:
+			err = xerrors.Errorf("unknown section %d", id)
+		}
+		if err != nil {
+			return nil, xerrors.Errorf("section %d: %v", id, err)
+		}
+		if id != sectionCustom && !sr.done() {
+			return nil, xerrors.Errorf("section %d: trailing bytes", id)
+		}
+	}
+	if len(funcTypes) != len(m.funcs) {
+		return nil, xerrors.New("function and code sections don't match")
+	}
+	if err := m.check(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *Module) decodeTypes(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		form, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if form != 0x60 {
+			return xerrors.New("invalid function type")
+		}
+		var ft FuncType
+		if ft.Params, err = r.valueTypes(); err != nil {
+			return err
+		}
+		if ft.Results, err = r.valueTypes(); err != nil {
+			return err
+		}
+		if len(ft.Results) > 1 {
+			return xerrors.New("multiple results are not supported")
+		}
+		m.Types = append(m.Types, ft)
+	}
+	return nil
+}
+
+func (m *Module) decodeImports(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var imp Import
+		if imp.Module, err = r.name(); err != nil {
+			return err
+		}
+		if imp.Name, err = r.name(); err != nil {
+			return err
+		}
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		if kind != 0 {
+			return xerrors.Errorf("import %s.%s: only functions can be imported",
+				imp.Module, imp.Name)
+		}
+		if imp.Type, err = r.u32(); err != nil {
+			return err
+		}
+		m.Imports = append(m.Imports, imp)
+	}
+	return nil
+}
+
+func (m *Module) decodeTable(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return xerrors.New("only one table is supported")
+	}
+	if n == 0 {
+		return nil
+	}
+	elemType, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if elemType != 0x70 {
+		return xerrors.New("only funcref tables are supported")
+	}
+	l, err := r.limits()
+	if err != nil {
+		return err
+	}
+	m.table = &l
+	return nil
+}
+
+func (m *Module) decodeMemory(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if n > 1 {
+		return xerrors.New("only one memory is supported")
+	}
+	if n == 0 {
+		return nil
+	}
+	l, err := r.limits()
+	if err != nil {
+		return err
+	}
+	m.memory = &l
+	return nil
+}
+
+func (m *Module) decodeGlobals(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		var g global
+		vt, err := r.byte()
+		if err != nil {
+			return err
+		}
+		g.Type = ValueType(vt)
+		if g.Type != I32 && g.Type != I64 {
+			return xerrors.New("only integer globals are supported")
+		}
+		mut, err := r.byte()
+		if err != nil {
+			return err
+		}
+		g.Mutable = mut == 1
+		if g.Init, err = r.constExpr(m, g.Type); err != nil {
+			return err
+		}
+		m.globals = append(m.globals, g)
+	}
+	return nil
+}
+
+func (m *Module) decodeExports(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	names := make(map[string]bool)
+	for i := uint32(0); i < n; i++ {
+		name, err := r.name()
+		if err != nil {
+			return err
+		}
+		if names[name] {
+			return xerrors.Errorf("duplicate export %s", name)
+		}
+		names[name] = true
+		kind, err := r.byte()
+		if err != nil {
+			return err
+		}
+		idx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if kind == 0 {
+			m.Exports = append(m.Exports, Export{Name: name, Index: idx})
+		}
+	}
+	return nil
+}
+
+func (m *Module) decodeElements(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		flags, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if flags != 0 {
+			return xerrors.New("only active element segments are supported")
+		}
+		offset, err := r.constExpr(m, I32)
+		if err != nil {
+			return err
+		}
+		funcs, err := r.u32s()
+		if err != nil {
+			return err
+		}
+		m.elems = append(m.elems, element{Offset: uint32(offset), Funcs: funcs})
+	}
+	return nil
+}
+
+func (m *Module) decodeCode(r *reader, funcTypes []uint32) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if int(n) != len(funcTypes) {
+		return xerrors.New("function and code sections don't match")
+	}
+	for i := uint32(0); i < n; i++ {
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		body, err := r.bytes(int(size))
+		if err != nil {
+			return err
+		}
+		br := &reader{buf: body}
+		f := function{Type: funcTypes[i]}
+		groups, err := br.u32()
+		if err != nil {
+			return err
+		}
+		for j := uint32(0); j < groups; j++ {
+			count, err := br.u32()
+			if err != nil {
+				return err
+			}
+			vt, err := br.byte()
+			if err != nil {
+				return err
+			}
+			if ValueType(vt) != I32 && ValueType(vt) != I64 {
+				return xerrors.Errorf("function %d: only integer locals "+
+					"are supported", i)
+			}
+			if uint64(len(f.Locals))+uint64(count) > maxLocals {
+				return xerrors.Errorf("function %d: too many locals", i)
+			}
+			for k := uint32(0); k < count; k++ {
+				f.Locals = append(f.Locals, ValueType(vt))
+			}
+		}
+		f.Code = body[br.pos:]
+		m.funcs = append(m.funcs, f)
+	}
+	return nil
+}
+
+func (m *Module) decodeData(r *reader) error {
+	n, err := r.u32()
+	if err != nil {
+		return err
+	}
+	for i := uint32(0); i < n; i++ {
+		flags, err := r.u32()
+		if err != nil {
+			return err
+		}
+		var d data
+		switch flags {
+		case 0:
+			offset, err := r.constExpr(m, I32)
+			if err != nil {
+				return err
+			}
+			d.Offset = uint32(offset)
+		case 1:
+			d.Passive = true
+		default:
+			return xerrors.New("unsupported data segment")
+		}
+		size, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if d.Init, err = r.bytes(int(size)); err != nil {
+			return err
+		}
+		m.datas = append(m.datas, d)
+	}
+	return nil
+}
+
+// check verifies the indexes of the module and the code of its functions.
+func (m *Module) check() error {
+	nFuncs := uint32(len(m.Imports) + len(m.funcs))
+	for _, imp := range m.Imports {
+		if imp.Type >= uint32(len(m.Types)) {
+			return xerrors.Errorf("import %s.%s: invalid type", imp.Module, imp.Name)
+		}
+	}
+	for i, f := range m.funcs {
+		if f.Type >= uint32(len(m.Types)) {
+			return xerrors.Errorf("function %d: invalid type", i)
+		}
+	}
+	for _, e := range m.Exports {
+		if e.Index >= nFuncs {
+			return xerrors.Errorf("export %s: invalid function", e.Name)
+		}
+	}
+	if m.start != nil {
+		if *m.start >= nFuncs {
+			return xerrors.New("invalid start function")
+		}
+		ft := m.funcType(*m.start)
+		if len(ft.Params) > 0 || len(ft.Results) > 0 {
+			return xerrors.New("start function must not have parameters " +
+				"or results")
+		}
+	}
+	if m.memory != nil {
+		if m.memory.Min > MaxPages ||
+			(m.memory.HasMax && m.memory.Max < m.memory.Min) {
+			return xerrors.New("invalid memory limits")
+		}
+	}
+	if m.table != nil && m.table.Min > maxTableSize {
+		return xerrors.New("table is too big")
+	}
+	for _, e := range m.elems {
+		if m.table == nil {
+			return xerrors.New("element segment without table")
+		}
+		for _, idx := range e.Funcs {
+			if idx >= nFuncs {
+				return xerrors.New("invalid function in element segment")
+			}
+		}
+	}
+	for _, d := range m.datas {
+		if !d.Passive && m.memory == nil {
+			return xerrors.New("data segment without memory")
+		}
+	}
+	for i := range m.funcs {
+		if err := m.checkCode(&m.funcs[i]); err != nil {
+			return xerrors.Errorf("function %d: %v", i+len(m.Imports), err)
+		}
+	}
+	return nil
+}
+
+// funcType returns the type of the function with the given index, counting
+// the imported functions first.
+func (m *Module) funcType(idx uint32) FuncType {
+	if idx < uint32(len(m.Imports)) {
+		return m.Types[m.Imports[idx].Type]
+	}
+	return m.Types[m.funcs[idx-uint32(len(m.Imports))].Type]
+}
+
+// ExportedFunc returns the signature of the exported function with the given
+// name.
+func (m *Module) ExportedFunc(name string) (FuncType, bool) {
+	for _, e := range m.Exports {
+		if e.Name == name {
+			return m.funcType(e.Index), true
+		}
+	}
+	return FuncType{}, false
+}
@@ -0,0 +1,255 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testModule is used to write the binary of the modules of the tests.
+type testModule struct {
+	types   []FuncType
+	imports []Import
+	funcs   []testFunc
+	memory  *limits
+	globals []global
+	exports []Export
+	table   []uint32
+	datas   []data
+}
+
+type testFunc struct {
+	typ    uint32
+	locals []ValueType
+	code   []byte
+}
+
+func uleb(v uint64) []byte {
+	var buf []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			buf = append(buf, b|0x80)
+		} else {
+			return append(buf, b)
+		}
+	}
+}
+
+func sleb(v int64) []byte {
+	var buf []byte
+	for {
+		b := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && b&0x40 == 0) || (v == -1 && b&0x40 != 0) {
+			return append(buf, b)
+		}
+		buf = append(buf, b|0x80)
+	}
+}
+
+func vec(n int, content ...[]byte) []byte {
+	buf := uleb(uint64(n))
+	for _, c := range content {
+		buf = append(buf, c...)
+	}
+	return buf
+}
+
+func name(s string) []byte {
+	return append(uleb(uint64(len(s))), s...)
+}
+
+func i32c(v int32) []byte {
+	return append([]byte{opI32Const}, sleb(int64(v))...)
+}
+
+func i64c(v int64) []byte {
+	return append([]byte{opI64Const}, sleb(v)...)
+}
+
+func code(parts ...interface{}) []byte {
+	var buf []byte
+	for _, p := range parts {
+		switch p := p.(type) {
+		case byte:
+			buf = append(buf, p)
+		case int:
+			buf = append(buf, uleb(uint64(p))...)
+		case []byte:
+			buf = append(buf, p...)
+		}
+	}
+	return buf
+}
+
+func (tm testModule) encode() []byte {
+	buf := []byte("\x00asm\x01\x00\x00\x00")
+	section := func(id byte, content []byte) {
+		buf = append(buf, id)
+		buf = append(buf, uleb(uint64(len(content)))...)
+		buf = append(buf, content...)
+	}
+	valueTypes := func(vts []ValueType) []byte {
+		b := uleb(uint64(len(vts)))
+		for _, vt := range vts {
+			b = append(b, byte(vt))
+		}
+		return b
+	}
+
+	var types [][]byte
+	for _, ft := range tm.types {
+		t := append([]byte{0x60}, valueTypes(ft.Params)...)
+		types = append(types, append(t, valueTypes(ft.Results)...))
+	}
+	section(sectionType, vec(len(types), types...))
+
+	if len(tm.imports) > 0 {
+		var imps [][]byte
+		for _, imp := range tm.imports {
+			i := append(name(imp.Module), name(imp.Name)...)
+			imps = append(imps, append(append(i, 0), uleb(uint64(imp.Type))...))
+		}
+		section(sectionImport, vec(len(imps), imps...))
+	}
+
+	var funcs [][]byte
+	for _, f := range tm.funcs {
+		funcs = append(funcs, uleb(uint64(f.typ)))
+	}
+	section(sectionFunction, vec(len(funcs), funcs...))
+
+	if tm.table != nil {
+		section(sectionTable, vec(1, []byte{0x70, 0}, uleb(uint64(len(tm.table)))))
+	}
+	if tm.memory != nil {
+		l := []byte{0}
+		l = append(l, uleb(uint64(tm.memory.Min))...)
+		if tm.memory.HasMax {
+			l[0] = 1
+			l = append(l, uleb(uint64(tm.memory.Max))...)
+		}
+		section(sectionMemory, vec(1, l))
+	}
+	if len(tm.globals) > 0 {
+		var gs [][]byte
+		for _, g := range tm.globals {
+			mut := byte(0)
+			if g.Mutable {
+				mut = 1
+			}
+			init := i32c(int32(g.Init))
+			if g.Type == I64 {
+				init = i64c(int64(g.Init))
+			}
+			gs = append(gs, append(append([]byte{byte(g.Type), mut}, init...), opEnd))
+		}
+		section(sectionGlobal, vec(len(gs), gs...))
+	}
+
+	var exps [][]byte
+	for _, e := range tm.exports {
+		exps = append(exps, append(append(name(e.Name), 0), uleb(uint64(e.Index))...))
+	}
+	section(sectionExport, vec(len(exps), exps...))
+
+	if tm.table != nil {
+		var idxs [][]byte
+		for _, idx := range tm.table {
+			idxs = append(idxs, uleb(uint64(idx)))
+		}
+		elem := append(append([]byte{0}, i32c(0)...), opEnd)
+		section(sectionElement, vec(1, elem, vec(len(idxs), idxs...)))
+	}
+
+	var bodies [][]byte
+	for _, f := range tm.funcs {
+		var locals [][]byte
+		for _, l := range f.locals {
+			locals = append(locals, []byte{1, byte(l)})
+		}
+		body := append(vec(len(locals), locals...), f.code...)
+		bodies = append(bodies, append(uleb(uint64(len(body))), body...))
+	}
+	section(sectionCode, vec(len(bodies), bodies...))
+
+	if len(tm.datas) > 0 {
+		var ds [][]byte
+		for _, d := range tm.datas {
+			var seg []byte
+			if d.Passive {
+				seg = []byte{1}
+			} else {
+				seg = append(append([]byte{0}, i32c(int32(d.Offset))...), opEnd)
+			}
+			ds = append(ds, append(append(seg, uleb(uint64(len(d.Init)))...), d.Init...))
+		}
+		section(sectionData, vec(len(ds), ds...))
+	}
+	return buf
+}
+
+var voidType = FuncType{}
+
+func TestDecode(t *testing.T) {
+	tm := testModule{
+		types:   []FuncType{voidType, {Params: []ValueType{I32}, Results: []ValueType{I64}}},
+		imports: []Import{{Module: "env", Name: "f", Type: 0}},
+		funcs: []testFunc{{typ: 1, locals: []ValueType{I64},
+			code: code(opLocalGet, 0, opI64ExtendI32U, opEnd)}},
+		memory:  &limits{Min: 1},
+		exports: []Export{{Name: "g", Index: 1}},
+		datas:   []data{{Offset: 8, Init: []byte("hello")}},
+	}
+	m, err := Decode(tm.encode())
+	require.NoError(t, err)
+	require.Equal(t, tm.imports, m.Imports)
+	require.Equal(t, tm.exports, m.Exports)
+	ft, ok := m.ExportedFunc("g")
+	require.True(t, ok)
+	require.Equal(t, tm.types[1], ft)
+	_, ok = m.ExportedFunc("f")
+	require.False(t, ok)
+
+	_, err = Decode([]byte("\x00asm\x02\x00\x00\x00"))
+	require.Error(t, err)
+	buf := tm.encode()
+	_, err = Decode(buf[:len(buf)-1])
+	require.Error(t, err)
+
+	// The floating point instructions are refused.
+	tm.funcs[0].code = code(byte(0x43), []byte{0, 0, 0, 0}, opDrop, i64c(0), opEnd)
+	_, err = Decode(tm.encode())
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "unsupported instruction 0x43")
+
+	// So are the floating point locals.
+	tm.funcs[0].code = code(i64c(0), opEnd)
+	tm.funcs[0].locals = []ValueType{0x7d}
+	_, err = Decode(tm.encode())
+	require.Error(t, err)
+
+	// The indexes are checked.
+	tm.funcs[0].locals = nil
+	tm.funcs[0].code = code(opLocalGet, 1, opEnd)
+	_, err = Decode(tm.encode())
+	require.Error(t, err)
+	tm.funcs[0].code = code(opCall, 2, i64c(0), opEnd)
+	_, err = Decode(tm.encode())
+	require.Error(t, err)
+	tm.funcs[0].code = code(opBr, 1, i64c(0), opEnd)
+	_, err = Decode(tm.encode())
+	require.Error(t, err)
+
+	// The blocks must be closed.
+	tm.funcs[0].code = code(opBlock, blockTypeEmpty, i64c(0), opEnd)
+	_, err = Decode(tm.encode())
+	require.Error(t, err)
+
+	tm.funcs[0].code = code(i64c(0), opEnd)
+	tm.memory = &limits{Min: MaxPages + 1}
+	_, err = Decode(tm.encode())
+	require.Error(t, err)
+}
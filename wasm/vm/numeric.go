@@ -0,0 +1,229 @@
+package vm
+
+import (
+	"math"
+	"math/bits"
+)
+
+func b2u(b bool) uint64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// numeric executes the integer instructions without immediates.
+func (vm *Instance) numeric(op byte) {
+	switch {
+	case op == opI32Eqz:
+		vm.push(b2u(uint32(vm.pop()) == 0))
+	case op > opI32Eqz && op <= opI32GeU:
+		b, a := uint32(vm.pop()), uint32(vm.pop())
+		vm.push(b2u(compare32(op, a, b)))
+	case op == opI64Eqz:
+		vm.push(b2u(vm.pop() == 0))
+	case op > opI64Eqz && op <= opI64GeU:
+		b, a := vm.pop(), vm.pop()
+		vm.push(b2u(compare64(op, a, b)))
+	case op >= opI32Clz && op <= opI32Popcnt:
+		a := uint32(vm.pop())
+		switch op {
+		case opI32Clz:
+			vm.push(uint64(bits.LeadingZeros32(a)))
+		case opI32Ctz:
+			vm.push(uint64(bits.TrailingZeros32(a)))
+		default:
+			vm.push(uint64(bits.OnesCount32(a)))
+		}
+	case op >= opI32Add && op <= opI32Rotr:
+		b, a := uint32(vm.pop()), uint32(vm.pop())
+		vm.push(uint64(binary32(op, a, b)))
+	case op >= opI64Clz && op <= opI64Popcnt:
+		a := vm.pop()
+		switch op {
+		case opI64Clz:
+			vm.push(uint64(bits.LeadingZeros64(a)))
+		case opI64Ctz:
+			vm.push(uint64(bits.TrailingZeros64(a)))
+		default:
+			vm.push(uint64(bits.OnesCount64(a)))
+		}
+	case op >= opI64Add && op <= opI64Rotr:
+		b, a := vm.pop(), vm.pop()
+		vm.push(binary64(op, a, b))
+	case op == opI32WrapI64:
+		vm.push(uint64(uint32(vm.pop())))
+	case op == opI64ExtendI32S:
+		vm.push(uint64(int32(uint32(vm.pop()))))
+	case op == opI64ExtendI32U:
+		vm.push(uint64(uint32(vm.pop())))
+	case op == opI32Extend8S:
+		vm.push(uint64(uint32(int8(vm.pop()))))
+	case op == opI32Extend16S:
+		vm.push(uint64(uint32(int16(vm.pop()))))
+	case op == opI64Extend8S:
+		vm.push(uint64(int8(vm.pop())))
+	case op == opI64Extend16S:
+		vm.push(uint64(int16(vm.pop())))
+	case op == opI64Extend32S:
+		vm.push(uint64(int32(vm.pop())))
+	default:
+		panic(trapf("unsupported instruction 0x%x", op))
+	}
+}
+
+func compare32(op byte, a, b uint32) bool {
+	switch op {
+	case opI32Eq:
+		return a == b
+	case opI32Ne:
+		return a != b
+	case opI32LtS:
+		return int32(a) < int32(b)
+	case opI32LtU:
+		return a < b
+	case opI32GtS:
+		return int32(a) > int32(b)
+	case opI32GtU:
+		return a > b
+	case opI32LeS:
+		return int32(a) <= int32(b)
+	case opI32LeU:
+		return a <= b
+	case opI32GeS:
+		return int32(a) >= int32(b)
+	default:
+		return a >= b
+	}
+}
+
+func compare64(op byte, a, b uint64) bool {
+	switch op {
+	case opI64Eq:
+		return a == b
+	case opI64Ne:
+		return a != b
+	case opI64LtS:
+		return int64(a) < int64(b)
+	case opI64LtU:
+		return a < b
+	case opI64GtS:
+		return int64(a) > int64(b)
+	case opI64GtU:
+		return a > b
+	case opI64LeS:
+		return int64(a) <= int64(b)
+	case opI64LeU:
+		return a <= b
+	case opI64GeS:
+		return int64(a) >= int64(b)
+	default:
+		return a >= b
+	}
+}
+
+func binary32(op byte, a, b uint32) uint32 {
+	switch op {
+	case opI32Add:
+		return a + b
+	case opI32Sub:
+		return a - b
+	case opI32Mul:
+		return a * b
+	case opI32DivS:
+		if b == 0 {
+			panic(trapf("integer divide by zero"))
+		}
+		if int32(a) == math.MinInt32 && int32(b) == -1 {
+			panic(trapf("integer overflow"))
+		}
+		return uint32(int32(a) / int32(b))
+	case opI32DivU:
+		if b == 0 {
+			panic(trapf("integer divide by zero"))
+		}
+		return a / b
+	case opI32RemS:
+		if b == 0 {
+			panic(trapf("integer divide by zero"))
+		}
+		if int32(b) == -1 {
+			return 0
+		}
+		return uint32(int32(a) % int32(b))
+	case opI32RemU:
+		if b == 0 {
+			panic(trapf("integer divide by zero"))
+		}
+		return a % b
+	case opI32And:
+		return a & b
+	case opI32Or:
+		return a | b
+	case opI32Xor:
+		return a ^ b
+	case opI32Shl:
+		return a << (b & 31)
+	case opI32ShrS:
+		return uint32(int32(a) >> (b & 31))
+	case opI32ShrU:
+		return a >> (b & 31)
+	case opI32Rotl:
+		return bits.RotateLeft32(a, int(b&31))
+	default:
+		return bits.RotateLeft32(a, -int(b&31))
+	}
+}
+
+func binary64(op byte, a, b uint64) uint64 {
+	switch op {
+	case opI64Add:
+		return a + b
+	case opI64Sub:
+		return a - b
+	case opI64Mul:
+		return a * b
+	case opI64DivS:
+		if b == 0 {
+			panic(trapf("integer divide by zero"))
+		}
+		if int64(a) == math.MinInt64 && int64(b) == -1 {
+			panic(trapf("integer overflow"))
+		}
+		return uint64(int64(a) / int64(b))
+	case opI64DivU:
+		if b == 0 {
+			panic(trapf("integer divide by zero"))
+		}
+		return a / b
+	case opI64RemS:
+		if b == 0 {
+			panic(trapf("integer divide by zero"))
+		}
+		if int64(b) == -1 {
+			return 0
+		}
+		return uint64(int64(a) % int64(b))
+	case opI64RemU:
+		if b == 0 {
+			panic(trapf("integer divide by zero"))
+		}
+		return a % b
+	case opI64And:
+		return a & b
+	case opI64Or:
+		return a | b
+	case opI64Xor:
+		return a ^ b
+	case opI64Shl:
+		return a << (b & 63)
+	case opI64ShrS:
+		return uint64(int64(a) >> (b & 63))
+	case opI64ShrU:
+		return a >> (b & 63)
+	case opI64Rotl:
+		return bits.RotateLeft64(a, int(b&63))
+	default:
+		return bits.RotateLeft64(a, -int(b&63))
+	}
+}
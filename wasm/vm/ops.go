@@ -0,0 +1,349 @@
+package vm
+
+import (
+	"golang.org/x/xerrors"
+)
+
+// The supported instructions. The floating point instructions are left out.
+const (
+	opUnreachable  byte = 0x00
+	opNop          byte = 0x01
+	opBlock        byte = 0x02
+	opLoop         byte = 0x03
+	opIf           byte = 0x04
+	opElse         byte = 0x05
+	opEnd          byte = 0x0b
+	opBr           byte = 0x0c
+	opBrIf         byte = 0x0d
+	opBrTable      byte = 0x0e
+	opReturn       byte = 0x0f
+	opCall         byte = 0x10
+	opCallIndirect byte = 0x11
+
+	opDrop        byte = 0x1a
+	opSelect      byte = 0x1b
+	opSelectTyped byte = 0x1c
+
+	opLocalGet  byte = 0x20
+	opLocalSet  byte = 0x21
+	opLocalTee  byte = 0x22
+	opGlobalGet byte = 0x23
+	opGlobalSet byte = 0x24
+
+	opI32Load    byte = 0x28
+	opI64Load    byte = 0x29
+	opI32Load8S  byte = 0x2c
+	opI32Load8U  byte = 0x2d
+	opI32Load16S byte = 0x2e
+	opI32Load16U byte = 0x2f
+	opI64Load8S  byte = 0x30
+	opI64Load8U  byte = 0x31
+	opI64Load16S byte = 0x32
+	opI64Load16U byte = 0x33
+	opI64Load32S byte = 0x34
+	opI64Load32U byte = 0x35
+	opI32Store   byte = 0x36
+	opI64Store   byte = 0x37
+	opI32Store8  byte = 0x3a
+	opI32Store16 byte = 0x3b
+	opI64Store8  byte = 0x3c
+	opI64Store16 byte = 0x3d
+	opI64Store32 byte = 0x3e
+	opMemorySize byte = 0x3f
+	opMemoryGrow byte = 0x40
+
+	opI32Const byte = 0x41
+	opI64Const byte = 0x42
+
+	opI32Eqz byte = 0x45
+	opI32Eq  byte = 0x46
+	opI32Ne  byte = 0x47
+	opI32LtS byte = 0x48
+	opI32LtU byte = 0x49
+	opI32GtS byte = 0x4a
+	opI32GtU byte = 0x4b
+	opI32LeS byte = 0x4c
+	opI32LeU byte = 0x4d
+	opI32GeS byte = 0x4e
+	opI32GeU byte = 0x4f
+
+	opI64Eqz byte = 0x50
+	opI64Eq  byte = 0x51
+	opI64Ne  byte = 0x52
+	opI64LtS byte = 0x53
+	opI64LtU byte = 0x54
+	opI64GtS byte = 0x55
+	opI64GtU byte = 0x56
+	opI64LeS byte = 0x57
+	opI64LeU byte = 0x58
+	opI64GeS byte = 0x59
+	opI64GeU byte = 0x5a
+
+	opI32Clz    byte = 0x67
+	opI32Ctz    byte = 0x68
+	opI32Popcnt byte = 0x69
+	opI32Add    byte = 0x6a
+	opI32Sub    byte = 0x6b
+	opI32Mul    byte = 0x6c
+	opI32DivS   byte = 0x6d
+	opI32DivU   byte = 0x6e
+	opI32RemS   byte = 0x6f
+	opI32RemU   byte = 0x70
+	opI32And    byte = 0x71
+	opI32Or     byte = 0x72
+	opI32Xor    byte = 0x73
+	opI32Shl    byte = 0x74
+	opI32ShrS   byte = 0x75
+	opI32ShrU   byte = 0x76
+	opI32Rotl   byte = 0x77
+	opI32Rotr   byte = 0x78
+
+	opI64Clz    byte = 0x79
+	opI64Ctz    byte = 0x7a
+	opI64Popcnt byte = 0x7b
+	opI64Add    byte = 0x7c
+	opI64Sub    byte = 0x7d
+	opI64Mul    byte = 0x7e
+	opI64DivS   byte = 0x7f
+	opI64DivU   byte = 0x80
+	opI64RemS   byte = 0x81
+	opI64RemU   byte = 0x82
+	opI64And    byte = 0x83
+	opI64Or     byte = 0x84
+	opI64Xor    byte = 0x85
+	opI64Shl    byte = 0x86
+	opI64ShrS   byte = 0x87
+	opI64ShrU   byte = 0x88
+	opI64Rotl   byte = 0x89
+	opI64Rotr   byte = 0x8a
+
+	opI32WrapI64    byte = 0xa7
+	opI64ExtendI32S byte = 0xac
+	opI64ExtendI32U byte = 0xad
+	opI32Extend8S   byte = 0xc0
+	opI32Extend16S  byte = 0xc1
+	opI64Extend8S   byte = 0xc2
+	opI64Extend16S  byte = 0xc3
+	opI64Extend32S  byte = 0xc4
+
+	// opPrefixFC is followed by the number of the bulk memory instruction.
+	opPrefixFC byte = 0xfc
+)
+
+const (
+	fcMemoryInit = 8
+	fcDataDrop   = 9
+	fcMemoryCopy = 10
+	fcMemoryFill = 11
+)
+
+const (
+	blockTypeEmpty        byte = 0x40
+	zeroByteImmediate     byte = 0
+	maxLocals                  = 50000
+	maxTableSize               = 10000
+	maxBranchTableTargets      = 10000
+)
+
+// checkCode goes through the code of the function to check that all the
+// instructions are supported and their immediates valid. It also finds the
+// else and end instructions of every block.
+func (m *Module) checkCode(f *function) error {
+	r := &reader{buf: f.Code}
+	nLocals := uint32(len(m.Types[f.Type].Params) + len(f.Locals))
+	nFuncs := uint32(len(m.Imports) + len(m.funcs))
+	f.blocks = make(map[int]blockInfo)
+	var open []int
+
+	for !r.done() {
+		pos := r.pos
+		op, _ := r.byte()
+		var err error
+		switch op {
+		case opBlock, opLoop, opIf:
+			var bt byte
+			bt, err = r.byte()
+			if err == nil && bt != blockTypeEmpty && ValueType(bt) != I32 &&
+				ValueType(bt) != I64 {
+				err = xerrors.New("unsupported block type")
+			}
+			open = append(open, pos)
+			f.blocks[pos] = blockInfo{elsePos: -1, endPos: -1}
+		case opElse:
+			if len(open) == 0 || f.Code[open[len(open)-1]] != opIf {
+				return xerrors.Errorf("else without if at %d", pos)
+			}
+			bi := f.blocks[open[len(open)-1]]
+			if bi.elsePos >= 0 {
+				return xerrors.Errorf("second else at %d", pos)
+			}
+			bi.elsePos = pos
+			f.blocks[open[len(open)-1]] = bi
+		case opEnd:
+			if len(open) == 0 {
+				if !r.done() {
+					return xerrors.New("code after the end of the function")
+				}
+				return nil
+			}
+			bi := f.blocks[open[len(open)-1]]
+			bi.endPos = pos
+			f.blocks[open[len(open)-1]] = bi
+			open = open[:len(open)-1]
+		case opBr, opBrIf:
+			err = r.checkLabel(len(open))
+		case opBrTable:
+			var n uint32
+			n, err = r.u32()
+			if err == nil && n > maxBranchTableTargets {
+				err = xerrors.New("branch table is too big")
+			}
+			for i := uint32(0); err == nil && i <= n; i++ {
+				err = r.checkLabel(len(open))
+			}
+		case opCall:
+			var idx uint32
+			idx, err = r.u32()
+			if err == nil && idx >= nFuncs {
+				err = xerrors.New("invalid function index")
+			}
+		case opCallIndirect:
+			var idx uint32
+			idx, err = r.u32()
+			if err == nil && idx >= uint32(len(m.Types)) {
+				err = xerrors.New("invalid type index")
+			}
+			if err == nil && m.table == nil {
+				err = xerrors.New("call_indirect without table")
+			}
+			if err == nil {
+				err = r.zeroByte()
+			}
+		case opSelectTyped:
+			_, err = r.valueTypes()
+		case opLocalGet, opLocalSet, opLocalTee:
+			var idx uint32
+			idx, err = r.u32()
+			if err == nil && idx >= nLocals {
+				err = xerrors.New("invalid local index")
+			}
+		case opGlobalGet, opGlobalSet:
+			var idx uint32
+			idx, err = r.u32()
+			if err == nil && idx >= uint32(len(m.globals)) {
+				err = xerrors.New("invalid global index")
+			}
+			if err == nil && op == opGlobalSet && !m.globals[idx].Mutable {
+				err = xerrors.New("global is immutable")
+			}
+		case opI32Load, opI64Load, opI32Load8S, opI32Load8U, opI32Load16S,
+			opI32Load16U, opI64Load8S, opI64Load8U, opI64Load16S, opI64Load16U,
+			opI64Load32S, opI64Load32U, opI32Store, opI64Store, opI32Store8,
+			opI32Store16, opI64Store8, opI64Store16, opI64Store32:
+			if m.memory == nil {
+				return xerrors.New("memory access without memory")
+			}
+			if _, err = r.u32(); err == nil {
+				_, err = r.u32()
+			}
+		case opMemorySize, opMemoryGrow:
+			if m.memory == nil {
+				return xerrors.New("memory access without memory")
+			}
+			err = r.zeroByte()
+		case opI32Const:
+			_, err = r.sleb(32)
+		case opI64Const:
+			_, err = r.sleb(64)
+		case opPrefixFC:
+			err = m.checkPrefixFC(r)
+		default:
+			if !isSimpleOp(op) {
+				return xerrors.Errorf("unsupported instruction 0x%x at %d", op, pos)
+			}
+		}
+		if err != nil {
+			return xerrors.Errorf("instruction 0x%x at %d: %v", op, pos, err)
+		}
+	}
+	return xerrors.New("missing end of function")
+}
+
+func (m *Module) checkPrefixFC(r *reader) error {
+	sub, err := r.u32()
+	if err != nil {
+		return err
+	}
+	if m.memory == nil {
+		return xerrors.New("memory access without memory")
+	}
+	switch sub {
+	case fcMemoryInit:
+		idx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if idx >= uint32(len(m.datas)) {
+			return xerrors.New("invalid data index")
+		}
+		return r.zeroByte()
+	case fcDataDrop:
+		idx, err := r.u32()
+		if err != nil {
+			return err
+		}
+		if idx >= uint32(len(m.datas)) {
+			return xerrors.New("invalid data index")
+		}
+		return nil
+	case fcMemoryCopy:
+		if err := r.zeroByte(); err != nil {
+			return err
+		}
+		return r.zeroByte()
+	case fcMemoryFill:
+		return r.zeroByte()
+	}
+	return xerrors.Errorf("unsupported instruction 0xfc %d", sub)
+}
+
+func (r *reader) checkLabel(depth int) error {
+	l, err := r.u32()
+	if err != nil {
+		return err
+	}
+	// The label can be the function itself.
+	if int(l) > depth {
+		return xerrors.New("invalid label")
+	}
+	return nil
+}
+
+func (r *reader) zeroByte() error {
+	b, err := r.byte()
+	if err != nil {
+		return err
+	}
+	if b != zeroByteImmediate {
+		return xerrors.New("only one memory or table is supported")
+	}
+	return nil
+}
+
+// isSimpleOp returns true for the supported instructions without immediates.
+func isSimpleOp(op byte) bool {
+	switch {
+	case op == opUnreachable, op == opNop, op == opReturn, op == opDrop,
+		op == opSelect:
+		return true
+	case op >= opI32Eqz && op <= opI64GeU:
+		return true
+	case op >= opI32Clz && op <= opI64Rotr:
+		return true
+	case op == opI32WrapI64, op == opI64ExtendI32S, op == opI64ExtendI32U:
+		return true
+	case op >= opI32Extend8S && op <= opI64Extend32S:
+		return true
+	}
+	return false
+}
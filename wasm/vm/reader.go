@@ -0,0 +1,202 @@
+package vm
+
+import (
+	"golang.org/x/xerrors"
+)
+
+var errEOF = xerrors.New("unexpected end of module")
+
+// reader decodes the binary format of WebAssembly.
+type reader struct {
+	buf []byte
+	pos int
+}
+
+func (r *reader) done() bool {
+	return r.pos >= len(r.buf)
+}
+
+func (r *reader) byte() (byte, error) {
+	if r.pos >= len(r.buf) {
+		return 0, errEOF
+	}
+	b := r.buf[r.pos]
+	r.pos++
+	return b, nil
+}
+
+func (r *reader) bytes(n int) ([]byte, error) {
+	if n < 0 || n > len(r.buf)-r.pos {
+		return nil, errEOF
+	}
+	b := r.buf[r.pos : r.pos+n]
+	r.pos += n
+	return b, nil
+}
+
+// uleb reads an unsigned LEB128 integer of at most the given number of bits.
+func (r *reader) uleb(bits uint) (uint64, error) {
+	var result uint64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits || (shift+7 > bits && uint64(b&0x7f)>>(bits-shift) != 0) {
+			return 0, xerrors.New("integer too large")
+		}
+		result |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return result, nil
+		}
+	}
+}
+
+// sleb reads a signed LEB128 integer of at most the given number of bits.
+func (r *reader) sleb(bits uint) (int64, error) {
+	var result int64
+	var shift uint
+	for {
+		b, err := r.byte()
+		if err != nil {
+			return 0, err
+		}
+		if shift >= bits {
+			return 0, xerrors.New("integer too large")
+		}
+		result |= int64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			if shift < 64 && b&0x40 != 0 {
+				result |= -1 << shift
+			}
+			if bits < 64 {
+				// The value must fit in the given number of bits.
+				min, max := int64(-1)<<(bits-1), int64(1)<<(bits-1)-1
+				if result < min || result > max {
+					return 0, xerrors.New("integer too large")
+				}
+			}
+			return result, nil
+		}
+	}
+}
+
+func (r *reader) u32() (uint32, error) {
+	v, err := r.uleb(32)
+	return uint32(v), err
+}
+
+func (r *reader) u32s() ([]uint32, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > len(r.buf)-r.pos {
+		return nil, errEOF
+	}
+	vs := make([]uint32, n)
+	for i := range vs {
+		if vs[i], err = r.u32(); err != nil {
+			return nil, err
+		}
+	}
+	return vs, nil
+}
+
+func (r *reader) name() (string, error) {
+	n, err := r.u32()
+	if err != nil {
+		return "", err
+	}
+	b, err := r.bytes(int(n))
+	return string(b), err
+}
+
+func (r *reader) valueTypes() ([]ValueType, error) {
+	n, err := r.u32()
+	if err != nil {
+		return nil, err
+	}
+	if int(n) > len(r.buf)-r.pos {
+		return nil, errEOF
+	}
+	vts := make([]ValueType, n)
+	for i := range vts {
+		b, err := r.byte()
+		if err != nil {
+			return nil, err
+		}
+		vts[i] = ValueType(b)
+		if vts[i] != I32 && vts[i] != I64 {
+			return nil, xerrors.New("only integer types are supported")
+		}
+	}
+	return vts, nil
+}
+
+func (r *reader) limits() (limits, error) {
+	var l limits
+	flag, err := r.byte()
+	if err != nil {
+		return l, err
+	}
+	if flag > 1 {
+		return l, xerrors.New("invalid limits")
+	}
+	if l.Min, err = r.u32(); err != nil {
+		return l, err
+	}
+	if flag == 1 {
+		l.HasMax = true
+		if l.Max, err = r.u32(); err != nil {
+			return l, err
+		}
+	}
+	return l, nil
+}
+
+// constExpr reads the initializer of a global or the offset of a segment.
+func (r *reader) constExpr(m *Module, vt ValueType) (uint64, error) {
+	op, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	var v uint64
+	switch {
+	case op == opI32Const && vt == I32:
+		c, err := r.sleb(32)
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(uint32(c))
+	case op == opI64Const && vt == I64:
+		c, err := r.sleb(64)
+		if err != nil {
+			return 0, err
+		}
+		v = uint64(c)
+	case op == opGlobalGet:
+		idx, err := r.u32()
+		if err != nil {
+			return 0, err
+		}
+		if idx >= uint32(len(m.globals)) || m.globals[idx].Type != vt ||
+			m.globals[idx].Mutable {
+			return 0, xerrors.New("invalid global in constant expression")
+		}
+		v = m.globals[idx].Init
+	default:
+		return 0, xerrors.New("unsupported constant expression")
+	}
+	end, err := r.byte()
+	if err != nil {
+		return 0, err
+	}
+	if end != opEnd {
+		return 0, xerrors.New("constant expression must end")
+	}
+	return v, nil
+}